@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Строка журнала доступа AWS ALB: поля через пробел, из них нужны первые тринадцать:
+// `type time elb client:port target:port request_processing_time target_processing_time
+// response_processing_time elb_status_code target_status_code received_bytes sent_bytes "request"`.
+// Дальше идут user agent, шифр, ARN и прочее; их состав меняется, и они не читаются
+const (
+	inputFormatALB = "alb"
+	// albTimeLayout - -time-layout по умолчанию для alb: время в UTC с микросекундами
+	albTimeLayout = "2006-01-02T15:04:05.999999Z07:00"
+	// albFields - сколько полей через пробел идёт до запроса в кавычках
+	albFields = 12
+)
+
+// parseLineALB разбирает строку ALB. Время ответа - сумма трёх задержек в секундах,
+// -1 (задержки нет, например, запрос не дошёл до цели) считается нулём; path - URL
+// запроса без схемы и хоста, статус - elb_status_code
+func parseLineALB(line []byte) (lineRecord, error) {
+	var rec lineRecord
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	var f [albFields]span
+	i := 0
+	for k := range f {
+		sp, ok := nextField(line, i)
+		if !ok {
+			return rec, &lineParseError{len(line), fmt.Errorf("expected %d space-separated fields before the request, found %d", albFields, k)}
+		}
+		f[k], i = sp, sp.end
+	}
+	rec.timestamp, rec.status = f[1], f[8]
+
+	// client:port; у IPv6 адрес бывает в квадратных скобках
+	rec.ip = f[3]
+	if c := bytes.LastIndexByte(line[rec.ip.start:rec.ip.end], ':'); c > 0 {
+		rec.ip.end = rec.ip.start + c
+	}
+	if rec.ip.end-rec.ip.start >= 2 && line[rec.ip.start] == '[' && line[rec.ip.end-1] == ']' {
+		rec.ip = span{rec.ip.start + 1, rec.ip.end - 1}
+	}
+
+	// Задержки суммируются в микросекундах и округляются до миллисекунд один раз
+	var micros int64
+	for _, sp := range f[5:8] {
+		b := line[sp.start:sp.end]
+		if bytes.Equal(b, []byte("-1")) {
+			continue
+		}
+		v, bad, err := parseScaled(b, 6)
+		if err != nil {
+			return rec, &lineParseError{sp.start + bad, err}
+		}
+		micros += v
+	}
+	rec.responseTime = span{f[5].start, f[7].end}
+	rec.value = (micros + 500) / 1000
+
+	request, err := quotedField(line, skipBlanks(line, i), "request")
+	if err != nil {
+		return rec, err
+	}
+	inner := line[request.start:request.end]
+	sp := bytes.IndexByte(inner, ' ')
+	if sp <= 0 {
+		return rec, &lineParseError{request.start, fmt.Errorf("expected \"METHOD URL PROTOCOL\" in the request")}
+	}
+	rec.method = span{request.start, request.start + sp}
+	url := span{request.start + sp + 1, request.end}
+	if last := bytes.LastIndexByte(inner, ' '); last > sp {
+		url.end = request.start + last
+	}
+	rec.path = span{url.start + urlPathStart(line[url.start:url.end]), url.end}
+	if rec.path.start == rec.path.end {
+		return rec, &lineParseError{rec.path.start, fmt.Errorf("empty path")}
+	}
+	return rec, nil
+}
+
+// urlPathStart - с какого байта в URL вида https://host:443/path начинается path.
+// У URL без схемы или без path это 0: он остаётся как есть
+func urlPathStart(url []byte) int {
+	scheme := bytes.Index(url, []byte("://"))
+	if scheme <= 0 || bytes.IndexByte(url[:scheme], '/') >= 0 {
+		return 0
+	}
+	host := scheme + len("://")
+	slash := bytes.IndexByte(url[host:], '/')
+	if slash < 0 {
+		return 0
+	}
+	return host + slash
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// albDocsExamples - примеры записей из документации ALB "Access logs for your
+// Application Load Balancer" (у записи Lambda изменены URL и статус) и строки для
+// задержек -1, клиента IPv6 и долей миллисекунды
+var albDocsExamples = []struct {
+	name, line       string
+	ip, method, path string
+	status           string
+	ms               int64
+}{
+	{"http", `http 2018-07-02T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188 192.168.131.39:2817 10.0.0.1:80 0.000 0.001 0.000 200 200 34 366 "GET http://www.example.com:80/ HTTP/1.1" "curl/7.46.0" - - arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067 "Root=1-58337262-36d228ad5d99923122bbe354" "-" "-" 0 2018-07-02T22:22:48.364000Z "forward" "-" "-" "10.0.0.1:80" "200" "-" "-"`,
+		"192.168.131.39", "GET", "/", "200", 1},
+	{"https", `https 2018-07-02T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188 192.168.131.39:2817 10.0.0.1:80 0.086 0.048 0.037 200 200 0 57 "GET https://www.example.com:443/ HTTP/1.1" "curl/7.46.0" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067 "Root=1-58337281-1d84f3d73c47ec4e58577259" "www.example.com" "arn:aws:acm:us-east-2:123456789012:certificate/12345678-1234-1234-1234-123456789012" 1 2018-07-02T22:22:48.364000Z "authenticate,forward" "-" "-" "10.0.0.1:80" "200" "-" "-"`,
+		"192.168.131.39", "GET", "/", "200", 171},
+	{"h2", `h2 2018-07-02T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188 10.0.1.252:48160 10.0.0.66:9000 0.000 0.002 0.000 200 200 5 257 "GET https://10.0.2.105:773/ HTTP/2.0" "curl/7.46.0" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067 "Root=1-58337327-72bd00b0343d75b906739c42" "-" "-" 1 2018-07-02T22:22:48.364000Z "redirect" "https://example.com:80/" "-" "10.0.0.66:9000" "200" "-" "-"`,
+		"10.0.1.252", "GET", "/", "200", 2},
+	{"websocket", `ws 2018-07-02T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188 10.0.0.140:40914 10.0.1.192:8010 0.001 0.003 0.000 101 101 218 587 "GET http://10.0.0.30:80/ HTTP/1.1" "-" - - arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067 "Root=1-58337364-23a8c76965a2ef7629b185e3" "-" "-" 1 2018-07-02T22:22:48.364000Z "forward" "-" "-" "10.0.1.192:8010" "101" "-" "-"`,
+		"10.0.0.140", "GET", "/", "101", 4},
+	{"lambda failed", `http 2018-11-30T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188 192.168.131.39:2817 - 0.000 0.001 0.000 502 - 34 366 "GET http://www.example.com:80/api/v1/items?page=2 HTTP/1.1" "curl/7.46.0" - - arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067 "Root=1-58337364-23a8c76965a2ef7629b185e3" "-" "-" 0 2018-11-30T22:22:48.364000Z "forward" "-" "LambdaInvalidResponse" "-" "-" "-" "-"`,
+		"192.168.131.39", "GET", "/api/v1/items?page=2", "502", 1},
+	// Цель не ответила: все три задержки -1 и считаются нулём
+	{"no target", `http 2018-11-30T22:23:01.000000Z app/my-loadbalancer/50dc6c495c0c9188 [2001:db8::7]:52311 - -1 -1 -1 504 - 120 0 "POST http://www.example.com:80/upload HTTP/1.1" "-" - - - "Root=1-58337364-23a8c76965a2ef7629b185e4" "-" "-" 0 2018-11-30T22:22:01.000000Z "forward" "-" "-" "-" "-" "-" "-"`,
+		"2001:db8::7", "POST", "/upload", "504", 0},
+	// 0.4ms трижды - это 1ms: задержки складываются до округления
+	{"sub-millisecond", `http 2018-11-30T22:23:02.000000Z app/my-loadbalancer/50dc6c495c0c9188 10.0.0.9:1000 10.0.0.1:80 0.0004 0.0004 0.0004 200 200 1 1 "GET /health HTTP/1.1" "-" - - - "-" "-" "-" 0 2018-11-30T22:23:02.000000Z "forward" "-" "-" "-" "-" "-" "-"`,
+		"10.0.0.9", "GET", "/health", "200", 1},
+}
+
+func TestParseLineALB(t *testing.T) {
+	for _, tt := range albDocsExamples {
+		rec, err := parseLineALB([]byte(tt.line))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		l := tt.line
+		got := [4]string{l[rec.ip.start:rec.ip.end], l[rec.method.start:rec.method.end], l[rec.path.start:rec.path.end], l[rec.status.start:rec.status.end]}
+		if want := [4]string{tt.ip, tt.method, tt.path, tt.status}; got != want || rec.value != tt.ms {
+			t.Errorf("%s: got %q and %dms, want %q and %dms", tt.name, got, rec.value, want, tt.ms)
+		}
+	}
+
+	for _, bad := range []string{
+		`http 2018-07-02T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188 192.168.131.39:2817 10.0.0.1:80 0.000 0.001`,
+		`http 2018-07-02T22:23:00.186641Z app/lb 1.2.3.4:1 - 0.000 abc 0.000 200 200 1 1 "GET / HTTP/1.1"`,
+		`http 2018-07-02T22:23:00.186641Z app/lb 1.2.3.4:1 - 0.000 0.001 0.000 200 200 1 1 GET / HTTP/1.1`,
+		`http 2018-07-02T22:23:00.186641Z app/lb 1.2.3.4:1 - 0.000 0.001 0.000 200 200 1 1 "-"`,
+	} {
+		if _, err := parseLineALB([]byte(bad)); err == nil {
+			t.Errorf("accepted %s", bad)
+		}
+	}
+}
+
+func TestUrlPathStart(t *testing.T) {
+	for url, want := range map[string]string{
+		"http://www.example.com:80/":        "/",
+		"https://example.com/a/b?c=d":       "/a/b?c=d",
+		"https://[2001:db8::1]:443/v6":      "/v6",
+		"/already/a/path":                   "/already/a/path",
+		"https://example.com":               "https://example.com",
+		"/redirect?to=https://example.com/": "/redirect?to=https://example.com/",
+	} {
+		if got := url[urlPathStart([]byte(url)):]; got != want {
+			t.Errorf("urlPathStart(%q) gives %q, want %q", url, got, want)
+		}
+	}
+}
+
+func TestALBRoundTrip(t *testing.T) {
+	var log string
+	for _, tt := range albDocsExamples {
+		log += tt.line + "\n"
+	}
+	out, code := runAnalyzeFile(t, "-input-format", "alb", "-schema-version", "2", writeTempFile(t, "alb.log", log))
+	if code != exitOK {
+		t.Fatalf("exit %d", code)
+	}
+	var doc struct {
+		Endpoints map[string]struct {
+			Count int64 `json:"count"`
+			Sum   int64 `json:"sum_response_time"`
+		} `json:"endpoints"`
+		Summary map[string]any `json:"summary"`
+	}
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	// Четыре примера из документации - запросы к /
+	if e := doc.Endpoints["/"]; e.Count != 4 || e.Sum != 1+171+2+4 {
+		t.Errorf("/ = %+v, want 4 requests of 178ms in total", e)
+	}
+	if doc.Summary["malformed_lines"] != 0.0 || doc.Summary["first_seen"] != "2018-07-02T22:23:00.186Z" {
+		t.Errorf("summary = %v", doc.Summary)
+	}
+}
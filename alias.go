@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// -alias-map: файл строк "СТАРЫЙ -> НОВЫЙ". Без '*' path заменяется при точном
+// совпадении, "/legacy/* -> /v2/*" заменяет префикс и переносит остаток, а у
+// "/legacy/* -> /v2" остаток отбрасывается. Пустые строки и строки с # - комментарии
+const aliasArrow = "->"
+
+// aliasPrefix - правило с '*': paths с prefix получают target и, если carry, остаток
+type aliasPrefix struct {
+	prefix []byte
+	target []byte
+	carry  bool
+}
+
+// aliasMap - точные алиасы в карте и префиксы от длинного к короткому: срабатывает
+// самый длинный подходящий префикс, а точное совпадение важнее любого префикса
+type aliasMap struct {
+	exact    map[string][]byte
+	prefixes []aliasPrefix
+}
+
+// loadAliasMap читает алиасы при старте. Один и тот же источник с разными целями -
+// ошибка: после переименования маршрутов это почти наверняка опечатка
+func loadAliasMap(path string) (*aliasMap, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("alias map %s: %w", path, err)
+	}
+	defer file.Close()
+	m := &aliasMap{exact: make(map[string][]byte)}
+	// lines - строка файла, где задан источник, для сообщения о конфликте
+	lines := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		from, to, ok := strings.Cut(text, aliasArrow)
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("alias map %s:%d: expected \"OLD %s NEW\", got %q", path, n, aliasArrow, text)
+		}
+		prefix, wildcard := strings.CutSuffix(from, "*")
+		base, carry := strings.CutSuffix(to, "*")
+		switch {
+		case strings.Contains(prefix, "*") || strings.Contains(base, "*"):
+			return nil, fmt.Errorf("alias map %s:%d: * is allowed only at the end", path, n)
+		case carry && !wildcard:
+			return nil, fmt.Errorf("alias map %s:%d: %q ends with * but %q does not", path, n, to, from)
+		}
+		if first, ok := lines[from]; ok {
+			if old := m.target(from); old != to {
+				return nil, fmt.Errorf("alias map %s:%d: %q is mapped to %q on line %d and to %q here", path, n, from, old, first, to)
+			}
+			continue
+		}
+		lines[from] = n
+		if wildcard {
+			m.prefixes = append(m.prefixes, aliasPrefix{prefix: []byte(prefix), target: []byte(base), carry: carry})
+		} else {
+			m.exact[from] = []byte(to)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("alias map %s: %w", path, err)
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("alias map %s: no aliases", path)
+	}
+	sort.SliceStable(m.prefixes, func(i, j int) bool { return len(m.prefixes[i].prefix) > len(m.prefixes[j].prefix) })
+	return m, nil
+}
+
+// target - цель уже прочитанного источника from в том виде, как она записана в файле
+func (m *aliasMap) target(from string) string {
+	if prefix, ok := strings.CutSuffix(from, "*"); ok {
+		for _, p := range m.prefixes {
+			if string(p.prefix) == prefix {
+				if p.carry {
+					return string(p.target) + "*"
+				}
+				return string(p.target)
+			}
+		}
+	}
+	return string(m.exact[from])
+}
+
+// apply заменяет path строки, уже обрезанный и нормализованный, на алиас. buf - буфер
+// воркера для целей с остатком; ok - path заменён
+func (m *aliasMap) apply(line []byte, rec *lineRecord, buf []byte) ([]byte, bool) {
+	path := rec.pathOf(line)
+	if to, ok := m.exact[string(path)]; ok {
+		rec.altPath = to
+		return buf, true
+	}
+	for _, p := range m.prefixes {
+		if !bytes.HasPrefix(path, p.prefix) {
+			continue
+		}
+		if !p.carry {
+			rec.altPath = p.target
+			return buf, true
+		}
+		// path может лежать в buf нормализатора, но это другой буфер
+		buf = append(append(buf[:0], p.target...), path[len(p.prefix):]...)
+		rec.altPath = buf
+		return buf, true
+	}
+	return buf, false
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// aliased - path после m; ok = false, если алиаса нет
+func aliased(m *aliasMap, path string) (string, bool) {
+	line := []byte(path)
+	rec := lineRecord{path: span{0, len(line)}}
+	if _, ok := m.apply(line, &rec, nil); !ok {
+		return path, false
+	}
+	return string(rec.altPath), true
+}
+
+func TestAliasMapApply(t *testing.T) {
+	m, err := loadAliasMap(writeTempFile(t, "aliases.txt", "# renamed in v2\n\n"+
+		"/v1/old-name -> /v2/new-name\n"+
+		"/legacy/* -> /v2/*\n"+
+		"/legacy/admin/* -> /admin\n"+
+		"/legacy/users -> /v2/people\n"+
+		"/v2/new-name -> /v3/newest\n"+
+		// Повтор с той же целью не ошибка
+		"/legacy/* -> /v2/*\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		"/v1/old-name": "/v2/new-name",
+		// Остаток переносится, и у пустого остатка тоже
+		"/legacy/items/7": "/v2/items/7",
+		"/legacy/":        "/v2/",
+		// Самый длинный префикс сильнее, а без * в цели остаток отбрасывается
+		"/legacy/admin/users/1": "/admin",
+		// Точное совпадение сильнее любого префикса
+		"/legacy/users": "/v2/people",
+		// Алиас применяется один раз, цепочки не раскрываются
+		"/v2/new-name": "/v3/newest",
+	} {
+		if got, ok := aliased(m, path); !ok || got != want {
+			t.Errorf("%s -> %s, %v; want %s", path, got, ok, want)
+		}
+	}
+	for _, path := range []string{"/v1/old-name/", "/v1/old", "/legacy", "/LEGACY/x", "/other/legacy/x", ""} {
+		if got, ok := aliased(m, path); ok {
+			t.Errorf("%q rewritten to %q", path, got)
+		}
+	}
+}
+
+func TestLoadAliasMapErrors(t *testing.T) {
+	for content, line := range map[string]string{
+		"/a -> /b\n/c\n":                 ":2:",
+		"/a ->\n":                        ":1:",
+		"-> /b\n":                        ":1:",
+		"/a/*/b -> /c\n":                 ":1:",
+		"/a/* -> /b/*/c\n":               ":1:",
+		"/a -> /b/*\n":                   ":1:",
+		"# c\n/a -> /b\n\n/a -> /c\n":    ":4:",
+		"/a/* -> /b\n/a/* -> /b/*\n":     ":2:",
+		"# only comments\n\n   \n":       "no aliases",
+		"/x -> /y\n/x/* -> /z\n/x -> /w": ":3:",
+	} {
+		_, err := loadAliasMap(writeTempFile(t, "aliases.txt", content))
+		if err == nil || !strings.Contains(err.Error(), line) {
+			t.Errorf("%q: error %v, want one with %q", content, err, line)
+		}
+	}
+	if _, err := loadAliasMap(writeTempFile(t, "x", "") + ".missing"); err == nil {
+		t.Error("missing file accepted")
+	}
+}
+
+func TestAliasMapAfterNormalize(t *testing.T) {
+	rules := writeTempFile(t, "rules.txt", "/users/* => /users/{id}\n")
+	aliases := writeTempFile(t, "aliases.txt", "/users/{id} -> /people/{id}\n/old/* -> /new/*\n")
+	path := writeTempFile(t, "access.log", "2024-01-01T00:00:00Z 10.0.0.1 GET /users/1?x=1 200 10\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /users/2 200 30\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /old/a 200 5\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /new/a 200 7\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /kept 200 1\n")
+	for _, workers := range []string{"1", "3"} {
+		out, code := runAnalyzeFile(t, "-strip-query", "-normalize-rules", rules, "-alias-map", aliases, "-include-count", "-workers", workers, path)
+		if code != exitOK {
+			t.Fatalf("-workers %s: exit %d", workers, code)
+		}
+		// Старое и новое имя маршрута складываются в одно
+		for _, want := range []string{`"/people/{id}": {`, `"/new/a": {`, `"min_response_time": 5,`, `"/kept": {`, `"unique_endpoints": 3,`} {
+			if !strings.Contains(out, want) {
+				t.Errorf("-workers %s: %s missing:\n%s", workers, want, out)
+			}
+		}
+	}
+
+	m, err := loadAliasMap(aliases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := runPipeline(context.Background(), path, 1, &processOptions{chunkSize: 1 << 16, aliases: m}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Без -normalize-rules алиас /users/{id} не срабатывает: заменена только /old/a
+	if res.counters.aliased != 1 {
+		t.Errorf("aliased %d, want 1", res.counters.aliased)
+	}
+	if _, code := runAnalyzeFile(t, "-alias-map", path+".missing", path); code != exitUsage {
+		t.Errorf("missing -alias-map file: exit %d, want %d", code, exitUsage)
+	}
+}
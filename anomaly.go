@@ -0,0 +1,61 @@
+package main
+
+const (
+	defaultAnomalyMaxRatio = 20
+	defaultAnomalyCV       = 3
+	defaultAnomalyMinCount = 100
+)
+
+// anomalyLimits - пороги правил -flag-anomalies
+type anomalyLimits struct {
+	// maxRatio - во сколько раз max может превышать avg
+	maxRatio float64
+	// cv - предел stddev / avg (коэффициент вариации)
+	cv float64
+	// minCount - с какого числа запросов одинаковые min и max подозрительны
+	minCount int64
+}
+
+// anomalyRule - одна эвристика -flag-anomalies: name попадает в "flags" эндпоинта,
+// для которого match вернул true. Новое правило - ещё один элемент anomalyRules
+type anomalyRule struct {
+	name  string
+	match func(s *Stats, l *anomalyLimits) bool
+}
+
+// anomalyRules - правила в порядке, в котором их имена идут в "flags"
+var anomalyRules = []anomalyRule{
+	// Редкий очень долгий запрос на фоне быстрых: таймаут, блокировка, холодный кеш
+	{"high_max_ratio", func(s *Stats, l *anomalyLimits) bool {
+		avg := float64(s.Sum) / float64(s.Count)
+		return avg > 0 && float64(s.Max)/avg > l.maxRatio
+	}},
+	// Разброс много больше среднего: у эндпоинта, похоже, несколько режимов работы
+	{"high_stddev", func(s *Stats, l *anomalyLimits) bool {
+		avg := float64(s.Sum) / float64(s.Count)
+		return avg > 0 && s.extra != nil && s.extra.spread != nil && s.extra.spread.stddev()/avg > l.cv
+	}},
+	// Много запросов с одним и тем же временем: закешированная константа или сломанный замер
+	{"constant_time", func(s *Stats, l *anomalyLimits) bool {
+		return s.Count >= l.minCount && s.Min == s.Max
+	}},
+}
+
+// anomalies - имена правил, сработавших для s; nil, если ни одно
+func (l *anomalyLimits) anomalies(s *Stats) []string {
+	var flags []string
+	for _, rule := range anomalyRules {
+		if rule.match(s, l) {
+			flags = append(flags, rule.name)
+		}
+	}
+	return flags
+}
+
+// anomalyLimitsOf - пороги для jsonRenderer; nil без -flag-anomalies
+func anomalyLimitsOf(opts *options) *anomalyLimits {
+	if !opts.flagAnomalies {
+		return nil
+	}
+	return &opts.anomalyLimits
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// spreadStats - Stats значений values вместе с разбросом для high_stddev
+func spreadStats(values ...int64) *Stats {
+	s := &Stats{Min: values[0], Max: values[0], extra: &statsExtra{spread: &welford{}}}
+	for _, v := range values {
+		s.Min, s.Max = min(s.Min, v), max(s.Max, v)
+		s.Sum += v
+		s.Count++
+		s.extra.spread.add(float64(v))
+	}
+	return s
+}
+
+func repeat(v int64, n int) []int64 {
+	values := make([]int64, n)
+	for i := range values {
+		values[i] = v
+	}
+	return values
+}
+
+func TestAnomalyRules(t *testing.T) {
+	defaults := anomalyLimits{maxRatio: defaultAnomalyMaxRatio, cv: defaultAnomalyCV, minCount: defaultAnomalyMinCount}
+	tests := []struct {
+		name   string
+		s      *Stats
+		limits anomalyLimits
+		want   []string
+	}{
+		// avg 10: max ровно в 20 раз больше - ещё не аномалия, порог строгий
+		{"max 20x avg", &Stats{Min: 1, Max: 200, Sum: 100, Count: 10}, defaults, nil},
+		{"max 20.1x avg", &Stats{Min: 1, Max: 201, Sum: 100, Count: 10}, defaults, []string{"high_max_ratio"}},
+		{"max 20.1x avg, -anomaly-max-ratio 25", &Stats{Min: 1, Max: 201, Sum: 100, Count: 10}, anomalyLimits{maxRatio: 25, cv: 3, minCount: 100}, nil},
+		{"max 6x avg, -anomaly-max-ratio 5", &Stats{Min: 1, Max: 60, Sum: 100, Count: 10}, anomalyLimits{maxRatio: 5, cv: 3, minCount: 100}, []string{"high_max_ratio"}},
+		{"avg 0", &Stats{Count: 10}, defaults, nil},
+
+		// avg 1: stddev 2 и sqrt(15) = 3.87
+		{"stddev 2x avg", spreadStats(append(repeat(0, 4), 5)...), defaults, nil},
+		{"stddev 3.87x avg", spreadStats(append(repeat(0, 15), 16)...), defaults, []string{"high_stddev"}},
+		{"stddev 3.87x avg, -anomaly-stddev-ratio 4", spreadStats(append(repeat(0, 15), 16)...), anomalyLimits{maxRatio: 20, cv: 4, minCount: 100}, nil},
+		{"stddev 2x avg, -anomaly-stddev-ratio 1.9", spreadStats(append(repeat(0, 4), 5)...), anomalyLimits{maxRatio: 20, cv: 1.9, minCount: 100}, []string{"high_stddev"}},
+		// Без накопителя разброса high_stddev не проверяется
+		{"no spread", &Stats{Min: 0, Max: 16, Sum: 16, Count: 16}, defaults, nil},
+
+		{"100 equal times", spreadStats(repeat(42, 100)...), defaults, []string{"constant_time"}},
+		{"99 equal times", spreadStats(repeat(42, 99)...), defaults, nil},
+		{"100 times, one differs", spreadStats(append(repeat(42, 99), 43)...), defaults, nil},
+		{"5 equal times, -anomaly-min-count 5", spreadStats(repeat(42, 5)...), anomalyLimits{maxRatio: 20, cv: 3, minCount: 5}, []string{"constant_time"}},
+		{"4 equal times, -anomaly-min-count 5", spreadStats(repeat(42, 4)...), anomalyLimits{maxRatio: 20, cv: 3, minCount: 5}, nil},
+
+		// Имена идут в порядке anomalyRules
+		{"all but constant_time", spreadStats(append(repeat(0, 99), 1000)...), defaults, []string{"high_max_ratio", "high_stddev"}},
+	}
+	for _, tt := range tests {
+		if got := tt.limits.anomalies(tt.s); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFlagAnomaliesOutput(t *testing.T) {
+	var sb strings.Builder
+	line := func(path string, ms int64) {
+		fmt.Fprintf(&sb, "2024-01-01T00:00:00Z 10.0.0.1 GET %s 200 %d\n", path, ms)
+	}
+	// /spiky: 49 запросов по 10ms и один в 1000ms. avg 29.8, max больше него в 33.6 раза,
+	// stddev - в 4.65 раза
+	for range 49 {
+		line("/spiky", 10)
+	}
+	line("/spiky", 1000)
+	for range 100 {
+		line("/constant", 5)
+	}
+	for i := range 20 {
+		line("/normal", int64(10+i))
+	}
+	path := writeTempFile(t, "anomalies.log", sb.String())
+
+	flagsOf := func(args ...string) (map[string][]string, []string) {
+		t.Helper()
+		out, code := runAnalyzeFile(t, append(append([]string{"-flag-anomalies"}, args...), path)...)
+		if code != exitOK {
+			t.Fatalf("%v: exit %d", args, code)
+		}
+		var doc struct {
+			Endpoints map[string]map[string]json.RawMessage `json:"endpoints"`
+			Summary   struct {
+				Flagged []string `json:"flagged_endpoints"`
+			} `json:"summary"`
+		}
+		if err := json.Unmarshal([]byte(out), &doc); err != nil {
+			t.Fatalf("%v\n%s", err, out)
+		}
+		flags := make(map[string][]string)
+		for name, e := range doc.Endpoints {
+			raw, ok := e["flags"]
+			if !ok {
+				continue
+			}
+			var f []string
+			if err := json.Unmarshal(raw, &f); err != nil || len(f) == 0 {
+				t.Errorf("%v: %s has flags %s", args, name, raw)
+			}
+			flags[name] = f
+		}
+		return flags, doc.Summary.Flagged
+	}
+
+	flags, flagged := flagsOf()
+	want := map[string][]string{"/spiky": {"high_max_ratio", "high_stddev"}, "/constant": {"constant_time"}}
+	if !reflect.DeepEqual(flags, want) || !reflect.DeepEqual(flagged, []string{"/constant", "/spiky"}) {
+		t.Errorf("defaults: flags %v, flagged_endpoints %v; want %v", flags, flagged, want)
+	}
+	flags, flagged = flagsOf("-anomaly-max-ratio", "34", "-anomaly-min-count", "101")
+	want = map[string][]string{"/spiky": {"high_stddev"}}
+	if !reflect.DeepEqual(flags, want) || !reflect.DeepEqual(flagged, []string{"/spiky"}) {
+		t.Errorf("-anomaly-*: flags %v, flagged_endpoints %v; want %v", flags, flagged, want)
+	}
+	// Без срабатываний flagged_endpoints - пустой массив, а "flags" нет ни у кого
+	flags, flagged = flagsOf("-anomaly-max-ratio", "34", "-anomaly-min-count", "101", "-anomaly-stddev-ratio", "4.7")
+	if len(flags) != 0 || flagged == nil || len(flagged) != 0 {
+		t.Errorf("nothing flagged: flags %v, flagged_endpoints %v", flags, flagged)
+	}
+
+	for _, bad := range [][]string{
+		{"-anomaly-max-ratio", "0"},
+		{"-anomaly-stddev-ratio", "-1"},
+		{"-anomaly-min-count", "0"},
+		{"-format", "table"},
+	} {
+		if _, code := runAnalyzeFile(t, append(append([]string{"-flag-anomalies"}, bad...), path)...); code != exitUsage {
+			t.Errorf("%v: exit %d, want %d", bad, code, exitUsage)
+		}
+	}
+}
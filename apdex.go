@@ -0,0 +1,46 @@
+package main
+
+// apdexCounts - счётчики Apdex эндпоинта для -apdex-t: satisfied (<= T),
+// tolerating (<= 4T) и frustrated (остальные)
+type apdexCounts struct {
+	satisfied  int64
+	tolerating int64
+	frustrated int64
+}
+
+func (a *apdexCounts) add(t, v int64) {
+	switch {
+	case v <= t:
+		a.satisfied++
+	case v <= 4*t:
+		a.tolerating++
+	default:
+		a.frustrated++
+	}
+}
+
+func (a *apdexCounts) merge(o *apdexCounts) {
+	a.satisfied += o.satisfied
+	a.tolerating += o.tolerating
+	a.frustrated += o.frustrated
+}
+
+// format печатает (satisfied + tolerating/2) / count с двумя знаками. Считаем в целых
+// как (2*satisfied + tolerating) / (2*count), чтобы округление не зависело от float
+func (a *apdexCounts) format() string {
+	count := a.satisfied + a.tolerating + a.frustrated
+	if count == 0 {
+		return formatRatio(0, 1, apdexPrecision)
+	}
+	return formatRatio(2*a.satisfied+a.tolerating, 2*count, apdexPrecision)
+}
+
+const apdexPrecision = 2
+
+// apdexOf - Apdex эндпоинта s; ok = false, если счётчиков нет (без -apdex-t или у merge)
+func apdexOf(s *Stats) (string, bool) {
+	if s == nil || s.extra == nil || s.extra.apdex == nil {
+		return "", false
+	}
+	return s.extra.apdex.format(), true
+}
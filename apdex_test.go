@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestApdexCounts(t *testing.T) {
+	tests := []struct {
+		values []int64
+		want   string
+	}{
+		// Границы включительно: T - satisfied, 4T - tolerating
+		{[]int64{100}, "1.00"},
+		{[]int64{101}, "0.50"},
+		{[]int64{400}, "0.50"},
+		{[]int64{401}, "0.00"},
+		{[]int64{0, 100, 101, 400, 401}, "0.60"},
+		// 1/8 = 0.125 округляется вверх, а не к чётному, как сделал бы float
+		{[]int64{101, 401, 500, 999}, "0.13"},
+		{[]int64{100, 100, 401}, "0.67"},
+		{nil, "0.00"},
+	}
+	for _, tt := range tests {
+		var a apdexCounts
+		for _, v := range tt.values {
+			a.add(100, v)
+		}
+		if got := a.format(); got != tt.want {
+			t.Errorf("apdex of %v = %s, want %s", tt.values, got, tt.want)
+		}
+	}
+
+	// Слияние частей - то же, что подсчёт целиком
+	var whole, left, right apdexCounts
+	for i, v := range []int64{5, 150, 90, 800, 399, 12, 401} {
+		whole.add(100, v)
+		if i%2 == 0 {
+			left.add(100, v)
+		} else {
+			right.add(100, v)
+		}
+	}
+	right.merge(&left)
+	if right != whole {
+		t.Errorf("merged %+v, want %+v", right, whole)
+	}
+}
+
+func TestApdexOutput(t *testing.T) {
+	path := writeTempFile(t, "apdex.log", "2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 100\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 101\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /b 200 101\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 400\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /b 200 401\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 401\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /b 200 500\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /b 200 999\n")
+	for _, workers := range []string{"1", "3"} {
+		out, code := runAnalyzeFile(t, "-apdex-t", "100", "-chunk-size", "64K", "-workers", workers, path)
+		if code != exitOK {
+			t.Fatalf("-workers %s: exit %d", workers, code)
+		}
+		// /a: 1 satisfied, 2 tolerating, 1 frustrated; /b: 0, 1, 3; summary: (2+3)/16
+		// json.Number сохраняет запись числа как есть, с нулём в конце
+		var doc struct {
+			Endpoints map[string]struct {
+				Apdex json.Number `json:"apdex"`
+			} `json:"endpoints"`
+			Summary struct {
+				Apdex json.Number `json:"apdex"`
+			} `json:"summary"`
+		}
+		if err := json.Unmarshal([]byte(out), &doc); err != nil {
+			t.Fatal(err)
+		}
+		if doc.Endpoints["/a"].Apdex != "0.50" || doc.Endpoints["/b"].Apdex != "0.13" || doc.Summary.Apdex != "0.31" {
+			t.Errorf("-workers %s:\n%s", workers, out)
+		}
+	}
+
+	out, _ := runAnalyzeFile(t, "-apdex-t", "100", "-format", "csv", path)
+	if !strings.Contains(out, ",apdex\n") || !strings.Contains(out, "/a,4,50.0,100,250.5,401,0.50\n") || !strings.Contains(out, ",0.13\n") {
+		t.Errorf("csv:\n%s", out)
+	}
+	out, _ = runAnalyzeFile(t, path)
+	if strings.Contains(out, "apdex") {
+		t.Errorf("apdex without -apdex-t:\n%s", out)
+	}
+	for _, bad := range [][]string{{"-apdex-t", "-1"}, {"-apdex-t", "100", "-format", "prom"}} {
+		if _, code := runAnalyzeFile(t, append(bad, path)...); code != exitUsage {
+			t.Errorf("%v: exit %d, want %d", bad, code, exitUsage)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// bucketList - значение флага -buckets: границы гистограммы в миллисекундах по
+// возрастанию, как le у Prometheus. Значения больше последней границы попадают в +Inf
+type bucketList []int64
+
+func (l bucketList) String() string {
+	return formatMsList(l)
+}
+
+func (l *bucketList) Set(s string) error {
+	list, err := parseMsList(s, "bucket boundary", "bucket boundaries")
+	if err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+func formatMsList(l []int64) string {
+	items := make([]string, len(l))
+	for i, v := range l {
+		items[i] = strconv.FormatInt(v, 10)
+	}
+	return strings.Join(items, ",")
+}
+
+// parseMsList разбирает список миллисекунд через запятую: неотрицательные целые строго по
+// возрастанию. what и plural - как называть значения в ошибках
+func parseMsList(s, what, plural string) ([]int64, error) {
+	var list []int64
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(item, 10, 64)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid %s %q: expected a non-negative number of milliseconds", what, item)
+		}
+		if n := len(list); n > 0 && v <= list[n-1] {
+			if v == list[n-1] {
+				return nil, fmt.Errorf("%s %d specified twice", what, v)
+			}
+			return nil, fmt.Errorf("%s must be sorted in ascending order: %d after %d", plural, v, list[n-1])
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+// index - номер корзины для значения v: первая граница, не меньшая v, или len(l) для +Inf.
+// Границ обычно несколько штук, линейный поиск быстрее двоичного
+func (l bucketList) index(v int64) int {
+	for i, b := range l {
+		if v <= b {
+			return i
+		}
+	}
+	return len(l)
+}
+
+// label - граница i в единице u без округления (250ms в s - "0.25"), чтобы
+// близкие границы не слились в одну метку; за последней границей - "+Inf"
+func (l bucketList) label(i int, u timeUnit) string {
+	if i >= len(l) {
+		return "+Inf"
+	}
+	return u.formatExact(l[i])
+}
+
+// cumulative превращает счётчики отдельных корзин в накопительные, как в выводе Prometheus
+func cumulative(counts []int64) []int64 {
+	out := make([]int64, len(counts))
+	var sum int64
+	for i, c := range counts {
+		sum += c
+		out[i] = sum
+	}
+	return out
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBucketList(t *testing.T) {
+	var l bucketList
+	if err := l.Set("10, 250,1000"); err != nil {
+		t.Fatal(err)
+	}
+	// Граница входит в свою корзину, как le у Prometheus
+	for v, want := range map[int64]int{0: 0, 10: 0, 11: 1, 250: 1, 251: 2, 1000: 2, 1001: 3, 1 << 40: 3} {
+		if got := l.index(v); got != want {
+			t.Errorf("index(%d) = %d, want %d", v, got, want)
+		}
+	}
+	var labels []string
+	for i := range len(l) + 1 {
+		labels = append(labels, l.label(i, timeUnits["s"]))
+	}
+	if want := []string{"0.01", "0.25", "1", "+Inf"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("labels in s %v, want %v", labels, want)
+	}
+	if got := cumulative([]int64{1, 0, 2, 3}); !reflect.DeepEqual(got, []int64{1, 1, 3, 6}) {
+		t.Errorf("cumulative = %v", got)
+	}
+	for _, bad := range []string{"100,10", "10,10", "-1", "1ms"} {
+		if err := l.Set(bad); err == nil {
+			t.Errorf("Set(%q) accepted", bad)
+		}
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	tests := []struct {
+		counts []int64
+		width  int
+		want   string
+	}{
+		// Корзин меньше ширины - каждая растягивается на несколько символов
+		{[]int64{1, 2, 1}, 8, "▄▄▄███▄▄"},
+		{[]int64{4, 0, 0, 1}, 4, "█▁▁▂"},
+		// Корзин больше ширины - соседние складываются
+		{[]int64{1, 1, 1, 1}, 2, "██"},
+		{[]int64{3, 1, 0, 0, 0, 2}, 3, "█▁▄"},
+		{[]int64{0, 0}, 2, "▁▁"},
+	}
+	for _, tt := range tests {
+		if got := sparkline(tt.counts, tt.width); got != tt.want {
+			t.Errorf("sparkline(%v, %d) = %s, want %s", tt.counts, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestBucketsOutput(t *testing.T) {
+	path := writeTempFile(t, "buckets.log", "2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 100\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 101\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /b 200 101\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 400\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /b 200 401\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 401\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /b 200 500\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /b 200 999\n")
+	want := map[string]map[string]float64{
+		"/a": {"100": 1, "400": 3, "+Inf": 4},
+		"/b": {"100": 0, "400": 1, "+Inf": 4},
+	}
+	for _, workers := range []string{"1", "3"} {
+		out, code := runAnalyzeFile(t, "-buckets", "100,400", "-chunk-size", "64K", "-workers", workers, path)
+		if code != exitOK {
+			t.Fatalf("-workers %s: exit %d", workers, code)
+		}
+		var doc struct {
+			Endpoints map[string]struct {
+				Buckets map[string]float64 `json:"buckets"`
+			} `json:"endpoints"`
+		}
+		if err := json.Unmarshal([]byte(out), &doc); err != nil {
+			t.Fatal(err)
+		}
+		for name, b := range want {
+			if got := doc.Endpoints[name].Buckets; !reflect.DeepEqual(got, b) {
+				t.Errorf("-workers %s: %s buckets %v, want %v", workers, name, got, b)
+			}
+		}
+		// Границы идут по возрастанию, +Inf последней
+		if got := keyOrder(t, out)["/endpoints//a/buckets"]; !reflect.DeepEqual(got, []string{"100", "400", "+Inf"}) {
+			t.Errorf("-workers %s: bucket order %v", workers, got)
+		}
+	}
+
+	out, _ := runAnalyzeFile(t, "-buckets", "100,400", "-format", "table", "-color", "never", path)
+	if !strings.Contains(out, "DIST") || !strings.Contains(out, "▄▄▄███▄▄") || !strings.Contains(out, "▁▁▁▃▃▃██") {
+		t.Errorf("table:\n%s", out)
+	}
+	out, _ = runAnalyzeFile(t, "-buckets", "100,400", "-format", "table", "-color", "never", "-sparkline-width", "3", path)
+	if !strings.Contains(out, "▄█▄") {
+		t.Errorf("-sparkline-width 3:\n%s", out)
+	}
+	for _, bad := range [][]string{
+		{"-buckets", "400,100"},
+		{"-buckets", "100,400", "-format", "csv"},
+		{"-buckets", "100,400", "-format", "table", "-sparkline-width", "0"},
+		{"-buckets", "100,400", "-format", "table", "-sparkline-width", "65"},
+	} {
+		if _, code := runAnalyzeFile(t, append(bad, path)...); code != exitUsage {
+			t.Errorf("%v: exit %d, want %d", bad, code, exitUsage)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseLineBytes(t *testing.T) {
+	tests := []struct {
+		line  string
+		field int
+		value int64
+		sent  int64
+	}{
+		{"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 10 1000", 7, 10, 1000},
+		// "-" - ответ без тела, ноль байт
+		{"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 10 -", 7, 10, 0},
+		// За размером могут идти другие поля, и с -bytes-field время - только своё поле
+		{`2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 10 512 "curl/8.0"`, 7, 10, 512},
+		{"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 10 x 2048\r", 8, 10, 2048},
+		{"2024-01-01T00:00:00Z  10.0.0.1 GET /a 200   10   77", 7, 10, 77},
+	}
+	for _, tt := range tests {
+		rec, err := parseLineBytes([]byte(tt.line), tt.field, timeIntMillis)
+		if err != nil {
+			t.Errorf("parseLineBytes(%q, %d): %v", tt.line, tt.field, err)
+			continue
+		}
+		if rec.value != tt.value || rec.sentBytes != tt.sent {
+			t.Errorf("parseLineBytes(%q, %d) = %dms, %d bytes; want %dms, %d bytes", tt.line, tt.field, rec.value, rec.sentBytes, tt.value, tt.sent)
+		}
+	}
+
+	for _, bad := range []string{
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 10",
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 10 12x",
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 10 -1",
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 10ms 100",
+	} {
+		if _, err := parseLineBytes([]byte(bad), 7, timeIntMillis); err == nil {
+			t.Errorf("parseLineBytes(%q, 7) accepted", bad)
+		}
+	}
+}
+
+func TestBytesFieldOutput(t *testing.T) {
+	path := writeTempFile(t, "bytes.log", `2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 10 1000 "ua x"`+"\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 20 -\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 30 501\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /b 200 30 12x\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /b 200 30\n")
+	type sizes struct {
+		Total, Min, Max int64
+		Avg             float64
+	}
+	want := sizes{Total: 1501, Min: 0, Max: 1000, Avg: 500.3}
+	for _, args := range [][]string{
+		{"-bytes-field", "7"},
+		{"-bytes-field", "7", "-workers", "3", "-chunk-size", "64K"},
+		{"-fields", "path=4,time=6", "-bytes-field", "7"},
+	} {
+		out, code := runAnalyzeFile(t, append([]string{"-schema-version", "2"}, append(args, path)...)...)
+		if code != exitOK {
+			t.Fatalf("%v: exit %d", args, code)
+		}
+		var doc struct {
+			Endpoints map[string]struct {
+				Bytes *sizes `json:"bytes"`
+			} `json:"endpoints"`
+			Summary struct {
+				Bytes          *sizes `json:"bytes"`
+				MalformedLines int    `json:"malformed_lines"`
+			} `json:"summary"`
+		}
+		if err := json.Unmarshal([]byte(out), &doc); err != nil {
+			t.Fatal(err)
+		}
+		// Строки без размера или с неверным размером - битые, /b не остаётся вовсе
+		if a := doc.Endpoints["/a"].Bytes; a == nil || *a != want || doc.Summary.Bytes == nil || *doc.Summary.Bytes != want {
+			t.Errorf("%v: bytes %+v, summary %+v, want %+v", args, a, doc.Summary.Bytes, want)
+		}
+		if _, ok := doc.Endpoints["/b"]; ok || doc.Summary.MalformedLines != 2 {
+			t.Errorf("%v: /b present or %d malformed lines\n%s", args, doc.Summary.MalformedLines, out)
+		}
+	}
+
+	// С -fields размер может стоять и до времени ответа
+	before := writeTempFile(t, "before.log", "/a 1000 10\n/a 24 30\n")
+	out, code := runAnalyzeFile(t, "-schema-version", "2", "-fields", "path=1,time=3", "-bytes-field", "2", before)
+	var doc struct {
+		Endpoints map[string]struct {
+			Bytes sizes `json:"bytes"`
+		} `json:"endpoints"`
+	}
+	if err := json.Unmarshal([]byte(out), &doc); code != exitOK || err != nil {
+		t.Fatalf("bytes before time: exit %d, %v", code, err)
+	}
+	if got := doc.Endpoints["/a"].Bytes; !reflect.DeepEqual(got, sizes{Total: 1024, Min: 24, Max: 1000, Avg: 512}) {
+		t.Errorf("bytes before time: %+v", got)
+	}
+
+	for _, bad := range [][]string{
+		{"-bytes-field", "7"},
+		{"-schema-version", "2", "-bytes-field", "6"},
+		{"-schema-version", "2", "-bytes-field", "7", "-format", "csv"},
+		{"-schema-version", "2", "-bytes-field", "7", "-paths-may-contain-spaces"},
+		{"-schema-version", "2", "-bytes-field", "4", "-fields", "path=4,time=6"},
+		{"-schema-version", "2", "-bytes-field", "7", "-input-format", "jsonl"},
+		{"-schema-version", "2", "-bytes-field", "7", "-line-format", "{path} {time_ms}"},
+	} {
+		if _, code := runAnalyzeFile(t, append(bad, path)...); code != exitUsage {
+			t.Errorf("%v: exit %d, want %d", bad, code, exitUsage)
+		}
+	}
+}
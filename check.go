@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	defaultCheckSize = 16 * 1024 * 1024
+	defaultCheckTail = 64 * 1024
+
+	maxCheckSamples  = 5
+	maxCheckFailures = 20
+)
+
+// Раскладка полей, которую ожидает parseLine
+var expectedFields = []string{"timestamp", "ip", "method", "path", "status", "response_time"}
+
+type checkSample struct {
+	offset       int64
+	path         string
+	responseTime int64
+}
+
+// checkReport собирает диагностику режима -check вместо статистики по эндпоинтам
+type checkReport struct {
+	samples       []checkSample
+	failures      []*malformedLineError
+	longest       int
+	longestOffset int64
+	// fieldCounts - сколько строк содержит данное количество полей, разделённых пробелами
+	fieldCounts map[int]int64
+}
+
+func newCheckReport() *checkReport {
+	return &checkReport{fieldCounts: make(map[int]int64)}
+}
+
+func (c *checkReport) observe(offset int64, line []byte) {
+	if len(line) > c.longest {
+		c.longest = len(line)
+		c.longestOffset = offset
+	}
+	c.fieldCounts[len(bytes.Fields(line))]++
+}
+
+func (c *checkReport) record(offset int64, line []byte, rec lineRecord) {
+	c.observe(offset, line)
+	if len(c.samples) < maxCheckSamples {
+		c.samples = append(c.samples, checkSample{offset, string(rec.pathOf(line)), rec.value})
+	}
+}
+
+func (c *checkReport) fail(err *malformedLineError, line []byte) {
+	c.observe(err.offset, line)
+	if len(c.failures) < maxCheckFailures {
+		c.failures = append(c.failures, err)
+	}
+}
+
+// runCheck прогоняет через processLines начало и конец файла, ничего не агрегируя,
+// и печатает, что удалось узнать о формате. Возвращает код выхода
+func runCheck(opts *options) int {
+	f, err := os.Open(opts.filePath)
+	if err != nil {
+		logger.errorf("%v", err)
+		return exitError
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		logger.errorf("%v", err)
+		return exitError
+	}
+	size := st.Size()
+
+	lp := &lineProcessor{check: newCheckReport(), format: lineFormatOf(opts), header: opts.headerLines(), comment: commentPrefixOf(opts), maxLine: int(opts.maxLineLength)}
+	var problems []string
+
+	headSize := min(int64(opts.checkSize), size)
+	head := make([]byte, headSize)
+	if _, err := io.ReadFull(f, head); err != nil {
+		logger.errorf("error reading file: %v", err)
+		return exitError
+	}
+	headEnd := headSize
+	if headSize < size {
+		nl := bytes.LastIndexByte(head, '\n')
+		if nl < 0 {
+			problems = append(problems, fmt.Sprintf("no newline in the first %s", humanBytes(headSize)))
+		}
+		headEnd = int64(nl + 1)
+		head = head[:headEnd]
+	}
+	lp.processLines(terminateLine(head))
+
+	tailStart := max(headEnd, size-int64(opts.checkTail))
+	if tailStart < size {
+		tail := make([]byte, size-tailStart)
+		if _, err := f.ReadAt(tail, tailStart); err != nil && err != io.EOF {
+			logger.errorf("error reading file: %v", err)
+			return exitError
+		}
+		// Если начали с середины строки, пропускаем её обрывок
+		if tailStart > headEnd {
+			nl := bytes.IndexByte(tail, '\n')
+			tail = tail[nl+1:]
+			tailStart += int64(nl + 1)
+		}
+		lp.offset = tailStart
+		lp.processLines(terminateLine(tail))
+	}
+
+	c := lp.check
+	fmt.Printf("file:        %s (%s)\n", opts.filePath, humanBytes(size))
+	if tailStart < size {
+		fmt.Printf("checked:     first %s and last %s\n", humanBytes(headEnd), humanBytes(size-tailStart))
+	} else {
+		fmt.Printf("checked:     whole file\n")
+	}
+	fmt.Printf("lines:       %d parsed, %d malformed\n", lp.counters.lines, lp.counters.malformed)
+	fmt.Printf("structure:   %s\n", c.structure(lp.counters.total(), lp.format))
+	fmt.Printf("longest:     %d bytes at offset %d\n", c.longest, c.longestOffset)
+	if len(c.samples) > 0 {
+		fmt.Println("samples:")
+		for _, s := range c.samples {
+			fmt.Printf("  offset %d: path=%q response_time=%d\n", s.offset, s.path, s.responseTime)
+		}
+	}
+	if len(c.failures) > 0 {
+		fmt.Println("failures:")
+		for _, e := range c.failures {
+			fmt.Printf("  %v\n", e)
+		}
+		if n := lp.counters.malformed - int64(len(c.failures)); n > 0 {
+			fmt.Printf("  ... and %d more\n", n)
+		}
+	}
+	for _, p := range problems {
+		fmt.Printf("problem:     %s\n", p)
+	}
+
+	if lp.counters.malformed > 0 || len(problems) > 0 || lp.counters.lines == 0 {
+		fmt.Println("result:      FAIL")
+		return exitError
+	}
+	fmt.Println("result:      OK")
+	return exitOK
+}
+
+// structure описывает, сколько полей в строках и совпадает ли это с ожидаемой раскладкой.
+// С -paths-may-contain-spaces пробелы в path дают лишние поля, и больше шести - тоже
+// нормально; с -fields полей должно хватать до последнего нужного. С -line-format
+// поля находит шаблон, и число полей через пробел ничего не говорит
+func (c *checkReport) structure(total int64, format lineFormat) string {
+	if total == 0 {
+		return "no lines found"
+	}
+	if format.template != nil {
+		return fmt.Sprintf("fields matched by -line-format %q", format.template.text)
+	}
+	if format.combined {
+		return "fields matched by -input-format " + inputFormatCombined
+	}
+	if format.w3c {
+		return "fields named by the #Fields directive"
+	}
+	if format.alb {
+		return "fields matched by -input-format " + inputFormatALB
+	}
+	if format.csv != nil {
+		return fmt.Sprintf("fields read from columns %s", format.csv.columns.mapping)
+	}
+	if format.logfmt != nil {
+		return fmt.Sprintf("fields read from logfmt keys %s", format.logfmt.keys)
+	}
+	if format.json != nil {
+		return fmt.Sprintf("fields read from JSON keys %s", format.json.keys)
+	}
+	counts := make([]int, 0, len(c.fieldCounts))
+	for n := range c.fieldCounts {
+		counts = append(counts, n)
+	}
+	sort.Slice(counts, func(i, j int) bool { return c.fieldCounts[counts[i]] > c.fieldCounts[counts[j]] })
+
+	top := counts[0]
+	desc := fmt.Sprintf("%d space-separated fields in %.1f%% of lines", top, float64(c.fieldCounts[top])*100/float64(total))
+	switch {
+	case format.fields != nil:
+		need := format.fields.wanted[len(format.fields.wanted)-1].index + 1
+		if top >= need {
+			desc += fmt.Sprintf(" (-fields %s)", format.fields.mapping)
+		} else {
+			desc += fmt.Sprintf(", -fields %s needs at least %d", format.fields.mapping, need)
+		}
+	case top == len(expectedFields):
+		desc += fmt.Sprintf(" (%v)", expectedFields)
+	case format.spacedPaths && top > len(expectedFields):
+		desc += fmt.Sprintf(" (%v, spaces in paths)", expectedFields)
+	default:
+		desc += fmt.Sprintf(", expected %d (%v)", len(expectedFields), expectedFields)
+	}
+	for _, n := range counts[1:] {
+		desc += fmt.Sprintf("; %d fields in %d lines", n, c.fieldCounts[n])
+	}
+	return desc
+}
@@ -0,0 +1,803 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	exitOK      = 0
+	exitError   = 1
+	exitUsage   = 2
+	exitTimeout = 3
+	// exitErrorRate - доля битых строк превысила -max-error-rate
+	exitErrorRate = 4
+	// exitRegression - diff нашёл ухудшение сверх порогов -fail-if-*
+	exitRegression = 5
+	// exitSLO - эндпоинты нарушили пороги из -slo
+	exitSLO = 6
+)
+
+const (
+	defaultChunkSize = 32 * 1024 * 1024
+	minChunkSize     = 64 * 1024
+	// defaultMaxLineLength с запасом больше обычной строки лога и меньше пачки
+	defaultMaxLineLength = 64 * 1024
+)
+
+const usageHeader = `Usage: iw_challenge analyze [flags] FILE...
+
+Aggregates min/avg/max response time per endpoint from an access log.
+
+Flags:
+`
+
+type options struct {
+	// filePaths - входные файлы; filePath - первый из них, для режимов с одним файлом
+	filePaths   []string
+	filePath    string
+	configPath  string
+	numWorkers  int
+	outPath     string
+	csvOutPath  string
+	htmlOutPath string
+	// sqliteOutPath - база SQLite, в которую дописывается прогон (-sqlite-out)
+	sqliteOutPath string
+	// parquetOutPath - файл Parquet с эндпоинтами (-parquet-out)
+	parquetOutPath string
+	// compressOutput сжимает весь вывод gzip с уровнем compressLevel; force разрешает сжатое в stdout
+	// и вход, который не похож на текстовый лог
+	compressOutput   bool
+	compressLevel    int
+	force            bool
+	format           string
+	list             bool
+	noHighlights     bool
+	fieldMapPath     string
+	fieldMap         map[string]string
+	metricPrefix     string
+	statsdAddr       string
+	statsdPrefix     string
+	precision        int
+	sharePrecision   int
+	unit             string
+	humanize         bool
+	percentiles      percentileList
+	sketchAccuracy   float64
+	exactPercentiles bool
+	// trimmedMean - доля в процентах, отбрасываемая снизу и сверху для trimmed_avg; 0 - не считать
+	trimmedMean float64
+	stddev      bool
+	geoMean     bool
+	// mode и modeMinShare - -mode и с какой доли запросов (в процентах) он печатается
+	mode         bool
+	modeMinShare float64
+	// globalDistribution - объект "distribution" в summary: квантили и гистограмма всех запросов
+	globalDistribution bool
+	// summarySpan - first_timestamp и last_timestamp лога в summary
+	summarySpan bool
+	// bytesField - номер поля размера ответа, считая с единицы; 0 - без "bytes"
+	bytesField int
+	// spacedPaths - status и время ответа берутся с конца строки, а path - всё между ними и method
+	spacedPaths bool
+	// fields - номера полей строки по -fields; не задан - раскладка по умолчанию
+	fields fieldMapping
+	// lineFormatText - шаблон или пресет -line-format; lineTemplate - он же, скомпилированный
+	lineFormatText string
+	lineTemplate   *lineTemplate
+	// inputFormat - -input-format: default, combined или jsonl
+	inputFormat string
+	// jsonKeys - ключи JSON для полей по -json-keys; не задан - defaultJSONKeys
+	jsonKeys keyMapping
+	// logfmtKeys - ключи logfmt по -logfmt-keys; не задан - defaultLogfmtKeys
+	logfmtKeys keyMapping
+	// unknownPath - строки logfmt без path идут в эндпоинт "(unknown)"
+	unknownPath bool
+	// csvColumns - номера колонок по -csv-columns; не задан - раскладка по умолчанию
+	csvColumns fieldMapping
+	// skipHeader - первая строка каждого файла - заголовок, а не запрос; skipLines - таких
+	// строк несколько
+	skipHeader bool
+	skipLines  int
+	// commentPrefix - строки с этим началом - комментарии, а не запросы; "" - без них
+	commentPrefix string
+	// stripQuery и stripFragment - path обрезается до первого '?' и '#'
+	stripQuery, stripFragment bool
+	// decodePaths - %XX в path раскрываются
+	decodePaths bool
+	// trailingSlash и caseInsensitivePaths - без '/' в конце и в нижнем регистре
+	trailingSlash        bool
+	caseInsensitivePaths bool
+	// normalizeRulesPath и normalizeRules - файл -normalize-rules и правила из него
+	normalizeRulesPath string
+	normalizeRules     []normalizeRule
+	autoNormalize      bool
+	// aliasMapPath и aliases - файл -alias-map и алиасы из него
+	aliasMapPath string
+	aliases      *aliasMap
+	// flagAnomalies и anomalyLimits - -flag-anomalies и пороги его правил
+	flagAnomalies bool
+	anomalyLimits anomalyLimits
+	// captureSlowest - сколько самых медленных запросов эндпоинта печатать в "slowest"
+	captureSlowest int
+	buckets        bucketList
+	// sparklineWidth - ширина спарклайна корзин в table и markdown
+	sparklineWidth  int
+	apdexT          int64
+	uniqueIPs       bool
+	statusBreakdown bool
+	groupBy         groupBy
+	// bucket - ширина интервалов -bucket; 0 - без разбивки по времени
+	bucket       time.Duration
+	maxBuckets   int
+	hllPrecision int
+	// timeLayoutText - -time-layout как задан, timeLayout - он же после разбора
+	timeLayoutText string
+	timeLayout     *timeLayout
+	// timeFormat - -time-format: как записано время ответа в строке
+	timeFormat string
+	maxMemory  byteSize
+	// quantiles собирается из -percentiles, -trimmed-mean, -global-distribution и -sketch-accuracy после проверки флагов
+	quantiles    *quantileSpec
+	sortKey      string
+	desc         bool
+	top          int
+	topOther     bool
+	minCount     int64
+	keepFiltered bool
+	chunkSize    byteSize
+	// maxLineLength - строки длиннее него пропускаются как битые; 0 - без предела
+	maxLineLength byteSize
+	verbose       bool
+	quiet         bool
+	strict        bool
+	maxErrorRate  float64
+	// maxResponseTime - строки с временем ответа больше него (мс) битые; 0 - без предела
+	maxResponseTime int64
+	sloPath         string
+
+	// color и slowThresholds (в мс) управляют подсветкой медленных эндпоинтов в -format table
+	color          string
+	slowThresholds slowList
+	// slowCounts - пороги, по которым считать медленные запросы; задаются только явным
+	// -slow-threshold, иначе nil
+	slowCounts slowList
+
+	headBytes byteSize
+	headLines int64
+
+	explain     string
+	explainLine int64
+
+	check     bool
+	checkSize byteSize
+	checkTail byteSize
+
+	progress   bool
+	stats      bool
+	debugParts bool
+
+	timeout       time.Duration
+	allowPartial  bool
+	flushInterval time.Duration
+
+	perFile bool
+
+	compact       bool
+	includeMeta   bool
+	includeCount  bool
+	schemaVersion int
+	version       bool
+
+	profiles             profileSpec
+	pprofAddr            string
+	blockProfileRate     int
+	mutexProfileFraction int
+}
+
+// errUsage означает, что аргументы некорректны и нужно показать usage
+var errUsage = errors.New("usage error")
+
+func newFlagSet(opts *options, output io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("analyze", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usageHeader)
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&opts.configPath, "config", "", "read option defaults from a JSON or TOML `file`; explicit flags override it")
+	fs.IntVar(&opts.numWorkers, "workers", runtime.NumCPU(), "number of parallel workers")
+	fs.StringVar(&opts.outPath, "o", "-", "write result to `file` instead of stdout (\"-\" means stdout)")
+	fs.StringVar(&opts.csvOutPath, "csv-out", "", "also write the result as CSV to `file`, whatever -format is")
+	fs.StringVar(&opts.htmlOutPath, "html-out", "", "also write a self-contained HTML report to `file`, whatever -format is")
+	fs.StringVar(&opts.parquetOutPath, "parquet-out", "", "also write the endpoints to the Parquet `file`, one row per endpoint (see README for the schema), whatever -format is")
+	fs.StringVar(&opts.sqliteOutPath, "sqlite-out", "", "also add the run to the SQLite database `file` (tables runs and endpoint_stats, created if missing), whatever -format is")
+	fs.BoolVar(&opts.noHighlights, "no-highlights", false, "with -format table, markdown or html, omit the top 5 by avg, max and count above the table")
+	fs.BoolVar(&opts.list, "list", false, "print only endpoint names with request counts, most requested first; same as -format list")
+	fs.BoolVar(&opts.compressOutput, "compress-output", false, "gzip every output file; files named *.gz are compressed without it")
+	fs.IntVar(&opts.compressLevel, "compress-level", defaultCompressLevel, "gzip `level` from 1 (fastest) to 9 (smallest)")
+	fs.BoolVar(&opts.force, "force", false, "allow -compress-output to write to stdout, and read input that does not look like a text log")
+	fs.StringVar(&opts.format, "format", "json", "output format: "+formatNames())
+	fs.StringVar(&opts.color, "color", "auto", "with -format table, highlight slow endpoints: auto (only on a terminal), always, never")
+	fs.Var(&opts.slowThresholds, "slow-threshold", "comma-separated `ms` thresholds, e.g. 200,500,1000: count requests slower than each as \"slow_count\" and \"slow_pct\"; with -color, endpoints whose max exceeds the highest are shown in red")
+	fs.StringVar(&opts.metricPrefix, "metric-prefix", "", "with -format prom, `prefix` for metric names, e.g. iw_")
+	fs.StringVar(&opts.statsdAddr, "statsd-addr", "", "after the run, send per-endpoint metrics to a statsd/DogStatsD agent at `host:port` over UDP")
+	fs.StringVar(&opts.statsdPrefix, "statsd-prefix", "", "with -statsd-addr, `prefix` for metric names, e.g. iw.")
+	fs.IntVar(&opts.precision, "precision", defaultPrecision, "decimal places for avg_response_time (0-6)")
+	fs.IntVar(&opts.sharePrecision, "share-precision", defaultPrecision, "decimal places for the share of traffic in percent (0-6)")
+	fs.BoolVar(&opts.humanize, "humanize", false, "with -format table or markdown, print min/avg/max as durations (1.2s, 183ms, 4µs) instead of numbers in -unit")
+	fs.Var(&opts.percentiles, "percentiles", "comma-separated `list` of percentiles to add to the output, e.g. 50,90,95,99 (estimated with a sketch)")
+	fs.Var(&opts.buckets, "buckets", "comma-separated ascending histogram `boundaries` in ms, e.g. 10,50,100,250,500,1000,5000; counts go to JSON/YAML \"buckets\", prom histograms and a sparkline in table and markdown")
+	fs.IntVar(&opts.sparklineWidth, "sparkline-width", defaultSparklineWidth, "with -buckets, width in `characters` of the distribution sparkline in table and markdown")
+	fs.StringVar(&opts.timeLayoutText, "time-layout", defaultTimeLayout, "Go time `layout` of the timestamp that starts every line; with -schema-version 2 it gives \"first_seen\", \"last_seen\" and \"rps\"")
+	fs.BoolVar(&opts.summarySpan, "summary-span", false, "add \"first_timestamp\" and \"last_timestamp\" of the whole log to the JSON/YAML summary, and below -schema-version 2 also \"duration_seconds\" and \"rps\"")
+	fs.DurationVar(&opts.bucket, "bucket", 0, "also aggregate every endpoint per time `interval` of the line timestamps, e.g. 1m, 5m, 1h, as a \"timeline\" array (JSON and YAML)")
+	fs.IntVar(&opts.maxBuckets, "max-buckets", defaultMaxBuckets, "with -bucket, fail when there are more than `N` (endpoint, interval) pairs")
+	fs.Var(&opts.groupBy, "group-by", "comma-separated `fields` that make the aggregation key: "+strings.Join(groupByFields, ", ")+"; method,path gives keys like \"GET /api/users\"")
+	fs.BoolVar(&opts.statusBreakdown, "status-breakdown", false, "count requests per status class as \"status_2xx\" ... \"status_5xx\" and \"status_unknown\", plus the 4xx and 5xx share as \"error_rate\"")
+	fs.BoolVar(&opts.uniqueIPs, "unique-ips", false, "estimate the number of distinct client IPs per endpoint with HyperLogLog as \"unique_ips\"")
+	fs.IntVar(&opts.hllPrecision, "hll-precision", defaultHLLPrecision, "with -unique-ips, HyperLogLog precision `p`: 2^p one-byte registers per endpoint, error about 1.04/sqrt(2^p)")
+	fs.Int64Var(&opts.apdexT, "apdex-t", 0, "add the Apdex score per endpoint with threshold `T` ms: satisfied <= T, tolerating <= 4T (0 means off)")
+	fs.Float64Var(&opts.trimmedMean, "trimmed-mean", 0, "add the mean without the lowest and highest `P` percent of values per endpoint as \"trimmed_avg\" (exact with -exact-percentiles, otherwise from the sketch; 0 means off)")
+	fs.BoolVar(&opts.globalDistribution, "global-distribution", false, "add a \"distribution\" object to the JSON/YAML summary: min/avg/max, p50/p95/p99 and a histogram over all requests (-buckets boundaries, or 10,50,100,250,500,1000,5000)")
+	fs.IntVar(&opts.bytesField, "bytes-field", 0, "with -schema-version 2, read the response size from field `N` (counted from 1 like awk; the response time is field 6, so usually 7; \"-\" means 0) and add total/min/avg/max as \"bytes\"")
+	fs.Var(&opts.fields, "fields", "1-based positions of the space-separated fields in every line, e.g. ts=1,ip=2,path=7,status=9,time=10; names: "+strings.Join(fieldNames[:], ", ")+"; path and time are required (default "+defaultFieldMapping.String()+")")
+	fs.StringVar(&opts.inputFormat, "input-format", inputFormatDefault, "log line `format`: "+inputFormatDefault+" (six space-separated fields), "+inputFormatCombined+" (Apache/nginx combined log format with nginx $request_time in seconds appended; the timestamp layout defaults to "+clfTimeLayout+"), "+inputFormatJSONL+" (one JSON object per line, keys set by -json-keys), "+inputFormatCSV+" or "+inputFormatTSV+" (comma- or tab-separated columns set by -csv-columns; quoted fields may contain the separator but not newlines), "+inputFormatALB+" (AWS ALB access logs: the three processing times summed, the URL cut to its path, the elb status code), "+inputFormatLogfmt+" (key=value pairs, keys set by -logfmt-keys) or "+inputFormatW3C+" (W3C extended logs of IIS and CDNs: columns from the #Fields directive, cs-uri-stem is the path and time-taken the response time)")
+	fs.Var(&opts.jsonKeys, "json-keys", "with -input-format jsonl, the JSON `keys` of the fields, e.g. path=url,time=duration_ms,status=code; nested keys as dotted paths like http.path; names: "+strings.Join(fieldNames[:], ", ")+"; path and time are required (default "+defaultJSONKeys+")")
+	fs.Var(&opts.logfmtKeys, "logfmt-keys", "with -input-format logfmt, the `keys` of the fields, e.g. path=route,time=dur_ms; names: "+strings.Join(fieldNames[:], ", ")+"; path and time are required (default "+defaultLogfmtKeys+")")
+	fs.BoolVar(&opts.unknownPath, "unknown-path", false, "with -input-format logfmt, count lines without the path key under the endpoint \"(unknown)\" instead of as malformed")
+	fs.Var(&opts.csvColumns, "csv-columns", "with -input-format csv or tsv, 1-based column `positions` of the fields, e.g. path=2,time=5; names: "+strings.Join(fieldNames[:], ", ")+"; path and time are required (default "+defaultFieldMapping.String()+")")
+	fs.BoolVar(&opts.stripQuery, "strip-query", false, "cut every path at the first '?' before aggregation, so /list?page=2 counts as /list; a path that starts with '?' becomes /")
+	fs.BoolVar(&opts.stripFragment, "strip-fragment", false, "cut every path at the first '#' before aggregation; a path that starts with '#' becomes /")
+	fs.BoolVar(&opts.decodePaths, "decode-paths", false, "percent-decode every path before aggregation, so /search%2Fq counts as /search/q; invalid escapes are kept as they are; applied after -strip-query and before -normalize-rules")
+	fs.BoolVar(&opts.trailingSlash, "normalize-trailing-slash", false, "drop one trailing / from every path except \"/\" itself, so /api/users/ counts as /api/users; applied after -strip-query")
+	fs.BoolVar(&opts.caseInsensitivePaths, "case-insensitive-paths", false, "lowercase every path before aggregation, so /API/Users counts as /api/users; only ASCII letters are changed")
+	fs.StringVar(&opts.normalizeRulesPath, "normalize-rules", "", "rewrite paths before aggregation with the rules in `file`, one \"PATTERN => REPLACEMENT\" per line: /users/*/orders/* => /users/{id}/orders/{id} matches by segments, ~ ^/v[0-9]+/(.*)$ => /v{n}/$1 is a regular expression; the first matching rule wins")
+	fs.BoolVar(&opts.autoNormalize, "auto-normalize", false, "replace path segments that are all digits or look like a UUID with {id}, e.g. /users/123 -> /users/{id}; -normalize-rules go first")
+	fs.StringVar(&opts.aliasMapPath, "alias-map", "", "report renamed routes together using the aliases in `file`, one \"OLD -> NEW\" per line: /v1/old-name -> /v2/new-name matches exactly, /legacy/* -> /v2/* replaces the prefix and keeps the rest; applied after -normalize-rules")
+	fs.BoolVar(&opts.skipHeader, "skip-header", false, "ignore the first line of every file, e.g. a CSV header row")
+	fs.IntVar(&opts.skipLines, "skip-lines", 0, "ignore the first `n` non-empty lines of every file, e.g. a multi-line export header")
+	fs.StringVar(&opts.commentPrefix, "comment-prefix", "", "ignore lines starting with `prefix`, e.g. \"#\", anywhere in the file")
+	fs.StringVar(&opts.lineFormatText, "line-format", "", "parse every line with a `template` like '{ts} {ip} \"{method} {path} {proto}\" {status} {time_ms}' or a preset ("+templatePresetNames()+"): text outside braces must match exactly, each {name} takes everything up to the next literal character; known names: ts, ip, method, path, status, time_ms (path and time_ms are required), other names are skipped")
+	fs.BoolVar(&opts.spacedPaths, "paths-may-contain-spaces", false, "take the status and response time from the end of every line and everything between the method and the status as the path, so unencoded spaces in paths do not break the line")
+	fs.BoolVar(&opts.flagAnomalies, "flag-anomalies", false, "mark suspicious endpoints in JSON/YAML with a \"flags\" array and list them in the summary as \"flagged_endpoints\"")
+	fs.Float64Var(&opts.anomalyLimits.maxRatio, "anomaly-max-ratio", defaultAnomalyMaxRatio, "with -flag-anomalies, flag high_max_ratio when max exceeds avg more than `R` times")
+	fs.Float64Var(&opts.anomalyLimits.cv, "anomaly-stddev-ratio", defaultAnomalyCV, "with -flag-anomalies, flag high_stddev when the standard deviation exceeds avg more than `R` times")
+	fs.Int64Var(&opts.anomalyLimits.minCount, "anomaly-min-count", defaultAnomalyMinCount, "with -flag-anomalies, flag constant_time when an endpoint has at least `N` requests and min equals max")
+	fs.IntVar(&opts.captureSlowest, "capture-slowest", 0, "with -schema-version 2, list the `N` slowest requests of every endpoint with their timestamp and client IP as \"slowest\" (0 means off)")
+	fs.BoolVar(&opts.mode, "mode", false, "with -exact-percentiles, add the most frequent response time as \"mode_response_time\" to the JSON/YAML output")
+	fs.Float64Var(&opts.modeMinShare, "mode-min-share", defaultModeMinShare, "with -mode, print the mode only when more than `P` percent of the endpoint's requests have it")
+	fs.BoolVar(&opts.geoMean, "geo-mean", false, "add the geometric mean of response times as \"geo_avg\" to the JSON/YAML output, printed with -precision; 0ms counts as 1ms")
+	fs.BoolVar(&opts.stddev, "stddev", false, "with -schema-version 2, add the standard deviation of response times as \"stddev_response_time\"")
+	fs.BoolVar(&opts.exactPercentiles, "exact-percentiles", false, "compute -percentiles exactly by counting every value up to 60000ms (about 480KB per endpoint); default percentiles 50,95,99")
+	fs.Var(&opts.maxMemory, "max-memory", "with -exact-percentiles, memory `size` for the counters; above it the run falls back to sketches with a warning")
+	fs.Float64Var(&opts.sketchAccuracy, "sketch-accuracy", defaultSketchAccuracy, "relative error of -percentiles estimates; smaller is more accurate but covers a narrower range of times in the same memory")
+	fs.StringVar(&opts.unit, "unit", defaultUnit, "unit for min/avg/max in the output: "+unitNames()+"; s is printed with -precision decimals, us always as integers")
+	fs.StringVar(&opts.sortKey, "sort", "name", "order endpoints by: "+sortKeyNames())
+	fs.BoolVar(&opts.desc, "desc", false, "sort in descending order")
+	fs.IntVar(&opts.top, "top", 0, "output only the first `N` endpoints in -sort order (0 means all)")
+	fs.BoolVar(&opts.topOther, "top-other", false, "with -top, add an \"_other\" entry aggregating the endpoints that were cut")
+	fs.Int64Var(&opts.minCount, "min-count", 0, "drop endpoints with fewer than `K` requests from the output")
+	fs.BoolVar(&opts.keepFiltered, "keep-filtered", false, "with -min-count, add a \"_filtered\" entry aggregating the dropped endpoints")
+	fs.BoolVar(&opts.verbose, "v", false, "verbose: print per-part progress to stderr")
+	fs.BoolVar(&opts.quiet, "q", false, "quiet: print only fatal errors to stderr")
+	fs.BoolVar(&opts.strict, "strict", false, "abort on the first malformed line instead of skipping it")
+	fs.StringVar(&opts.sloPath, "slo", "", "check endpoints against the SLO rules in a JSON or YAML `file`; fail with exit code 6 on violations")
+	fs.Float64Var(&opts.maxErrorRate, "max-error-rate", 1, "fail with exit code 4 when the `fraction` of malformed lines exceeds this value")
+	fs.StringVar(&opts.timeFormat, "time-format", timeFormatIntMs, "how the response time is written: "+timeFormatIntMs+" (integer milliseconds), "+timeFormatSeconds+" (seconds like 0.042) or "+timeFormatAuto+" (an optional ms, s or us suffix; without it an integer is milliseconds and a number with a point is seconds); fractions are rounded half up to whole milliseconds; combined, alb and w3c have their own units")
+	fs.Int64Var(&opts.maxResponseTime, "max-response-time", 0, "count lines whose response time exceeds `ms` as malformed instead of aggregating absurd values (0 means no limit)")
+	fs.Var(&opts.headBytes, "head-bytes", "process only the first `size` bytes of the file, cut at a line boundary (0 means all)")
+	fs.Int64Var(&opts.headLines, "head-lines", 0, "stop after `N` parsed lines in total across all parts; blank, header and comment lines do not count; with several workers the lines come from every part, use -workers 1 for exactly the first N lines (0 means all)")
+	fs.StringVar(&opts.explain, "explain", "", "show how a single log `line` is split into fields and exit; FILE is not needed")
+	fs.Int64Var(&opts.explainLine, "explain-line", 0, "show how line `N` of FILE is split into fields and exit")
+	fs.BoolVar(&opts.check, "check", false, "validate the file format on its head and tail without aggregating")
+	fs.Var(&opts.checkSize, "check-size", "with -check, `size` of the beginning of the file to read")
+	fs.Var(&opts.checkTail, "check-tail", "with -check, `size` of the end of the file to read")
+	fs.BoolVar(&opts.progress, "progress", false, "report bytes processed, throughput and ETA on stderr")
+	fs.BoolVar(&opts.debugParts, "debug-parts", false, "print every part's offset, size, lines, unique endpoints, wall time and throughput, plus the merge time, to stderr")
+	fs.BoolVar(&opts.stats, "stats", false, "print a run summary (duration, throughput, line counts, heap) to stderr")
+	fs.DurationVar(&opts.timeout, "timeout", 0, "abort the run after this `duration`, e.g. 5m (0 means no limit)")
+	fs.BoolVar(&opts.allowPartial, "allow-partial", false, "on -timeout emit the results collected so far marked as partial")
+	fs.DurationVar(&opts.flushInterval, "flush-interval", 0, "rewrite -o with the results so far, marked \"partial\": true, every `duration` (0 means only at the end)")
+	fs.StringVar(&opts.fieldMapPath, "field-map", "", "rename JSON/YAML output fields using a JSON `file` like {\"count\": \"requests\"}")
+	fs.BoolVar(&opts.compact, "compact", false, "with -format json, write the whole document on one line")
+	fs.BoolVar(&opts.includeMeta, "include-meta", false, "add a \"meta\" block with the tool version to the JSON output")
+	fs.BoolVar(&opts.perFile, "per-file", false, "with several FILEs, report every file separately next to the combined result (JSON and YAML only)")
+	fs.BoolVar(&opts.includeCount, "include-count", false, "add the request \"count\" to every endpoint in the JSON output")
+	fs.IntVar(&opts.schemaVersion, "schema-version", defaultSchemaVersion, "JSON output schema: 1, or 2 where \"count\" and \"sum_response_time\" are always present")
+	fs.BoolVar(&opts.version, "version", false, "print version information and exit")
+	fs.Var(opts.profiles, "profile", "comma-separated `kind=path` list of profiles to write; kinds: "+strings.Join(profileKinds, ", "))
+	fs.StringVar(&opts.pprofAddr, "pprof-addr", "", "serve net/http/pprof on this `address` (e.g. :6060) while the run is in progress")
+	fs.IntVar(&opts.blockProfileRate, "block-profile-rate", 1, "runtime.SetBlockProfileRate value used with -profile block=...")
+	fs.IntVar(&opts.mutexProfileFraction, "mutex-profile-fraction", 1, "runtime.SetMutexProfileFraction value used with -profile mutex=...")
+	fs.Var(&opts.chunkSize, "chunk-size", "per-worker read buffer `size`, e.g. 512K, 4M, 64M")
+	fs.Var(&opts.maxLineLength, "max-line-length", "skip lines longer than `size` and count them as malformed instead of buffering them (0 means no limit)")
+	return fs
+}
+
+// parseArgs разбирает аргументы командной строки. Для -h возвращает flag.ErrHelp,
+// для любых некорректных аргументов - errUsage (usage к этому моменту уже напечатан)
+func parseArgs(args []string) (*options, error) {
+	opts, fs, err := parseFlags(args)
+	if err != nil {
+		return nil, err
+	}
+	if opts.version {
+		return opts, nil
+	}
+
+	opts.filePaths = fs.Args()
+	switch {
+	case len(opts.filePaths) == 0 && opts.explain == "":
+		return nil, usageError(fs, "missing FILE argument")
+	case len(opts.filePaths) > 1 && (opts.check || opts.explain != "" || opts.explainLine > 0):
+		return nil, usageError(fs, "-check and -explain take a single FILE, got %d", len(opts.filePaths))
+	case len(opts.filePaths) > 0:
+		opts.filePath = opts.filePaths[0]
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if opts.list {
+		if set["format"] && opts.format != "list" {
+			return nil, usageError(fs, "-list and -format %s are mutually exclusive", opts.format)
+		}
+		opts.format = "list"
+	}
+	// Для списка важнее всего самые частые эндпоинты; явный -sort или -desc это отменяет
+	if opts.format == "list" && !set["sort"] && !set["desc"] {
+		opts.sortKey, opts.desc = "count", true
+	}
+
+	// Порог по умолчанию нужен только подсветке; счётчики - по явному -slow-threshold
+	// и в форматах, которые умеют их печатать
+	if set["slow-threshold"] {
+		switch opts.format {
+		case "json", "yaml", "ndjson", "csv", "table", "markdown":
+			opts.slowCounts = opts.slowThresholds
+		}
+	}
+
+	// У combined и alb свой timestamp; явный -time-layout это отменяет
+	if layout, ok := inputTimeLayouts[opts.inputFormat]; ok && !set["time-layout"] {
+		opts.timeLayoutText = layout
+	}
+
+	if opts.exactPercentiles && len(opts.percentiles) == 0 {
+		opts.percentiles.Set(defaultExactPercentiles)
+	}
+
+	if err := validateOptions(opts); err != nil {
+		return nil, usageError(fs, "%v", err)
+	}
+	opts.timeLayout, _ = compileTimeLayout(opts.timeLayoutText)
+	if opts.lineFormatText != "" {
+		opts.lineTemplate, _ = compileLineTemplate(opts.lineFormatText)
+	}
+	if len(opts.percentiles) > 0 || opts.trimmedMean > 0 || opts.globalDistribution {
+		opts.quantiles = &quantileSpec{opts.percentiles, newSketchMapping(opts.sketchAccuracy), opts.trimmedMean}
+	}
+	if opts.fieldMapPath != "" {
+		m, err := loadFieldMap(opts.fieldMapPath, append(opts.quantiles.fieldNames(), opts.slowCounts.fieldNames()...))
+		if err != nil {
+			return nil, usageError(fs, "%v", err)
+		}
+		opts.fieldMap = m
+	}
+	if opts.normalizeRulesPath != "" {
+		rules, err := loadNormalizeRules(opts.normalizeRulesPath)
+		if err != nil {
+			return nil, usageError(fs, "%v", err)
+		}
+		opts.normalizeRules = rules
+	}
+	if opts.aliasMapPath != "" {
+		aliases, err := loadAliasMap(opts.aliasMapPath)
+		if err != nil {
+			return nil, usageError(fs, "%v", err)
+		}
+		opts.aliases = aliases
+	}
+	return opts, nil
+}
+
+// parseFlags разбирает флаги analyze и подмешивает -config: значения по умолчанию,
+// поверх них файл конфигурации, поверх него явно заданные флаги
+func parseFlags(args []string) (*options, *flag.FlagSet, error) {
+	opts := &options{
+		chunkSize:      defaultChunkSize,
+		maxLineLength:  defaultMaxLineLength,
+		profiles:       profileSpec{},
+		checkSize:      defaultCheckSize,
+		checkTail:      defaultCheckTail,
+		maxMemory:      defaultMaxMemory,
+		slowThresholds: slowList{defaultSlowThreshold},
+		groupBy:        groupBy{defaultGroupBy},
+	}
+	fs := newFlagSet(opts, os.Stderr)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, nil, err
+		}
+		return nil, nil, errUsage
+	}
+	if opts.configPath != "" {
+		if err := applyConfigFile(fs, opts.configPath); err != nil {
+			fmt.Fprintln(fs.Output(), err)
+			return nil, nil, errUsage
+		}
+	}
+	return opts, fs, nil
+}
+
+// parseInterspersed разбирает флаги вперемешку с позиционными аргументами,
+// например "merge a.json b.json -o out.json", и возвращает позиционные
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func usageError(fs *flag.FlagSet, format string, a ...any) error {
+	fmt.Fprintf(fs.Output(), format+"\n", a...)
+	fs.Usage()
+	return errUsage
+}
+
+// Уровень по умолчанию тот же, что у gzip(1) и gzip.DefaultCompression
+const defaultCompressLevel = 6
+
+// headerLines - сколько первых непустых строк файла пропустить: -skip-header - это
+// -skip-lines 1
+func (o *options) headerLines() int {
+	if o.skipHeader {
+		return 1
+	}
+	return o.skipLines
+}
+
+// commentPrefixOf - -comment-prefix в байтах для lineProcessor; nil, если не задан
+func commentPrefixOf(opts *options) []byte {
+	if opts.commentPrefix == "" {
+		return nil
+	}
+	return []byte(opts.commentPrefix)
+}
+
+// gzipLevel - уровень сжатия для файла path: с -compress-output сжимается всё, без него
+// только файлы с суффиксом .gz; 0 - без сжатия
+func (o *options) gzipLevel(path string) int {
+	if o.compressOutput || strings.HasSuffix(path, ".gz") {
+		return o.compressLevel
+	}
+	return 0
+}
+
+// validateOptions проверяет значения флагов, не зависящие от позиционных аргументов
+func validateOptions(opts *options) error {
+	if opts.numWorkers < 1 {
+		return fmt.Errorf("invalid -workers value %d: must be >= 1", opts.numWorkers)
+	}
+	if opts.verbose && opts.quiet {
+		return errors.New("-v and -q are mutually exclusive")
+	}
+	if opts.maxErrorRate < 0 || opts.maxErrorRate > 1 {
+		return fmt.Errorf("invalid -max-error-rate %v: must be between 0 and 1", opts.maxErrorRate)
+	}
+	if opts.maxResponseTime < 0 {
+		return fmt.Errorf("invalid -max-response-time %d: must not be negative", opts.maxResponseTime)
+	}
+	if opts.timeout < 0 {
+		return fmt.Errorf("invalid -timeout %v: must not be negative", opts.timeout)
+	}
+	if opts.flushInterval < 0 {
+		return fmt.Errorf("invalid -flush-interval %v: must not be negative", opts.flushInterval)
+	}
+	if opts.flushInterval > 0 {
+		// Снимки заменяют файл целиком, в stdout они шли бы друг за другом
+		if opts.outPath == "" || opts.outPath == "-" {
+			return errors.New("-flush-interval requires -o FILE")
+		}
+		if opts.format != "json" && opts.format != "yaml" && opts.format != "ndjson" {
+			return fmt.Errorf("-flush-interval is only supported with -format json, yaml or ndjson, not %q", opts.format)
+		}
+	}
+	if opts.compressLevel < gzip.BestSpeed || opts.compressLevel > gzip.BestCompression {
+		return fmt.Errorf("invalid -compress-level %d: must be between %d and %d", opts.compressLevel, gzip.BestSpeed, gzip.BestCompression)
+	}
+	if opts.compressOutput && !opts.force {
+		// Сжатые данные в терминале бесполезны; в конвейер их можно отправить с -force
+		if opts.outPath == "" || opts.outPath == "-" || opts.csvOutPath == "-" || opts.htmlOutPath == "-" {
+			return errors.New("-compress-output refuses to write to stdout, use -o FILE or -force")
+		}
+	}
+	if opts.sqliteOutPath == "-" {
+		return errors.New("-sqlite-out needs a database file, not stdout")
+	}
+	if opts.parquetOutPath == "-" {
+		return errors.New("-parquet-out needs a file, not stdout")
+	}
+	if opts.chunkSize < minChunkSize {
+		return fmt.Errorf("invalid -chunk-size %s: must be at least %s", opts.chunkSize, byteSize(minChunkSize))
+	}
+	if opts.skipLines < 0 {
+		return fmt.Errorf("invalid -skip-lines %d: must not be negative", opts.skipLines)
+	}
+	if opts.skipHeader && opts.skipLines > 0 {
+		return errors.New("-skip-header and -skip-lines are mutually exclusive")
+	}
+	if opts.explain != "" && opts.explainLine > 0 {
+		return errors.New("-explain and -explain-line are mutually exclusive")
+	}
+	if opts.explainLine < 0 {
+		return fmt.Errorf("invalid -explain-line %d: must be positive", opts.explainLine)
+	}
+	if opts.headLines < 0 {
+		return fmt.Errorf("invalid -head-lines %d: must not be negative", opts.headLines)
+	}
+	if opts.checkSize <= 0 {
+		return fmt.Errorf("invalid -check-size %s: must be positive", opts.checkSize)
+	}
+	if opts.precision < 0 || opts.precision > maxPrecision {
+		return fmt.Errorf("invalid -precision %d: must be between 0 and %d", opts.precision, maxPrecision)
+	}
+	if opts.sharePrecision < 0 || opts.sharePrecision > maxPrecision {
+		return fmt.Errorf("invalid -share-precision %d: must be between 0 and %d", opts.sharePrecision, maxPrecision)
+	}
+	if opts.top < 0 {
+		return fmt.Errorf("invalid -top %d: must not be negative", opts.top)
+	}
+	if opts.minCount < 0 {
+		return fmt.Errorf("invalid -min-count %d: must not be negative", opts.minCount)
+	}
+	if opts.fieldMapPath != "" && opts.format != "json" && opts.format != "yaml" {
+		return fmt.Errorf("-field-map is only supported with -format json or yaml, not %q", opts.format)
+	}
+	if opts.humanize && opts.format != "table" && opts.format != "markdown" {
+		return fmt.Errorf("-humanize is only supported with -format table or markdown, not %q", opts.format)
+	}
+	if opts.sketchAccuracy <= 0 || opts.sketchAccuracy > maxSketchAccuracy {
+		return fmt.Errorf("invalid -sketch-accuracy %v: must be in (0, %v]", opts.sketchAccuracy, maxSketchAccuracy)
+	}
+	if opts.maxMemory <= 0 {
+		return fmt.Errorf("invalid -max-memory %s: must be positive", opts.maxMemory)
+	}
+	if len(opts.percentiles) > 0 {
+		switch opts.format {
+		case "json", "yaml", "ndjson", "csv", "table", "markdown":
+		default:
+			return fmt.Errorf("-percentiles is only supported with -format json, yaml, ndjson, csv, table or markdown, not %q", opts.format)
+		}
+	}
+	if _, err := compileTimeLayout(opts.timeLayoutText); err != nil {
+		return err
+	}
+	// Без шаблона и combined timestamp - поле через пробел, и пробела в нём быть не может
+	if opts.lineFormatText == "" && opts.inputFormat == inputFormatDefault && strings.Contains(opts.timeLayoutText, " ") {
+		return fmt.Errorf("invalid time layout %q: must not contain spaces, the timestamp is a space-separated field", opts.timeLayoutText)
+	}
+	if opts.bucket != 0 {
+		if !validBucketWidth(opts.bucket) {
+			return fmt.Errorf("invalid -bucket %v: must be a positive whole number of milliseconds", opts.bucket)
+		}
+		if opts.format != "json" && opts.format != "yaml" {
+			return fmt.Errorf("-bucket is only supported with -format json or yaml, not %q", opts.format)
+		}
+	}
+	if opts.maxBuckets <= 0 {
+		return fmt.Errorf("invalid -max-buckets %d: must be positive", opts.maxBuckets)
+	}
+	if opts.hllPrecision < minHLLPrecision || opts.hllPrecision > maxHLLPrecision {
+		return fmt.Errorf("invalid -hll-precision %d: must be between %d and %d", opts.hllPrecision, minHLLPrecision, maxHLLPrecision)
+	}
+	// Поля необязательных накопителей умеют печатать только эти форматы
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{{"-apdex-t", opts.apdexT > 0}, {"-unique-ips", opts.uniqueIPs}, {"-status-breakdown", opts.statusBreakdown}, {"-trimmed-mean", opts.trimmedMean > 0}} {
+		if !f.set {
+			continue
+		}
+		switch opts.format {
+		case "json", "yaml", "ndjson", "csv", "table", "markdown":
+		default:
+			return fmt.Errorf("%s is only supported with -format json, yaml, ndjson, csv, table or markdown, not %q", f.name, opts.format)
+		}
+	}
+	if opts.apdexT < 0 {
+		return fmt.Errorf("invalid -apdex-t %d: must not be negative", opts.apdexT)
+	}
+	if !(opts.trimmedMean >= 0 && opts.trimmedMean < 50) {
+		return fmt.Errorf("invalid -trimmed-mean %v: must be in [0, 50)", opts.trimmedMean)
+	}
+	if len(opts.buckets) > 0 {
+		switch opts.format {
+		case "json", "yaml", "prom", "table", "markdown":
+		default:
+			return fmt.Errorf("-buckets is only supported with -format json, yaml, prom, table or markdown, not %q", opts.format)
+		}
+	}
+	if opts.sparklineWidth < 1 || opts.sparklineWidth > maxSparklineWidth {
+		return fmt.Errorf("invalid -sparkline-width %d: must be between 1 and %d", opts.sparklineWidth, maxSparklineWidth)
+	}
+	if opts.stddev && (opts.schemaVersion < 2 || (opts.format != "json" && opts.format != "yaml")) {
+		return errors.New("-stddev requires -schema-version 2 and -format json or yaml")
+	}
+	if opts.globalDistribution && opts.format != "json" && opts.format != "yaml" {
+		return fmt.Errorf("-global-distribution is only supported with -format json or yaml, not %q", opts.format)
+	}
+	if opts.summarySpan && opts.format != "json" && opts.format != "yaml" {
+		return fmt.Errorf("-summary-span is only supported with -format json or yaml, not %q", opts.format)
+	}
+	if err := validateFields(opts); err != nil {
+		return err
+	}
+	if opts.bytesField != 0 {
+		// С пробелами в path номера полей после него не постоянны
+		if opts.spacedPaths {
+			return errors.New("-bytes-field cannot be combined with -paths-may-contain-spaces")
+		}
+		switch {
+		case !opts.fields.isSet() && opts.bytesField <= responseTimeField:
+			return fmt.Errorf("invalid -bytes-field %d: must come after the response time, field %d", opts.bytesField, responseTimeField)
+		case opts.fields.isSet() && (opts.bytesField < 1 || opts.bytesField > maxFieldIndex):
+			return fmt.Errorf("invalid -bytes-field %d: must be from 1 to %d", opts.bytesField, maxFieldIndex)
+		case opts.fields.isSet() && slices.Contains(opts.fields[:], opts.bytesField):
+			return fmt.Errorf("invalid -bytes-field %d: -fields already uses it", opts.bytesField)
+		}
+		// -check и -explain только разбирают строки, им вывод "bytes" не нужен
+		inspect := opts.check || opts.explain != "" || opts.explainLine > 0
+		if !inspect && (opts.schemaVersion < 2 || (opts.format != "json" && opts.format != "yaml")) {
+			return errors.New("-bytes-field requires -schema-version 2 and -format json or yaml")
+		}
+	}
+	if opts.flagAnomalies && opts.format != "json" && opts.format != "yaml" {
+		return fmt.Errorf("-flag-anomalies is only supported with -format json or yaml, not %q", opts.format)
+	}
+	if !(opts.anomalyLimits.maxRatio > 0) {
+		return fmt.Errorf("invalid -anomaly-max-ratio %v: must be positive", opts.anomalyLimits.maxRatio)
+	}
+	if !(opts.anomalyLimits.cv > 0) {
+		return fmt.Errorf("invalid -anomaly-stddev-ratio %v: must be positive", opts.anomalyLimits.cv)
+	}
+	if opts.anomalyLimits.minCount < 1 {
+		return fmt.Errorf("invalid -anomaly-min-count %d: must be positive", opts.anomalyLimits.minCount)
+	}
+	if opts.mode {
+		if !opts.exactPercentiles {
+			return errors.New("-mode requires -exact-percentiles")
+		}
+		if opts.format != "json" && opts.format != "yaml" {
+			return fmt.Errorf("-mode is only supported with -format json or yaml, not %q", opts.format)
+		}
+	}
+	if !(opts.modeMinShare >= 0 && opts.modeMinShare <= 100) {
+		return fmt.Errorf("invalid -mode-min-share %v: must be between 0 and 100", opts.modeMinShare)
+	}
+	if opts.geoMean && opts.format != "json" && opts.format != "yaml" {
+		return fmt.Errorf("-geo-mean is only supported with -format json or yaml, not %q", opts.format)
+	}
+	if opts.captureSlowest < 0 {
+		return fmt.Errorf("invalid -capture-slowest %d: must not be negative", opts.captureSlowest)
+	}
+	if opts.captureSlowest > 0 && (opts.schemaVersion < 2 || (opts.format != "json" && opts.format != "yaml")) {
+		return errors.New("-capture-slowest requires -schema-version 2 and -format json or yaml")
+	}
+	if opts.perFile {
+		if opts.format != "json" && opts.format != "yaml" {
+			return fmt.Errorf("-per-file is only supported with -format json or yaml, not %q", opts.format)
+		}
+		seen := make(map[string]bool, len(opts.filePaths))
+		for _, path := range opts.filePaths {
+			if seen[path] {
+				return fmt.Errorf("-per-file: %s is given twice", path)
+			}
+			seen[path] = true
+		}
+	}
+	if opts.schemaVersion < 1 || opts.schemaVersion > maxSchemaVersion {
+		return fmt.Errorf("invalid -schema-version %d: must be between 1 and %d", opts.schemaVersion, maxSchemaVersion)
+	}
+	if opts.statsdPrefix != "" && !statsdPrefixRe.MatchString(opts.statsdPrefix) {
+		return fmt.Errorf("invalid -statsd-prefix %q: must start with a letter and contain only letters, digits, _ and .", opts.statsdPrefix)
+	}
+	if _, ok := timeUnits[opts.unit]; !ok {
+		return fmt.Errorf("unknown -unit %q: expected one of %s", opts.unit, unitNames())
+	}
+	if _, ok := timeFormats[opts.timeFormat]; !ok {
+		return fmt.Errorf("unknown -time-format %q: expected one of %s", opts.timeFormat, timeFormatNames())
+	}
+	switch opts.inputFormat {
+	case inputFormatCombined, inputFormatALB, inputFormatW3C:
+		if opts.timeFormat != timeFormatIntMs {
+			return fmt.Errorf("-time-format cannot be used with -input-format %s: its response time unit is fixed", opts.inputFormat)
+		}
+	}
+	if !slices.Contains(colorModes, opts.color) {
+		return fmt.Errorf("unknown -color %q: expected one of %s", opts.color, strings.Join(colorModes, ", "))
+	}
+	if len(opts.slowThresholds) == 0 {
+		return errors.New("-slow-threshold needs at least one threshold")
+	}
+	if opts.metricPrefix != "" && !metricNameRe.MatchString(opts.metricPrefix) {
+		return fmt.Errorf("invalid -metric-prefix %q: must match %s", opts.metricPrefix, metricNameRe)
+	}
+	if !validSortKey(opts.sortKey) {
+		return fmt.Errorf("unknown -sort %q: expected one of %s", opts.sortKey, sortKeyNames())
+	}
+	if _, ok := renderers[opts.format]; !ok {
+		return fmt.Errorf("unknown -format %q: expected one of %s", opts.format, formatNames())
+	}
+	return nil
+}
+
+// byteSize - значение флага с суффиксами K/M/G (степени 1024), например 512K или 64M
+type byteSize int64
+
+func (b byteSize) String() string {
+	v := int64(b)
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}} {
+		if v != 0 && v%u.size == 0 {
+			return strconv.FormatInt(v/u.size, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	v, err := parseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = byteSize(v)
+	return nil
+}
+
+func parseByteSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(str, "B")
+	mult := int64(1)
+	if n := len(str); n > 0 {
+		switch str[n-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		}
+		if mult != 1 {
+			str = str[:n-1]
+		}
+	}
+	v, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q: expected a number with optional K, M or G suffix", s)
+	}
+	if v > math.MaxInt64/mult {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return v * mult, nil
+}
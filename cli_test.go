@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"512K", 512 << 10},
+		{"4M", 4 << 20},
+		{"64m", 64 << 20},
+		{"1G", 1 << 30},
+		{"4MB", 4 << 20},
+		{" 2k ", 2 << 10},
+		{"65536", 65536},
+		{"0", 0},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "M", "4X", "-1M", "1.5M", "K4", "99999999999G"} {
+		if got, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize(%q) = %d, want an error", in, got)
+		}
+	}
+}
+
+func TestChunkSizeMinimum(t *testing.T) {
+	if _, err := parseArgs([]string{"-chunk-size", "32K", "a.log"}); err == nil {
+		t.Error("-chunk-size 32K accepted, want an error below 64K")
+	}
+	opts, err := parseArgs([]string{"-chunk-size", "64K", "a.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.chunkSize != 64<<10 {
+		t.Errorf("chunkSize = %d, want %d", opts.chunkSize, 64<<10)
+	}
+}
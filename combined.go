@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+)
+
+// Строка combined: `IP - user [timestamp] "METHOD /path HTTP/1.1" status bytes "referer" "ua" request_time`,
+// где request_time - $request_time nginx, секунды с миллисекундами после точки
+const (
+	// inputFormatDefault - шесть полей через пробел, inputFormatCombined - -input-format combined
+	inputFormatDefault  = "default"
+	inputFormatCombined = "combined"
+	// clfTimeLayout - раскладка timestamp в квадратных скобках CLF, -time-layout по умолчанию для combined
+	clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+)
+
+var inputFormats = []string{inputFormatDefault, inputFormatCombined, inputFormatJSONL, inputFormatCSV, inputFormatTSV, inputFormatALB, inputFormatW3C, inputFormatLogfmt}
+
+// inputTimeLayouts - -time-layout по умолчанию для форматов со своим timestamp
+var inputTimeLayouts = map[string]string{inputFormatCombined: clfTimeLayout, inputFormatALB: albTimeLayout, inputFormatW3C: w3cTimeLayout}
+
+// parseLineCombined разбирает строку combined. Поля в кавычках читаются с учётом
+// экранирования \", так что кавычки внутри referer и user agent не сдвигают
+// следующие поля; path - всё между первым и последним пробелом запроса
+func parseLineCombined(line []byte) (lineRecord, error) {
+	var rec lineRecord
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	ip, ok := nextField(line, 0)
+	if !ok {
+		return rec, &lineParseError{len(line), fmt.Errorf("expected a client IP")}
+	}
+	rec.ip = ip
+	// ident и user
+	i := ip.end
+	for range 2 {
+		f, ok := nextField(line, i)
+		if !ok {
+			return rec, &lineParseError{len(line), fmt.Errorf("expected ident and user after the client IP")}
+		}
+		i = f.end
+	}
+
+	i = skipBlanks(line, i)
+	if i >= len(line) || line[i] != '[' {
+		return rec, &lineParseError{i, fmt.Errorf("expected [timestamp]")}
+	}
+	end := bytes.IndexByte(line[i:], ']')
+	if end < 0 {
+		return rec, &lineParseError{len(line), fmt.Errorf("unclosed [ of the timestamp")}
+	}
+	rec.timestamp = span{i + 1, i + end}
+	i += end + 1
+
+	request, err := quotedField(line, skipBlanks(line, i), "request")
+	if err != nil {
+		return rec, err
+	}
+	// Запрос "METHOD /path HTTP/1.1"; у HTTP/0.9 протокола нет
+	inner := line[request.start:request.end]
+	sp := bytes.IndexByte(inner, ' ')
+	if sp <= 0 {
+		return rec, &lineParseError{request.start, fmt.Errorf("expected \"METHOD /path PROTOCOL\" in the request")}
+	}
+	rec.method = span{request.start, request.start + sp}
+	path := span{request.start + sp + 1, request.end}
+	if last := bytes.LastIndexByte(inner, ' '); last > sp {
+		path.end = request.start + last
+	}
+	if path.start == path.end {
+		return rec, &lineParseError{path.start, fmt.Errorf("empty path")}
+	}
+	rec.path = path
+	i = request.end + 1
+
+	status, ok := nextField(line, i)
+	if !ok {
+		return rec, &lineParseError{len(line), fmt.Errorf("expected a status after the request")}
+	}
+	rec.status = status
+	// Размер ответа
+	sent, ok := nextField(line, status.end)
+	if !ok {
+		return rec, &lineParseError{len(line), fmt.Errorf("expected the response size after the status")}
+	}
+	i = sent.end
+	for _, name := range []string{"referer", "user agent"} {
+		q, err := quotedField(line, skipBlanks(line, i), name)
+		if err != nil {
+			return rec, err
+		}
+		i = q.end + 1
+	}
+
+	t, ok := nextField(line, i)
+	if !ok {
+		return rec, &lineParseError{len(line), fmt.Errorf("expected the request time after the user agent")}
+	}
+	if rest := skipBlanks(line, t.end); rest != len(line) {
+		return rec, &lineParseError{rest, fmt.Errorf("unexpected text after the request time")}
+	}
+	rec.responseTime = t
+	value, bad, err := parseSeconds(line[t.start:t.end])
+	if err != nil {
+		return rec, &lineParseError{t.start + bad, err}
+	}
+	rec.value = value
+	return rec, nil
+}
+
+// skipBlanks - первый байт с from, который не пробел и не табуляция
+func skipBlanks(line []byte, from int) int {
+	for from < len(line) && isBlank(line[from]) {
+		from++
+	}
+	return from
+}
+
+// quotedField читает строку в кавычках с line[from] и возвращает её содержимое без
+// кавычек; name - что это за поле, для ошибки. Обратная косая экранирует следующий
+// байт, так что \" кавычку не закрывает
+func quotedField(line []byte, from int, name string) (span, error) {
+	if from >= len(line) || line[from] != '"' {
+		return span{}, &lineParseError{from, fmt.Errorf("expected the %s in quotes", name)}
+	}
+	for i := from + 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			return span{from + 1, i}, nil
+		}
+	}
+	return span{}, &lineParseError{len(line), fmt.Errorf("unclosed quote of the %s", name)}
+}
+
+// parseSeconds переводит секунды вида 0.123 в миллисекунды. Цифры после третьей
+// округляют до ближайшей миллисекунды; bad - байт b, на котором разбор сломался
+func parseSeconds(b []byte) (ms int64, bad int, err error) {
+	return parseScaled(b, 3)
+}
+
+// parseScaled читает неотрицательное десятичное число b в единицах 10^-digits:
+// лишние цифры после точки округляют по первой из них
+func parseScaled(b []byte, digits int) (v int64, bad int, err error) {
+	var whole, frac int64
+	i := 0
+	for ; i < len(b) && b[i] >= '0' && b[i] <= '9'; i++ {
+		whole = whole*10 + int64(b[i]-'0')
+	}
+	if i == 0 {
+		return 0, 0, fmt.Errorf("invalid time %q: expected a number like 0.123", b)
+	}
+	if i > maxFastDigits {
+		return 0, 0, fmt.Errorf("time %q overflows int64", b)
+	}
+	n := 0
+	if i < len(b) && b[i] == '.' {
+		i++
+		start := i
+		for ; i < len(b) && b[i] >= '0' && b[i] <= '9'; i++ {
+			switch {
+			case n < digits:
+				frac = frac*10 + int64(b[i]-'0')
+				n++
+			case i == start+digits && b[i] >= '5':
+				frac++
+			}
+		}
+		if i == start {
+			return 0, i, fmt.Errorf("invalid time %q: expected digits after the point", b)
+		}
+	}
+	if i != len(b) {
+		return 0, i, fmt.Errorf("invalid time %q: expected a number like 0.123", b)
+	}
+	scale := int64(1)
+	for range digits {
+		scale *= 10
+	}
+	for ; n < digits; n++ {
+		frac *= 10
+	}
+	if whole > (math.MaxInt64-frac)/scale {
+		return 0, 0, fmt.Errorf("time %q overflows int64", b)
+	}
+	return whole*scale + frac, 0, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// nginxCombined - строки в том виде, в каком их пишет nginx с
+// log_format combined '... "$http_user_agent" $request_time'
+var nginxCombined = []struct {
+	line                 string
+	method, path, status string
+	ms                   int64
+}{
+	{`203.0.113.7 - - [10/Oct/2024:13:55:36 +0000] "GET /api/users HTTP/1.1" 200 612 "-" "curl/8.5.0" 0.123`,
+		"GET", "/api/users", "200", 123},
+	{`198.51.100.23 - alice [10/Oct/2024:13:55:37 +0000] "POST /api/orders?id=7 HTTP/2.0" 201 1043 "https://example.com/cart" "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0 Safari/537.36" 1.004`,
+		"POST", "/api/orders?id=7", "201", 1004},
+	// Экранированные кавычки в referer и user agent не сдвигают request_time
+	{`2001:db8::1 - - [10/Oct/2024:13:55:38 +0300] "GET /search?q=%22x%22 HTTP/1.1" 200 5 "https://example.com/?q=\"x\"" "bot \"v2\" (+https://example.com/bot)" 0.000`,
+		"GET", "/search?q=%22x%22", "200", 0},
+	{`192.0.2.1 - - [10/Oct/2024:13:55:39 -0700] "HEAD / HTTP/1.0" 304 0 "-" "-" 0.0005`,
+		"HEAD", "/", "304", 1},
+	// HTTP/0.9: протокола нет, path - весь остаток запроса
+	{`192.0.2.2 - - [10/Oct/2024:13:55:40 +0000] "GET /old" 200 12 "-" "-" 0.010`,
+		"GET", "/old", "200", 10},
+}
+
+func TestParseLineCombined(t *testing.T) {
+	for _, tt := range nginxCombined {
+		rec, err := parseLineCombined([]byte(tt.line))
+		if err != nil {
+			t.Errorf("%s: %v", tt.line, err)
+			continue
+		}
+		l := tt.line
+		got := [3]string{l[rec.method.start:rec.method.end], l[rec.path.start:rec.path.end], l[rec.status.start:rec.status.end]}
+		if got != [3]string{tt.method, tt.path, tt.status} || rec.value != tt.ms {
+			t.Errorf("%s:\ngot %q and %dms, want %q %q %q and %dms", tt.line, got, rec.value, tt.method, tt.path, tt.status, tt.ms)
+		}
+	}
+
+	for _, bad := range []string{
+		// Рукопожатие TLS на порт с HTTP: nginx пишет байты запроса как есть
+		`192.0.2.3 - - [10/Oct/2024:13:55:41 +0000] "\x16\x03\x01\x00\xa5\x01\x00" 400 157 "-" "-" 0.001`,
+		`192.0.2.3 - - [10/Oct/2024:13:55:41 +0000] "GET /a HTTP/1.1" 200 1 "-" "-"`,
+		`192.0.2.3 - - [10/Oct/2024:13:55:41 +0000] "GET /a HTTP/1.1" 200 1 "-" "unclosed 0.001`,
+		`192.0.2.3 - - 10/Oct/2024:13:55:41 +0000 "GET /a HTTP/1.1" 200 1 "-" "-" 0.001`,
+		`192.0.2.3 - - [10/Oct/2024:13:55:41 +0000] "GET /a HTTP/1.1" 200 1 "-" "-" 0.001 extra`,
+		`192.0.2.3 - - [10/Oct/2024:13:55:41 +0000] "GET /a HTTP/1.1" 200 1 "-" "-" 1e3`,
+	} {
+		if _, err := parseLineCombined([]byte(bad)); err == nil {
+			t.Errorf("accepted %s", bad)
+		}
+	}
+}
+
+func TestCombinedRoundTrip(t *testing.T) {
+	var log string
+	for _, tt := range nginxCombined {
+		log += tt.line + "\n"
+	}
+	path := writeTempFile(t, "access.log", log+"not a combined line\n")
+	out, code := runAnalyzeFile(t, "-input-format", "combined", "-schema-version", "2", path)
+	if code != exitOK {
+		t.Fatalf("exit %d", code)
+	}
+	var doc struct {
+		Endpoints map[string]struct {
+			Count int64 `json:"count"`
+			Max   int64 `json:"max_response_time"`
+		} `json:"endpoints"`
+		Summary map[string]any `json:"summary"`
+	}
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if len(doc.Endpoints) != len(nginxCombined) {
+		t.Errorf("%d endpoints, want %d:\n%s", len(doc.Endpoints), len(nginxCombined), out)
+	}
+	for _, tt := range nginxCombined {
+		if e := doc.Endpoints[tt.path]; e.Count != 1 || e.Max != tt.ms {
+			t.Errorf("%s = %+v, want one request of %dms", tt.path, e, tt.ms)
+		}
+	}
+	// Timestamp в скобках разбирается раскладкой CLF с её смещением зоны
+	want := map[string]any{
+		"first_seen":      "2024-10-10T10:55:38Z",
+		"last_seen":       "2024-10-10T20:55:39Z",
+		"malformed_lines": 1.0,
+	}
+	for k, v := range want {
+		if doc.Summary[k] != v {
+			t.Errorf("summary %q = %v, want %v", k, doc.Summary[k], v)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	// init нужен, чтобы help мог ссылаться на сам список команд
+	commands = []command{
+		{"analyze", "aggregate response time statistics per endpoint (default)", analyzeMain},
+		{"gen", "generate a synthetic access log", genMain},
+		{"merge", "combine previously produced result files", mergeMain},
+		{"diff", "compare two result files and flag regressions", diffMain},
+		{"verify", "check the parallel pipeline against a simple reference parser", verifyMain},
+		{"config", "print the effective configuration (config dump)", configMain},
+		{"help", "show help for a command", helpMain},
+	}
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprint(w, "Usage: iw_challenge <command> [flags] [args]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprint(w, "\nWithout a command the arguments are passed to analyze, so\n"+
+		"\"iw_challenge FILE\" is the same as \"iw_challenge analyze FILE\".\n"+
+		"Run \"iw_challenge help <command>\" for the command's flags.\n")
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// runCommand выбирает подкоманду по первому аргументу. Если это не имя команды
+// (путь к файлу или флаг), аргументы целиком уходят в analyze, как раньше
+func runCommand(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+	switch args[0] {
+	case "-h", "-help", "--help":
+		printUsage(os.Stdout)
+		return exitOK
+	}
+	if c := findCommand(args[0]); c != nil {
+		return c.run(args[1:])
+	}
+	return analyzeMain(args)
+}
+
+func helpMain(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stdout)
+		return exitOK
+	}
+	c := findCommand(args[0])
+	if c == nil || c.name == "help" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+	return c.run([]string{"-h"})
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Флаги, которые не имеют смысла в файле конфигурации
+var configExcluded = map[string]bool{"config": true, "version": true}
+
+// applyConfigFile выставляет флаги из файла конфигурации. Ключи - имена флагов analyze
+// без дефиса; флаги, явно заданные в командной строке, не трогаются
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	values, err := readConfigFile(path)
+	if err != nil {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if configExcluded[key] || fs.Lookup(key) == nil {
+			return fmt.Errorf("config %s: unknown key %q", path, key)
+		}
+		if explicit[key] {
+			continue
+		}
+		if err := fs.Set(key, values[key]); err != nil {
+			return fmt.Errorf("config %s: key %q: invalid value %q: %v", path, key, values[key], err)
+		}
+	}
+	return nil
+}
+
+// readConfigFile возвращает значения в том же строковом виде, в каком их принимает flag
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		return parseTOMLConfig(data)
+	}
+	return parseJSONConfig(data)
+}
+
+func parseJSONConfig(data []byte) (map[string]string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("expected a JSON object: %w", err)
+	}
+	values := make(map[string]string, len(raw))
+	for key, msg := range raw {
+		msg = bytes.TrimSpace(msg)
+		switch {
+		case len(msg) > 0 && msg[0] == '"':
+			var s string
+			if err := json.Unmarshal(msg, &s); err != nil {
+				return nil, fmt.Errorf("key %q: %w", key, err)
+			}
+			values[key] = s
+		case string(msg) == "true" || string(msg) == "false":
+			values[key] = string(msg)
+		case len(msg) > 0 && (msg[0] == '-' || msg[0] >= '0' && msg[0] <= '9'):
+			values[key] = string(msg)
+		default:
+			return nil, fmt.Errorf("key %q: expected a string, number or boolean, got %s", key, msg)
+		}
+	}
+	return values, nil
+}
+
+// parseTOMLConfig понимает плоское подмножество TOML: key = value, где value - строка,
+// число или true/false, и комментарии через #. Таблицы и массивы не поддерживаются
+func parseTOMLConfig(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			return nil, fmt.Errorf("line %d: tables are not supported", lineNo)
+		}
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		key = strings.TrimSpace(key)
+		if uk, err := strconv.Unquote(key); err == nil {
+			key = uk
+		}
+		if _, dup := values[key]; dup {
+			return nil, fmt.Errorf("line %d: key %q defined twice", lineNo, key)
+		}
+		v, err := parseTOMLValue(strings.TrimSpace(val))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: key %q: %w", lineNo, key, err)
+		}
+		values[key] = v
+	}
+	return values, sc.Err()
+}
+
+func parseTOMLValue(val string) (string, error) {
+	switch {
+	case strings.HasPrefix(val, `"`):
+		end := closingQuote(val)
+		if end < 0 {
+			return "", errors.New("unterminated string")
+		}
+		if rest := strings.TrimSpace(val[end+1:]); rest != "" && rest[0] != '#' {
+			return "", fmt.Errorf("unexpected %q after string", rest)
+		}
+		return strconv.Unquote(val[:end+1])
+	case strings.HasPrefix(val, "'"):
+		end := strings.IndexByte(val[1:], '\'')
+		if end < 0 {
+			return "", errors.New("unterminated string")
+		}
+		return val[1 : end+1], nil
+	}
+	if i := strings.IndexByte(val, '#'); i >= 0 {
+		val = strings.TrimSpace(val[:i])
+	}
+	if val == "true" || val == "false" {
+		return val, nil
+	}
+	num := strings.ReplaceAll(val, "_", "")
+	if _, err := strconv.ParseFloat(num, 64); err != nil {
+		return "", fmt.Errorf("expected a string, number or boolean, got %q", val)
+	}
+	return num, nil
+}
+
+// closingQuote ищет закрывающую кавычку строки, учитывая экранирование
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+const configUsage = `Usage: iw_challenge config dump [analyze flags]
+
+Prints the effective analyze configuration as JSON after applying defaults,
+the -config file and explicit flags, in that order. The output can be used
+as a -config file.
+`
+
+func configMain(args []string) int {
+	if len(args) == 0 || args[0] != "dump" {
+		if len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+			fmt.Print(configUsage)
+			return exitOK
+		}
+		fmt.Fprint(os.Stderr, configUsage)
+		return exitUsage
+	}
+
+	opts, fs, err := parseFlags(args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if err := validateOptions(opts); err != nil {
+		usageError(fs, "%v", err)
+		return exitUsage
+	}
+
+	effective := make(map[string]any)
+	fs.VisitAll(func(f *flag.Flag) {
+		if configExcluded[f.Name] {
+			return
+		}
+		effective[f.Name] = configValue(f.Value)
+	})
+	out, err := json.MarshalIndent(effective, "", "  ")
+	if err != nil {
+		logger.errorf("%v", err)
+		return exitError
+	}
+	fmt.Println(string(out))
+	return exitOK
+}
+
+// configValue представляет значение флага так, чтобы его снова принял applyConfigFile
+func configValue(v flag.Value) any {
+	g, ok := v.(flag.Getter)
+	if !ok {
+		return v.String()
+	}
+	switch val := g.Get().(type) {
+	case bool, int, int64, uint64, float64, string:
+		return val
+	case time.Duration:
+		return val.String()
+	}
+	return v.String()
+}
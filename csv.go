@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// -input-format csv и tsv: колонки через запятую или табуляцию, поля в кавычках по RFC 4180
+const (
+	inputFormatCSV = "csv"
+	inputFormatTSV = "tsv"
+)
+
+// csvPlan - какие колонки брать из строки CSV или TSV
+type csvPlan struct {
+	delim byte
+	// columns - номера колонок по -csv-columns, как fieldPlan для -fields
+	columns *fieldPlan
+}
+
+func newCSVPlan(columns fieldMapping, delim byte) *csvPlan {
+	return &csvPlan{delim: delim, columns: newFieldPlan(columns, 0)}
+}
+
+// columnsOf - -csv-columns или раскладка по умолчанию
+func columnsOf(opts *options) fieldMapping {
+	if opts.csvColumns.isSet() {
+		return opts.csvColumns
+	}
+	return defaultFieldMapping
+}
+
+// parse разбирает строку CSV за один проход. Поле в кавычках может содержать
+// разделитель, а "" внутри него - это кавычка; такие поля раскрываются прямо в line.
+// Поле в кавычках, которое не закрылось до конца строки, - битая строка: многострочные
+// поля не поддерживаются, части файла режутся по переводам строк без учёта кавычек
+func (plan *csvPlan) parse(line []byte, times timeFormat) (lineRecord, error) {
+	var rec lineRecord
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	slots := [fieldSent]*span{&rec.timestamp, &rec.ip, &rec.method, &rec.path, &rec.status, &rec.responseTime}
+	wanted := plan.columns.wanted
+	// n - сколько нужных колонок найдено, k - номер текущей колонки; quoted - нужные
+	// колонки с "" внутри
+	n, k := 0, 0
+	var quoted uint8
+	for i := 0; ; k++ {
+		start, end, next := i, 0, 0
+		escaped := false
+		if i < len(line) && line[i] == '"' {
+			j := i + 1
+			for {
+				q := bytes.IndexByte(line[j:], '"')
+				if q < 0 {
+					return rec, &lineParseError{i, fmt.Errorf("quoted field is not closed on this line; multi-line fields are not supported")}
+				}
+				j += q
+				if j+1 < len(line) && line[j+1] == '"' {
+					escaped = true
+					j += 2
+					continue
+				}
+				break
+			}
+			start, end, next = i+1, j, j+1
+			if next < len(line) && line[next] != plan.delim {
+				return rec, &lineParseError{next, fmt.Errorf("unexpected %q after a quoted field", line[next])}
+			}
+		} else {
+			end = len(line)
+			if d := bytes.IndexByte(line[i:], plan.delim); d >= 0 {
+				end = i + d
+			}
+			next = end
+		}
+		if n < len(wanted) && wanted[n].index == k {
+			kind := wanted[n].kind
+			*slots[kind] = span{start, end}
+			if escaped {
+				quoted |= 1 << kind
+			}
+			n++
+		}
+		if next >= len(line) {
+			break
+		}
+		i = next + 1
+	}
+	if n < len(wanted) {
+		return rec, &lineParseError{len(line), fmt.Errorf("line has %d columns, -csv-columns %s needs %d", k+1, plan.columns.mapping, wanted[len(wanted)-1].index+1)}
+	}
+	for kind, sp := range slots {
+		if quoted&(1<<kind) != 0 {
+			sp.end = sp.start + unquoteCSV(line[sp.start:sp.end])
+		}
+	}
+	if rec.path.start == rec.path.end {
+		return rec, &lineParseError{rec.path.start, fmt.Errorf("empty path")}
+	}
+
+	value, bad, err := times.millis(line[rec.responseTime.start:rec.responseTime.end])
+	if err != nil {
+		return rec, &lineParseError{rec.responseTime.start + bad, err}
+	}
+	rec.value = value
+	return rec, nil
+}
+
+// unquoteCSV заменяет "" на " прямо в b и возвращает новую длину
+func unquoteCSV(b []byte) int {
+	w := 0
+	for r := 0; r < len(b); r++ {
+		b[w] = b[r]
+		w++
+		if b[r] == '"' && r+1 < len(b) && b[r+1] == '"' {
+			r++
+		}
+	}
+	return w
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCSVQuotedFields(t *testing.T) {
+	plan := newCSVPlan(defaultFieldMapping, ',')
+	tests := []struct {
+		line, path, method string
+		ms                 int64
+	}{
+		{`2024-01-01T00:00:00Z,10.0.0.1,GET,/api/users,200,12`, "/api/users", "GET", 12},
+		// Разделитель внутри кавычек не делит поле
+		{`2024-01-01T00:00:00Z,10.0.0.1,GET,"/search?q=a,b,c",200,7`, "/search?q=a,b,c", "GET", 7},
+		// "" внутри кавычек - одна кавычка
+		{`2024-01-01T00:00:00Z,10.0.0.1,"GET","/q?name=""x""",200,"30"`, `/q?name="x"`, "GET", 30},
+		{`"2024-01-01T00:00:00Z","10.0.0.1","POST","/a,""b""",201,5` + "\r", `/a,"b"`, "POST", 5},
+		// Пустые колонки за нужными не мешают
+		{`2024-01-01T00:00:00Z,10.0.0.1,GET,/tail,200,9,,"x,y",`, "/tail", "GET", 9},
+	}
+	for _, tt := range tests {
+		line := []byte(tt.line)
+		rec, err := plan.parse(line, timeIntMillis)
+		if err != nil {
+			t.Errorf("%s: %v", tt.line, err)
+			continue
+		}
+		if path, method := string(line[rec.path.start:rec.path.end]), string(line[rec.method.start:rec.method.end]); path != tt.path || method != tt.method || rec.value != tt.ms {
+			t.Errorf("%s: got %q %q %dms, want %q %q %dms", tt.line, method, path, rec.value, tt.method, tt.path, tt.ms)
+		}
+	}
+
+	for _, bad := range []string{
+		// Многострочные поля не поддерживаются: кавычка не закрылась до конца строки
+		`2024-01-01T00:00:00Z,10.0.0.1,GET,"/multi`,
+		`2024-01-01T00:00:00Z,10.0.0.1,GET,"/a"x,200,5`,
+		`2024-01-01T00:00:00Z,10.0.0.1,GET,/a,200`,
+		`2024-01-01T00:00:00Z,10.0.0.1,GET,,200,5`,
+		`2024-01-01T00:00:00Z,10.0.0.1,GET,/a,200,"5x"`,
+	} {
+		if _, err := plan.parse([]byte(bad), timeIntMillis); err == nil {
+			t.Errorf("accepted %s", bad)
+		}
+	}
+
+	tsv := newCSVPlan(defaultFieldMapping, '\t')
+	line := []byte("2024-01-01T00:00:00Z\t10.0.0.1\tGET\t\"/a\tb, c\"\t200\t4")
+	if rec, err := tsv.parse(line, timeIntMillis); err != nil || string(line[rec.path.start:rec.path.end]) != "/a\tb, c" {
+		t.Errorf("tsv: %q, %v", line[rec.path.start:rec.path.end], err)
+	}
+}
+
+func TestCSVHeaderOnlyInFirstPart(t *testing.T) {
+	const header = "timestamp,ip,method,path,status,time\n"
+	var sb strings.Builder
+	for i := range 4000 {
+		fmt.Fprintf(&sb, "2024-01-01T00:00:00Z,10.0.0.1,GET,\"/items,%d\",200,%d\n", i%5, i%100+1)
+	}
+	rows := sb.String()
+	path := writeTempFile(t, "in.csv", header+rows)
+	want, code := runAnalyzeFile(t, "-input-format", "csv", path)
+	if code != exitOK || !strings.Contains(want, `"malformed_lines": 1`) {
+		t.Fatalf("without -skip-header the header must be the one malformed line: exit %d\n%s", code, want)
+	}
+	want, _ = runAnalyzeFile(t, "-input-format", "csv", "-workers", "1", "-skip-header", path)
+	if !strings.Contains(want, `"malformed_lines": 0`) || !strings.Contains(want, `"total_requests": 4000,`) {
+		t.Fatalf("-skip-header, 1 worker:\n%s", want)
+	}
+	for _, workers := range []string{"2", "4", "7"} {
+		got, code := runAnalyzeFile(t, "-input-format", "csv", "-workers", workers, "-skip-header", path)
+		if code != exitOK || got != want {
+			t.Errorf("-workers %s: exit %d, output differs from one worker:\n%s", workers, code, got)
+		}
+	}
+
+	// Строка с текстом заголовка в середине файла - данные другого воркера, а не заголовок
+	mid := len(rows) / 2
+	mid += strings.IndexByte(rows[mid:], '\n') + 1
+	path = writeTempFile(t, "mid.csv", header+rows[:mid]+header+rows[mid:])
+	for _, workers := range []string{"1", "4"} {
+		out, _ := runAnalyzeFile(t, "-input-format", "csv", "-workers", workers, "-skip-header", path)
+		if !strings.Contains(out, `"malformed_lines": 1`) || !strings.Contains(out, `"total_requests": 4000,`) {
+			t.Errorf("-workers %s: want only the middle header counted as malformed:\n%s", workers, out)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const diffUsageHeader = `Usage: iw_challenge diff [flags] OLD.json NEW.json
+
+Compares two result files produced by analyze (JSON format): prints avg and max
+deltas for every endpoint present in both, and the endpoints that appeared or
+disappeared. With -fail-if-* thresholds exits with code 5 on a regression.
+Flags may follow the files.
+
+Flags:
+`
+
+// percent - значение флага в процентах: "10%" или "10". Незаданный флаг отключает проверку
+type percent struct {
+	value float64
+	set   bool
+}
+
+func (p *percent) String() string {
+	if !p.set {
+		return ""
+	}
+	return strconv.FormatFloat(p.value, 'f', -1, 64) + "%"
+}
+
+func (p *percent) Set(s string) error {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil || v < 0 {
+		return fmt.Errorf("invalid percentage %q: expected a non-negative number like 10%%", s)
+	}
+	p.value, p.set = v, true
+	return nil
+}
+
+type diffOptions struct {
+	failAvg, failMax percent
+	minCount         int64
+}
+
+// endpointDiff - изменение одного эндпоинта между двумя отчётами
+type endpointDiff struct {
+	name             string
+	oldAvg, newAvg   float64
+	oldMax, newMax   int64
+	count            *int64
+	avgPct, maxPct   float64
+	avgFail, maxFail bool
+}
+
+func diffMain(args []string) int {
+	opts := &diffOptions{}
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), diffUsageHeader)
+		fs.PrintDefaults()
+	}
+	fs.Var(&opts.failAvg, "fail-if-avg-regresses", "exit with code 5 if some endpoint's avg grew by more than this `percent`, e.g. 10%")
+	fs.Var(&opts.failMax, "fail-if-max-regresses", "exit with code 5 if some endpoint's max grew by more than this `percent`, e.g. 20%")
+	fs.Int64Var(&opts.minCount, "min-count", 0, "ignore endpoints with fewer than `K` requests in NEW when checking thresholds (needs count in the files)")
+
+	paths, err := parseInterspersed(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if len(paths) != 2 {
+		fmt.Fprintln(fs.Output(), "expected OLD.json and NEW.json")
+		fs.Usage()
+		return exitUsage
+	}
+	if opts.minCount < 0 {
+		fmt.Fprintf(fs.Output(), "invalid -min-count %d: must not be negative\n", opts.minCount)
+		fs.Usage()
+		return exitUsage
+	}
+
+	oldRes, err := loadResultFile(paths[0])
+	if err != nil {
+		logger.errorf("%v", err)
+		return exitError
+	}
+	newRes, err := loadResultFile(paths[1])
+	if err != nil {
+		logger.errorf("%v", err)
+		return exitError
+	}
+
+	diffs, added, removed, err := diffResults(oldRes, newRes, opts)
+	if err != nil {
+		logger.errorf("%v", err)
+		return exitError
+	}
+	printDiff(os.Stdout, diffs, added, removed)
+
+	failed := 0
+	for _, d := range diffs {
+		if d.avgFail || d.maxFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		logger.errorf("%d endpoints regressed beyond the thresholds", failed)
+		return exitRegression
+	}
+	return exitOK
+}
+
+// diffResults сопоставляет эндпоинты по имени и отмечает превысившие пороги
+func diffResults(oldRes, newRes *resultFile, opts *diffOptions) (diffs []endpointDiff, added, removed []string, err error) {
+	for name, n := range newRes.Endpoints {
+		o, ok := oldRes.Endpoints[name]
+		if !ok {
+			added = append(added, name)
+			continue
+		}
+		if o.Avg == nil || o.Max == nil || n.Avg == nil || n.Max == nil {
+			return nil, nil, nil, fmt.Errorf("endpoint %q lacks avg/max", name)
+		}
+		d := endpointDiff{
+			name:   name,
+			oldAvg: *o.Avg, newAvg: *n.Avg,
+			oldMax: *o.Max, newMax: *n.Max,
+			count: n.Count,
+		}
+		d.avgPct = percentChange(d.oldAvg, d.newAvg)
+		d.maxPct = percentChange(float64(d.oldMax), float64(d.newMax))
+
+		checked := true
+		if opts.minCount > 0 {
+			if d.count == nil {
+				return nil, nil, nil, fmt.Errorf("-min-count needs count in NEW, endpoint %q has none (produce it with -include-count)", name)
+			}
+			checked = *d.count >= opts.minCount
+		}
+		if checked {
+			d.avgFail = opts.failAvg.set && d.avgPct > opts.failAvg.value
+			d.maxFail = opts.failMax.set && d.maxPct > opts.failMax.value
+		}
+		diffs = append(diffs, d)
+	}
+	for name := range oldRes.Endpoints {
+		if _, ok := newRes.Endpoints[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].name < diffs[j].name })
+	sort.Strings(added)
+	sort.Strings(removed)
+	return diffs, added, removed, nil
+}
+
+// percentChange - рост от old к new в процентах; рост с нуля считается бесконечным
+func percentChange(old, new float64) float64 {
+	if old == 0 {
+		if new == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return (new - old) / old * 100
+}
+
+func formatPercent(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+inf%"
+	}
+	return fmt.Sprintf("%+.1f%%", v)
+}
+
+func printDiff(w io.Writer, diffs []endpointDiff, added, removed []string) {
+	header := []string{"ENDPOINT", "OLD AVG", "NEW AVG", "Δ AVG", "Δ AVG %", "OLD MAX", "NEW MAX", "Δ MAX", "Δ MAX %"}
+	rows := make([][]string, 0, len(diffs))
+	marks := make([]string, 0, len(diffs)+1)
+	marks = append(marks, "")
+	for _, d := range diffs {
+		var flags []string
+		if d.avgFail {
+			flags = append(flags, "avg")
+		}
+		if d.maxFail {
+			flags = append(flags, "max")
+		}
+		mark := ""
+		if len(flags) > 0 {
+			mark = "  REGRESSION (" + strings.Join(flags, ", ") + ")"
+		}
+		marks = append(marks, mark)
+		rows = append(rows, []string{
+			truncate(d.name, maxTableEndpointWidth),
+			formatFloat(d.oldAvg, 1),
+			formatFloat(d.newAvg, 1),
+			fmt.Sprintf("%+.1f", d.newAvg-d.oldAvg),
+			formatPercent(d.avgPct),
+			strconv.FormatInt(d.oldMax, 10),
+			strconv.FormatInt(d.newMax, 10),
+			fmt.Sprintf("%+d", d.newMax-d.oldMax),
+			formatPercent(d.maxPct),
+		})
+	}
+
+	widths := make([]int, len(header))
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	// Пометку о регрессии дописываем после таблицы, чтобы она не выравнивалась вправо
+	for i, row := range append([][]string{header}, rows...) {
+		var sb strings.Builder
+		writeTableRow(&sb, row, widths, "")
+		fmt.Fprintf(w, "%s%s\n", strings.TrimSuffix(sb.String(), "\n"), marks[i])
+	}
+
+	for _, name := range added {
+		fmt.Fprintf(w, "added:   %s\n", name)
+	}
+	for _, name := range removed {
+		fmt.Fprintf(w, "removed: %s\n", name)
+	}
+}
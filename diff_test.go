@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiffFlagsAfterFiles(t *testing.T) {
+	dir := t.TempDir()
+	report := func(name, log string) string {
+		t.Helper()
+		out, code := runAnalyzeFile(t, "-include-count", writeTempFile(t, "in.log", log))
+		if code != exitOK {
+			t.Fatalf("%s: exit %d", name, code)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(out), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	// avg /a растёт с 10 до 15 (+50%), max - с 10 до 20 (+100%)
+	old := report("old.json", "2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 10\n")
+	cur := report("new.json", "2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 10\n2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 20\n")
+
+	stdout := os.Stdout
+	devNull, err := os.Create(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = devNull
+	t.Cleanup(func() {
+		os.Stdout = stdout
+		devNull.Close()
+	})
+
+	for _, tt := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{old, cur}, exitOK},
+		{[]string{"-fail-if-avg-regresses", "10%", old, cur}, exitRegression},
+		// Флаги после файлов раньше молча пропускались, и diff выходил с 0
+		{[]string{old, cur, "-fail-if-avg-regresses", "10%"}, exitRegression},
+		{[]string{old, "-fail-if-max-regresses", "50", cur}, exitRegression},
+		{[]string{old, cur, "-fail-if-avg-regresses", "60%"}, exitOK},
+		{[]string{old, cur, "-fail-if-avg-regresses", "10%", "-min-count", "3"}, exitOK},
+		{[]string{old, cur, "-fail-if-avg-regresses", "10%", "-min-count", "2"}, exitRegression},
+		{[]string{old, cur, "-min-count", "-1"}, exitUsage},
+		{[]string{old, cur, "-no-such-flag"}, exitUsage},
+		{[]string{old, "-fail-if-avg-regresses", "10%"}, exitUsage},
+		{[]string{old, cur, old}, exitUsage},
+	} {
+		if code := runCommand(append([]string{"diff"}, tt.args...)); code != tt.want {
+			t.Errorf("diff %v: exit %d, want %d", tt.args, code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import "math"
+
+// Квантили объекта "distribution" в summary
+var globalPercentiles = percentileList{{"50", 0.5}, {"95", 0.95}, {"99", 0.99}}
+
+// defaultHistogramBuckets - границы гистограммы "distribution", если -buckets не задан
+var defaultHistogramBuckets = bucketList{10, 50, 100, 250, 500, 1000, 5000}
+
+// distributionOf - объект "distribution" для summary: распределение всех запросов
+// лога без разбивки по эндпоинтам. Отдельного накопителя на строку нет: total - это
+// уже слитые при выводе Stats эндпоинтов (grandTotal), их скетчи или точные гистограммы
+// сложены так же, как при слиянии воркеров. Гистограмма по -buckets точная, без него
+// она строится по границам defaultHistogramBuckets из того же распределения. nil, если
+// распределения нет (у merge, который сводит готовые отчёты)
+func (r jsonRenderer) distributionOf(rep *report, total *Stats) docObject {
+	if x := total.extra; x == nil || (x.sketch == nil && x.exact == nil) {
+		return nil
+	}
+	minV, avg, maxV := r.unit.formatStats(total, r.precision)
+	dist := docObject{{"min", docNumber(minV)}, {"avg", docNumber(avg)}, {"max", docNumber(maxV)}}
+	q := &quantileSpec{percentiles: globalPercentiles, mapping: rep.quantiles.mapping}
+	for i, v := range q.values(total) {
+		dist = append(dist, docField{globalPercentiles[i].name(), docNumber(r.unit.formatMs(v, r.precision))})
+	}
+	bounds, counts := r.buckets, total.extra.buckets
+	if counts == nil {
+		bounds = defaultHistogramBuckets
+		counts = make([]int64, len(bounds)+1)
+		q.each(total, func(v float64, c int64) { counts[bounds.index(int64(math.Ceil(v)))] += c })
+	}
+	hist := make(docObject, len(counts))
+	for i, c := range cumulative(counts) {
+		hist[i] = docField{bounds.label(i, r.unit), docInt(c)}
+	}
+	return append(dist, docField{"histogram", hist})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"sync/atomic"
+)
+
+const (
+	// exactPercentileCap - до скольких миллисекунд -exact-percentiles считает каждое значение
+	// отдельно; значения от cap и выше уходят в скетч переполнения
+	exactPercentileCap = 60000
+	// Счётчик - 8 байт, так что точная гистограмма эндпоинта занимает до 8 * cap = 480KB
+	exactBytesPerEndpoint = 8 * exactPercentileCap
+	defaultMaxMemory      = 1 << 30
+	// Квантили -exact-percentiles, если -percentiles не задан
+	defaultExactPercentiles = "50,95,99"
+	// defaultModeMinShare - с какой доли запросов эндпоинта в процентах печатается -mode
+	defaultModeMinShare = 5
+)
+
+// modeMinShareOf - порог -mode для jsonRenderer; -1 без флага
+func modeMinShareOf(opts *options) float64 {
+	if !opts.mode {
+		return -1
+	}
+	return opts.modeMinShare
+}
+
+// exactHist - счётчик каждого значения 0..cap-1 одного эндпоинта. Массив растёт до
+// наибольшего встреченного значения, а не сразу до cap: дельтам воркеров на одну пачку
+// полный массив не нужен
+type exactHist struct {
+	counts []uint64
+	// overflow - значения от exactPercentileCap и выше; nil, пока таких не было
+	overflow *sketch
+}
+
+func (h *exactHist) add(m *sketchMapping, v int64) {
+	if v >= exactPercentileCap {
+		if h.overflow == nil {
+			h.overflow = &sketch{}
+		}
+		h.overflow.add(m, v)
+		return
+	}
+	i := int(max(v, 0))
+	if i >= len(h.counts) {
+		h.grow(i + 1)
+	}
+	h.counts[i]++
+}
+
+// grow расширяет counts хотя бы до n, удваивая, но не больше cap
+func (h *exactHist) grow(n int) {
+	size := min(max(n, 2*len(h.counts), 64), exactPercentileCap)
+	grown := make([]uint64, size)
+	copy(grown, h.counts)
+	h.counts = grown
+}
+
+// merge складывает гистограммы поэлементно
+func (h *exactHist) merge(o *exactHist) {
+	if len(o.counts) > len(h.counts) {
+		h.grow(len(o.counts))
+	}
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	if o.overflow != nil {
+		if h.overflow == nil {
+			h.overflow = &sketch{}
+		}
+		h.overflow.merge(o.overflow)
+	}
+}
+
+func (h *exactHist) clone() *exactHist {
+	c := &exactHist{counts: append([]uint64(nil), h.counts...)}
+	if h.overflow != nil {
+		c.overflow = h.overflow.clone()
+	}
+	return c
+}
+
+// toSketch переводит гистограмму в скетч, когда точный режим не помещается в -max-memory
+func (h *exactHist) toSketch(m *sketchMapping) *sketch {
+	s := &sketch{}
+	if h.overflow != nil {
+		s.merge(h.overflow)
+	}
+	for v := len(h.counts) - 1; v >= 1; v-- {
+		if c := h.counts[v]; c != 0 {
+			s.addAt(coarser(m.index(int64(v)), s.level), c)
+		}
+	}
+	if len(h.counts) > 0 {
+		s.zero += int64(h.counts[0])
+	}
+	return s
+}
+
+// quantile - точное значение квантиля q из count значений; если оно среди значений
+// от cap и выше, возвращается оценка скетча переполнения
+func (h *exactHist) quantile(m *sketchMapping, q float64, count int64) float64 {
+	rank := q * float64(count-1)
+	var cum float64
+	for v, c := range h.counts {
+		cum += float64(c)
+		if cum > rank {
+			return float64(v)
+		}
+	}
+	if h.overflow == nil {
+		return float64(len(h.counts) - 1)
+	}
+	return h.overflow.atRank(m, rank-cum)
+}
+
+// mode - самое частое значение до cap и сколько раз оно встретилось; при равенстве
+// берётся меньшее. Значения от cap и выше в скетче переполнения не различимы и не считаются
+func (h *exactHist) mode() (v int64, count uint64) {
+	for i, c := range h.counts {
+		if c > count {
+			v, count = int64(i), c
+		}
+	}
+	return v, count
+}
+
+// exactSpec - состояние -exact-percentiles на прогон. fallback выставляется один раз,
+// когда гистограммы перестают помещаться в budget: новые эндпоинты воркеры заводят со
+// скетчами, а уже накопленные гистограммы merger переводит в скетчи
+type exactSpec struct {
+	budget   int64
+	fallback atomic.Bool
+}
+
+// limitExact проверяет, помещаются ли точные гистограммы в -max-memory, и если нет -
+// один раз переводит все накопленные результаты на скетчи. Считаются и итог, и
+// результаты файлов: merger держит обе копии
+func (m *merger) limitExact(spec *exactSpec, mapping *sketchMapping) {
+	if spec.fallback.Load() {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	endpoints := int64(len(m.total.totals))
+	for _, f := range m.files {
+		endpoints += int64(len(f.res.totals))
+	}
+	need := endpoints * exactBytesPerEndpoint
+	if need <= spec.budget {
+		return
+	}
+	spec.fallback.Store(true)
+	logger.warnf("-exact-percentiles needs about %s for %d endpoints, more than -max-memory %s; falling back to sketches",
+		humanBytes(need), len(m.total.totals), humanBytes(spec.budget))
+	for _, res := range append([]*pipelineResult{m.total}, fileResults(m.files)...) {
+		for _, s := range res.totals {
+			s.extra.dropExact(mapping)
+		}
+	}
+}
+
+func fileResults(files []fileResult) []*pipelineResult {
+	out := make([]*pipelineResult, len(files))
+	for i, f := range files {
+		out[i] = f.res
+	}
+	return out
+}
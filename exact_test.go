@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExactHistMode(t *testing.T) {
+	m := newSketchMapping(defaultSketchAccuracy)
+	for _, tt := range []struct {
+		name   string
+		values map[int64]int
+		want   int64
+		count  uint64
+	}{
+		// Ответы из кэша и из базы: второй пик выше первого
+		{"bimodal", map[int64]int{4: 30, 5: 300, 6: 30, 250: 40, 300: 400, 350: 40}, 300, 400},
+		{"bimodal, low peak wins", map[int64]int{5: 500, 300: 400}, 5, 500},
+		// При равенстве выигрывает меньшее значение
+		{"tie", map[int64]int{20: 7, 10: 7, 30: 3}, 10, 7},
+		{"single", map[int64]int{0: 1}, 0, 1},
+		// Значения от exactPercentileCap не входят в гистограмму, моды среди них нет
+		{"overflow", map[int64]int{exactPercentileCap + 5: 100, 1: 2}, 1, 2},
+	} {
+		h := &exactHist{}
+		for v, n := range tt.values {
+			for range n {
+				h.add(m, v)
+			}
+		}
+		if v, c := h.mode(); v != tt.want || c != tt.count {
+			t.Errorf("%s: mode %d (%d times), want %d (%d times)", tt.name, v, c, tt.want, tt.count)
+		}
+	}
+}
+
+func TestModeOutput(t *testing.T) {
+	var sb strings.Builder
+	line := func(path string, ms int) {
+		fmt.Fprintf(&sb, "2024-01-01T00:00:00Z 10.0.0.1 GET %s 200 %d\n", path, ms)
+	}
+	// /cached: пики 3ms и 180ms, у второго 45% запросов
+	for i := range 100 {
+		switch {
+		case i < 40:
+			line("/cached", 3)
+		case i < 85:
+			line("/cached", 180)
+		default:
+			line("/cached", 100+i)
+		}
+	}
+	// /flat: 50 разных значений по 2 раза, у моды 2% запросов
+	for i := range 100 {
+		line("/flat", 10+i/2)
+	}
+	path := writeTempFile(t, "mode.log", sb.String())
+	out, code := runAnalyzeFile(t, "-exact-percentiles", "-mode", "-sort", "name", path)
+	if code != exitOK {
+		t.Fatalf("exit %d", code)
+	}
+	cached := out[strings.Index(out, `"/cached"`):strings.Index(out, `"/flat"`)]
+	if !strings.Contains(cached, `"mode_response_time": 180`) {
+		t.Errorf("/cached: want mode 180:\n%s", cached)
+	}
+	flat := out[strings.Index(out, `"/flat"`):strings.Index(out, `"summary"`)]
+	if strings.Contains(flat, "mode_response_time") {
+		t.Errorf("/flat: mode below -mode-min-share printed:\n%s", flat)
+	}
+	// С меньшим порогом мода /flat - наименьшее из равных значений
+	out, _ = runAnalyzeFile(t, "-exact-percentiles", "-mode", "-mode-min-share", "1", "-sort", "name", path)
+	if flat := out[strings.Index(out, `"/flat"`):]; !strings.Contains(flat, `"mode_response_time": 10`) {
+		t.Errorf("/flat with -mode-min-share 1: want mode 10:\n%s", flat)
+	}
+}
+
+func TestExactHistQuantiles(t *testing.T) {
+	m := newSketchMapping(defaultSketchAccuracy)
+	rng := rand.New(rand.NewPCG(13, 14))
+	values := make([]int64, 5000)
+	for i := range values {
+		values[i] = rng.Int64N(3000)
+	}
+	// Немного значений выше cap уходит в скетч переполнения
+	for i := range 50 {
+		values[i] = exactPercentileCap + int64(i)*1000
+	}
+	h := &exactHist{}
+	for _, v := range values {
+		h.add(m, v)
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	n := int64(len(values))
+	for _, q := range []float64{0, 0.5, 0.9, 0.98, 0.989} {
+		if got, want := h.quantile(m, q, n), float64(sorted[int(q*float64(n-1))]); got != want {
+			t.Errorf("p%v = %v, want exactly %v", q*100, got, want)
+		}
+	}
+	for _, q := range []float64{0.995, 1} {
+		want := float64(sorted[int(q*float64(n-1))])
+		if got := h.quantile(m, q, n); math.Abs(got-want)/want > defaultSketchAccuracy {
+			t.Errorf("overflow p%v = %v, want %v within %v", q*100, got, want, defaultSketchAccuracy)
+		}
+	}
+
+	// После перевода в скетч квантили - оценки с ошибкой скетча. От 1 до 109000ms
+	// корзин не хватает, и скетч огрубляется
+	s := h.toSketch(m)
+	g := math.Pow(m.gamma, float64(uint64(1)<<s.level))
+	checkQuantiles(t, "toSketch", m, s, values, (g-1)/(g+1))
+}
+
+func TestExactPercentilesFallback(t *testing.T) {
+	var sb strings.Builder
+	// Четыре эндпоинта со значениями 1..1000: точные p50 = 500, p99 = 990
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		for i := range 1000 {
+			fmt.Fprintf(&sb, "2024-01-01T00:00:00Z 10.0.0.1 GET %s 200 %d\n", path, i+1)
+		}
+	}
+	path := writeTempFile(t, "exact.log", sb.String())
+	run := func(args ...string) (map[string]map[string]any, string) {
+		t.Helper()
+		var stderr strings.Builder
+		w, level := logger.w, logger.level
+		logger.w, logger.level = &stderr, levelWarn
+		defer func() { logger.w, logger.level = w, level }()
+		out := writeTempFile(t, "out.json", "")
+		if code := runCommand(append(append([]string{"analyze", "-o", out, "-exact-percentiles", "-percentiles", "50,99"}, args...), path)); code != exitOK {
+			t.Fatalf("%v: exit %d", args, code)
+		}
+		var doc struct {
+			Endpoints map[string]map[string]any `json:"endpoints"`
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			t.Fatalf("%v: %v\n%s", args, err, data)
+		}
+		return doc.Endpoints, stderr.String()
+	}
+
+	// merger держит и итог, и результат файла: 8 * 480KB помещаются в 4MB, значения
+	// точные, и предупреждения нет
+	for _, workers := range []string{"1", "3"} {
+		endpoints, stderr := run("-max-memory", "4M", "-workers", workers)
+		if strings.Contains(stderr, "falling back") {
+			t.Errorf("-max-memory 4M -workers %s: unexpected fallback: %s", workers, stderr)
+		}
+		for name, e := range endpoints {
+			if e["p50_response_time"] != 500.0 || e["p99_response_time"] != 990.0 {
+				t.Errorf("-workers %s: %s = %v, want exact p50 500 and p99 990", workers, name, e)
+			}
+		}
+	}
+
+	// В 2MB не помещаются: прогон переходит на скетчи с предупреждением
+	for _, workers := range []string{"1", "3"} {
+		endpoints, stderr := run("-max-memory", "2M", "-workers", workers)
+		if !strings.Contains(stderr, "-exact-percentiles needs about") || !strings.Contains(stderr, "falling back to sketches") {
+			t.Errorf("-max-memory 2M -workers %s: no fallback warning: %q", workers, stderr)
+		}
+		if len(endpoints) != 4 {
+			t.Errorf("-max-memory 2M -workers %s: %d endpoints, want 4", workers, len(endpoints))
+		}
+		for name, e := range endpoints {
+			for key, want := range map[string]float64{"p50_response_time": 500, "p99_response_time": 990} {
+				got, _ := e[key].(float64)
+				if math.Abs(got-want)/want > defaultSketchAccuracy {
+					t.Errorf("-workers %s: %s %s = %v, want %v within %v", workers, name, key, e[key], want, defaultSketchAccuracy)
+				}
+			}
+		}
+	}
+}
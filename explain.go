@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// runExplain показывает, как parseLine режет одну строку: -explain берёт строку
+// из аргумента, -explain-line - N-ю строку FILE
+func runExplain(opts *options) int {
+	line := []byte(opts.explain)
+	format := lineFormatOf(opts)
+	if opts.explainLine > 0 {
+		var offset int64
+		var err error
+		line, offset, err = readLine(opts.filePath, opts.explainLine)
+		if err != nil {
+			logger.errorf("%v", err)
+			return exitError
+		}
+		fmt.Printf("source:        %s line %d (byte offset %d)\n", opts.filePath, opts.explainLine, offset)
+		// Строку W3C разбирает последняя директива #Fields перед ней
+		if format.w3c {
+			plans, err := w3cPartPlans(context.Background(), opts.filePath, []part{{offset: offset}})
+			if err != nil {
+				logger.errorf("error reading #Fields directives: %v", err)
+				return exitError
+			}
+			format.fieldsW3C = plans[0]
+		}
+	}
+	fmt.Printf("line:          %q (%d bytes)\n", line, len(line))
+
+	rec, err := format.parse(line)
+	if err == nil {
+		err = format.limit(&rec)
+	}
+	fields := []struct {
+		name string
+		sp   span
+		used string
+	}{
+		{"timestamp", rec.timestamp, ""},
+		{"ip", rec.ip, ""},
+		{"method", rec.method, ""},
+		{"path", rec.path, "endpoint key"},
+		{"status", rec.status, ""},
+		{"response_time", rec.responseTime, "min/avg/max"},
+	}
+	if opts.bytesField > 0 {
+		fields = append(fields, struct {
+			name string
+			sp   span
+			used string
+		}{"bytes", rec.sent, "-bytes-field"})
+	}
+	for _, f := range fields {
+		// Поля после места ошибки и поля, которых нет в -fields, не заполнены
+		if f.sp.end == 0 {
+			continue
+		}
+		used := ""
+		if f.used != "" {
+			used = "  <- " + f.used
+		}
+		fmt.Printf("%-14s %-10s %q%s\n", f.name, fmt.Sprintf("[%d,%d)", f.sp.start, f.sp.end), line[f.sp.start:f.sp.end], used)
+	}
+
+	var perr *lineParseError
+	if errors.As(err, &perr) {
+		at := "end of line"
+		if perr.index < len(line) {
+			at = fmt.Sprintf("%q", line[perr.index])
+		}
+		fmt.Printf("error:         at byte %d (%s): %v\n", perr.index, at, perr.err)
+		// Указатель под местом ошибки в строке, напечатанной как есть
+		fmt.Printf("  %s\n  %s^\n", line, strings.Repeat(" ", perr.index))
+		return exitError
+	}
+	fmt.Printf("response time: %d ms\n", rec.value)
+	if opts.bytesField > 0 {
+		fmt.Printf("bytes sent:    %d\n", rec.sentBytes)
+	}
+	return exitOK
+}
+
+// readLine возвращает n-ю (с единицы) строку файла без перевода строки и её смещение
+func readLine(path string, n int64) ([]byte, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for i := int64(1); ; i++ {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		if len(line) == 0 {
+			return nil, 0, fmt.Errorf("%s has only %d lines", path, i-1)
+		}
+		if i == n {
+			return bytes.TrimSuffix(line, []byte{'\n'}), offset, nil
+		}
+		offset += int64(len(line))
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import "slices"
+
+// extraSpec - какие необязательные накопители вести для каждого эндпоинта. nil в
+// processOptions означает, что ни один не нужен и Stats остаются из четырёх чисел
+type extraSpec struct {
+	// sketches - отображение скетчей для -percentiles
+	sketches *sketchMapping
+	// exact, пока не откатился на скетчи, заменяет скетчи точными гистограммами
+	exact *exactSpec
+	// stddev - накопитель Уэлфорда для -stddev и правила high_stddev -flag-anomalies
+	stddev bool
+	// geo - сумма логарифмов для -geo-mean
+	geo bool
+	// buckets - границы -buckets
+	buckets bucketList
+	// apdexT - порог -apdex-t; 0 - Apdex не считается
+	apdexT int64
+	// slow - пороги -slow-threshold, если счётчики медленных запросов нужны
+	slow slowList
+	// times - раскладка timestamp, если он нужен seen или timeline; иначе timestamp не разбирается
+	times *timeLayout
+	// seen - вести first_seen и last_seen для rps (схема 2)
+	seen bool
+	// bucketWidth - ширина интервала -bucket в мс; 0 - timeline не ведётся
+	bucketWidth int64
+	// ips - HyperLogLog для -unique-ips
+	ips *hllSpec
+	// status - считать классы статусов для -status-breakdown
+	status bool
+	// sent - вести размер ответа -bytes-field
+	sent bool
+	// slowest - сколько самых медленных запросов хранить для -capture-slowest; 0 - ни одного
+	slowest int
+}
+
+// newExtraSpec собирает extraSpec из флагов; nil, если ни один накопитель не нужен
+func newExtraSpec(opts *options) *extraSpec {
+	spec := &extraSpec{stddev: opts.stddev || opts.flagAnomalies, geo: opts.geoMean, buckets: opts.buckets, apdexT: opts.apdexT, slow: opts.slowCounts,
+		seen: wantSeen(opts), bucketWidth: opts.bucket.Milliseconds(), status: opts.statusBreakdown,
+		slowest: opts.captureSlowest, sent: opts.bytesField > 0}
+	if spec.seen || spec.bucketWidth > 0 {
+		spec.times = opts.timeLayout
+	}
+	if opts.uniqueIPs {
+		spec.ips = newHLLSpec(opts.hllPrecision)
+	}
+	if opts.quantiles != nil {
+		spec.sketches = opts.quantiles.mapping
+	}
+	if opts.exactPercentiles {
+		spec.exact = &exactSpec{budget: int64(opts.maxMemory)}
+	}
+	if spec.sketches == nil && !spec.stddev && !spec.geo && spec.buckets == nil && spec.apdexT == 0 && spec.slow == nil && spec.times == nil && spec.ips == nil && !spec.status && spec.slowest == 0 && !spec.sent {
+		return nil
+	}
+	return spec
+}
+
+// spanLayoutOf - раскладка timestamp для first_timestamp и last_timestamp в summary
+// или nil: без -summary-span timestamp по пути всех строк не разбирается
+func spanLayoutOf(opts *options) *timeLayout {
+	if !opts.summarySpan {
+		return nil
+	}
+	return opts.timeLayout
+}
+
+// wantSeen - нужны ли first_seen, last_seen и rps: их печатают только JSON и YAML схемы 2
+func wantSeen(opts *options) bool {
+	return opts.schemaVersion >= 2 && (opts.format == "json" || opts.format == "yaml")
+}
+
+// statsExtra - необязательные накопители одного эндпоинта. Они вынесены из Stats за
+// один указатель, чтобы без флагов эндпоинт не занимал лишней памяти
+type statsExtra struct {
+	// sketch или exact - распределение для -percentiles; задано не больше одного из них
+	sketch  *sketch
+	exact   *exactHist
+	spread  *welford
+	logs    *logSum
+	buckets []int64
+	apdex   *apdexCounts
+	slow    []int64
+	seen    *seenRange
+	ips     *hll
+	status  *statusCounts
+	slowest *slowestHeap
+	// sent - min/max/sum/count размера ответа в байтах, заводится с первой строкой
+	sent *Stats
+	// timeline - Stats по интервалам -bucket, ключ - начало интервала в мс Unix
+	timeline map[int64]*Stats
+}
+
+// newExtra заводит накопители для нового эндпоинта
+func (spec *extraSpec) newExtra() *statsExtra {
+	x := &statsExtra{}
+	switch {
+	case spec.exact != nil && !spec.exact.fallback.Load():
+		x.exact = &exactHist{}
+	case spec.sketches != nil:
+		x.sketch = &sketch{}
+	}
+	if spec.stddev {
+		x.spread = &welford{}
+	}
+	if spec.geo {
+		x.logs = &logSum{}
+	}
+	if spec.buckets != nil {
+		x.buckets = make([]int64, len(spec.buckets)+1)
+	}
+	if spec.apdexT > 0 {
+		x.apdex = &apdexCounts{}
+	}
+	if spec.slow != nil {
+		x.slow = make([]int64, len(spec.slow))
+	}
+	if spec.seen {
+		x.seen = &seenRange{}
+	}
+	if spec.bucketWidth > 0 {
+		x.timeline = make(map[int64]*Stats)
+	}
+	if spec.ips != nil {
+		x.ips = spec.ips.newHLL()
+	}
+	if spec.status {
+		x.status = &statusCounts{}
+	}
+	if spec.slowest > 0 {
+		x.slowest = newSlowestHeap(spec.slowest)
+	}
+	return x
+}
+
+// add учитывает разобранную строку line во всех накопителях x. Строка с timestamp
+// не по раскладке учитывается везде, кроме seen и timeline
+func (x *statsExtra) add(spec *extraSpec, line []byte, rec *lineRecord) {
+	v := rec.value
+	if x.exact != nil {
+		x.exact.add(spec.sketches, v)
+	} else if x.sketch != nil {
+		x.sketch.add(spec.sketches, v)
+	}
+	if x.spread != nil {
+		x.spread.add(float64(v))
+	}
+	if x.logs != nil {
+		x.logs.add(v)
+	}
+	if x.buckets != nil {
+		x.buckets[spec.buckets.index(v)]++
+	}
+	if x.apdex != nil {
+		x.apdex.add(spec.apdexT, v)
+	}
+	if x.slow != nil {
+		spec.slow.count(x.slow, v)
+	}
+	if spec.times != nil {
+		if ms, ok := spec.times.parse(line[rec.timestamp.start:rec.timestamp.end]); ok {
+			if x.seen != nil {
+				x.seen.add(ms)
+			}
+			if x.timeline != nil {
+				addTimeline(x.timeline, bucketStart(ms, spec.bucketWidth), v)
+			}
+		}
+	}
+	if x.ips != nil {
+		x.ips.add(spec.ips, line[rec.ip.start:rec.ip.end])
+	}
+	if x.status != nil {
+		x.status[statusClass(line[rec.status.start:rec.status.end])]++
+	}
+	if x.slowest != nil {
+		x.slowest.add(line, rec)
+	}
+	if spec.sent {
+		x.sent = addValue(x.sent, rec.sentBytes)
+	}
+}
+
+func (x *statsExtra) merge(o *statsExtra) {
+	if o.sketch != nil {
+		if x.sketch == nil {
+			x.sketch = &sketch{}
+		}
+		x.sketch.merge(o.sketch)
+	}
+	if o.exact != nil {
+		if x.exact == nil {
+			x.exact = &exactHist{}
+		}
+		x.exact.merge(o.exact)
+	}
+	if o.spread != nil {
+		if x.spread == nil {
+			x.spread = &welford{}
+		}
+		x.spread.merge(o.spread)
+	}
+	if o.logs != nil {
+		if x.logs == nil {
+			x.logs = &logSum{}
+		}
+		x.logs.merge(o.logs)
+	}
+	if o.buckets != nil {
+		if x.buckets == nil {
+			x.buckets = make([]int64, len(o.buckets))
+		}
+		for i, c := range o.buckets {
+			x.buckets[i] += c
+		}
+	}
+	if o.apdex != nil {
+		if x.apdex == nil {
+			x.apdex = &apdexCounts{}
+		}
+		x.apdex.merge(o.apdex)
+	}
+	if o.slow != nil {
+		if x.slow == nil {
+			x.slow = make([]int64, len(o.slow))
+		}
+		for i, c := range o.slow {
+			x.slow[i] += c
+		}
+	}
+	if o.seen != nil {
+		if x.seen == nil {
+			x.seen = &seenRange{}
+		}
+		x.seen.merge(o.seen)
+	}
+	if o.ips != nil {
+		if x.ips == nil {
+			x.ips = o.ips.clone()
+		} else {
+			x.ips.merge(o.ips)
+		}
+	}
+	if o.status != nil {
+		if x.status == nil {
+			x.status = &statusCounts{}
+		}
+		x.status.merge(o.status)
+	}
+	if o.sent != nil {
+		x.sent = mergeInto(x.sent, o.sent)
+	}
+	if o.slowest != nil {
+		if x.slowest == nil {
+			x.slowest = o.slowest.clone()
+		} else {
+			x.slowest.merge(o.slowest)
+		}
+	}
+	if o.timeline != nil {
+		if x.timeline == nil {
+			x.timeline = make(map[int64]*Stats, len(o.timeline))
+		}
+		mergeTimeline(x.timeline, o.timeline)
+	}
+}
+
+// clone копирует x целиком; nil остаётся nil
+func (x *statsExtra) clone() *statsExtra {
+	if x == nil {
+		return nil
+	}
+	c := &statsExtra{buckets: slices.Clone(x.buckets), slow: slices.Clone(x.slow)}
+	if x.sketch != nil {
+		c.sketch = x.sketch.clone()
+	}
+	if x.exact != nil {
+		c.exact = x.exact.clone()
+	}
+	if x.spread != nil {
+		spread := *x.spread
+		c.spread = &spread
+	}
+	if x.logs != nil {
+		logs := *x.logs
+		c.logs = &logs
+	}
+	if x.apdex != nil {
+		apdex := *x.apdex
+		c.apdex = &apdex
+	}
+	if x.seen != nil {
+		seen := *x.seen
+		c.seen = &seen
+	}
+	if x.ips != nil {
+		c.ips = x.ips.clone()
+	}
+	if x.status != nil {
+		status := *x.status
+		c.status = &status
+	}
+	if x.slowest != nil {
+		c.slowest = x.slowest.clone()
+	}
+	if x.sent != nil {
+		c.sent = x.sent.clone()
+	}
+	if x.timeline != nil {
+		c.timeline = cloneTimeline(x.timeline)
+	}
+	return c
+}
+
+// dropExact заменяет точную гистограмму скетчем (откат -exact-percentiles по -max-memory)
+func (x *statsExtra) dropExact(m *sketchMapping) {
+	if x == nil || x.exact == nil {
+		return
+	}
+	sk := x.exact.toSketch(m)
+	if x.sketch != nil {
+		sk.merge(x.sketch)
+	}
+	x.sketch, x.exact = sk, nil
+}
+
+// extraColumn - одно числовое поле необязательных накопителей: apdex, slow_count,
+// unique_ips и т.п. Все форматы, кроме JSON-вложенных buckets, печатают их одним циклом
+type extraColumn struct {
+	// name - имя поля в JSON, YAML, ndjson и колонки csv; table печатает его заглавными
+	name string
+	// title - заголовок колонки markdown
+	title string
+	// value - значение для s; ok = false, если у s нет накопителя (например, у merge)
+	value func(s *Stats) (string, bool)
+}
+
+// extraColumns - поля необязательных накопителей, запрошенных флагами, в порядке вывода
+func (rep *report) extraColumns(sharePrecision int) []extraColumn {
+	var cols []extraColumn
+	if rep.apdex {
+		cols = append(cols, extraColumn{"apdex", "Apdex", apdexOf})
+	}
+	cols = append(cols, rep.slow.columns(sharePrecision)...)
+	if rep.uniqueIPs {
+		cols = append(cols, extraColumn{"unique_ips", "Unique IPs", uniqueIPsOf})
+	}
+	if rep.statusBreakdown {
+		cols = append(cols, statusColumns()...)
+	}
+	return cols
+}
+
+// extraCells - значения cols для s; пустые строки там, где накопителя нет
+func extraCells(cols []extraColumn, s *Stats) []string {
+	cells := make([]string, len(cols))
+	for i, c := range cols {
+		cells[i], _ = c.value(s)
+	}
+	return cells
+}
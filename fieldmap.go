@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// documentKeys - все имена полей, которые может содержать документ JSON/YAML, кроме
+// имён эндпоинтов и файлов. Только их можно переименовать через -field-map
+var documentKeys = []string{
+	"schema_version", "partial", "meta", "input_file", "input_files", "file_size_bytes",
+	"parts", "duration_ms", "lines_parsed", "trimmed_mean", "percent", "method", "version", "files", "combined", "endpoints",
+	"summary", "min_response_time", "avg_response_time", "max_response_time", "count",
+	"sum_response_time", "share", "rps", "first_seen", "last_seen", "first_timestamp", "last_timestamp", "stddev_response_time", "cv_response_time", "duration_seconds", "geo_avg", "mode_response_time", "apdex",
+	"unique_ips", "status_2xx", "status_3xx", "status_4xx", "status_5xx", "status_unknown", "error_rate",
+	"buckets", "timeline", "bucket_start", "min", "avg", "max", "slowest", "response_time", "timestamp", "ip",
+	"distribution", "p50", "p95", "p99", "histogram", "flags", "flagged_endpoints",
+	"bytes", "total",
+	"total_requests", "unique_endpoints", "malformed_lines",
+}
+
+// Поля, значения которых - отображения с именами эндпоинтов, файлов или границами корзин в ключах
+var documentDataMaps = map[string]bool{"endpoints": true, "files": true, "buckets": true, "histogram": true}
+
+// loadFieldMap читает -field-map: JSON-объект {"старое имя": "новое имя"}. extra -
+// поля, которые есть только в этом прогоне, например p99_response_time для -percentiles
+func loadFieldMap(path string, extra []string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("field map %s: %w", path, err)
+	}
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("field map %s: expected a JSON object of strings: %w", path, err)
+	}
+	if err := validateFieldMap(m, append(slices.Clip(documentKeys), extra...)); err != nil {
+		return nil, fmt.Errorf("field map %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// validateFieldMap проверяет, что переименовываются существующие поля и что после
+// переименования все имена различны: иначе два поля одного объекта могли бы совпасть
+func validateFieldMap(m map[string]string, keys []string) error {
+	known := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		known[k] = true
+	}
+	var unknown []string
+	for from, to := range m {
+		if !known[from] {
+			unknown = append(unknown, from)
+		}
+		if to == "" {
+			return fmt.Errorf("empty new name for %q", from)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown field %s; known fields: %s", strings.Join(unknown, ", "), strings.Join(keys, ", "))
+	}
+
+	owner := make(map[string]string, len(keys))
+	for _, k := range keys {
+		name := k
+		if to, ok := m[k]; ok {
+			name = to
+		}
+		if prev, dup := owner[name]; dup {
+			return fmt.Errorf("fields %q and %q would both be named %q", prev, k, name)
+		}
+		owner[name] = k
+	}
+	return nil
+}
+
+// renameFields применяет -field-map к дереву документа; ключи-данные (эндпоинты,
+// файлы) не трогает. dataKeys - ключи obj являются данными
+func renameFields(obj docObject, m map[string]string, dataKeys bool) {
+	for i := range obj {
+		f := &obj[i]
+		switch v := f.value.(type) {
+		case docObject:
+			renameFields(v, m, !dataKeys && documentDataMaps[f.key])
+		case docArray:
+			for _, obj := range v {
+				renameFields(obj, m, false)
+			}
+		}
+		if to, ok := m[f.key]; ok && !dataKeys {
+			f.key = to
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// fieldKind - поле строки, которое умеет находить разбор
+type fieldKind uint8
+
+const (
+	fieldTimestamp fieldKind = iota
+	fieldIP
+	fieldMethod
+	fieldPath
+	fieldStatus
+	fieldTime
+	// fieldSent - поле -bytes-field; в -fields его нет, номер берётся из флага
+	fieldSent
+	fieldKindCount
+)
+
+// fieldNames - имена полей в -fields в порядке fieldKind; у fieldSent имени нет
+var fieldNames = [fieldSent]string{"ts", "ip", "method", "path", "status", "time"}
+
+// maxFieldIndex - наибольший номер поля в -fields
+const maxFieldIndex = 64
+
+// fieldMapping - значение флага -fields: номер каждого поля с единицы, 0 - поля нет.
+// Нулевое значение - флаг не задан, действует раскладка по умолчанию
+type fieldMapping [fieldSent]int
+
+// defaultFieldMapping - раскладка по умолчанию: "ts=1,ip=2,method=3,path=4,status=5,time=6"
+var defaultFieldMapping = fieldMapping{1, 2, 3, 4, 5, responseTimeField}
+
+func (m fieldMapping) isSet() bool {
+	return m != fieldMapping{}
+}
+
+func (m fieldMapping) String() string {
+	if !m.isSet() {
+		return ""
+	}
+	var items []string
+	for kind, index := range m {
+		if index > 0 {
+			items = append(items, fieldNames[kind]+"="+strconv.Itoa(index))
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+func (m *fieldMapping) Set(s string) error {
+	var mapping fieldMapping
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("invalid item %q: expected name=N", item)
+		}
+		kind := slices.Index(fieldNames[:], strings.TrimSpace(name))
+		if kind < 0 {
+			return fmt.Errorf("unknown field %q: expected %s", name, strings.Join(fieldNames[:], ", "))
+		}
+		if mapping[kind] != 0 {
+			return fmt.Errorf("field %q specified twice", name)
+		}
+		index, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || index < 1 || index > maxFieldIndex {
+			return fmt.Errorf("invalid index %q for %s: must be from 1 to %d", value, name, maxFieldIndex)
+		}
+		if other := slices.Index(mapping[:], index); other >= 0 {
+			return fmt.Errorf("fields %s and %s both have index %d", fieldNames[other], name, index)
+		}
+		mapping[kind] = index
+	}
+	for _, kind := range []fieldKind{fieldPath, fieldTime} {
+		if mapping[kind] == 0 {
+			return fmt.Errorf("missing %s: path and time are required", fieldNames[kind])
+		}
+	}
+	*m = mapping
+	return nil
+}
+
+// fieldUsers - флаги, которым нужно поле; без него в -fields или -line-format они бессмысленны
+var fieldUsers = []struct {
+	kind fieldKind
+	flag string
+	on   func(opts *options) bool
+}{
+	{fieldTimestamp, "-bucket", func(opts *options) bool { return opts.bucket > 0 }},
+	{fieldTimestamp, "-capture-slowest", func(opts *options) bool { return opts.captureSlowest > 0 }},
+	{fieldIP, "-capture-slowest", func(opts *options) bool { return opts.captureSlowest > 0 }},
+	{fieldIP, "-unique-ips", func(opts *options) bool { return opts.uniqueIPs }},
+	{fieldMethod, "-group-by method", func(opts *options) bool { return slices.Contains(opts.groupBy, "method") }},
+	{fieldStatus, "-status-breakdown", func(opts *options) bool { return opts.statusBreakdown }},
+}
+
+// validateFields проверяет -fields, -line-format и -input-format вместе с остальными флагами
+func validateFields(opts *options) error {
+	if opts.jsonKeys.isSet() && opts.inputFormat != inputFormatJSONL {
+		return errors.New("-json-keys requires -input-format jsonl")
+	}
+	if (opts.logfmtKeys.isSet() || opts.unknownPath) && opts.inputFormat != inputFormatLogfmt {
+		return errors.New("-logfmt-keys and -unknown-path require -input-format logfmt")
+	}
+	if opts.csvColumns.isSet() && opts.inputFormat != inputFormatCSV && opts.inputFormat != inputFormatTSV {
+		return errors.New("-csv-columns requires -input-format csv or tsv")
+	}
+	var has func(kind fieldKind) bool
+	var source string
+	switch {
+	case opts.inputFormat != inputFormatDefault:
+		if !slices.Contains(inputFormats, opts.inputFormat) {
+			return fmt.Errorf("invalid -input-format %q: must be one of %s", opts.inputFormat, strings.Join(inputFormats, ", "))
+		}
+		// Места полей задаёт сам формат
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{{"-line-format", opts.lineFormatText != ""}, {"-fields", opts.fields.isSet()}, {"-paths-may-contain-spaces", opts.spacedPaths}, {"-bytes-field", opts.bytesField != 0}} {
+			if f.set {
+				return fmt.Errorf("-input-format %s cannot be combined with %s", opts.inputFormat, f.name)
+			}
+		}
+		switch opts.inputFormat {
+		case inputFormatJSONL:
+			keys := keysOr(opts.jsonKeys, defaultJSONKeys)
+			has = func(kind fieldKind) bool { return keys[kind] != "" }
+			source = "-json-keys"
+		case inputFormatLogfmt:
+			keys := keysOr(opts.logfmtKeys, defaultLogfmtKeys)
+			has = func(kind fieldKind) bool { return keys[kind] != "" }
+			source = "-logfmt-keys"
+		case inputFormatCSV, inputFormatTSV:
+			columns := columnsOf(opts)
+			has = func(kind fieldKind) bool { return columns[kind] != 0 }
+			source = "-csv-columns"
+		default:
+			return nil
+		}
+	case opts.lineFormatText != "":
+		switch {
+		case opts.fields.isSet():
+			return errors.New("-line-format cannot be combined with -fields")
+		case opts.spacedPaths:
+			return errors.New("-line-format cannot be combined with -paths-may-contain-spaces")
+		case opts.bytesField != 0:
+			return errors.New("-line-format cannot be combined with -bytes-field")
+		}
+		t, err := compileLineTemplate(opts.lineFormatText)
+		if err != nil {
+			return err
+		}
+		has = func(kind fieldKind) bool { return t.has[kind] }
+		source = "-line-format"
+	case opts.fields.isSet():
+		if opts.spacedPaths {
+			return errors.New("-fields cannot be combined with -paths-may-contain-spaces")
+		}
+		has = func(kind fieldKind) bool { return opts.fields[kind] != 0 }
+		source = "-fields"
+	default:
+		return nil
+	}
+	for _, u := range fieldUsers {
+		if !has(u.kind) && u.on(opts) {
+			return fmt.Errorf("%s needs the %s field, add it to %s", u.flag, fieldNames[u.kind], source)
+		}
+	}
+	return nil
+}
+
+// fieldPlan - какие поля брать из строки по -fields и -bytes-field, по возрастанию номера
+type fieldPlan struct {
+	wanted []fieldSlot
+	// mapping - -fields как задан, и source - откуда он взят, для сообщений об ошибке
+	mapping fieldMapping
+	source  string
+	// sent - среди полей есть -bytes-field
+	sent bool
+}
+
+// fieldSlot - поле номер index (с нуля) строки идёт в kind
+type fieldSlot struct {
+	index int
+	kind  fieldKind
+}
+
+func newFieldPlan(m fieldMapping, bytesField int) *fieldPlan {
+	plan := &fieldPlan{mapping: m, source: "-fields", sent: bytesField > 0}
+	for kind, index := range m {
+		if index > 0 {
+			plan.wanted = append(plan.wanted, fieldSlot{index - 1, fieldKind(kind)})
+		}
+	}
+	if bytesField > 0 {
+		plan.wanted = append(plan.wanted, fieldSlot{bytesField - 1, fieldSent})
+	}
+	slices.SortFunc(plan.wanted, func(a, b fieldSlot) int { return a.index - b.index })
+	return plan
+}
+
+// parse разбирает строку по плану за один проход: поля считаются по пробелам, как в
+// parseLineBytes, и разбор кончается на последнем нужном поле. В отличие от раскладки
+// по умолчанию время ответа - обычное поле, а не весь остаток строки
+func (plan *fieldPlan) parse(line []byte, times timeFormat) (lineRecord, error) {
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	rec, err := plan.split(line)
+	if err != nil {
+		return rec, err
+	}
+	value, bad, err := times.millis(line[rec.responseTime.start:rec.responseTime.end])
+	if err != nil {
+		return rec, &lineParseError{rec.responseTime.start + bad, err}
+	}
+	rec.value = value
+	if plan.sent {
+		return rec, rec.parseSentValue(line)
+	}
+	return rec, nil
+}
+
+// split находит границы нужных полей строки без '\r', но значения не разбирает
+func (plan *fieldPlan) split(line []byte) (lineRecord, error) {
+	var rec lineRecord
+	slots := [fieldKindCount]*span{&rec.timestamp, &rec.ip, &rec.method, &rec.path, &rec.status, &rec.responseTime, &rec.sent}
+	wanted := plan.wanted
+	// n - сколько нужных полей найдено, k - номер текущего поля, prev - последний пробел
+	n, k, prev := 0, 0, -1
+	field := func(p int) bool {
+		if p > prev+1 {
+			if w := wanted[n]; w.index == k {
+				*slots[w.kind] = span{prev + 1, p}
+				n++
+			}
+			k++
+		}
+		prev = p
+		return n == len(wanted)
+	}
+	i := 0
+words:
+	for ; i+8 <= len(line); i += 8 {
+		for m := spaceMask(line[i:]); m != 0; m &= m - 1 {
+			if field(i + bits.TrailingZeros64(m)/8) {
+				break words
+			}
+		}
+	}
+	for ; n < len(wanted) && i < len(line); i++ {
+		if line[i] == ' ' {
+			field(i)
+		}
+	}
+	if n < len(wanted) {
+		// Последнее поле кончается концом строки
+		field(len(line))
+	}
+	if n < len(wanted) {
+		return rec, &lineParseError{len(line), fmt.Errorf("line has %d space-separated fields, %s %s needs %d", k, plan.source, plan.mapping, wanted[len(wanted)-1].index+1)}
+	}
+	return rec, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFieldMappingSet(t *testing.T) {
+	var m fieldMapping
+	if err := m.Set(" time=10, path=7 ,ts=1"); err != nil {
+		t.Fatal(err)
+	}
+	if want := (fieldMapping{1, 0, 0, 7, 0, 10}); m != want {
+		t.Errorf("Set = %v, want %v", m, want)
+	}
+	// String печатает поля в порядке fieldNames, не в порядке флага
+	if got := m.String(); got != "ts=1,path=7,time=10" {
+		t.Errorf("String = %q", got)
+	}
+	if got := (fieldMapping{}).String(); got != "" {
+		t.Errorf("unset String = %q", got)
+	}
+	for _, bad := range []string{
+		"path=4",
+		"time=6",
+		"path=4,time=4",
+		"path=4,time=6,path=5",
+		"path=0,time=6",
+		"path=65,time=6",
+		"path=x,time=6",
+		"url=4,path=5,time=6",
+		"path:4,time=6",
+	} {
+		if err := m.Set(bad); err == nil {
+			t.Errorf("Set(%q) accepted", bad)
+		}
+	}
+}
+
+func TestFieldPlanParse(t *testing.T) {
+	var m fieldMapping
+	if err := m.Set("ts=1,ip=2,method=4,path=5,status=7,time=8"); err != nil {
+		t.Fatal(err)
+	}
+	plan := newFieldPlan(m, 0)
+	line := []byte(`2024-01-01T00:00:00Z 10.0.0.1 - GET /api/users HTTP/1.1 200 0.042 "curl/8.0" extra` + "\r")
+	rec, err := plan.parse(line, timeAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := [5]string{string(line[rec.timestamp.start:rec.timestamp.end]), string(line[rec.ip.start:rec.ip.end]),
+		string(line[rec.method.start:rec.method.end]), string(line[rec.path.start:rec.path.end]), string(line[rec.status.start:rec.status.end])}
+	if got != [5]string{"2024-01-01T00:00:00Z", "10.0.0.1", "GET", "/api/users", "200"} || rec.value != 42 {
+		t.Errorf("parse = %q %dms", got, rec.value)
+	}
+
+	// Время может стоять раньше path, а лишние пробелы - одна граница
+	if err := m.Set("time=1,path=3"); err != nil {
+		t.Fatal(err)
+	}
+	line = []byte("17   x   /b")
+	rec, err = newFieldPlan(m, 0).parse(line, timeIntMillis)
+	if err != nil || string(line[rec.path.start:rec.path.end]) != "/b" || rec.value != 17 || rec.ip != (span{}) {
+		t.Errorf("time=1,path=3: %q %dms, %v", line[rec.path.start:rec.path.end], rec.value, err)
+	}
+	for _, bad := range []string{"17 x", "abc x /b", ""} {
+		if _, err := newFieldPlan(m, 0).parse([]byte(bad), timeIntMillis); err == nil {
+			t.Errorf("time=1,path=3: accepted %q", bad)
+		}
+	}
+	_, err = newFieldPlan(m, 0).parse([]byte("17 x"), timeIntMillis)
+	if err == nil || !strings.Contains(err.Error(), "line has 2 space-separated fields, -fields path=3,time=1 needs 3") {
+		t.Errorf("short line: %v", err)
+	}
+}
+
+func TestFieldsFlag(t *testing.T) {
+	path := writeTempFile(t, "nginx.log", `2024-01-01T00:00:00Z 10.0.0.1 - - "GET /a HTTP/1.1" 200 12 "ua"`+"\n"+
+		`2024-01-01T00:00:01Z 10.0.0.2 - - "GET /a HTTP/1.1" 500 30 "ua"`+"\n"+
+		`2024-01-01T00:00:02Z 10.0.0.2 - - "POST /b HTTP/1.1" 201 7`+"\n"+
+		`2024-01-01T00:00:03Z short line`+"\n")
+	args := []string{"-fields", "ts=1,ip=2,path=6,status=8,time=9", "-include-count"}
+	out, code := runAnalyzeFile(t, append(args, path)...)
+	if code != exitOK {
+		t.Fatalf("exit %d", code)
+	}
+	for _, want := range []string{`"/a": {`, `"avg_response_time": 21.0,`, `"/b": {`, `"malformed_lines": 1`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%s missing:\n%s", want, out)
+		}
+	}
+	// Те же поля в другом формате дают тот же результат
+	out, _ = runAnalyzeFile(t, append(args, "-status-breakdown", "-format", "csv", path)...)
+	if !strings.Contains(out, "/a,2,") || !strings.Contains(out, "/b,1,") {
+		t.Errorf("csv:\n%s", out)
+	}
+
+	for _, bad := range [][]string{
+		// Флагу нужно поле, которого нет в -fields
+		{"-fields", "path=6,time=9", "-unique-ips"},
+		{"-fields", "path=6,time=9", "-group-by", "method,path"},
+		{"-fields", "ip=2,path=6,time=9", "-bucket", "1m"},
+		{"-fields", "path=6,time=9", "-paths-may-contain-spaces"},
+		{"-fields", "path=6,time=9", "-input-format", "csv"},
+		{"-fields", "path=6"},
+	} {
+		if _, code := runAnalyzeFile(t, append(bad, path)...); code != exitUsage {
+			t.Errorf("%v: exit %d, want %d", bad, code, exitUsage)
+		}
+	}
+}
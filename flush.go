@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// merger сводит дельты воркеров в результаты файлов и общий итог по мере поступления.
+// Снимок для -flush-interval берётся из другой горутины, поэтому всё под мьютексом
+type merger struct {
+	mu    sync.Mutex
+	total *pipelineResult
+	files []fileResult
+}
+
+func newMerger() *merger {
+	return &merger{total: &pipelineResult{totals: make(map[string]*Stats)}}
+}
+
+// beginFile заводит результат очередного файла; дельты в него добавляет addPart
+func (m *merger) beginFile(path string, parts int, fileSize int64) *pipelineResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := &pipelineResult{totals: make(map[string]*Stats), fileSize: fileSize, parts: parts}
+	m.files = append(m.files, fileResult{path, res})
+	m.total.fileSize += fileSize
+	m.total.parts += parts
+	return res
+}
+
+// addPart добавляет дельту воркера к результату файла res и к общему итогу
+func (m *merger) addPart(res *pipelineResult, r partResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, dst := range []*pipelineResult{res, m.total} {
+		mergeStats(dst.totals, r.stats)
+		dst.counters = dst.counters.add(r.counters)
+		dst.bytesRead += r.bytesRead
+		dst.addRawKeys(r.rawKeys)
+		dst.span.merge(&r.span)
+	}
+}
+
+func (m *merger) markPartial(res *pipelineResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res.partial = true
+	m.total.partial = true
+}
+
+// snapshot возвращает копию текущего состояния, которую можно рендерить без блокировки
+func (m *merger) snapshot() (*pipelineResult, []fileResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clone := func(r *pipelineResult) *pipelineResult {
+		c := &pipelineResult{totals: make(map[string]*Stats, len(r.totals))}
+		c.add(r)
+		return c
+	}
+	files := make([]fileResult, len(m.files))
+	for i, f := range m.files {
+		files[i] = fileResult{f.path, clone(f.res)}
+	}
+	return clone(m.total), files
+}
+
+// flusher раз в interval переписывает -o промежуточным результатом с "partial": true
+type flusher struct {
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// startFlusher запускает периодическую запись; write получает снимок merger
+func startFlusher(interval time.Duration, m *merger, write func(*pipelineResult, []fileResult) error) *flusher {
+	f := &flusher{stopCh: make(chan struct{})}
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				res, files := m.snapshot()
+				if err := write(res, files); err != nil {
+					// Промежуточная запись не должна обрывать долгий прогон, итог всё равно будет записан
+					logger.warnf("error writing intermediate result: %v", err)
+					continue
+				}
+				logger.infof("intermediate result written: %s lines", humanCount(res.counters.total()))
+			case <-f.stopCh:
+				return
+			}
+		}
+	}()
+	return f
+}
+
+// stop дожидается текущей записи, чтобы она не легла поверх итогового результата
+func (f *flusher) stop() {
+	if f == nil || f.stopCh == nil {
+		return
+	}
+	close(f.stopCh)
+	f.wg.Wait()
+	f.stopCh = nil
+}
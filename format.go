@@ -0,0 +1,195 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPrecision = 1
+	maxPrecision     = 6
+)
+
+var pow10 = [...]uint64{1, 10, 100, 1000, 10000, 100000, 1000000}
+
+const defaultUnit = "ms"
+
+// timeUnit - единица, в которой печатаются min/avg/max. В логе и в Stats время
+// всегда в миллисекундах, переводим только при выводе, чтобы не терять точность
+type timeUnit struct {
+	name string
+	// perMs - сколько единиц в миллисекунде (us), msPer - сколько миллисекунд в единице (s)
+	perMs, msPer int64
+	// humanize - печатать время как time.Duration (1.2s, 183ms, 4µs) вместо чисел в единице
+	humanize bool
+}
+
+var timeUnits = map[string]timeUnit{
+	"ms": {name: "ms", perMs: 1, msPer: 1},
+	"s":  {name: "s", perMs: 1, msPer: 1000},
+	"us": {name: "us", perMs: 1000, msPer: 1},
+}
+
+// humanUnit - единица для -humanize; используется только в table и markdown
+var humanUnit = timeUnit{name: "human", perMs: 1, msPer: 1, humanize: true}
+
+func unitNames() string {
+	return "ms, s, us"
+}
+
+// formatValue печатает min/max: в ms и us целым числом, в s - с prec знаками (999ms -> "1.0")
+func (u timeUnit) formatValue(ms int64, prec int) string {
+	if u.humanize {
+		return humanDuration(float64(ms))
+	}
+	if u.msPer == 1 {
+		return strconv.FormatInt(ms*u.perMs, 10)
+	}
+	return formatRatio(ms, u.msPer, prec)
+}
+
+// formatExact печатает ms в единице u без округления: 250ms в s - "0.25"
+func (u timeUnit) formatExact(ms int64) string {
+	if u.msPer == 1 {
+		return u.formatValue(ms, 0)
+	}
+	return strconv.FormatFloat(float64(ms)/float64(u.msPer), 'f', -1, 64)
+}
+
+// formatStats - min, avg и max в единице u, как их печатают JSON-форматы
+func (u timeUnit) formatStats(s *Stats, prec int) (minV, avg, maxV string) {
+	return u.formatValue(s.Min, prec), u.formatAvg(s, prec), u.formatValue(s.Max, prec)
+}
+
+// formatMs печатает оценку в миллисекундах (квантиль -percentiles) в единице u с prec знаками
+func (u timeUnit) formatMs(ms float64, prec int) string {
+	if u.humanize {
+		return humanDuration(ms)
+	}
+	return formatFloat(ms*float64(u.perMs)/float64(u.msPer), u.precision(prec))
+}
+
+// precision - сколько знаков печатать в единице u: микросекунды всегда целые, дробь
+// микросекунды для времени ответа в миллисекундах - шум
+func (u timeUnit) precision(prec int) int {
+	if u.perMs > 1 {
+		return 0
+	}
+	return prec
+}
+
+// formatAvg печатает Sum/Count в единице u с prec знаками после запятой
+func (u timeUnit) formatAvg(s *Stats, prec int) string {
+	if u.humanize {
+		return humanDuration(float64(s.Sum) / float64(s.Count))
+	}
+	prec = u.precision(prec)
+	num, okNum := mulInt64(s.Sum, u.perMs)
+	den, okDen := mulInt64(s.Count, u.msPer)
+	if !okNum || !okDen {
+		return formatFloat(float64(s.Sum)*float64(u.perMs)/(float64(s.Count)*float64(u.msPer)), prec)
+	}
+	return formatRatio(num, den, prec)
+}
+
+// humanDuration печатает ms как time.Duration, оставляя 2-3 значащие цифры:
+// 1234ms -> "1.2s", 183.4ms -> "183ms", 0.004ms -> "4µs". Ноль - "0ms", а не "0s"
+func humanDuration(ms float64) string {
+	d := time.Duration(ms * float64(time.Millisecond))
+	switch {
+	case d >= time.Second:
+		d = d.Round(100 * time.Millisecond)
+	case d >= 10*time.Millisecond:
+		d = d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		d = d.Round(100 * time.Microsecond)
+	default:
+		d = d.Round(time.Microsecond)
+	}
+	if d == 0 {
+		return "0ms"
+	}
+	return d.String()
+}
+
+func mulInt64(a, b int64) (int64, bool) {
+	hi, lo := bits.Mul64(absU64(a), absU64(b))
+	if hi != 0 || lo > math.MaxInt64 {
+		return 0, false
+	}
+	return a * b, true
+}
+
+// formatRatio печатает num/den с prec знаками после запятой.
+// Считаем в целых числах (128 бит), округляя половину от нуля: 99.95 -> "100.0",
+// 0.25 -> "0.3" при prec=1 независимо от платформы и представления float
+func formatRatio(num, den int64, prec int) string {
+	neg := (num < 0) != (den < 0)
+	n, d := absU64(num), absU64(den)
+
+	hi, lo := bits.Mul64(n, pow10[prec])
+	if hi >= d {
+		// Не помещается в uint64 - такие значения нам не встретятся, но не падаем
+		return strconv.FormatFloat(float64(num)/float64(den), 'f', prec, 64)
+	}
+	q, r := bits.Div64(hi, lo, d)
+	if r >= d-r {
+		q++
+	}
+	return formatScaled(q, prec, neg && q != 0)
+}
+
+// formatShare - доля count в total в процентах с prec знаками. Каждое значение округлено
+// верно само по себе, поэтому сумма по эндпоинтам может отличаться от 100 на доли процента
+func formatShare(count, total int64, prec int) string {
+	if total == 0 {
+		return formatScaled(0, prec, false)
+	}
+	// count*100 переполняет int64 только на нереальных объёмах, там хватит и float
+	if count > math.MaxInt64/100 {
+		return formatFloat(float64(count)*100/float64(total), prec)
+	}
+	return formatRatio(count*100, total, prec)
+}
+
+// formatFloat - то же округление для значений, которые уже являются float (например, после -unit)
+func formatFloat(v float64, prec int) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return strconv.FormatFloat(v, 'f', prec, 64)
+	}
+	scaled := math.Abs(v) * float64(pow10[prec])
+	if scaled >= 1<<63 {
+		return strconv.FormatFloat(v, 'f', prec, 64)
+	}
+	q := uint64(math.Floor(scaled))
+	// Сравниваем остаток с половиной с допуском, чтобы 99.95 (99.9499999...) округлялось вверх
+	if frac := scaled - float64(q); frac >= 0.5-1e-9 {
+		q++
+	}
+	return formatScaled(q, prec, v < 0 && q != 0)
+}
+
+func formatScaled(q uint64, prec int, neg bool) string {
+	var sb strings.Builder
+	if neg {
+		sb.WriteByte('-')
+	}
+	sb.WriteString(strconv.FormatUint(q/pow10[prec], 10))
+	if prec > 0 {
+		frac := strconv.FormatUint(q%pow10[prec], 10)
+		sb.WriteByte('.')
+		sb.WriteString(strings.Repeat("0", prec-len(frac)))
+		sb.WriteString(frac)
+	}
+	return sb.String()
+}
+
+func absU64(v int64) uint64 {
+	if v < 0 {
+		return uint64(-v)
+	}
+	return uint64(v)
+}
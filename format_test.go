@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFormatRatio(t *testing.T) {
+	tests := []struct {
+		num, den int64
+		prec     int
+		want     string
+	}{
+		{9995, 100, 1, "100.0"},
+		{-9995, 100, 1, "-100.0"},
+		{9994, 100, 1, "99.9"},
+		{1, 4, 1, "0.3"},
+		{-1, 4, 1, "-0.3"},
+		{-1, 100, 1, "0.0"},
+		{5, 2, 0, "3"},
+		{-5, 2, 0, "-3"},
+		{3, 2, 0, "2"},
+		{1, 4, 0, "0"},
+		{10, 3, 6, "3.333333"},
+		{2, 3, 6, "0.666667"},
+		{0, 5, 2, "0.00"},
+		{1000, 1, 3, "1000.000"},
+	}
+	for _, tt := range tests {
+		if got := formatRatio(tt.num, tt.den, tt.prec); got != tt.want {
+			t.Errorf("formatRatio(%d, %d, %d) = %q, want %q", tt.num, tt.den, tt.prec, got, tt.want)
+		}
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		v    float64
+		prec int
+		want string
+	}{
+		// 99.95 и 2.675 в float чуть меньше половины, но округляются вверх, как в formatRatio
+		{99.95, 1, "100.0"},
+		{2.675, 2, "2.68"},
+		{0.05, 1, "0.1"},
+		{-0.05, 1, "-0.1"},
+		{1.5, 0, "2"},
+		{42, 0, "42"},
+		{0.0004, 3, "0.000"},
+	}
+	for _, tt := range tests {
+		if got := formatFloat(tt.v, tt.prec); got != tt.want {
+			t.Errorf("formatFloat(%v, %d) = %q, want %q", tt.v, tt.prec, got, tt.want)
+		}
+	}
+}
+
+func TestFormatAvgPrecision(t *testing.T) {
+	s := &Stats{Min: 1, Max: 200, Sum: 19990, Count: 200}
+	ms := timeUnits["ms"]
+	for prec, want := range []string{"100", "100.0", "99.95", "99.950", "99.9500", "99.95000", "99.950000"} {
+		if got := ms.formatAvg(s, prec); got != want {
+			t.Errorf("avg 99.95 at precision %d = %q, want %q", prec, got, want)
+		}
+	}
+}
+
+func TestPrecisionRange(t *testing.T) {
+	for _, bad := range []string{"-1", "7"} {
+		if _, err := parseArgs([]string{"-precision", bad, "a.log"}); err == nil {
+			t.Errorf("-precision %s accepted", bad)
+		}
+	}
+	opts, err := parseArgs([]string{"a.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.precision != defaultPrecision {
+		t.Errorf("default precision = %d, want %d", opts.precision, defaultPrecision)
+	}
+}
+
+func TestPrecisionSharedByFormats(t *testing.T) {
+	// 19 по 100ms и одна 99ms: avg ровно 99.95
+	var sb strings.Builder
+	for i := range 20 {
+		ms := 100
+		if i == 0 {
+			ms = 99
+		}
+		fmt.Fprintf(&sb, "2024-01-01T00:00:00Z 10.0.0.1 GET /a %d %d\n", 200, ms)
+	}
+	path := writeTempFile(t, "p.log", sb.String())
+	avgOf := map[string]string{
+		"json":   `"avg_response_time": %s,`,
+		"ndjson": `"avg":%s,`,
+		"csv":    `,99,%s,100`,
+	}
+	for format, pattern := range avgOf {
+		for prec, avg := range map[string]string{"0": "100", "1": "100.0", "2": "99.95"} {
+			out, code := runAnalyzeFile(t, "-format", format, "-precision", prec, path)
+			if code != exitOK {
+				t.Fatalf("-format %s: exit %d", format, code)
+			}
+			if want := fmt.Sprintf(pattern, avg); !strings.Contains(out, want) {
+				t.Errorf("-format %s -precision %s: want %s in\n%s", format, prec, want, out)
+			}
+		}
+	}
+}
+
+func TestTimeUnitBoundaries(t *testing.T) {
+	s, us := timeUnits["s"], timeUnits["us"]
+	for _, tt := range []struct {
+		ms   int64
+		prec int
+		want string
+	}{
+		{999, 1, "1.0"},
+		{949, 1, "0.9"},
+		{950, 1, "1.0"},
+		{1049, 1, "1.0"},
+		{1050, 1, "1.1"},
+		{999, 3, "0.999"},
+		{1500, 0, "2"},
+		{0, 1, "0.0"},
+	} {
+		if got := s.formatValue(tt.ms, tt.prec); got != tt.want {
+			t.Errorf("%dms in s at precision %d = %q, want %q", tt.ms, tt.prec, got, tt.want)
+		}
+	}
+	if got := us.formatValue(12, 1); got != "12000" {
+		t.Errorf("12ms in us = %q, want 12000", got)
+	}
+	// Микросекунды целые при любом -precision, в том числе у avg и оценок
+	avg := &Stats{Sum: 19990, Count: 200}
+	for _, prec := range []int{0, 1, 3} {
+		if got := us.formatAvg(avg, prec); got != "99950" {
+			t.Errorf("avg 99.95ms in us at precision %d = %q, want 99950", prec, got)
+		}
+		if got := us.formatMs(1.2345, prec); got != "1235" {
+			t.Errorf("1.2345ms in us at precision %d = %q, want 1235", prec, got)
+		}
+	}
+	if got := s.formatAvg(avg, 3); got != "0.100" {
+		t.Errorf("avg 99.95ms in s = %q, want 0.100", got)
+	}
+}
+
+func TestUnitSharedByFormats(t *testing.T) {
+	path := writeTempFile(t, "u.log", "2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 999\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 1000\n")
+	for unit, want := range map[string][2]string{
+		"s":  {`"min_response_time": 1.0,`, `,1.0,1.0,1.0`},
+		"us": {`"avg_response_time": 999500,`, `,999000,999500,1000000`},
+	} {
+		for i, format := range []string{"json", "csv"} {
+			out, code := runAnalyzeFile(t, "-unit", unit, "-format", format, path)
+			if code != exitOK || !strings.Contains(out, want[i]) {
+				t.Errorf("-unit %s -format %s: exit %d, want %s in\n%s", unit, format, code, want[i], out)
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"time"
+)
+
+const genUsageHeader = `Usage: iw_challenge gen [flags]
+
+Writes a synthetic access log in the format analyze expects:
+  2024-01-01T00:00:00Z 192.168.1.1 GET /api/resource/3 200 123
+
+Endpoint popularity follows a Zipf distribution; every endpoint gets its own
+mean latency around -mean drawn from the -latency distribution.
+
+Flags:
+`
+
+type genOptions struct {
+	outPath   string
+	size      byteSize
+	lines     int64
+	endpoints int
+	latency   string
+	mean      float64
+	spread    float64
+	malformed float64
+	rps       int
+	start     string
+	seed      uint64
+}
+
+var latencyDists = map[string]bool{"uniform": true, "normal": true, "lognormal": true, "exp": true}
+
+func genMain(args []string) int {
+	opts := &genOptions{}
+	fs := flag.NewFlagSet("gen", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), genUsageHeader)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.outPath, "o", "-", "write the log to `file` (\"-\" means stdout)")
+	fs.Var(&opts.size, "size", "stop after this many bytes, e.g. 512M (overrides -lines)")
+	fs.Int64Var(&opts.lines, "lines", 1000, "number of lines to generate")
+	fs.IntVar(&opts.endpoints, "endpoints", 20, "number of distinct endpoints")
+	fs.StringVar(&opts.latency, "latency", "lognormal", "latency distribution: uniform, normal, lognormal, exp")
+	fs.Float64Var(&opts.mean, "mean", 100, "mean response time in ms")
+	fs.Float64Var(&opts.spread, "spread", 0.5, "distribution width: stddev as a fraction of the mean for normal, sigma for lognormal")
+	fs.Float64Var(&opts.malformed, "malformed", 0, "fraction of lines with a broken response time field")
+	fs.IntVar(&opts.rps, "rps", 100, "lines per second of log time")
+	fs.StringVar(&opts.start, "start", "2024-01-01T00:00:00Z", "timestamp of the first line (RFC3339)")
+	fs.Uint64Var(&opts.seed, "seed", 1, "random seed")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	usageErr := func(format string, a ...any) int {
+		fmt.Fprintf(fs.Output(), format+"\n", a...)
+		fs.Usage()
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		return usageErr("unexpected arguments: %v", fs.Args())
+	}
+	if !latencyDists[opts.latency] {
+		return usageErr("unknown -latency %q", opts.latency)
+	}
+	if opts.endpoints < 1 || opts.lines < 0 || opts.mean <= 0 || opts.spread < 0 || opts.rps < 1 {
+		return usageErr("-endpoints, -mean and -rps must be positive, -lines and -spread non-negative")
+	}
+	if opts.malformed < 0 || opts.malformed > 1 {
+		return usageErr("-malformed must be between 0 and 1")
+	}
+	start, err := time.Parse(time.RFC3339, opts.start)
+	if err != nil {
+		return usageErr("invalid -start: %v", err)
+	}
+
+	out, err := createOutput(opts.outPath, 0)
+	if err != nil {
+		logger.errorf("error creating output file: %v", err)
+		return exitError
+	}
+	defer out.abort()
+	w := bufio.NewWriterSize(out, 1<<20)
+	generateLog(w, opts, start.UTC())
+	if err := closeOutput(w, out); err != nil {
+		logger.errorf("error writing output: %v", err)
+		return exitError
+	}
+	return exitOK
+}
+
+func generateLog(w *bufio.Writer, opts *genOptions, start time.Time) {
+	rng := rand.New(rand.NewPCG(opts.seed, opts.seed^0x9e3779b97f4a7c15))
+	zipf := rand.NewZipf(rng, 1.1, 1, uint64(opts.endpoints-1))
+
+	paths := make([]string, opts.endpoints)
+	means := make([]float64, opts.endpoints)
+	for i := range paths {
+		paths[i] = "/api/resource/" + strconv.Itoa(i)
+		means[i] = opts.mean * (0.5 + rng.Float64())
+	}
+	methods := []string{"GET", "GET", "GET", "POST", "PUT", "DELETE"}
+	statuses := []int{200, 200, 200, 200, 201, 204, 301, 400, 404, 500}
+
+	sample := func(mean float64) int64 {
+		var v float64
+		switch opts.latency {
+		case "uniform":
+			v = rng.Float64() * 2 * mean
+		case "normal":
+			v = mean + rng.NormFloat64()*mean*opts.spread
+		case "lognormal":
+			sigma := opts.spread
+			v = math.Exp(math.Log(mean) - sigma*sigma/2 + sigma*rng.NormFloat64())
+		case "exp":
+			v = rng.ExpFloat64() * mean
+		}
+		return int64(max(math.Round(v), 0))
+	}
+
+	line := make([]byte, 0, 256)
+	var written int64
+	for n := int64(0); ; n++ {
+		if opts.size > 0 {
+			if written >= int64(opts.size) {
+				break
+			}
+		} else if n >= opts.lines {
+			break
+		}
+
+		ep := int(zipf.Uint64())
+		ts := start.Add(time.Duration(n/int64(opts.rps)) * time.Second)
+
+		// Парсер analyze рассчитывает на префикс фиксированной ширины,
+		// поэтому IP всегда вида 192.168.X.Y с однозначными октетами
+		line = ts.AppendFormat(line[:0], time.RFC3339)
+		line = append(line, " 192.168."...)
+		line = strconv.AppendInt(line, int64(rng.IntN(9)+1), 10)
+		line = append(line, '.')
+		line = strconv.AppendInt(line, int64(rng.IntN(9)+1), 10)
+		line = append(line, ' ')
+		line = append(line, methods[rng.IntN(len(methods))]...)
+		line = append(line, ' ')
+		line = append(line, paths[ep]...)
+		line = append(line, ' ')
+		line = strconv.AppendInt(line, int64(statuses[rng.IntN(len(statuses))]), 10)
+		line = append(line, ' ')
+		if opts.malformed > 0 && rng.Float64() < opts.malformed {
+			line = append(line, "n/a"...)
+		} else {
+			line = strconv.AppendInt(line, sample(means[ep]), 10)
+		}
+		line = append(line, '\n')
+
+		w.Write(line)
+		written += int64(len(line))
+	}
+}
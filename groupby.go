@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// groupBy - значение флага -group-by: по каким полям строки собирается ключ агрегации.
+// Поля перечислены в порядке, в котором они стоят в ключе
+type groupBy []string
+
+// groupByFields - поля, по которым можно группировать
+var groupByFields = []string{"method", "path"}
+
+const defaultGroupBy = "path"
+
+func (g groupBy) String() string {
+	return strings.Join(g, ",")
+}
+
+func (g *groupBy) Set(s string) error {
+	var fields groupBy
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !slices.Contains(groupByFields, item) {
+			return fmt.Errorf("unknown field %q: expected %s", item, strings.Join(groupByFields, ", "))
+		}
+		if slices.Contains(fields, item) {
+			return fmt.Errorf("field %q specified twice", item)
+		}
+		fields = append(fields, item)
+	}
+	if len(fields) == 0 {
+		return fmt.Errorf("expected at least one of %s", strings.Join(groupByFields, ", "))
+	}
+	*g = fields
+	return nil
+}
+
+// keyKind - как processLines собирает ключ для groupBy
+type keyKind uint8
+
+const (
+	keyPath keyKind = iota
+	keyMethod
+	// keyMethodPath - "GET /api/users": в строке метод и путь стоят рядом через один
+	// пробел, так что ключ - просто кусок строки без копирования
+	keyMethodPath
+	// keyPathMethod - "/api/users GET": такого куска в строке нет, ключ собирается в буфер
+	keyPathMethod
+)
+
+func (g groupBy) kind() keyKind {
+	switch g.String() {
+	case "method":
+		return keyMethod
+	case "method,path":
+		return keyMethodPath
+	case "path,method":
+		return keyPathMethod
+	}
+	return keyPath
+}
+
+// key - ключ агрегации строки line. Результат ссылается на line или на p.keyBuf и
+// годится только до следующей строки
+func (p *lineProcessor) key(line []byte, rec *lineRecord) []byte {
+	switch p.keyKind {
+	case keyMethod:
+		return line[rec.method.start:rec.method.end]
+	case keyMethodPath:
+		// Обычно method и path разделяет один пробел, и ключ - это кусок самой строки.
+		// В csv, tsv и -line-format между ними может стоять другой разделитель
+		if rec.path.start == rec.method.end+1 && line[rec.method.end] == ' ' && rec.altPath == nil {
+			return line[rec.method.start:rec.path.end]
+		}
+		p.keyBuf = append(p.keyBuf[:0], line[rec.method.start:rec.method.end]...)
+		p.keyBuf = append(p.keyBuf, ' ')
+		p.keyBuf = append(p.keyBuf, rec.pathOf(line)...)
+		return p.keyBuf
+	case keyPathMethod:
+		p.keyBuf = append(p.keyBuf[:0], rec.pathOf(line)...)
+		p.keyBuf = append(p.keyBuf, ' ')
+		p.keyBuf = append(p.keyBuf, line[rec.method.start:rec.method.end]...)
+		return p.keyBuf
+	}
+	return rec.pathOf(line)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGroupByMethodPathKey(t *testing.T) {
+	rows := [][]string{
+		{"2024-01-01T00:00:00Z", "10.0.0.1", "GET", "/a", "200", "10"},
+		{"2024-01-01T00:00:01Z", "10.0.0.1", "GET", "/a", "200", "30"},
+		{"2024-01-01T00:00:02Z", "10.0.0.2", "POST", "/b", "201", "5"},
+	}
+	join := func(sep string, quotePath bool) string {
+		var sb strings.Builder
+		for i, r := range rows {
+			f := append([]string(nil), r...)
+			if quotePath && i == 0 {
+				f[3] = `"` + f[3] + `"`
+			}
+			sb.WriteString(strings.Join(f, sep) + "\n")
+		}
+		return sb.String()
+	}
+	inputs := []struct {
+		name, content string
+		args          []string
+	}{
+		{"default", join(" ", false), nil},
+		{"csv", join(",", false), []string{"-input-format", "csv"}},
+		// Путь в кавычках раньше давал ключ "GET /a", а без кавычек - "GET,/a"
+		{"csv, one path quoted", join(",", true), []string{"-input-format", "csv"}},
+		{"tsv", join("\t", false), []string{"-input-format", "tsv"}},
+		{"line-format", join("|", false), []string{"-line-format", "{ts}|{ip}|{method}|{path}|{status}|{time_ms}"}},
+	}
+	for _, group := range []struct {
+		by   string
+		want map[string]int64
+	}{
+		{"method,path", map[string]int64{"GET /a": 2, "POST /b": 1}},
+		{"path,method", map[string]int64{"/a GET": 2, "/b POST": 1}},
+	} {
+		for _, in := range inputs {
+			args := append(append([]string{"-include-count", "-group-by", group.by}, in.args...), writeTempFile(t, "in.log", in.content))
+			out, code := runAnalyzeFile(t, args...)
+			if code != exitOK {
+				t.Fatalf("%s, -group-by %s: exit %d", in.name, group.by, code)
+			}
+			var doc struct {
+				Endpoints map[string]struct {
+					Count int64 `json:"count"`
+				} `json:"endpoints"`
+			}
+			if err := json.Unmarshal([]byte(out), &doc); err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]int64{}
+			for k, e := range doc.Endpoints {
+				got[k] = e.Count
+			}
+			if !reflect.DeepEqual(got, group.want) {
+				t.Errorf("%s, -group-by %s: keys %v, want %v", in.name, group.by, got, group.want)
+			}
+		}
+	}
+}
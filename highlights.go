@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Сколько эндпоинтов в каждом списке сводки
+const highlightsSize = 5
+
+// highlights - сводка над основной таблицей: самые медленные по avg и по max
+// и самые частые. Списки короче highlightsSize, если эндпоинтов меньше
+type highlights struct {
+	byAvg, byMax, byCount []endpointStat
+}
+
+// findHighlights выбирает лидеров по всем эндпоинтам, до -top и -min-count,
+// или nil, если эндпоинтов нет
+func findHighlights(totals map[string]*Stats) *highlights {
+	if len(totals) == 0 {
+		return nil
+	}
+	all := make([]endpointStat, 0, len(totals))
+	for name, s := range totals {
+		all = append(all, endpointStat{name, s})
+	}
+	top := func(key string) []endpointStat {
+		entries := append([]endpointStat(nil), all...)
+		sortEntries(entries, sortOrder{key: key, desc: true})
+		return entries[:min(len(entries), highlightsSize)]
+	}
+	return &highlights{byAvg: top("avg"), byMax: top("max"), byCount: top("count")}
+}
+
+// highlightItem - строка сводки: эндпоинт и отформатированное значение
+type highlightItem struct {
+	Name, Value string
+}
+
+// highlightList - один список сводки с заголовком
+type highlightList struct {
+	Title string
+	Items []highlightItem
+}
+
+// lists форматирует сводку для вывода: avg и max в unit, count - как есть
+func (h *highlights) lists(unit timeUnit, precision int, count func(int64) string) []highlightList {
+	items := func(entries []endpointStat, value func(*Stats) string) []highlightItem {
+		out := make([]highlightItem, len(entries))
+		for i, e := range entries {
+			out[i] = highlightItem{e.name, value(e.stats)}
+		}
+		return out
+	}
+	return []highlightList{
+		{"Slowest by avg", items(h.byAvg, func(s *Stats) string { return unit.formatAvg(s, precision) })},
+		{"Slowest by max", items(h.byMax, func(s *Stats) string { return unit.formatValue(s.Max, precision) })},
+		{"Most requested", items(h.byCount, func(s *Stats) string { return count(s.Count) })},
+	}
+}
+
+// writeTableHighlights пишет сводку для -format table: три списка с выровненными колонками
+func writeTableHighlights(w io.Writer, h *highlights, unit timeUnit, precision int) error {
+	lists := h.lists(unit, precision, groupThousands)
+	nameWidth, valueWidth := 0, 0
+	for _, l := range lists {
+		for _, it := range l.Items {
+			nameWidth = max(nameWidth, utf8.RuneCountInString(truncate(it.Name, maxTableEndpointWidth)))
+			valueWidth = max(valueWidth, utf8.RuneCountInString(it.Value))
+		}
+	}
+	var sb strings.Builder
+	for _, l := range lists {
+		sb.WriteString(l.Title + ":\n")
+		for _, it := range l.Items {
+			name := truncate(it.Name, maxTableEndpointWidth)
+			pad := strings.Repeat(" ", nameWidth-utf8.RuneCountInString(name))
+			// µs занимает два байта, поэтому значения дополняем по числу рун, а не через %*s
+			valuePad := strings.Repeat(" ", valueWidth-utf8.RuneCountInString(it.Value))
+			fmt.Fprintf(&sb, "  %s%s  %s%s\n", name, pad, valuePad, it.Value)
+		}
+	}
+	sb.WriteByte('\n')
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// writeMarkdownHighlights пишет сводку для -format markdown строкой на список
+func writeMarkdownHighlights(w io.Writer, h *highlights, unit timeUnit, precision int) error {
+	var sb strings.Builder
+	for _, l := range h.lists(unit, precision, func(n int64) string { return strconv.FormatInt(n, 10) }) {
+		parts := make([]string, len(l.Items))
+		for i, it := range l.Items {
+			parts[i] = markdownEscaper.Replace(it.Name) + " (" + it.Value + ")"
+		}
+		fmt.Fprintf(&sb, "**%s:** %s\n\n", l.Title, strings.Join(parts, ", "))
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+)
+
+const (
+	// defaultHLLPrecision - 2^12 регистров по байту: 4KB на эндпоинт и ошибка около 1.6%
+	defaultHLLPrecision = 12
+	minHLLPrecision     = 4
+	maxHLLPrecision     = 16
+	// hllWarnBytes - с какого объёма регистров всех эндпоинтов -unique-ips предупреждает о памяти
+	hllWarnBytes = 256 << 20
+)
+
+// hllSpec - настройки -unique-ips на прогон
+type hllSpec struct {
+	precision uint8
+	// warned - предупреждение о памяти уже выдано; пишет и читает только сборщик runPipeline
+	warned bool
+}
+
+func newHLLSpec(precision int) *hllSpec {
+	return &hllSpec{precision: uint8(precision)}
+}
+
+// hashIP - 64-битный хеш IP: FNV-1a и перемешивание из MurmurHash3, чтобы старшие биты,
+// по которым выбирается регистр, зависели от всех байт. Хеш без случайного seed: у
+// одного лога оценка одна и та же при любом числе воркеров и от запуска к запуску
+func hashIP(b []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// hll - HyperLogLog для числа различных IP одного эндпоинта: 2^precision регистров,
+// в каждом наибольший ранг (позиция первой единицы) среди хешей, попавших в регистр
+type hll struct {
+	registers []uint8
+}
+
+func (spec *hllSpec) newHLL() *hll {
+	return &hll{registers: make([]uint8, 1<<spec.precision)}
+}
+
+func (h *hll) add(spec *hllSpec, b []byte) {
+	x := hashIP(b)
+	p := spec.precision
+	// Старшие p бит выбирают регистр, по остальным считается ранг. Единица в младшем
+	// из сдвинутых бит ограничивает ранг, если остаток хеша нулевой
+	rank := uint8(bits.LeadingZeros64(x<<p|1<<(p-1))) + 1
+	if i := x >> (64 - p); rank > h.registers[i] {
+		h.registers[i] = rank
+	}
+}
+
+// merge сливает регистры поэлементным максимумом: результат тот же, что у одного
+// HLL по всем значениям, поэтому порядок воркеров не важен
+func (h *hll) merge(o *hll) {
+	for i, r := range o.registers {
+		h.registers[i] = max(h.registers[i], r)
+	}
+}
+
+func (h *hll) clone() *hll {
+	return &hll{registers: append([]uint8(nil), h.registers...)}
+}
+
+// estimate - оценка числа различных значений. На малых числах, пока есть пустые
+// регистры, точнее линейный подсчёт; поправка для больших не нужна, хеш 64-битный
+func (h *hll) estimate() int64 {
+	m := float64(len(h.registers))
+	var sum float64
+	zeros := 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	var alpha float64
+	switch len(h.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+	e := alpha * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(e))
+}
+
+// uniqueIPsOf - оценка unique_ips для s; ok = false без -unique-ips или у merge
+func uniqueIPsOf(s *Stats) (string, bool) {
+	if s == nil || s.extra == nil || s.extra.ips == nil {
+		return "", false
+	}
+	return strconv.FormatInt(s.extra.ips.estimate(), 10), true
+}
+
+// checkUniqueIPs один раз предупреждает, если регистры -unique-ips всех эндпоинтов
+// заняли больше hllWarnBytes. Считаются эндпоинты итога, без копий -per-file
+func (m *merger) checkUniqueIPs(spec *hllSpec) {
+	if spec.warned {
+		return
+	}
+	m.mu.Lock()
+	endpoints := int64(len(m.total.totals))
+	m.mu.Unlock()
+	if need := endpoints << spec.precision; need > hllWarnBytes {
+		spec.warned = true
+		logger.warnf("-unique-ips keeps %s of HyperLogLog registers for %d endpoints; lower -hll-precision to save memory",
+			humanBytes(need), endpoints)
+	}
+}
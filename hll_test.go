@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// hllOf - HLL с точностью precision по IP 10.x.y.z с номерами [from, from+n)
+func hllOf(spec *hllSpec, from, n int) *hll {
+	h := spec.newHLL()
+	for i := from; i < from+n; i++ {
+		h.add(spec, fmt.Appendf(nil, "10.%d.%d.%d", i>>16&255, i>>8&255, i&255))
+	}
+	return h
+}
+
+func TestHLLErrorBound(t *testing.T) {
+	spec := newHLLSpec(defaultHLLPrecision)
+	// Стандартная ошибка HyperLogLog - 1.04/sqrt(m), около 1.6% при 2^12 регистров
+	stdErr := 1.04 / math.Sqrt(float64(int(1)<<defaultHLLPrecision))
+	var sq float64
+	trials := 0
+	for _, n := range []int{20000, 50000, 200000} {
+		for trial := range 8 {
+			got := hllOf(spec, trial*1000003%(1<<24), n).estimate()
+			rel := float64(got-int64(n)) / float64(n)
+			// Одиночная оценка почти всегда в пределах трёх стандартных ошибок
+			if math.Abs(rel) > 3*stdErr {
+				t.Errorf("n=%d trial %d: estimate %d, error %.2f%%", n, trial, got, 100*rel)
+			}
+			sq += rel * rel
+			trials++
+		}
+	}
+	if rms := math.Sqrt(sq / float64(trials)); rms > 1.5*stdErr {
+		t.Errorf("RMS error %.2f%%, want about %.2f%%", 100*rms, 100*stdErr)
+	}
+}
+
+func TestHLLSmallCounts(t *testing.T) {
+	spec := newHLLSpec(defaultHLLPrecision)
+	// Пока есть пустые регистры, линейный подсчёт почти точен
+	for _, n := range []int{0, 1, 10, 100, 1000} {
+		got := hllOf(spec, 0, n).estimate()
+		if math.Abs(float64(got-int64(n))) > math.Max(1, 0.01*float64(n)) {
+			t.Errorf("n=%d: estimate %d", n, got)
+		}
+	}
+	// Повторы не меняют оценку
+	h := hllOf(spec, 0, 500)
+	h.merge(hllOf(spec, 0, 500))
+	if got := h.estimate(); got != hllOf(spec, 0, 500).estimate() {
+		t.Errorf("duplicates changed the estimate to %d", got)
+	}
+}
+
+func TestHLLMergeMatchesSingle(t *testing.T) {
+	for _, precision := range []int{minHLLPrecision, defaultHLLPrecision, maxHLLPrecision} {
+		spec := newHLLSpec(precision)
+		single := hllOf(spec, 0, 30000)
+		// Части пересекаются, как IP разных воркеров
+		merged := hllOf(spec, 0, 12000)
+		merged.merge(hllOf(spec, 10000, 15000))
+		merged.merge(hllOf(spec, 24000, 6000))
+		if fmt.Sprint(merged.registers) != fmt.Sprint(single.registers) {
+			t.Errorf("precision %d: merged registers differ from a single HLL", precision)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	_ "embed"
+	"html/template"
+	"io"
+)
+
+//go:embed report.html.tmpl
+var htmlReportTemplate string
+
+var htmlReport = template.Must(template.New("report").Parse(htmlReportTemplate))
+
+// Сколько эндпоинтов с наибольшим avg показывать на графике
+const htmlChartBars = 20
+
+// Геометрия SVG-графика в пикселях
+const (
+	htmlChartLabelWidth = 320
+	htmlChartBarsWidth  = 480
+	htmlChartBarStep    = 22
+	htmlChartLabelChars = 48
+)
+
+// htmlRenderer пишет самодостаточный HTML-отчёт: сортируемую таблицу и SVG-график
+// top-N по avg. Шаблон зашит в бинарник, внешних ресурсов нет; экранирует html/template
+type htmlRenderer struct {
+	precision int
+	unit      timeUnit
+}
+
+type htmlRow struct {
+	Name, Min, Avg, Max string
+	Count               int64
+	// Сырые значения для сортировки таблицы на стороне браузера
+	MinValue, MaxValue int64
+	AvgValue           float64
+}
+
+type htmlBar struct {
+	Name, Label, Avg string
+	Y, Width, ValueX int
+}
+
+type htmlChart struct {
+	Width, Height, LabelWidth int
+}
+
+type htmlReportData struct {
+	Version string
+	Unit    string
+	Partial bool
+	Rows    []htmlRow
+	Bars    []htmlBar
+	Chart   htmlChart
+	// Highlights пуст с -no-highlights
+	Highlights []highlightList
+}
+
+func (r htmlRenderer) render(w io.Writer, rep *report) error {
+	data := htmlReportData{
+		Version: getBuildInfo().versionString(),
+		Unit:    r.unit.name,
+		Partial: rep.partial,
+		Rows:    make([]htmlRow, 0, len(rep.entries)),
+	}
+	if rep.highlights != nil {
+		data.Highlights = rep.highlights.lists(r.unit, r.precision, groupThousands)
+	}
+	for _, e := range rep.entries {
+		minV, avg, maxV := r.unit.formatStats(e.stats, r.precision)
+		data.Rows = append(data.Rows, htmlRow{
+			Name:     e.name,
+			Min:      minV,
+			Avg:      avg,
+			Max:      maxV,
+			Count:    e.stats.Count,
+			MinValue: e.stats.Min,
+			MaxValue: e.stats.Max,
+			AvgValue: float64(e.stats.Sum) / float64(e.stats.Count),
+		})
+	}
+
+	// График строим по avg независимо от -sort, но только по тем эндпоинтам, что попали в таблицу
+	byAvg := append([]endpointStat(nil), rep.entries...)
+	sortEntries(byAvg, sortOrder{key: "avg", desc: true})
+	byAvg = byAvg[:min(len(byAvg), htmlChartBars)]
+	if len(byAvg) > 0 {
+		top := float64(byAvg[0].stats.Sum) / float64(byAvg[0].stats.Count)
+		for i, e := range byAvg {
+			width := 0
+			if top > 0 {
+				width = int(float64(e.stats.Sum) / float64(e.stats.Count) / top * htmlChartBarsWidth)
+			}
+			data.Bars = append(data.Bars, htmlBar{
+				Name:   e.name,
+				Label:  truncate(e.name, htmlChartLabelChars),
+				Avg:    r.unit.formatAvg(e.stats, r.precision),
+				Y:      i * htmlChartBarStep,
+				Width:  width,
+				ValueX: htmlChartLabelWidth + width,
+			})
+		}
+		data.Chart = htmlChart{
+			// Справа оставляем место под подпись значения
+			Width:      htmlChartLabelWidth + htmlChartBarsWidth + 100,
+			Height:     len(byAvg) * htmlChartBarStep,
+			LabelWidth: htmlChartLabelWidth,
+		}
+	}
+	return htmlReport.Execute(w, data)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata")
+
+func TestHTMLReportGolden(t *testing.T) {
+	path := writeTempFile(t, "html.log", strings.Join([]string{
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /api/users 200 120",
+		"2024-01-01T00:00:01Z 10.0.0.2 GET /api/users 200 80",
+		"2024-01-01T00:00:02Z 10.0.0.3 POST /api/orders 201 340",
+		"2024-01-01T00:00:03Z 10.0.0.4 GET /health 200 2",
+		"2024-01-01T00:00:04Z 10.0.0.5 GET /search?q=<script>alert(1)</script>&x=\"y\" 200 55",
+		"",
+	}, "\n"))
+	out, code := runAnalyzeFile(t, "-format", "html", path)
+	if code != exitOK {
+		t.Fatalf("exit %d", code)
+	}
+	// Версия зависит от сборки, в эталоне она заменена
+	out = strings.Replace(out, "Version "+getBuildInfo().versionString()+",", "Version VERSION,", 1)
+	if strings.Contains(out, "<script>alert") {
+		t.Error("endpoint name is not HTML-escaped")
+	}
+	if strings.Contains(out, "src=") || strings.Contains(out, "<link") {
+		t.Error("report refers to external resources")
+	}
+
+	golden := filepath.Join("testdata", "report.golden.html")
+	if *updateGolden {
+		if err := os.WriteFile(golden, []byte(out), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%v (run go test -run HTMLReportGolden -update to create it)", err)
+	}
+	if out != string(want) {
+		t.Errorf("HTML report differs from %s; if the change is intended, rerun with -update\n%s", golden, out)
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/bits"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// inputFormatJSONL - -input-format jsonl: в каждой строке JSON-объект
+const inputFormatJSONL = "jsonl"
+
+// defaultJSONKeys - -json-keys по умолчанию
+const defaultJSONKeys = "ts=timestamp,ip=ip,method=method,path=path,status=status,time=duration_ms"
+
+// maxJSONDepth - глубже этой вложенности строка считается битой, а не разбирается рекурсией
+const maxJSONDepth = 64
+
+// keyMapping - значение флагов -json-keys и -logfmt-keys: ключ строки для каждого поля,
+// у JSON вложенные через точку; "" - поля нет. Нулевое значение - флаг не задан
+type keyMapping [fieldSent]string
+
+func (k keyMapping) isSet() bool {
+	return k != keyMapping{}
+}
+
+func (k keyMapping) String() string {
+	var items []string
+	for kind, key := range k {
+		if key != "" {
+			items = append(items, fieldNames[kind]+"="+key)
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+func (k *keyMapping) Set(s string) error {
+	var keys keyMapping
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("invalid item %q: expected name=key", item)
+		}
+		kind := slices.Index(fieldNames[:], strings.TrimSpace(name))
+		if kind < 0 {
+			return fmt.Errorf("unknown field %q: expected %s", name, strings.Join(fieldNames[:], ", "))
+		}
+		if keys[kind] != "" {
+			return fmt.Errorf("field %q specified twice", name)
+		}
+		key = strings.TrimSpace(key)
+		if key == "" || slices.Contains(strings.Split(key, "."), "") {
+			return fmt.Errorf("invalid key %q for %s: expected a key or dotted path like http.path", key, name)
+		}
+		if other := slices.Index(keys[:], key); other >= 0 {
+			return fmt.Errorf("fields %s and %s both read key %q", fieldNames[other], name, key)
+		}
+		keys[kind] = key
+	}
+	for _, kind := range []fieldKind{fieldPath, fieldTime} {
+		if keys[kind] == "" {
+			return fmt.Errorf("missing %s: path and time are required", fieldNames[kind])
+		}
+	}
+	*k = keys
+	return nil
+}
+
+// keysOr - ключи флага или, если он не задан, defaults
+func keysOr(keys keyMapping, defaults string) keyMapping {
+	if keys.isSet() {
+		return keys
+	}
+	keys.Set(defaults)
+	return keys
+}
+
+// jsonPlan - какие ключи брать из JSON-строки: путь каждого поля по сегментам
+type jsonPlan struct {
+	keys     keyMapping
+	segments [fieldSent][][]byte
+	// all - маска полей, у которых есть ключ
+	all uint8
+}
+
+func newJSONPlan(keys keyMapping) *jsonPlan {
+	plan := &jsonPlan{keys: keys}
+	for kind, key := range keys {
+		if key == "" {
+			continue
+		}
+		for _, seg := range strings.Split(key, ".") {
+			plan.segments[kind] = append(plan.segments[kind], []byte(seg))
+		}
+		plan.all |= 1 << kind
+	}
+	return plan
+}
+
+// jsonScanner - состояние разбора одной строки: найденные значения и какие из них
+// строки JSON с экранированием
+type jsonScanner struct {
+	plan    *jsonPlan
+	line    []byte
+	values  [fieldSent]span
+	found   uint8
+	escaped uint8
+}
+
+// parse разбирает JSON-объект строки за один проход без map и без копий: значения
+// нужных ключей запоминаются как границы в line, остальные значения только
+// пропускаются. Экранирование в нужных строках раскрывается прямо в line, она от этого
+// только короче
+func (plan *jsonPlan) parse(line []byte, times timeFormat) (lineRecord, error) {
+	var rec lineRecord
+	s := jsonScanner{plan: plan, line: line}
+	i := s.space(0)
+	if i >= len(line) || line[i] != '{' {
+		return rec, &lineParseError{i, fmt.Errorf("expected a JSON object")}
+	}
+	i, err := s.object(i, 0, plan.all)
+	if err != nil {
+		return rec, err
+	}
+	if i = s.space(i); i != len(line) {
+		return rec, &lineParseError{i, fmt.Errorf("unexpected text after the JSON object")}
+	}
+	for _, kind := range []fieldKind{fieldPath, fieldTime} {
+		if s.found&(1<<kind) == 0 {
+			return rec, &lineParseError{len(line), fmt.Errorf("no %q key", plan.keys[kind])}
+		}
+	}
+
+	t := s.values[fieldTime]
+	value, bad, err := times.jsonMillis(line[t.start:t.end])
+	if err != nil {
+		return rec, &lineParseError{t.start + bad, err}
+	}
+	rec.value = value
+	for kind := range fieldSent {
+		if s.escaped&(1<<kind) != 0 {
+			v := &s.values[kind]
+			n, ok := unescapeJSON(line[v.start:v.end])
+			if !ok {
+				return rec, &lineParseError{v.start, fmt.Errorf("invalid escape in the %q value", plan.keys[kind])}
+			}
+			v.end = v.start + n
+		}
+	}
+	if s.values[fieldPath].start == s.values[fieldPath].end {
+		return rec, &lineParseError{s.values[fieldPath].start, fmt.Errorf("empty path")}
+	}
+	rec.timestamp, rec.ip, rec.method, rec.path, rec.status, rec.responseTime = s.values[0], s.values[1], s.values[2], s.values[3], s.values[4], s.values[5]
+	return rec, nil
+}
+
+// space пропускает пробельные символы JSON
+func (s *jsonScanner) space(i int) int {
+	for i < len(s.line) {
+		switch s.line[i] {
+		case ' ', '\t', '\r', '\n':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// object разбирает объект с line[i] == '{' на глубине depth. want - поля, чей путь
+// проходит через этот объект: их следующий сегмент сравнивается с ключами
+func (s *jsonScanner) object(i, depth int, want uint8) (int, error) {
+	if depth >= maxJSONDepth {
+		return 0, &lineParseError{i, fmt.Errorf("JSON nested deeper than %d levels", maxJSONDepth)}
+	}
+	line := s.line
+	i = s.space(i + 1)
+	if i < len(line) && line[i] == '}' {
+		return i + 1, nil
+	}
+	for {
+		if i >= len(line) || line[i] != '"' {
+			return 0, &lineParseError{i, fmt.Errorf("expected a key in quotes")}
+		}
+		end, _, err := s.str(i)
+		if err != nil {
+			return 0, err
+		}
+		key := line[i+1 : end-1]
+		if i = s.space(end); i >= len(line) || line[i] != ':' {
+			return 0, &lineParseError{i, fmt.Errorf("expected ':' after the key")}
+		}
+		i = s.space(i + 1)
+
+		// take - поле, чьё значение под этим ключом; deeper - поля, чей путь идёт глубже
+		take, deeper := -1, uint8(0)
+		for m := want; m != 0; m &= m - 1 {
+			kind := bits.TrailingZeros8(m)
+			seg := s.plan.segments[kind]
+			if !bytes.Equal(seg[depth], key) {
+				continue
+			}
+			if len(seg) == depth+1 {
+				take = kind
+			} else {
+				deeper |= 1 << kind
+			}
+		}
+		switch {
+		case take >= 0:
+			i, err = s.take(i, fieldKind(take))
+		case deeper != 0 && i < len(line) && line[i] == '{':
+			i, err = s.object(i, depth+1, deeper)
+		default:
+			i, err = s.skip(i, depth+1)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		i = s.space(i)
+		if i >= len(line) {
+			return 0, &lineParseError{i, fmt.Errorf("unclosed JSON object")}
+		}
+		switch line[i] {
+		case ',':
+			i = s.space(i + 1)
+		case '}':
+			return i + 1, nil
+		default:
+			return 0, &lineParseError{i, fmt.Errorf("expected ',' or '}'")}
+		}
+	}
+}
+
+// take запоминает значение поля kind с line[i]. Строка даёт своё содержимое без
+// кавычек, число и true/false - себя; null - как если бы ключа не было
+func (s *jsonScanner) take(i int, kind fieldKind) (int, error) {
+	line := s.line
+	if i < len(line) && line[i] == '"' {
+		end, escaped, err := s.str(i)
+		if err != nil {
+			return 0, err
+		}
+		s.values[kind] = span{i + 1, end - 1}
+		s.found |= 1 << kind
+		if escaped {
+			s.escaped |= 1 << kind
+		}
+		return end, nil
+	}
+	if i < len(line) && (line[i] == '{' || line[i] == '[') {
+		return 0, &lineParseError{i, fmt.Errorf("the %q value must be a string or a number", s.plan.keys[kind])}
+	}
+	end, err := s.skip(i, 0)
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(line[i:end], []byte("null")) {
+		s.values[kind] = span{i, end}
+		s.found |= 1 << kind
+	}
+	return end, nil
+}
+
+// str пропускает строку с line[i] == '"' и возвращает байт после закрывающей кавычки;
+// escaped - были ли в строке обратные косые
+func (s *jsonScanner) str(i int) (end int, escaped bool, err error) {
+	line := s.line
+	for j := i + 1; j < len(line); j++ {
+		switch c := line[j]; {
+		case c == '\\':
+			escaped = true
+			j++
+		case c == '"':
+			return j + 1, escaped, nil
+		case c < 0x20:
+			return 0, false, &lineParseError{j, fmt.Errorf("control character in a JSON string")}
+		}
+	}
+	return 0, false, &lineParseError{len(line), fmt.Errorf("unclosed JSON string")}
+}
+
+// skip пропускает любое значение JSON с line[i]
+func (s *jsonScanner) skip(i, depth int) (int, error) {
+	line := s.line
+	if i >= len(line) {
+		return 0, &lineParseError{i, fmt.Errorf("expected a JSON value")}
+	}
+	switch c := line[i]; {
+	case c == '"':
+		end, _, err := s.str(i)
+		return end, err
+	case c == '{':
+		return s.object(i, depth, 0)
+	case c == '[':
+		if depth >= maxJSONDepth {
+			return 0, &lineParseError{i, fmt.Errorf("JSON nested deeper than %d levels", maxJSONDepth)}
+		}
+		i = s.space(i + 1)
+		if i < len(line) && line[i] == ']' {
+			return i + 1, nil
+		}
+		for {
+			var err error
+			if i, err = s.skip(i, depth+1); err != nil {
+				return 0, err
+			}
+			i = s.space(i)
+			if i >= len(line) {
+				return 0, &lineParseError{i, fmt.Errorf("unclosed JSON array")}
+			}
+			switch line[i] {
+			case ',':
+				i = s.space(i + 1)
+			case ']':
+				return i + 1, nil
+			default:
+				return 0, &lineParseError{i, fmt.Errorf("expected ',' or ']'")}
+			}
+		}
+	case c == '-' || (c >= '0' && c <= '9'):
+		j := i + 1
+		for j < len(line) && (line[j] >= '0' && line[j] <= '9' || line[j] == '.' || line[j] == 'e' || line[j] == 'E' || line[j] == '+' || line[j] == '-') {
+			j++
+		}
+		return j, nil
+	}
+	for _, lit := range []string{"true", "false", "null"} {
+		if bytes.HasPrefix(line[i:], []byte(lit)) {
+			return i + len(lit), nil
+		}
+	}
+	return 0, &lineParseError{i, fmt.Errorf("invalid JSON value")}
+}
+
+// parseJSONMillis читает время ответа в миллисекундах: целое число, дробное (оно
+// округляется) или такое же число в строке
+func parseJSONMillis(b []byte) (int64, error) {
+	digits := len(b) > 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			digits = false
+			break
+		}
+	}
+	if digits {
+		return parseIntFast(b)
+	}
+	v, err := strconv.ParseFloat(unsafe.String(unsafe.SliceData(b), len(b)), 64)
+	if err != nil || !(v >= 0 && v < math.MaxInt64) {
+		return 0, fmt.Errorf("invalid response time %q: expected a non-negative number of milliseconds", b)
+	}
+	return int64(math.Round(v)), nil
+}
+
+// unescapeJSON раскрывает экранирование строки JSON в самом b и возвращает новую длину.
+// Раскрытая последовательность не длиннее своей записи, так что запись не обгоняет чтение
+func unescapeJSON(b []byte) (int, bool) {
+	w := 0
+	for r := 0; r < len(b); r++ {
+		c := b[r]
+		if c != '\\' {
+			b[w] = c
+			w++
+			continue
+		}
+		r++
+		if r >= len(b) {
+			return 0, false
+		}
+		switch b[r] {
+		case '"', '\\', '/':
+			b[w] = b[r]
+		case 'b':
+			b[w] = '\b'
+		case 'f':
+			b[w] = '\f'
+		case 'n':
+			b[w] = '\n'
+		case 'r':
+			b[w] = '\r'
+		case 't':
+			b[w] = '\t'
+		case 'u':
+			ch, ok := hex4(b[r+1:])
+			if !ok {
+				return 0, false
+			}
+			r += 4
+			// Суррогатная пара \ud83d\ude00 - один символ
+			if ch >= 0xD800 && ch < 0xDC00 && r+6 < len(b) && b[r+1] == '\\' && b[r+2] == 'u' {
+				if lo, ok := hex4(b[r+3:]); ok && lo >= 0xDC00 && lo < 0xE000 {
+					ch = 0x10000 + (ch-0xD800)<<10 + (lo - 0xDC00)
+					r += 6
+				}
+			}
+			w += utf8.EncodeRune(b[w:], ch)
+			continue
+		default:
+			return 0, false
+		}
+		w++
+	}
+	return w, true
+}
+
+// hex4 читает четыре шестнадцатеричные цифры \uXXXX
+func hex4(b []byte) (rune, bool) {
+	if len(b) < 4 {
+		return 0, false
+	}
+	var v rune
+	for _, c := range b[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			v = v<<4 | rune(c-'0')
+		case c >= 'a' && c <= 'f':
+			v = v<<4 | rune(c-'a'+10)
+		case c >= 'A' && c <= 'F':
+			v = v<<4 | rune(c-'A'+10)
+		default:
+			return 0, false
+		}
+	}
+	return v, true
+}
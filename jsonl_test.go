@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJSONLParse(t *testing.T) {
+	plan := newJSONPlan(keysOr(keyMapping{}, defaultJSONKeys))
+	tests := []struct {
+		line, path, method string
+		ms                 int64
+	}{
+		{`{"timestamp":"2024-01-01T00:00:00Z","ip":"10.0.0.1","method":"GET","path":"/api/users","status":200,"duration_ms":42}`, "/api/users", "GET", 42},
+		// Порядок ключей любой; чужие объекты и массивы пропускаются, даже с теми же ключами внутри
+		{`{"x":{"path":"/no"},"duration_ms":7.5,"arr":[1,{"a":[]},"]"],"ok":true,"path":"/b" , "method":"POST"}`, "/b", "POST", 8},
+		// Экранирование раскрывается, суррогатная пара - один символ
+		{`{"path":"/a\"b\u00e9\/c\ud83d\ude00","duration_ms":"12"}`, "/a\"bé/c😀", "", 12},
+		// null - как если бы ключа не было
+		{` {"method":null,"path":"/n","duration_ms":1}` + "\r", "/n", "", 1},
+	}
+	for _, tt := range tests {
+		line := []byte(tt.line)
+		rec, err := plan.parse(line, timeIntMillis)
+		if err != nil {
+			t.Errorf("%s: %v", tt.line, err)
+			continue
+		}
+		if path, method := string(line[rec.path.start:rec.path.end]), string(line[rec.method.start:rec.method.end]); path != tt.path || method != tt.method || rec.value != tt.ms {
+			t.Errorf("%s: got %q %q %dms, want %q %q %dms", tt.line, method, path, rec.value, tt.method, tt.path, tt.ms)
+		}
+	}
+
+	for _, bad := range []string{
+		`["/a", 1]`,
+		`{"path":"/a"}`,
+		`{"path":null,"duration_ms":1}`,
+		`{"path":"","duration_ms":1}`,
+		`{"path":{"p":"/a"},"duration_ms":1}`,
+		`{"path":"/a","duration_ms":-1}`,
+		`{"path":"/a","duration_ms":1} x`,
+		`{"path":"/a","duration_ms":1`,
+		`{"path":"/a\q","duration_ms":1}`,
+		"{\"path\":\"/a\tb\",\"duration_ms\":1}",
+		`{"path":"/a","duration_ms":1,"deep":` + strings.Repeat("[", 70) + strings.Repeat("]", 70) + `}`,
+		`{path:"/a","duration_ms":1}`,
+	} {
+		if _, err := plan.parse([]byte(bad), timeIntMillis); err == nil {
+			t.Errorf("accepted %s", bad)
+		}
+	}
+}
+
+func TestJSONKeysNested(t *testing.T) {
+	var keys keyMapping
+	if err := keys.Set("path=http.path, time=http.dur,status=code"); err != nil {
+		t.Fatal(err)
+	}
+	plan := newJSONPlan(keys)
+	line := []byte(`{"path":"/top","http":{"dur":5,"req":{"path":"/deeper"},"path":"/nested"},"code":404}`)
+	rec, err := plan.parse(line, timeIntMillis)
+	if err != nil || string(line[rec.path.start:rec.path.end]) != "/nested" || rec.value != 5 || string(line[rec.status.start:rec.status.end]) != "404" {
+		t.Errorf("nested keys: %q %dms, %v", line[rec.path.start:rec.path.end], rec.value, err)
+	}
+
+	for _, bad := range []string{"path=url", "time=d", "path=url,time=d,path=p", "path=a..b,time=d", "path=url,time=url", "size=s,path=a,time=d", "path"} {
+		if err := keys.Set(bad); err == nil {
+			t.Errorf("-json-keys %q accepted", bad)
+		}
+	}
+}
+
+func TestJSONLInput(t *testing.T) {
+	path := writeTempFile(t, "access.jsonl", `{"timestamp":"2024-01-01T00:00:00Z","method":"GET","path":"/a","status":200,"duration_ms":10}`+"\n"+
+		`{"path":"/a","duration_ms":20.4}`+"\n"+
+		`{"path":"/b","duration_ms":"5"}`+"\n"+
+		`not json`+"\n"+
+		`{"path":"/b"}`+"\n")
+	out, code := runAnalyzeFile(t, "-input-format", "jsonl", "-include-count", path)
+	if code != exitOK {
+		t.Fatalf("exit %d", code)
+	}
+	for _, want := range []string{`"/a": {`, `"max_response_time": 20,`, `"/b": {`, `"total_requests": 3,`, `"malformed_lines": 2`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%s missing:\n%s", want, out)
+		}
+	}
+
+	other := writeTempFile(t, "other.jsonl", `{"req":{"url":"/x"},"latency":0.25}`+"\n")
+	out, code = runAnalyzeFile(t, "-input-format", "jsonl", "-json-keys", "path=req.url,time=latency", "-time-format", "float-seconds", other)
+	if code != exitOK || !strings.Contains(out, `"/x": {`) || !strings.Contains(out, `"max_response_time": 250`) {
+		t.Errorf("-json-keys path=req.url,time=latency: exit %d\n%s", code, out)
+	}
+	// -json-keys без -input-format jsonl и ключи без time - ошибка использования
+	for _, bad := range [][]string{{"-json-keys", "path=p,time=t"}, {"-input-format", "jsonl", "-json-keys", "path=p"}} {
+		if _, code := runAnalyzeFile(t, append(bad, path)...); code != exitUsage {
+			t.Errorf("%v: exit %d, want %d", bad, code, exitUsage)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// lineFormatPresets - готовые шаблоны -line-format. Это форматы common и combined
+// Apache/nginx с временем ответа в миллисекундах последним полем: в самом CLF его нет
+var lineFormatPresets = map[string]string{
+	"common":   `{ip} {ident} {user} [{ts}] "{method} {path} {proto}" {status} {bytes} {time_ms}`,
+	"combined": `{ip} {ident} {user} [{ts}] "{method} {path} {proto}" {status} {bytes} "{referer}" "{agent}" {time_ms}`,
+}
+
+// templateNames - имена захватов шаблона, которые попадают в поля строки; остальные
+// имена ({proto}, {user} и т.п.) только пропускают свой кусок
+var templateNames = map[string]fieldKind{
+	"ts":      fieldTimestamp,
+	"ip":      fieldIP,
+	"method":  fieldMethod,
+	"path":    fieldPath,
+	"status":  fieldStatus,
+	"time_ms": fieldTime,
+}
+
+// templateOp - шаг шаблона: литерал, который должен совпасть байт в байт, или захват
+// до байта delim (первого байта следующего литерала); у последнего захвата delim нет
+// и он тянется до конца строки
+type templateOp struct {
+	literal []byte
+	// capture - захват; kind - куда он идёт, если known
+	capture bool
+	known   bool
+	kind    fieldKind
+	delim   byte
+	last    bool
+}
+
+// lineTemplate - скомпилированный -line-format
+type lineTemplate struct {
+	text string
+	ops  []templateOp
+	// has - какие поля строки есть в шаблоне
+	has [fieldSent]bool
+}
+
+// compileLineTemplate разбирает шаблон или имя пресета. Захваты должны разделяться
+// литералами: иначе непонятно, где кончается первый
+func compileLineTemplate(text string) (*lineTemplate, error) {
+	if preset, ok := lineFormatPresets[text]; ok {
+		text = preset
+	}
+	t := &lineTemplate{text: text}
+	for rest := text; rest != ""; {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			open = len(rest)
+		}
+		if open > 0 {
+			if strings.ContainsRune(rest[:open], '}') {
+				return nil, fmt.Errorf("invalid -line-format %q: unexpected }", text)
+			}
+			t.ops = append(t.ops, templateOp{literal: []byte(rest[:open])})
+			rest = rest[open:]
+			continue
+		}
+		end := strings.IndexByte(rest, '}')
+		if end < 0 {
+			return nil, fmt.Errorf("invalid -line-format %q: unclosed {", text)
+		}
+		name := rest[1:end]
+		if name == "" || strings.ContainsAny(name, "{ ") {
+			return nil, fmt.Errorf("invalid -line-format %q: bad capture name %q", text, name)
+		}
+		if n := len(t.ops); n > 0 && t.ops[n-1].capture {
+			return nil, fmt.Errorf("invalid -line-format %q: {%s} must be separated from the previous capture by a literal", text, name)
+		}
+		op := templateOp{capture: true}
+		if kind, ok := templateNames[name]; ok {
+			if t.has[kind] {
+				return nil, fmt.Errorf("invalid -line-format %q: {%s} appears twice", text, name)
+			}
+			op.known, op.kind, t.has[kind] = true, kind, true
+		}
+		t.ops = append(t.ops, op)
+		rest = rest[end+1:]
+	}
+	for i := range t.ops {
+		if !t.ops[i].capture {
+			continue
+		}
+		if i+1 < len(t.ops) {
+			t.ops[i].delim = t.ops[i+1].literal[0]
+		} else {
+			t.ops[i].last = true
+		}
+	}
+	for _, name := range []string{"path", "time_ms"} {
+		if !t.has[templateNames[name]] {
+			return nil, fmt.Errorf("invalid -line-format %q: {%s} is required", text, name)
+		}
+	}
+	return t, nil
+}
+
+// templatePresetNames - имена пресетов для справки флага
+func templatePresetNames() string {
+	names := make([]string, 0, len(lineFormatPresets))
+	for name := range lineFormatPresets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
+
+// parse сопоставляет строку с шаблоном слева направо: литерал сверяется, захват
+// берёт байты до своего разделителя. Строка, которая не совпала, - битая
+func (t *lineTemplate) parse(line []byte, times timeFormat) (lineRecord, error) {
+	var rec lineRecord
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	slots := [fieldSent]*span{&rec.timestamp, &rec.ip, &rec.method, &rec.path, &rec.status, &rec.responseTime}
+	i := 0
+	for _, op := range t.ops {
+		if !op.capture {
+			if !bytes.HasPrefix(line[i:], op.literal) {
+				return rec, &lineParseError{i, fmt.Errorf("expected %q from -line-format", op.literal)}
+			}
+			i += len(op.literal)
+			continue
+		}
+		end := len(line)
+		if !op.last {
+			j := bytes.IndexByte(line[i:], op.delim)
+			if j < 0 {
+				return rec, &lineParseError{len(line), fmt.Errorf("line ends before %q from -line-format", op.delim)}
+			}
+			end = i + j
+		}
+		if op.known {
+			*slots[op.kind] = span{i, end}
+		}
+		i = end
+	}
+	if i != len(line) {
+		return rec, &lineParseError{i, fmt.Errorf("unexpected text after the end of -line-format")}
+	}
+	if rec.path.start == rec.path.end {
+		return rec, &lineParseError{rec.path.start, fmt.Errorf("empty path")}
+	}
+
+	value, bad, err := times.millis(line[rec.responseTime.start:rec.responseTime.end])
+	if err != nil {
+		return rec, &lineParseError{rec.responseTime.start + bad, err}
+	}
+	rec.value = value
+	return rec, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLineTemplateParse(t *testing.T) {
+	tests := []struct {
+		template, line   string
+		ts, method, path string
+		ms               int64
+	}{
+		{"combined", `10.0.0.1 - frank [10/Oct/2024:13:55:36 -0700] "GET /a?x=1 HTTP/1.1" 200 2326 "http://ex.com/" "Mozilla/5.0 (X11; Linux)" 42`,
+			"10/Oct/2024:13:55:36 -0700", "GET", "/a?x=1", 42},
+		{"common", `::1 - - [10/Oct/2024:13:55:36 +0000] "POST /b HTTP/2.0" 201 - 7` + "\r", "10/Oct/2024:13:55:36 +0000", "POST", "/b", 7},
+		// Захват берёт всё до первого байта следующего литерала, включая пробелы
+		{`{ts}|{method}|{path}|{time_ms}`, "t 1|GET|/my file|5", "t 1", "GET", "/my file", 5},
+		// Текст после последнего захвата должен совпасть до конца строки
+		{`<{path}> took {time_ms}ms`, "</c> took 12ms", "", "", "/c", 12},
+		// Незнакомые имена только пропускают свой кусок
+		{`{x} {path} {y} {time_ms}`, "skip /d also 3", "", "", "/d", 3},
+	}
+	for _, tt := range tests {
+		tmpl, err := compileLineTemplate(tt.template)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.template, err)
+		}
+		line := []byte(tt.line)
+		rec, err := tmpl.parse(line, timeIntMillis)
+		if err != nil {
+			t.Errorf("%s: %q: %v", tt.template, tt.line, err)
+			continue
+		}
+		got := [3]string{string(line[rec.timestamp.start:rec.timestamp.end]), string(line[rec.method.start:rec.method.end]), string(line[rec.path.start:rec.path.end])}
+		if got != [3]string{tt.ts, tt.method, tt.path} || rec.value != tt.ms {
+			t.Errorf("%s: %q = %q %dms, want %q %dms", tt.template, tt.line, got, rec.value, [3]string{tt.ts, tt.method, tt.path}, tt.ms)
+		}
+	}
+
+	tmpl, err := compileLineTemplate(`<{path}> took {time_ms}ms`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"</c> took 12", "</c> took 12ms extra", "[/c] took 12ms", "<> took 12ms", "</c> took 1xms", "</c"} {
+		if _, err := tmpl.parse([]byte(bad), timeIntMillis); err == nil {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+}
+
+func TestCompileLineTemplateErrors(t *testing.T) {
+	for _, bad := range []string{
+		"{path} {ip}",
+		"{time_ms}",
+		"{path}{time_ms}",
+		"{path} {time_ms} {path}",
+		"{path} {time_ms",
+		"{path} } {time_ms}",
+		"{path} {} {time_ms}",
+		"{path} {a b} {time_ms}",
+		"",
+	} {
+		if _, err := compileLineTemplate(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestLineFormatFlag(t *testing.T) {
+	path := writeTempFile(t, "combined.log", `10.0.0.1 - - [10/Oct/2024:13:55:36 -0700] "GET /a HTTP/1.1" 200 2326 "-" "curl/8.0" 10`+"\n"+
+		`10.0.0.2 - - [10/Oct/2024:13:55:37 -0700] "GET /a HTTP/1.1" 500 12 "-" "Mozilla/5.0 (X11; Linux)" 30`+"\n"+
+		`10.0.0.2 - - [10/Oct/2024:13:55:38 -0700] "GET /b HTTP/1.1" 200 - "-" "-"`+"\n")
+	out, code := runAnalyzeFile(t, "-line-format", "combined", "-include-count", "-status-breakdown", path)
+	if code != exitOK {
+		t.Fatalf("exit %d", code)
+	}
+	for _, want := range []string{`"/a": {`, `"avg_response_time": 20.0,`, `"status_5xx": 1`, `"malformed_lines": 1`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%s missing:\n%s", want, out)
+		}
+	}
+	for _, bad := range [][]string{
+		{"-line-format", "{path}"},
+		{"-line-format", "{path} {time_ms}", "-unique-ips"},
+		{"-line-format", "combined", "-fields", "path=1,time=2"},
+		{"-line-format", "combined", "-input-format", "jsonl"},
+	} {
+		if _, code := runAnalyzeFile(t, append(bad, path)...); code != exitUsage {
+			t.Errorf("%v: exit %d, want %d", bad, code, exitUsage)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+type logLevel int
+
+const (
+	// levelQuiet - только фатальные ошибки
+	levelQuiet logLevel = iota
+	// levelWarn - плюс предупреждения (битые строки и т.п.), уровень по умолчанию
+	levelWarn
+	// levelInfo - плюс прогресс по каждой части файла
+	levelInfo
+)
+
+// leveledLogger пишет диагностику в stderr, чтобы stdout оставался чистым JSON
+type leveledLogger struct {
+	mu    sync.Mutex
+	level logLevel
+	w     io.Writer
+	// statusLine - в терминале сейчас висит строка прогресса без перевода строки
+	statusLine bool
+}
+
+var logger = &leveledLogger{level: levelWarn, w: os.Stderr}
+
+func (l *leveledLogger) logf(level logLevel, prefix, format string, args ...any) {
+	if l.level < level {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.statusLine {
+		fmt.Fprint(l.w, "\r\033[K")
+		l.statusLine = false
+	}
+	fmt.Fprintf(l.w, prefix+format+"\n", args...)
+}
+
+func (l *leveledLogger) errorf(format string, args ...any) {
+	l.logf(levelQuiet, "", format, args...)
+}
+
+func (l *leveledLogger) warnf(format string, args ...any) {
+	l.logf(levelWarn, "warning: ", format, args...)
+}
+
+func (l *leveledLogger) infof(format string, args ...any) {
+	l.logf(levelInfo, "", format, args...)
+}
+
+func (l *leveledLogger) enabled(level logLevel) bool {
+	return l.level >= level
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return strconv.FormatFloat(float64(n)/float64(div), 'f', 1, 64) + string("KMGTPE"[exp]) + "B"
+}
+
+func humanCount(n int64) string {
+	switch {
+	case n >= 1_000_000_000:
+		return strconv.FormatFloat(float64(n)/1e9, 'f', 1, 64) + "G"
+	case n >= 1_000_000:
+		return strconv.FormatFloat(float64(n)/1e6, 'f', 1, 64) + "M"
+	case n >= 1_000:
+		return strconv.FormatFloat(float64(n)/1e3, 'f', 1, 64) + "K"
+	}
+	return strconv.FormatInt(n, 10)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// -input-format logfmt: пары key=value через пробел, значение с пробелами - в кавычках.
+// Разделитель - только пробел, как в строках, которые пишут библиотеки logfmt
+const (
+	inputFormatLogfmt = "logfmt"
+	// defaultLogfmtKeys - -logfmt-keys по умолчанию
+	defaultLogfmtKeys = "ts=ts,ip=ip,method=method,path=path,status=status,time=dur_ms"
+)
+
+// unknownPath - эндпоинт строк logfmt без ключа path с -unknown-path
+var unknownPath = []byte("(unknown)")
+
+// logfmtPlan - какие ключи брать из строки logfmt
+type logfmtPlan struct {
+	keys keyMapping
+	// names - ключи полей в байтах; all - маска полей, у которых есть ключ
+	names [fieldSent][]byte
+	all   uint8
+	// unknownPath - строка без path не битая, а идёт в эндпоинт "(unknown)"
+	unknownPath bool
+}
+
+func newLogfmtPlan(keys keyMapping, unknownPath bool) *logfmtPlan {
+	plan := &logfmtPlan{keys: keys, unknownPath: unknownPath}
+	for kind, key := range keys {
+		if key != "" {
+			plan.names[kind] = []byte(key)
+			plan.all |= 1 << kind
+		}
+	}
+	return plan
+}
+
+// parse проходит строку один раз и останавливается, как только найдены все нужные
+// ключи. Значение в кавычках может содержать пробелы и \", экранирование раскрывается
+// прямо в line и только у нужных значений, как у jsonl
+func (plan *logfmtPlan) parse(line []byte, times timeFormat) (lineRecord, error) {
+	var rec lineRecord
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	var values [fieldSent]span
+	var found, escaped uint8
+	for i := 0; i < len(line) && found != plan.all; {
+		if line[i] == ' ' {
+			i++
+			continue
+		}
+		// Ключ до '=' или пробела; ключ без '=' - флаг без значения
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' {
+			i++
+		}
+		key := line[start:i]
+		if i >= len(line) || line[i] != '=' {
+			continue
+		}
+		i++
+		var value span
+		quoted := false
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for ; end < len(line) && line[end] != '"'; end++ {
+				if line[end] == '\\' {
+					quoted = true
+					end++
+				}
+			}
+			if end >= len(line) {
+				return rec, &lineParseError{i, fmt.Errorf("unclosed quote in the value of %q", key)}
+			}
+			value, i = span{i + 1, end}, end+1
+		} else {
+			end := len(line)
+			if sp := bytes.IndexByte(line[i:], ' '); sp >= 0 {
+				end = i + sp
+			}
+			value, i = span{i, end}, end
+		}
+		for kind := range fieldSent {
+			if found&(1<<kind) == 0 && bytes.Equal(plan.names[kind], key) {
+				values[kind] = value
+				found |= 1 << kind
+				if quoted {
+					escaped |= 1 << kind
+				}
+				break
+			}
+		}
+	}
+	if found&(1<<fieldTime) == 0 {
+		return rec, &lineParseError{len(line), fmt.Errorf("no %q key", plan.keys[fieldTime])}
+	}
+	if found&(1<<fieldPath) == 0 && !plan.unknownPath {
+		return rec, &lineParseError{len(line), fmt.Errorf("no %q key", plan.keys[fieldPath])}
+	}
+
+	t := values[fieldTime]
+	value, bad, err := times.jsonMillis(line[t.start:t.end])
+	if err != nil {
+		return rec, &lineParseError{t.start + bad, err}
+	}
+	rec.value = value
+	for kind := range fieldSent {
+		if escaped&(1<<kind) != 0 {
+			v := &values[kind]
+			n, ok := unescapeJSON(line[v.start:v.end])
+			if !ok {
+				return rec, &lineParseError{v.start, fmt.Errorf("invalid escape in the value of %q", plan.keys[kind])}
+			}
+			v.end = v.start + n
+		}
+	}
+	rec.timestamp, rec.ip, rec.method, rec.path, rec.status, rec.responseTime = values[0], values[1], values[2], values[3], values[4], values[5]
+	if found&(1<<fieldPath) == 0 || rec.path.start == rec.path.end {
+		if !plan.unknownPath {
+			return rec, &lineParseError{rec.path.start, fmt.Errorf("empty path")}
+		}
+		rec.altPath = unknownPath
+	}
+	return rec, nil
+}
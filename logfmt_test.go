@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLogfmtParse(t *testing.T) {
+	plan := newLogfmtPlan(keysOr(keyMapping{}, defaultLogfmtKeys), false)
+	tests := []struct {
+		line, path, method string
+		ms                 int64
+	}{
+		{`ts=2024-01-01T00:00:00Z ip=10.0.0.1 method=GET path=/api/users status=200 dur_ms=42`, "/api/users", "GET", 42},
+		// Порядок ключей любой, лишние ключи и флаги без значения пропускаются
+		{`level=info dur_ms=7 debug path=/b method=POST msg="a b=c" status=201`, "/b", "POST", 7},
+		// Значение в кавычках с пробелами и экранированными кавычками
+		{`path="/search?q=\"a b\"" dur_ms=5 method=GET`, `/search?q="a b"`, "GET", 5},
+		{`path=/crlf   dur_ms=3` + "\r", "/crlf", "", 3},
+		// Повтор ключа: берётся первый
+		{`path=/first path=/second dur_ms=1 dur_ms=2`, "/first", "", 1},
+	}
+	for _, tt := range tests {
+		line := []byte(tt.line)
+		rec, err := plan.parse(line, timeIntMillis)
+		if err != nil {
+			t.Errorf("%s: %v", tt.line, err)
+			continue
+		}
+		if path, method := string(line[rec.path.start:rec.path.end]), string(line[rec.method.start:rec.method.end]); path != tt.path || method != tt.method || rec.value != tt.ms {
+			t.Errorf("%s: got %q %q %dms, want %q %q %dms", tt.line, method, path, rec.value, tt.method, tt.path, tt.ms)
+		}
+	}
+
+	for _, bad := range []string{
+		`path=/a status=200`,
+		`path=/a dur_ms=abc`,
+		`path="/unclosed dur_ms=1`,
+		`dur_ms=1`,
+		`path= dur_ms=1`,
+		// Ключ, который только начинается с нужного, - другой ключ
+		`path_raw=/a dur_ms=1`,
+	} {
+		if _, err := plan.parse([]byte(bad), timeIntMillis); err == nil {
+			t.Errorf("accepted %s", bad)
+		}
+	}
+}
+
+func TestLogfmtUnknownPath(t *testing.T) {
+	plan := newLogfmtPlan(keysOr(keyMapping{}, defaultLogfmtKeys), true)
+	for _, line := range []string{`dur_ms=9 status=500`, `path= dur_ms=9`} {
+		rec, err := plan.parse([]byte(line), timeIntMillis)
+		if err != nil || string(rec.pathOf([]byte(line))) != "(unknown)" {
+			t.Errorf("%s: path %q, %v", line, rec.pathOf([]byte(line)), err)
+		}
+	}
+	// Без времени строка битая и с -unknown-path
+	if _, err := plan.parse([]byte(`path=/a`), timeIntMillis); err == nil {
+		t.Error("line without dur_ms accepted")
+	}
+
+	var keys keyMapping
+	if err := keys.Set("path=route,time=latency"); err != nil {
+		t.Fatal(err)
+	}
+	line := []byte(`route=/custom latency=12 path=/ignored dur_ms=1`)
+	rec, err := newLogfmtPlan(keysOr(keys, defaultLogfmtKeys), false).parse(line, timeIntMillis)
+	if err != nil || string(line[rec.path.start:rec.path.end]) != "/custom" || rec.value != 12 {
+		t.Errorf("-logfmt-keys path=route,time=latency: %q %dms, %v", line[rec.path.start:rec.path.end], rec.value, err)
+	}
+}
+
+// BenchmarkLogfmtParse сравнивает разбор logfmt с форматом по умолчанию на тех же
+// полях: logfmt сравнивает каждый ключ и потому заметно дороже
+func BenchmarkLogfmtParse(b *testing.B) {
+	const (
+		ts, ip, path = "2024-01-01T00:00:00Z", "192.168.1.1", "/api/items/123"
+	)
+	plain := []byte(fmt.Sprintf("%s %s GET %s 200 1234", ts, ip, path))
+	logfmt := []byte(fmt.Sprintf("ts=%s ip=%s method=GET path=%s status=200 dur_ms=1234", ts, ip, path))
+	extra := []byte(fmt.Sprintf("level=info ts=%s caller=http.go:42 ip=%s method=GET path=%s status=200 msg=\"request done\" dur_ms=1234 trace_id=4bf92f3577b34da6", ts, ip, path))
+	plan := newLogfmtPlan(keysOr(keyMapping{}, defaultLogfmtKeys), false)
+	b.Run("default", func(b *testing.B) {
+		b.SetBytes(int64(len(plain)))
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := parseLine(plain); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	for name, line := range map[string][]byte{"logfmt": logfmt, "logfmt-extra-keys": extra} {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(line)))
+			b.ReportAllocs()
+			for range b.N {
+				if _, err := plan.parse(line, timeIntMillis); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"runtime"
-	"runtime/pprof"
 	"sort"
-	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
@@ -17,118 +24,727 @@ type Stats struct {
 	Max   int64
 	Sum   int64
 	Count int64
+	// extra - накопители -percentiles, -stddev, -buckets и -apdex-t; nil без этих флагов
+	extra *statsExtra
 }
 
 func main() {
-	cpuProfile := os.Getenv("CPU_PROFILE")
-	if cpuProfile != "" {
-		f, err := os.Create("cpu.prof")
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error creating CPU profile: %v\n", err)
-			os.Exit(1)
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// analyzeMain - подкоманда analyze: разбирает лог и печатает статистику по эндпоинтам
+func analyzeMain(args []string) int {
+	opts, err := parseArgs(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
 		}
-		defer f.Close()
+		return exitUsage
+	}
+	if opts.version {
+		fmt.Println(getBuildInfo())
+		return exitOK
+	}
 
-		if err := pprof.StartCPUProfile(f); err != nil {
-			fmt.Fprintf(os.Stderr, "error starting CPU profile: %v\n", err)
-			os.Exit(1)
+	setLogLevel(opts.verbose, opts.quiet)
+	if opts.check {
+		return runCheck(opts)
+	}
+	if opts.explain != "" || opts.explainLine > 0 {
+		return runExplain(opts)
+	}
+	if err := runAnalyze(opts); err != nil {
+		if !errors.Is(err, errPartialResult) {
+			logger.errorf("%v", err)
 		}
-		defer pprof.StopCPUProfile()
+		return exitCodeFor(err)
 	}
+	return exitOK
+}
 
-	var filePath string
-	if len(os.Args) > 1 {
-		filePath = os.Args[1]
-	} else {
-		fmt.Println("You need provide file path in first argument")
+// headDescription описывает ограничение -head-bytes/-head-lines для предупреждения
+func headDescription(opts *options) string {
+	var limits []string
+	if opts.headBytes > 0 {
+		limits = append(limits, "the first "+opts.headBytes.String()+" bytes")
+	}
+	if opts.headLines > 0 {
+		limits = append(limits, fmt.Sprintf("%d parsed lines", opts.headLines))
+	}
+	return strings.Join(limits, " and ")
+}
+
+// errPartialResult - результат записан, но неполный из-за -timeout -allow-partial
+var errPartialResult = errors.New("partial result")
+
+// errorRateError - доля битых строк превысила -max-error-rate
+type errorRateError struct {
+	counters lineCounters
+	maxRate  float64
+}
+
+func (e *errorRateError) Error() string {
+	return fmt.Sprintf("malformed line rate %.4f (%d of %d lines) exceeds -max-error-rate %v",
+		e.counters.errorRate(), e.counters.malformed, e.counters.total(), e.maxRate)
+}
+
+// runAnalyze выполняет analyze. Все выходы идут через return, чтобы отложенные
+// остановка профилей и закрытие файла результата срабатывали на любом пути
+func runAnalyze(opts *options) (err error) {
+	render := renderers[opts.format](opts)
+
+	applyProfileEnv(opts.profiles)
+	prof, err := startProfiles(opts.profiles, opts.blockProfileRate, opts.mutexProfileFraction)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if stopErr := prof.stop(); stopErr != nil {
+			if err != nil {
+				logger.errorf("%v", stopErr)
+				return
+			}
+			err = stopErr
+		}
+	}()
+	if opts.pprofAddr != "" {
+		if err := prof.serveHTTP(opts.pprofAddr); err != nil {
+			return err
+		}
 	}
 
-	numWorkers := runtime.NumCPU()
-	runtime.GOMAXPROCS(numWorkers)
+	// Файл -slo проверяем до обработки, чтобы опечатка в нём не стоила целого прогона
+	var slo *sloConfig
+	if opts.sloPath != "" {
+		if slo, err = loadSLOConfig(opts.sloPath); err != nil {
+			return err
+		}
+		slo.mapping = newSketchMapping(opts.sketchAccuracy)
+		if opts.quantiles != nil {
+			slo.mapping = opts.quantiles.mapping
+		}
+	}
 
-	parts, err := splitFile(filePath, numWorkers)
+	// Файл результата создаём заранее, чтобы не узнать об ошибке после долгой обработки
+	out, err := createOutput(opts.outPath, opts.gzipLevel(opts.outPath))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error splitting file: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("error creating output file: %w", err)
 	}
+	// На успешном пути файл переименовывает closeOutput, иначе временный файл удаляется
+	defer out.abort()
 
-	resultsChan := make(chan map[string]*Stats, numWorkers)
+	// Количество воркеров задаёт и GOMAXPROCS, флаг важнее переменной окружения
+	runtime.GOMAXPROCS(opts.numWorkers)
 
-	for _, part := range parts {
-		go processPart(filePath, part.offset, part.size, resultsChan)
+	ctx := context.Background()
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
 	}
 
-	totals := make(map[string]*Stats)
-	for range parts {
-		result := <-resultsChan
+	popts := &processOptions{
+		chunkSize:  int(opts.chunkSize),
+		maxLine:    int(opts.maxLineLength),
+		force:      opts.force,
+		strict:     opts.strict,
+		headBytes:  int64(opts.headBytes),
+		keyKind:    opts.groupBy.kind(),
+		maxBuckets: opts.maxBuckets,
+		format:     lineFormatOf(opts),
+		skipLines:  opts.headerLines(),
+		comment:    commentPrefixOf(opts),
+		strip:      pathStripOf(opts),
+		normalize:  newPathNormalizer(opts.normalizeRules, opts.autoNormalize),
+		rawKeys:    rawKeysOf(opts),
+		aliases:    opts.aliases,
+		span:       spanLayoutOf(opts),
+	}
+	if opts.debugParts {
+		popts.debug = &partsDebug{}
+	}
+	popts.extras = newExtraSpec(opts)
+	// Порогам max_pNN нужно распределение, даже если -percentiles не задан
+	if slo != nil && slo.hasPercentiles() && opts.quantiles == nil {
+		if popts.extras == nil {
+			popts.extras = &extraSpec{}
+		}
+		popts.extras.sketches = slo.mapping
+	}
+	if opts.headLines > 0 {
+		popts.lineBudget = new(atomic.Int64)
+		popts.lineBudget.Store(opts.headLines)
+	}
+	if opts.progress {
+		var total int64
+		for _, path := range opts.filePaths {
+			st, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			size := st.Size()
+			if opts.headBytes > 0 {
+				size = min(size, int64(opts.headBytes))
+			}
+			total += size
+		}
+		popts.progress = newProgressReporter(total)
+	}
 
-		for endpoint, s := range result {
-			end, ok := totals[endpoint]
-			if !ok {
-				totals[endpoint] = &Stats{
-					Min:   s.Min,
-					Max:   s.Max,
-					Sum:   s.Sum,
-					Count: s.Count,
-				}
-				continue
+	start := time.Now()
+	// С -flush-interval промежуточные снимки пишутся прямо в -o, итоговый commit их заменит
+	var flush *flusher
+	if opts.flushInterval > 0 {
+		popts.merger = newMerger()
+		flush = startFlusher(opts.flushInterval, popts.merger, func(res *pipelineResult, files []fileResult) error {
+			res.partial = true
+			return writeReport(opts.outPath, opts.gzipLevel(opts.outPath), render, assembleReport(res, files, opts, start))
+		})
+	}
+	res, files, err := analyzeFiles(ctx, opts, popts)
+	flush.stop()
+	popts.progress.finish()
+	if err != nil {
+		return err
+	}
+
+	if res.partial {
+		logger.warnf("timeout of %v exceeded, emitting partial results", opts.timeout)
+	}
+
+	if opts.headBytes > 0 || opts.headLines > 0 {
+		logger.warnf("result covers only %s (%s, %s lines)",
+			headDescription(opts), humanBytes(res.bytesRead), humanCount(res.counters.total()))
+	}
+
+	if res.counters.malformed > 0 {
+		logger.warnf("skipped %d malformed lines out of %d", res.counters.malformed, res.counters.total())
+	}
+
+	// Без единого разобранного timestamp промежуток, rps и timeline пропадают молча;
+	// чаще всего это -time-layout, который не совпал с логом
+	wantTimes := popts.span != nil || (popts.extras != nil && popts.extras.times != nil)
+	if wantTimes && res.counters.lines > 0 && !timestampsParsed(res) {
+		logger.warnf("no timestamp matched -time-layout %q, time span, rps and timeline are omitted", opts.timeLayoutText)
+	}
+
+	if res.counters.errorRate() > opts.maxErrorRate {
+		if opts.stats {
+			printRunStats(os.Stderr, time.Since(start), res, opts.aliases != nil)
+		}
+		return &errorRateError{res.counters, opts.maxErrorRate}
+	}
+
+	w := bufio.NewWriter(out)
+	rep := assembleReport(res, files, opts, start)
+	if err := render.render(w, rep); err != nil {
+		return fmt.Errorf("error writing output: %w", err)
+	}
+
+	if err := closeOutput(w, out); err != nil {
+		return fmt.Errorf("error writing output: %w", err)
+	}
+
+	if opts.csvOutPath != "" {
+		if err := writeReport(opts.csvOutPath, opts.gzipLevel(opts.csvOutPath), renderers["csv"](opts), rep); err != nil {
+			return fmt.Errorf("error writing -csv-out: %w", err)
+		}
+	}
+	if opts.htmlOutPath != "" {
+		if err := writeReport(opts.htmlOutPath, opts.gzipLevel(opts.htmlOutPath), renderers["html"](opts), rep); err != nil {
+			return fmt.Errorf("error writing -html-out: %w", err)
+		}
+	}
+	if opts.parquetOutPath != "" {
+		// Страницы Parquet сжаты внутри файла, внешний gzip сделал бы его нечитаемым
+		if err := writeReport(opts.parquetOutPath, 0, parquetRenderer{start}, rep); err != nil {
+			return fmt.Errorf("error writing -parquet-out: %w", err)
+		}
+	}
+	if opts.sqliteOutPath != "" {
+		runID, err := writeSQLite(opts.sqliteOutPath, rep)
+		if err != nil {
+			return fmt.Errorf("error writing -sqlite-out: %w", err)
+		}
+		logger.infof("added run %d to %s", runID, opts.sqliteOutPath)
+	}
+
+	// Метрики - дополнительный выход: недоступный агент не должен проваливать прогон
+	if opts.statsdAddr != "" {
+		if err := sendStatsd(opts.statsdAddr, opts.statsdPrefix, opts.precision, rep); err != nil {
+			logger.warnf("error sending metrics to statsd %s: %v", opts.statsdAddr, err)
+		}
+	}
+
+	if opts.stats {
+		printRunStats(os.Stderr, time.Since(start), res, opts.aliases != nil)
+	}
+	if popts.debug != nil {
+		printPartsDebug(os.Stderr, popts.debug)
+	}
+
+	// SLO проверяется по всем эндпоинтам, без учёта -top и -min-count
+	if slo != nil {
+		sloRep := evaluateSLO(slo, res.totals)
+		sloRep.print(os.Stderr, opts.sloPath)
+		if sloRep.failed > 0 {
+			return &sloError{sloRep.failed, len(sloRep.results)}
+		}
+	}
+
+	if res.partial {
+		return errPartialResult
+	}
+	return nil
+}
+
+// fileResult - результат одного входного файла, для -per-file
+type fileResult struct {
+	path string
+	res  *pipelineResult
+}
+
+// analyzeFiles обрабатывает входные файлы по очереди, каждый всеми воркерами, и сводит
+// их в общий результат. После истечения -timeout оставшиеся файлы уже не читаются
+func analyzeFiles(ctx context.Context, opts *options, popts *processOptions) (*pipelineResult, []fileResult, error) {
+	if popts.merger == nil {
+		popts.merger = newMerger()
+	}
+	for _, path := range opts.filePaths {
+		res, err := runPipeline(ctx, path, opts.numWorkers, popts, opts.allowPartial)
+		if err != nil {
+			if len(opts.filePaths) > 1 {
+				err = fmt.Errorf("%s: %w", path, err)
 			}
+			return nil, nil, err
+		}
+		if res.partial {
+			break
+		}
+	}
+	return popts.merger.total, popts.merger.files, nil
+}
 
-			end.Min = min(end.Min, s.Min)
-			end.Max = max(end.Max, s.Max)
-			end.Sum += s.Sum
-			end.Count += s.Count
-			totals[endpoint] = end
+// assembleReport собирает отчёт целиком: итог, meta и, с -per-file, отчёты по файлам
+func assembleReport(res *pipelineResult, files []fileResult, opts *options, start time.Time) *report {
+	rep := buildReport(res, opts)
+	rep.meta = &runMeta{
+		inputFiles:  opts.filePaths,
+		fileSize:    res.fileSize,
+		parts:       res.parts,
+		duration:    time.Since(start),
+		linesParsed: res.counters.lines,
+	}
+	if opts.trimmedMean > 0 {
+		rep.meta.trimmedMean, rep.meta.trimmedMethod = opts.trimmedMean, trimmedMethod(res, opts)
+	}
+	if opts.perFile {
+		for _, f := range files {
+			rep.files = append(rep.files, fileReport{f.path, buildReport(f.res, opts)})
 		}
 	}
+	// Промежуточные записи помечены "partial": true, поэтому итог явно пишет false
+	rep.markPartial = opts.flushInterval > 0
+	return rep
+}
 
-	endpoints := make([]string, 0, len(totals))
-	for endpoint := range totals {
-		endpoints = append(endpoints, endpoint)
+// buildReport упорядочивает и обрезает результат по -sort, -top и -min-count
+func buildReport(res *pipelineResult, opts *options) *report {
+	rep := newReport(res.totals, sortOrder{opts.sortKey, opts.desc})
+	rep.trim(trimOptions{
+		minCount:     opts.minCount,
+		keepFiltered: opts.keepFiltered,
+		top:          opts.top,
+		topOther:     opts.topOther,
+	})
+	rep.partial = res.partial
+	rep.malformed = res.counters.malformed
+	rep.span = res.span
+	rep.quantiles = opts.quantiles
+	rep.apdex = opts.apdexT > 0
+	rep.slow = opts.slowCounts
+	rep.uniqueIPs = opts.uniqueIPs
+	rep.statusBreakdown = opts.statusBreakdown
+	if !opts.noHighlights {
+		rep.highlights = findHighlights(res.totals)
 	}
-	sort.Strings(endpoints)
+	return rep
+}
+
+// pipelineResult - объединённый результат всех частей файла
+type pipelineResult struct {
+	totals    map[string]*Stats
+	counters  lineCounters
+	bytesRead int64
+	// fileSize - размер входного файла, parts - на сколько частей он поделён;
+	// для нескольких файлов - суммы по ним
+	fileSize int64
+	parts    int
+	// partial - часть данных не обработана из-за истечения ctx
+	partial bool
+	// rawKeys - оценка ключей до обрезки и нормализации path для -stats; nil без них
+	rawKeys *hll
+	// span - первый и последний timestamp лога для summary
+	span seenRange
+}
 
-	fmt.Fprint(os.Stdout, "{\n  \"endpoints\": {\n")
-	for i, endpoint := range endpoints {
-		if i > 0 {
-			fmt.Fprint(os.Stdout, ",\n")
+// timestampsParsed - разобрался ли хоть один timestamp: для промежутка summary,
+// first_seen или timeline какого-нибудь эндпоинта
+func timestampsParsed(res *pipelineResult) bool {
+	if res.span.ok {
+		return true
+	}
+	for _, s := range res.totals {
+		if x := s.extra; x != nil && ((x.seen != nil && x.seen.ok) || len(x.timeline) > 0) {
+			return true
 		}
-		end := totals[endpoint]
-		mean := float64(end.Sum) / float64(end.Count)
-		fmt.Fprintf(os.Stdout, "    \"%s\": {\n      \"min_response_time\": %d,\n      \"avg_response_time\": %.1f,\n      \"max_response_time\": %d\n    }",
-			endpoint, end.Min, mean, end.Max)
 	}
-	fmt.Fprint(os.Stdout, "\n  }\n}\n")
+	return false
+}
 
-	memProfile := os.Getenv("MEM_PROFILE")
-	if memProfile != "" {
-		f, err := os.Create(memProfile)
+// runPipeline делит файл на части, обрабатывает их параллельно и сводит результаты.
+// При истечении ctx возвращает ошибку ctx, а с allowPartial - то, что успели собрать
+func runPipeline(ctx context.Context, filePath string, numWorkers int, popts *processOptions, allowPartial bool) (*pipelineResult, error) {
+	parts, err := splitFile(ctx, filePath, numWorkers, popts.headBytes, !popts.force)
+	if isNotText(err) {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error splitting file: %w", err)
+	}
+	// Директива #Fields обычно только в начале файла, то есть в части 0, а нужна
+	// всем частям: её ищут до запуска воркеров
+	if popts.format.w3c {
+		plans, err := w3cPartPlans(ctx, filePath, parts)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error creating memory profile: %v\n", err)
-			os.Exit(1)
+			return nil, fmt.Errorf("error reading #Fields directives: %w", err)
+		}
+		for i := range parts {
+			parts[i].w3c = plans[i]
+		}
+	}
+
+	m := popts.merger
+	if m == nil {
+		m = newMerger()
+	}
+	var fileSize int64
+	if st, err := os.Stat(filePath); err == nil {
+		fileSize = st.Size()
+	}
+	res := m.beginFile(filePath, len(parts), fileSize)
+
+	// Воркеры отдают дельты после каждой пачки. quit закрывается на выходе, чтобы
+	// воркеры, которых уже не ждут (ошибка в другой части, истёк grace), не висели на отправке
+	resultsChan := make(chan partResult, len(parts))
+	quit := make(chan struct{})
+	defer close(quit)
+	popts.progress.begin()
+
+	var debug *filePartsDebug
+	if popts.debug != nil {
+		popts.debug.files = append(popts.debug.files, filePartsDebug{path: filePath})
+		debug = &popts.debug.files[len(popts.debug.files)-1]
+	}
+
+	for i, part := range parts {
+		go processPart(ctx, filePath, i, len(parts), part, popts, resultsChan, quit)
+	}
+
+	// После дедлайна ждём воркеров не дольше partialGracePeriod: они останавливаются
+	// на границе пачки, но зависший на чтении воркер не должен блокировать выход
+	done := ctx.Done()
+	var grace <-chan time.Time
+
+collect:
+	for finished := 0; finished < len(parts); {
+		var result partResult
+		select {
+		case result = <-resultsChan:
+			if result.done || result.err != nil {
+				finished++
+			}
+		case <-done:
+			if !allowPartial {
+				return nil, fmt.Errorf("discarding partial results: %w", ctx.Err())
+			}
+			m.markPartial(res)
+			done = nil
+			grace = time.After(partialGracePeriod)
+			continue
+		case <-grace:
+			logger.warnf("%d of %d parts did not stop in time, their results are lost", len(parts)-finished, len(parts))
+			break collect
 		}
-		defer f.Close()
 
-		runtime.GC()
-		if err := pprof.WriteHeapProfile(f); err != nil {
-			fmt.Fprintf(os.Stderr, "error writing memory profile: %v\n", err)
-			os.Exit(1)
+		if result.err != nil {
+			if !errors.Is(result.err, ctx.Err()) {
+				return nil, result.err
+			}
+			m.markPartial(res)
+		}
+		// После отката -exact-percentiles дельты, начатые до него, тоже переводятся в скетчи
+		exact := popts.extras != nil && popts.extras.exact != nil
+		if exact && popts.extras.exact.fallback.Load() {
+			for _, s := range result.stats {
+				s.extra.dropExact(popts.extras.sketches)
+			}
+		}
+		mergeStart := time.Now()
+		m.addPart(res, result)
+		if exact {
+			m.limitExact(popts.extras.exact, popts.extras.sketches)
+		}
+		if popts.extras != nil && popts.extras.ips != nil {
+			m.checkUniqueIPs(popts.extras.ips)
+		}
+		if popts.extras != nil && popts.extras.bucketWidth > 0 {
+			if err := m.checkTimeline(popts.maxBuckets); err != nil {
+				return nil, err
+			}
 		}
+		if debug == nil {
+			continue
+		}
+		debug.merge += time.Since(mergeStart)
+		if result.debug != nil {
+			debug.parts = append(debug.parts, *result.debug)
+		}
+	}
+
+	if debug != nil {
+		sort.Slice(debug.parts, func(i, j int) bool { return debug.parts[i].index < debug.parts[j].index })
+	}
+	return res, nil
+}
+
+// add сводит в r результат ещё одного файла
+func (r *pipelineResult) add(o *pipelineResult) {
+	mergeStats(r.totals, o.totals)
+	r.counters = r.counters.add(o.counters)
+	r.bytesRead += o.bytesRead
+	r.fileSize += o.fileSize
+	r.parts += o.parts
+	r.partial = r.partial || o.partial
+	r.addRawKeys(o.rawKeys)
+	r.span.merge(&o.span)
+}
+
+// addRawKeys сливает оценку ключей до обрезки и нормализации path
+func (r *pipelineResult) addRawKeys(h *hll) {
+	switch {
+	case h == nil:
+	case r.rawKeys == nil:
+		r.rawKeys = h.clone()
+	default:
+		r.rawKeys.merge(h)
+	}
+}
+
+// merge добавляет к s статистику o
+func (s *Stats) merge(o *Stats) {
+	s.Min = min(s.Min, o.Min)
+	s.Max = max(s.Max, o.Max)
+	s.Sum += o.Sum
+	s.Count += o.Count
+	if o.extra != nil {
+		if s.extra == nil {
+			s.extra = &statsExtra{}
+		}
+		s.extra.merge(o.extra)
+	}
+}
+
+// addValue учитывает v в s без накопителей и возвращает s; nil заводит новый Stats.
+// Так же, как время ответа, считаются интервалы -bucket и размеры -bytes-field
+func addValue(s *Stats, v int64) *Stats {
+	if s == nil {
+		return &Stats{Min: v, Max: v, Sum: v, Count: 1}
+	}
+	s.Min = min(s.Min, v)
+	s.Max = max(s.Max, v)
+	s.Sum += v
+	s.Count++
+	return s
+}
+
+// clone копирует s вместе с накопителями, чтобы слияние в копию не меняло оригинал
+func (s *Stats) clone() *Stats {
+	c := *s
+	c.extra = s.extra.clone()
+	return &c
+}
+
+// mergeStats добавляет статистику src в dst
+func mergeStats(dst, src map[string]*Stats) {
+	for endpoint, s := range src {
+		if end, ok := dst[endpoint]; ok {
+			end.merge(s)
+			continue
+		}
+		dst[endpoint] = s.clone()
+	}
+}
+
+func setLogLevel(verbose, quiet bool) {
+	switch {
+	case quiet:
+		logger.level = levelQuiet
+	case verbose:
+		logger.level = levelInfo
+	}
+}
+
+// outputFile - место, куда пишется результат. Файл пишется во временный рядом с целевым
+// и переименовывается в commit, так что читатель видит либо старое, либо полное новое
+// содержимое. Для stdout tmp == nil
+type outputFile struct {
+	*os.File
+	tmp  *os.File
+	path string
+	// gz, если задан, сжимает всё, что пишется через Write; закрывает его closeOutput
+	gz *gzip.Writer
+}
+
+// createOutput открывает файл результата; пустой путь и "-" означают stdout.
+// gzipLevel больше нуля включает сжатие gzip с этим уровнем
+func createOutput(path string, gzipLevel int) (*outputFile, error) {
+	out := &outputFile{File: os.Stdout}
+	if path != "" && path != "-" {
+		// Временный файл в том же каталоге: rename атомарен только в пределах одной ФС
+		tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+		if err != nil {
+			return nil, err
+		}
+		out = &outputFile{File: tmp, tmp: tmp, path: path}
+	}
+	if gzipLevel > 0 {
+		gz, err := gzip.NewWriterLevel(out.File, gzipLevel)
+		if err != nil {
+			out.abort()
+			return nil, err
+		}
+		out.gz = gz
+	}
+	return out, nil
+}
+
+func (o *outputFile) Write(p []byte) (int, error) {
+	if o.gz != nil {
+		return o.gz.Write(p)
+	}
+	return o.File.Write(p)
+}
+
+// commit сбрасывает временный файл на диск и переименовывает его в целевой
+func (o *outputFile) commit() error {
+	if o.tmp == nil {
+		return nil
+	}
+	// CreateTemp создаёт файл с правами 0600; сохраняем права заменяемого файла
+	mode := os.FileMode(0o644)
+	if st, err := os.Stat(o.path); err == nil {
+		mode = st.Mode().Perm()
+	}
+	err := o.tmp.Chmod(mode)
+	if err == nil {
+		err = o.tmp.Sync()
+	}
+	if cerr := o.tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(o.tmp.Name(), o.path)
+	}
+	if err != nil {
+		os.Remove(o.tmp.Name())
+	}
+	o.tmp = nil
+	return err
+}
+
+// abort удаляет незавершённый временный файл; после commit ничего не делает
+func (o *outputFile) abort() {
+	if o == nil || o.tmp == nil {
+		return
+	}
+	o.tmp.Close()
+	os.Remove(o.tmp.Name())
+	o.tmp = nil
+}
+
+// Сколько ждать воркеров после дедлайна в режиме -allow-partial
+const partialGracePeriod = 2 * time.Second
+
+// exitCodeFor выбирает код выхода по ошибке: истечение -timeout, превышение
+// -max-error-rate и нарушение -slo отличаются от прочих ошибок
+func exitCodeFor(err error) int {
+	var rateErr *errorRateError
+	var sloErr *sloError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, errPartialResult):
+		return exitTimeout
+	case errors.As(err, &rateErr):
+		return exitErrorRate
+	case errors.As(err, &sloErr):
+		return exitSLO
+	}
+	return exitError
+}
+
+// writeReport рендерит rep в файл path (или stdout для "-"), со сжатием при gzipLevel > 0
+func writeReport(path string, gzipLevel int, render renderer, rep *report) error {
+	out, err := createOutput(path, gzipLevel)
+	if err != nil {
+		return err
 	}
+	defer out.abort()
+	w := bufio.NewWriter(out)
+	if err := render.render(w, rep); err != nil {
+		return err
+	}
+	return closeOutput(w, out)
+}
+
+// closeOutput сбрасывает буфер и, если вывод идёт в файл, атомарно заменяет им целевой файл.
+// При ошибке временный файл удаляется, целевой остаётся прежним
+func closeOutput(w *bufio.Writer, out *outputFile) error {
+	err := w.Flush()
+	// gzip дописывает последний блок и контрольную сумму только в Close
+	if err == nil && out.gz != nil {
+		err = out.gz.Close()
+	}
+	if err != nil {
+		out.abort()
+		return err
+	}
+	return out.commit()
 }
 
 type part struct {
 	offset, size int64
+	// w3c - раскладка -input-format w3c, действующая в начале части
+	w3c *w3cPlan
 }
 
-func splitFile(filePath string, numParts int) ([]part, error) {
-	const maxLineLength = 100
-
+// splitFile делит файл на numParts частей по границам строк. Если limit > 0, делится
+// только начало файла длиной не больше limit, обрезанное по последнему переводу строки.
+// Граница части - начало строки, в которой приходится примерная граница; строка длиннее
+// части сдвигает границу вперёд, и частей выходит меньше, вплоть до одной. С sniff
+// сначала проверяется, что файл похож на текст (checkTextInput)
+func splitFile(ctx context.Context, filePath string, numParts int, limit int64, sniff bool) ([]part, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
+	if sniff {
+		if err := checkTextInput(file); err != nil {
+			return nil, err
+		}
+	}
 
 	st, err := file.Stat()
 	if err != nil {
@@ -136,83 +752,353 @@ func splitFile(filePath string, numParts int) ([]part, error) {
 	}
 
 	fileSize := st.Size()
+	if limit > 0 && limit < fileSize {
+		if fileSize, err = lineBoundary(file, limit); err != nil {
+			return nil, err
+		}
+	}
 	chunkSize := fileSize / int64(numParts)
 
 	// Если файл слишком малкий, то нет смысла сплитить его
 	if chunkSize < 4096 {
-		return []part{{0, fileSize}}, nil
+		return []part{{offset: 0, size: fileSize}}, nil
 	}
 
-	buf := make([]byte, maxLineLength)
+	buf := make([]byte, lineSearchWindow)
 
 	parts := make([]part, 0, numParts)
 
 	offset := int64(0)
 
 	for i := range numParts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		if i == numParts-1 {
-			if offset < fileSize {
-				parts = append(parts, part{offset, fileSize - offset})
-			}
 			break
 		}
 
-		seekOffset := max(offset+chunkSize-maxLineLength, 0)
-		_, err := file.Seek(seekOffset, io.SeekStart)
+		target := offset + chunkSize
+		nextOffset, err := lastLineStart(file, buf, offset, target)
 		if err != nil {
 			return nil, err
 		}
-		n, _ := io.ReadFull(file, buf)
-		chunk := buf[:n]
-		newline := bytes.LastIndexByte(chunk, '\n')
-		if newline < 0 {
-			return nil, fmt.Errorf("newline not found at offset %d", offset+chunkSize-maxLineLength)
+		if nextOffset < 0 {
+			// В части нет ни одного перевода строки: граница - конец строки, которая её накрыла
+			if nextOffset, err = nextLineStart(file, buf, target, fileSize); err != nil {
+				return nil, err
+			}
 		}
-		remaining := len(chunk) - newline - 1
-		nextOffset := seekOffset + int64(len(chunk)) - int64(remaining)
-		parts = append(parts, part{offset, nextOffset - offset})
+		if nextOffset < 0 || nextOffset >= fileSize {
+			break
+		}
+		parts = append(parts, part{offset: offset, size: nextOffset - offset})
 		offset = nextOffset
 	}
+	if offset < fileSize {
+		parts = append(parts, part{offset: offset, size: fileSize - offset})
+	}
 
 	return parts, nil
 }
 
-func processPart(filePath string, fileOffset, fileSize int64, resultsChan chan map[string]*Stats) {
+// lineSearchWindow - сколько байт за раз читают поиски границ строк
+const lineSearchWindow = 64 * 1024
+
+// lastLineStart ищет последний перевод строки в [from, to), читая назад окнами по
+// len(buf), и возвращает позицию за ним; -1, если перевода строки там нет
+func lastLineStart(file *os.File, buf []byte, from, to int64) (int64, error) {
+	for end := to; end > from; {
+		start := max(end-int64(len(buf)), from)
+		n, err := file.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if nl := bytes.LastIndexByte(buf[:n], '\n'); nl >= 0 {
+			return start + int64(nl) + 1, nil
+		}
+		end = start
+	}
+	return -1, nil
+}
+
+// nextLineStart ищет первый перевод строки в [from, to), читая вперёд окнами по
+// len(buf), и возвращает позицию за ним; -1, если перевода строки там нет
+func nextLineStart(file *os.File, buf []byte, from, to int64) (int64, error) {
+	for start := from; start < to; {
+		n, err := file.ReadAt(buf[:min(int64(len(buf)), to-start)], start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if nl := bytes.IndexByte(buf[:n], '\n'); nl >= 0 {
+			return start + int64(nl) + 1, nil
+		}
+		if n == 0 {
+			break
+		}
+		start += int64(n)
+	}
+	return -1, nil
+}
+
+// lineBoundary возвращает конец последней полной строки, целиком лежащей в первых limit байтах
+func lineBoundary(file *os.File, limit int64) (int64, error) {
+	end, err := lastLineStart(file, make([]byte, lineSearchWindow), 0, limit)
+	if err != nil {
+		return 0, err
+	}
+	if end < 0 {
+		return 0, fmt.Errorf("no complete line in the first %d bytes", limit)
+	}
+	return end, nil
+}
+
+// processOptions - настройки обработки, общие для всех воркеров
+type processOptions struct {
+	chunkSize int
+	// maxLine - предел длины строки (-max-line-length); 0 - без предела
+	maxLine int
+	// force - не проверять, что вход похож на текст (-force)
+	force bool
+	// strict останавливает обработку на первой битой строке
+	strict bool
+	// headBytes, если больше нуля, ограничивает обработку началом файла (-head-bytes)
+	headBytes int64
+	// lineBudget, если задан, - сколько строк ещё можно разобрать всем воркерам вместе (-head-lines)
+	lineBudget *atomic.Int64
+	// progress, если задан, получает количество прочитанных байт по частям
+	progress *progressReporter
+	// merger, если задан, копит результат всех файлов по мере обработки (-flush-interval)
+	merger *merger
+	// debug, если задан, получает границы и тайминги каждой части (-debug-parts)
+	debug *partsDebug
+	// extras, если задан, - какие необязательные накопители вести в Stats
+	extras *extraSpec
+	// keyKind - из каких полей строки собирается ключ агрегации (-group-by)
+	keyKind keyKind
+	// maxBuckets - предел пар (эндпоинт, интервал) для -bucket
+	maxBuckets int
+	// format - как разбирать строки (-bytes-field, -paths-may-contain-spaces)
+	format lineFormat
+	// skipLines - сколько первых непустых строк файла - заголовок (-skip-lines, -skip-header)
+	skipLines int
+	// comment - начало строк-комментариев (-comment-prefix); nil - без них
+	comment []byte
+	// strip и normalize - что отрезать от path (-strip-query, -strip-fragment) и чем его
+	// заменить (-normalize-rules, -auto-normalize); rawKeys, если задан, - точность
+	// HyperLogLog ключей до этого для -stats
+	strip     pathStrip
+	normalize *pathNormalizer
+	rawKeys   *hllSpec
+	// aliases - -alias-map, применяется последним
+	aliases *aliasMap
+	// span, если задан, - раскладка timestamp для промежутка лога в summary
+	span *timeLayout
+}
+
+// lineCounters - счётчики строк, которые processLines ведёт для своей части файла
+type lineCounters struct {
+	lines     int64
+	malformed int64
+	// aliased - сколько разобранных строк получили path из -alias-map
+	aliased int64
+	// oversize - строки длиннее -max-line-length; они же входят в malformed
+	oversize int64
+	// headers и comments - пропущенные строки заголовка и комментарии; ни в lines, ни в
+	// malformed не входят
+	headers  int64
+	comments int64
+}
+
+func (c lineCounters) total() int64 {
+	return c.lines + c.malformed
+}
+
+// errorRate - доля битых строк среди всех прочитанных
+func (c lineCounters) errorRate() float64 {
+	if c.total() == 0 {
+		return 0
+	}
+	return float64(c.malformed) / float64(c.total())
+}
+
+func (c lineCounters) sub(o lineCounters) lineCounters {
+	return lineCounters{lines: c.lines - o.lines, malformed: c.malformed - o.malformed,
+		aliased: c.aliased - o.aliased, oversize: c.oversize - o.oversize,
+		headers: c.headers - o.headers, comments: c.comments - o.comments}
+}
+
+func (c lineCounters) add(o lineCounters) lineCounters {
+	return lineCounters{lines: c.lines + o.lines, malformed: c.malformed + o.malformed,
+		aliased: c.aliased + o.aliased, oversize: c.oversize + o.oversize,
+		headers: c.headers + o.headers, comments: c.comments + o.comments}
+}
+
+// partResult - дельта, которую воркер отдаёт после каждой пачки: статистика, счётчики
+// и байты с прошлой отправки. Последнее сообщение части помечено done или несёт err
+type partResult struct {
+	stats     map[string]*Stats
+	counters  lineCounters
+	bytesRead int64
+	done      bool
+	err       error
+	// debug - итог части для -debug-parts, только в последнем сообщении
+	debug *partDebug
+	// rawKeys - ключи части до обрезки и нормализации path, тоже только в последнем сообщении
+	rawKeys *hll
+	// span - промежуток timestamp части с её начала: слияние повторов не меняет
+	span seenRange
+}
+
+// В сообщении об ошибке показываем не больше этого количества байт строки
+const maxErrorLineLength = 200
+
+// malformedLineError - ошибка разбора строки в strict-режиме
+type malformedLineError struct {
+	part   int
+	offset int64
+	line   []byte
+	err    error
+}
+
+func (e *malformedLineError) Error() string {
+	line := e.line
+	suffix := ""
+	if len(line) > maxErrorLineLength {
+		line = line[:maxErrorLineLength]
+		suffix = "..."
+	}
+	return fmt.Sprintf("malformed line at byte offset %d (part %d): %v: %q%s", e.offset, e.part, e.err, line, suffix)
+}
+
+func (e *malformedLineError) Unwrap() error {
+	return e.err
+}
+
+func processPart(ctx context.Context, filePath string, index, numParts int, p part, opts *processOptions, resultsChan chan<- partResult, quit <-chan struct{}) {
+	started := time.Now()
+	fileOffset, fileSize := p.offset, p.size
+	chunkSize := opts.chunkSize
+
 	// Открываем файл
 	file, err := os.Open(filePath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error opening file: %v\n", err)
-		os.Exit(1)
+		sendResult(resultsChan, quit, partResult{err: fmt.Errorf("error opening file: %w", err)})
+		return
 	}
 	defer file.Close()
 
 	// Перемещаемся на начало нашего куска
 	_, err = file.Seek(fileOffset, io.SeekStart)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error seek file: %v]n", err)
-		os.Exit(1)
+		sendResult(resultsChan, quit, partResult{err: fmt.Errorf("error seek file: %w", err)})
+		return
 	}
 
-	endpointStats := make(map[string]*Stats)
+	lp := &lineProcessor{
+		part:      index,
+		offset:    fileOffset,
+		stats:     make(map[string]*Stats),
+		strict:    opts.strict,
+		budget:    opts.lineBudget,
+		extras:    opts.extras,
+		keyKind:   opts.keyKind,
+		format:    opts.format,
+		maxLine:   opts.maxLine,
+		comment:   opts.comment,
+		strip:     opts.strip,
+		normalize: opts.normalize,
+		aliases:   opts.aliases,
+		times:     opts.span,
+	}
+	lp.format.fieldsW3C = p.w3c
+	// Заголовок есть только в начале файла, то есть в части 0
+	if fileOffset == 0 {
+		lp.header = opts.skipLines
+	}
+	if opts.rawKeys != nil {
+		lp.rawSpec, lp.rawKeys = opts.rawKeys, opts.rawKeys.newHLL()
+	}
 
-	// Будем читать пачками по 32Mb
-	chunkSize := 32 * 1024 * 1024
+	// Читаем пачками размера chunkSize (по умолчанию 32Mb)
 	buf := make([]byte, chunkSize)
 
 	// Буфер для неполных строк между пачками
 	remainder := make([]byte, 0, 4096)
+	// skipping - строка длиннее -max-line-length уже учтена, и её конец выбрасывается
+	// до следующего перевода строки, не попадая в remainder
+	skipping := false
+	// dropRemainder учитывает остаток, который уже длиннее предела, и начинает пропуск:
+	// копить такую строку дальше незачем. Остаток лежит в файле с lp.offset
+	dropRemainder := func() error {
+		if lp.maxLine == 0 || len(remainder) <= lp.maxLine {
+			return nil
+		}
+		err := lp.oversizeLine(lp.offset, remainder)
+		lp.offset += int64(len(remainder))
+		remainder, skipping = remainder[:0], true
+		return err
+	}
 
 	// Считаем количество прочитанных байт
 	var bytesRead int64 = 0
 
-	for bytesRead < fileSize {
+	// flush отдаёт накопленное с прошлой отправки и начинает новую дельту. Счётчики
+	// в lp остаются накопительными для логов, в дельту идёт разница
+	var sentBytes int64
+	var sentCounters lineCounters
+	// seen - все эндпоинты части: дельты каждый раз начинаются с пустой карты
+	var seen map[string]struct{}
+	if opts.debug != nil {
+		seen = make(map[string]struct{})
+	}
+	flush := func(done bool, err error) bool {
+		r := partResult{
+			stats:     lp.stats,
+			counters:  lp.counters.sub(sentCounters),
+			bytesRead: bytesRead - sentBytes,
+			done:      done,
+			err:       err,
+			span:      lp.span,
+		}
+		if done {
+			r.rawKeys = lp.rawKeys
+		}
+		if seen != nil {
+			for endpoint := range lp.stats {
+				seen[endpoint] = struct{}{}
+			}
+			if done {
+				r.debug = &partDebug{
+					index:     index,
+					offset:    fileOffset,
+					size:      fileSize,
+					lines:     lp.counters.lines,
+					bytesRead: bytesRead,
+					endpoints: len(seen),
+					wall:      time.Since(started),
+				}
+			}
+		}
+		lp.stats = make(map[string]*Stats)
+		sentBytes, sentCounters = bytesRead, lp.counters
+		return sendResult(resultsChan, quit, r)
+	}
+
+	for bytesRead < fileSize && !lp.exhausted {
+		// Отмену проверяем на границе пачек; отдаём то, что успели насчитать
+		if err := ctx.Err(); err != nil {
+			flush(true, err)
+			return
+		}
+
 		bytesToRead := min(int64(chunkSize), fileSize-bytesRead)
 
 		// Read a chunk
 		n, err := file.Read(buf[:bytesToRead])
 		if err != nil && err != io.EOF {
-			fmt.Fprintf(os.Stderr, "error reading file: %v\n", err)
-			os.Exit(1)
+			sendResult(resultsChan, quit, partResult{err: fmt.Errorf("error reading file: %w", err)})
+			return
 		}
 
 		if n == 0 {
@@ -220,13 +1106,33 @@ func processPart(filePath string, fileOffset, fileSize int64, resultsChan chan m
 		}
 
 		bytesRead += int64(n)
+		opts.progress.add(int64(n))
 
 		chunk := buf[:n]
+		if skipping {
+			end := bytes.IndexByte(chunk, '\n')
+			if end < 0 {
+				lp.offset += int64(n)
+				continue
+			}
+			lp.offset += int64(end + 1)
+			chunk, skipping = chunk[end+1:], false
+		}
 
 		lastNewline := bytes.LastIndexByte(chunk, '\n')
 
 		var processingChunk []byte
 		if lastNewline >= 0 {
+			// Начало строки пришло прошлыми пачками, и вместе с концом она длиннее предела
+			if end := bytes.IndexByte(chunk, '\n'); len(remainder) > 0 && lp.maxLine > 0 && len(remainder)+end > lp.maxLine {
+				if err := lp.oversizeLine(lp.offset, remainder); err != nil {
+					sendResult(resultsChan, quit, partResult{err: err})
+					return
+				}
+				lp.offset += int64(len(remainder) + end + 1)
+				chunk, lastNewline = chunk[end+1:], lastNewline-end-1
+				remainder = remainder[:0]
+			}
 			if len(remainder) > 0 {
 				processingChunk = make([]byte, len(remainder)+lastNewline+1)
 				copy(processingChunk, remainder)
@@ -236,75 +1142,290 @@ func processPart(filePath string, fileOffset, fileSize int64, resultsChan chan m
 				processingChunk = chunk[:lastNewline+1]
 			}
 
-			if lastNewline < n-1 {
+			if lastNewline < len(chunk)-1 {
 				remainder = append(remainder[:0], chunk[lastNewline+1:]...)
 			}
 		} else {
 			// Если не нашли символа новой строки, то это очень странно, но просто добавляем к остатку
 			remainder = append(remainder, chunk...)
+			if err := dropRemainder(); err != nil {
+				sendResult(resultsChan, quit, partResult{err: err})
+				return
+			}
 			continue
 		}
 
-		processLines(processingChunk, endpointStats)
+		if err := lp.processLines(processingChunk); err != nil {
+			sendResult(resultsChan, quit, partResult{err: err})
+			return
+		}
+		if err := dropRemainder(); err != nil {
+			sendResult(resultsChan, quit, partResult{err: err})
+			return
+		}
+		if !flush(false, nil) {
+			return
+		}
+
+		if logger.enabled(levelInfo) {
+			logger.infof("part %d/%d: %s processed, %s lines", index+1, numParts, humanBytes(bytesRead), humanCount(lp.counters.lines))
+		}
 	}
 
-	if len(remainder) > 0 {
-		processLines(remainder, endpointStats)
+	if len(remainder) > 0 && !lp.exhausted {
+		// Остаток - последняя строка файла без перевода строки; дописываем его, чтобы
+		// processLines её разобрал
+		if err := lp.processLines(terminateLine(remainder)); err != nil {
+			sendResult(resultsChan, quit, partResult{err: err})
+			return
+		}
+	}
+
+	if lp.counters.malformed > 0 {
+		logger.infof("part %d/%d: skipped %d malformed lines", index+1, numParts, lp.counters.malformed)
 	}
 
-	resultsChan <- endpointStats
+	flush(true, nil)
+}
+
+// sendResult отдаёт результат сборщику; false - сборщик уже не ждёт эту часть
+func sendResult(resultsChan chan<- partResult, quit <-chan struct{}, r partResult) bool {
+	select {
+	case resultsChan <- r:
+		return true
+	case <-quit:
+		return false
+	}
 }
 
-func processLines(data []byte, stats map[string]*Stats) error {
-	spaceCount := 0
+// lineProcessor - состояние разбора строк одной части файла
+type lineProcessor struct {
+	part int
+	// offset - смещение в файле начала данных, которые будут переданы в следующий processLines
+	offset   int64
+	stats    map[string]*Stats
+	counters lineCounters
+	// strict останавливает разбор на первой битой строке
+	strict bool
+	// maxLine - предел длины строки; 0 - без предела
+	maxLine int
+	// check, если задан, получает строки вместо stats: режим -check только собирает диагностику
+	check *checkReport
+	// budget - общий для воркеров остаток строк -head-lines; exhausted - остаток кончился,
+	// и часть больше не читается
+	budget    *atomic.Int64
+	exhausted bool
+	// extras, если задан, - накопители, которые получает каждый новый Stats
+	extras *extraSpec
+	// keyKind и keyBuf - ключ агрегации по -group-by; в keyBuf собираются ключи, которых
+	// нет в строке одним куском, чтобы не аллоцировать на каждую строку
+	keyKind keyKind
+	keyBuf  []byte
+	// format - как разбирать строки
+	format lineFormat
+	// header - сколько первых непустых строк ещё впереди и будет пропущено как заголовок
+	header int
+	// comment - начало строк-комментариев; nil - без них
+	comment []byte
+	// strip и normalize меняют path перед ключом, pathBuf - буфер для нового path;
+	// rawKeys, если задан, собирает ключи до этого, чтобы -stats показал, сколько
+	// эндпоинтов было бы без обрезки и нормализации
+	strip     pathStrip
+	normalize *pathNormalizer
+	pathBuf   []byte
+	rawSpec   *hllSpec
+	rawKeys   *hll
+	// aliases и aliasBuf - -alias-map и буфер для алиасов с остатком path
+	aliases  *aliasMap
+	aliasBuf []byte
+	// times, если задан, - раскладка timestamp для span, промежутка всех строк части;
+	// lastTS - последний разобранный timestamp
+	times  *timeLayout
+	span   seenRange
+	lastTS []byte
+}
 
-	var pathStart, pathEnd, timeStart int
+func (p *lineProcessor) processLines(data []byte) error {
+	defer func() { p.offset += int64(len(data)) }()
 
-	for i := 32; i < len(data); i++ {
-		if data[i] == ' ' {
-			spaceCount++
-			switch spaceCount {
-			// Встретили начало PATH
-			case 2:
-				pathStart = i + 1
-			// Встретили конец PATH
-			case 3:
-				pathEnd = i
-				i += 5
-				timeStart = i
+	lineStart := 0
+	// BOM UTF-8 в начале файла - не часть первой строки
+	if p.offset == 0 && bytes.HasPrefix(data, utf8BOM) {
+		lineStart = len(utf8BOM)
+	}
+	for lineStart < len(data) {
+		n := bytes.IndexByte(data[lineStart:], '\n')
+		if n < 0 {
+			// Неполная строка без перевода строки не разбирается: это обрывок пачки.
+			// Последней строке файла перевод строки дописывают вызывающие (terminateLine)
+			break
+		}
+		line := data[lineStart : lineStart+n]
+		offset := p.offset + int64(lineStart)
+		lineStart += n + 1
+		// Пустые строки и строки из одних пробелов не запросы и не битые строки
+		if blankLine(line) {
+			continue
+		}
+		// Комментарии W3C не запросы; #Fields меняет раскладку следующих строк
+		if p.format.w3c && line[0] == '#' {
+			if bytes.HasPrefix(line, w3cFieldsPrefix) {
+				p.format.fieldsW3C = parseW3CDirective(bytes.TrimSuffix(line, []byte("\r")))
 			}
+			continue
+		}
+		// Комментарий может стоять и перед заголовком: строкой заголовка он не считается
+		if p.comment != nil && bytes.HasPrefix(line, p.comment) {
+			p.counters.comments++
+			continue
+		}
+		if p.header > 0 {
+			p.header--
+			p.counters.headers++
+			continue
+		}
+		if p.maxLine > 0 && len(line) > p.maxLine {
+			if err := p.oversizeLine(offset, line); err != nil {
+				return err
+			}
+			continue
+		}
+		// -head-lines тратят только строки, которые дошли до разбора: пустые строки,
+		// заголовок и комментарии его не уменьшают. Отрицательный остаток видят все воркеры
+		if p.budget != nil && p.budget.Add(-1) < 0 {
+			p.exhausted = true
+			break
 		}
 
-		// Если встречаем перевод строки, то сбрасываем счетчик пробелов
-		if data[i] == '\n' {
-			endpointStr := unsafe.String(&data[pathStart], pathEnd-pathStart)
-
-			responseTime, err := strconv.Atoi(unsafe.String(&data[timeStart], i-timeStart))
-			if err != nil {
-				fmt.Println("Error parsing response time:", err)
-				continue
+		rec, err := p.format.parse(line)
+		if err == nil {
+			err = p.format.limit(&rec)
+		}
+		switch {
+		case err != nil && (p.strict || p.check != nil):
+			lineErr := &malformedLineError{
+				part:   p.part,
+				offset: offset,
+				line:   bytes.Clone(line[:min(len(line), maxErrorLineLength+1)]),
+				err:    err,
 			}
+			if p.check == nil {
+				return lineErr
+			}
+			p.counters.malformed++
+			p.check.fail(lineErr, line)
+		case err != nil:
+			logger.infof("error parsing line: %v", err)
+			p.counters.malformed++
+		case p.check != nil:
+			p.counters.lines++
+			p.check.record(offset, line, rec)
+		default:
+			p.counters.lines++
 
-			s := stats[endpointStr]
+			if p.strip.enabled() || p.normalize != nil || p.aliases != nil {
+				if p.rawKeys != nil {
+					p.rawKeys.add(p.rawSpec, p.key(line, &rec))
+				}
+				p.strip.apply(line, &rec)
+				if p.normalize != nil {
+					p.pathBuf = p.normalize.apply(line, &rec, p.pathBuf)
+				}
+				if p.aliases != nil {
+					var aliased bool
+					if p.aliasBuf, aliased = p.aliases.apply(line, &rec, p.aliasBuf); aliased {
+						p.counters.aliased++
+					}
+				}
+			}
+			key := p.key(line, &rec)
+			endpointStr := unsafe.String(unsafe.SliceData(key), len(key))
+			s := p.stats[endpointStr]
 			if s == nil {
-				stats[endpointStr] = &Stats{
-					Min:   int64(responseTime),
-					Max:   int64(responseTime),
-					Sum:   int64(responseTime),
+				// endpointStr ссылается на буфер чтения, который перезапишется следующей пачкой,
+				// поэтому в карту кладём копию
+				s = &Stats{
+					Min:   rec.value,
+					Max:   rec.value,
+					Sum:   rec.value,
 					Count: 1,
 				}
+				p.stats[strings.Clone(endpointStr)] = s
+				if p.extras != nil {
+					s.extra = p.extras.newExtra()
+				}
 			} else {
-				s.Min = min(s.Min, int64(responseTime))
-				s.Max = max(s.Max, int64(responseTime))
-				s.Sum += int64(responseTime)
+				s.Min = min(s.Min, rec.value)
+				s.Max = max(s.Max, rec.value)
+				s.Sum += rec.value
 				s.Count++
 			}
+			if s.extra != nil {
+				s.extra.add(p.extras, line, &rec)
+			}
+			if p.times != nil {
+				p.addSpan(line[rec.timestamp.start:rec.timestamp.end])
+			}
+		}
+	}
+
+	return nil
+}
+
+// addSpan учитывает timestamp ts в промежутке части. Подряд идущие строки часто несут
+// одну и ту же секунду, поэтому повтор предыдущего timestamp не разбирается заново
+func (p *lineProcessor) addSpan(ts []byte) {
+	if bytes.Equal(ts, p.lastTS) {
+		return
+	}
+	p.lastTS = append(p.lastTS[:0], ts...)
+	if ms, ok := p.times.parse(ts); ok {
+		p.span.add(ms)
+	}
+}
+
+// utf8BOM - метка порядка байт, которую пишут некоторые редакторы и выгрузки с Windows
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
 
-			spaceCount = 0
-			// Смещаемся, исключая timestamp и IP
-			i += 32
+// blankLine - в строке только пробелы, табуляции и '\r'
+func blankLine(line []byte) bool {
+	for _, c := range line {
+		if c != ' ' && c != '\t' && c != '\r' {
+			return false
 		}
 	}
+	return true
+}
+
+// terminateLine дописывает перевод строки в конец data, если его там нет: так
+// processLines разбирает последнюю строку файла, которой перевод строки не достался
+func terminateLine(data []byte) []byte {
+	if len(data) == 0 || data[len(data)-1] == '\n' {
+		return data
+	}
+	return append(data, '\n')
+}
 
+// oversizeLine учитывает строку длиннее -max-line-length как битую. head - строка или,
+// если processPart не стал её дочитывать, её начало; offset - где она начинается в файле
+func (p *lineProcessor) oversizeLine(offset int64, head []byte) error {
+	err := fmt.Errorf("line is longer than -max-line-length %s", byteSize(p.maxLine))
+	p.counters.oversize++
+	if p.strict || p.check != nil {
+		lineErr := &malformedLineError{
+			part:   p.part,
+			offset: offset,
+			line:   bytes.Clone(head[:min(len(head), maxErrorLineLength+1)]),
+			err:    err,
+		}
+		if p.check == nil {
+			return lineErr
+		}
+		p.counters.malformed++
+		p.check.fail(lineErr, head)
+		return nil
+	}
+	logger.infof("error parsing line at byte offset %d: %v", offset, err)
+	p.counters.malformed++
 	return nil
 }
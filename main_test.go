@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTempFile кладёт content в файл name во временном каталоге теста
+func writeTempFile(t testing.TB, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// runAnalyzeFile запускает analyze с args и -o во временный файл; возвращает результат и код выхода
+func runAnalyzeFile(t testing.TB, args ...string) (string, int) {
+	t.Helper()
+	out := filepath.Join(t.TempDir(), "out")
+	code := runCommand(append([]string{"analyze", "-q", "-o", out}, args...))
+	data, err := os.ReadFile(out)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(data), code
+}
+
+func pipelineTotals(t testing.TB, path string, workers, chunkSize int) map[string]*Stats {
+	t.Helper()
+	res, err := runPipeline(context.Background(), path, workers, &processOptions{chunkSize: chunkSize}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.totals
+}
+
+func TestSmallChunkLinesSpanChunks(t *testing.T) {
+	// Строки длиннее минимальной пачки: каждая переносится через remainder
+	var sb strings.Builder
+	for i := range 40 {
+		path := "/long/" + strings.Repeat(string(rune('a'+i%26)), 100*1024+i)
+		fmt.Fprintf(&sb, "2024-01-01T00:00:00Z 10.0.0.1 GET %s 200 %d\n", path, i+1)
+		fmt.Fprintf(&sb, "2024-01-01T00:00:00Z 10.0.0.1 GET /short 200 %d\n", i+1)
+	}
+	path := writeTempFile(t, "long.log", sb.String())
+
+	want := pipelineTotals(t, path, 1, defaultChunkSize)
+	if len(want) != 41 || want["/short"].Count != 40 {
+		t.Fatalf("reference run: %d endpoints", len(want))
+	}
+	for _, workers := range []int{1, 3} {
+		got := pipelineTotals(t, path, workers, minChunkSize)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("workers %d, chunk %s: stats differ from the default chunk size", workers, byteSize(minChunkSize))
+		}
+	}
+}
+
+// BenchmarkChunkSize показывает цену маленькой пачки: меньше памяти на воркера, больше
+// вызовов Read и переносов через remainder. B/op - в основном буферы воркеров
+func BenchmarkChunkSize(b *testing.B) {
+	var sb strings.Builder
+	for i := range 200000 {
+		fmt.Fprintf(&sb, "2024-01-01T00:00:00Z 192.168.1.%d GET /api/items/%d 200 %d\n", i%250, i%500, i%2000)
+	}
+	path := writeTempFile(b, "bench.log", sb.String())
+	for _, size := range []byteSize{64 << 10, 1 << 20, 4 << 20, defaultChunkSize} {
+		b.Run(size.String(), func(b *testing.B) {
+			b.SetBytes(int64(sb.Len()))
+			b.ReportAllocs()
+			for range b.N {
+				pipelineTotals(b, path, 4, int(size))
+			}
+		})
+	}
+}
+
+func TestHeadLinesCountsParsedLines(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("timestamp ip method path status time\n")
+	for i := range 2000 {
+		fmt.Fprintf(&sb, "2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 %d\n\n# comment\n", i)
+	}
+	path := writeTempFile(t, "head.log", sb.String())
+	for _, workers := range []string{"1", "4"} {
+		out, code := runAnalyzeFile(t, "-workers", workers, "-head-lines", "7", "-skip-header", "-comment-prefix", "#", path)
+		if code != exitOK {
+			t.Fatalf("exit %d", code)
+		}
+		if !strings.Contains(out, `"total_requests": 7,`) || !strings.Contains(out, `"malformed_lines": 0`) {
+			t.Errorf("-workers %s: want 7 requests and no malformed lines:\n%s", workers, out)
+		}
+	}
+	// С одним воркером это ровно первые строки файла
+	out, _ := runAnalyzeFile(t, "-workers", "1", "-head-lines", "3", "-skip-header", "-comment-prefix", "#", path)
+	if !strings.Contains(out, `"max_response_time": 2`) {
+		t.Errorf("-workers 1 -head-lines 3: want max 2:\n%s", out)
+	}
+}
+
+// failingRenderer пишет часть отчёта и падает, как оборванный посреди записи рендер
+type failingRenderer struct{}
+
+func (failingRenderer) render(w io.Writer, rep *report) error {
+	// Больше буфера bufio: данные успевают попасть во временный файл
+	if _, err := w.Write(bytes.Repeat([]byte("x"), 256<<10)); err != nil {
+		return err
+	}
+	return errors.New("render failed")
+}
+
+type stringRenderer string
+
+func (s stringRenderer) render(w io.Writer, rep *report) error {
+	_, err := io.WriteString(w, string(s))
+	return err
+}
+
+func TestWriteReportIsAtomic(t *testing.T) {
+	for _, level := range []int{0, 6} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "report.json")
+		if err := os.WriteFile(path, []byte("old report\n"), 0o640); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeReport(path, level, failingRenderer{}, &report{}); err == nil {
+			t.Fatalf("gzip %d: writeReport succeeded with a failing renderer", level)
+		}
+		if data, _ := os.ReadFile(path); string(data) != "old report\n" {
+			t.Errorf("gzip %d: target changed to %d bytes", level, len(data))
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Errorf("gzip %d: temporary file left behind: %v", level, entries)
+		}
+	}
+
+	// Успешная запись заменяет файл целиком и сохраняет его права
+	dir := t.TempDir()
+	path := filepath.Join(dir, "report.json")
+	if err := os.WriteFile(path, []byte("old report\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeReport(path, 0, stringRenderer("new report\n"), &report{}); err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new report\n" {
+		t.Errorf("report not replaced:\n%s", data)
+	}
+	if st.Mode().Perm() != 0o640 {
+		t.Errorf("mode = %v, want 0640", st.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary file left behind: %v", entries)
+	}
+}
+
+func TestSplitFileLineBoundaries(t *testing.T) {
+	for _, lineLen := range []int{1 << 10, 1 << 20} {
+		var sb strings.Builder
+		for i := 0; sb.Len() < 8<<20; i++ {
+			head := fmt.Sprintf("2024-01-01T00:00:00Z 10.0.0.1 GET /%d/", i)
+			tail := fmt.Sprintf(" 200 %d\n", i%1000)
+			sb.WriteString(head + strings.Repeat("x", lineLen-len(head)-len(tail)) + tail)
+		}
+		data := sb.String()
+		path := writeTempFile(t, "lines.log", data)
+		for _, numParts := range []int{1, 2, 3, 7, 16, 64} {
+			for _, limit := range []int64{0, int64(len(data)) / 3} {
+				parts, err := splitFile(context.Background(), path, numParts, limit, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Части идут подряд без пропусков и наложений и начинаются с начала строки
+				end := int64(len(data))
+				if limit > 0 {
+					end = int64(strings.LastIndexByte(data[:limit], '\n') + 1)
+				}
+				offset := int64(0)
+				for i, p := range parts {
+					if p.offset != offset || p.size <= 0 {
+						t.Fatalf("lines of %d, %d parts, limit %d: part %d is [%d, +%d), want it to start at %d",
+							lineLen, numParts, limit, i, p.offset, p.size, offset)
+					}
+					if p.offset > 0 && data[p.offset-1] != '\n' {
+						t.Errorf("lines of %d, %d parts: part %d starts mid-line at %d", lineLen, numParts, i, p.offset)
+					}
+					offset += p.size
+				}
+				if offset != end || len(parts) > numParts {
+					t.Errorf("lines of %d, %d parts, limit %d: %d parts end at %d, want at most %d ending at %d",
+						lineLen, numParts, limit, len(parts), offset, numParts, end)
+				}
+			}
+		}
+	}
+}
+
+// lineEndingLog - лог из n строк с переводом строки nl после каждой
+func lineEndingLog(n int, nl string) string {
+	var sb strings.Builder
+	for i := range n {
+		fmt.Fprintf(&sb, "2024-01-01T00:%02d:%02dZ 10.0.0.%d GET /api/items/%d 200 %d%s", i/60%60, i%60, i%250, i%7, i%997+1, nl)
+	}
+	return sb.String()
+}
+
+func TestLineEndingVariants(t *testing.T) {
+	lf := lineEndingLog(3000, "\n")
+	variants := map[string]string{
+		"no trailing newline":    strings.TrimSuffix(lf, "\n"),
+		"CRLF":                   lineEndingLog(3000, "\r\n"),
+		"CRLF, no trailing":      strings.TrimSuffix(lineEndingLog(3000, "\r\n"), "\r\n"),
+		"CR before EOF":          strings.TrimSuffix(lineEndingLog(3000, "\r\n"), "\n"),
+		"mixed LF and CRLF":      strings.ReplaceAll(lf, "7\n", "7\r\n"),
+		"BOM":                    "\uFEFF" + lf,
+		"BOM and blank lines":    "\uFEFF\n\r\n  \n\t\n" + lf,
+		"blank lines inside":     strings.ReplaceAll(lf, "3\n", "3\n\n \r\n"),
+		"BOM, CRLF, no trailing": "\uFEFF" + strings.TrimSuffix(lineEndingLog(3000, "\r\n"), "\r\n"),
+	}
+	for _, args := range [][]string{
+		{"-workers", "1"},
+		{"-workers", "4"},
+		{"-workers", "4", "-schema-version", "2"},
+		{"-workers", "4", "-format", "csv"},
+	} {
+		want, code := runAnalyzeFile(t, append(args, writeTempFile(t, "lf.log", lf))...)
+		if code != exitOK || !strings.Contains(want, "/api/items/6") {
+			t.Fatalf("%v, LF: exit %d\n%s", args, code, want)
+		}
+		for name, content := range variants {
+			got, code := runAnalyzeFile(t, append(args, writeTempFile(t, "variant.log", content))...)
+			if code != exitOK || got != want {
+				t.Errorf("%v, %s: exit %d, output differs from LF:\n%s\nwant:\n%s", args, name, code, got, want)
+			}
+		}
+	}
+}
+
+func TestOversizeLineSkipped(t *testing.T) {
+	var sb strings.Builder
+	for i := range 1000 {
+		fmt.Fprintf(&sb, "2024-01-01T00:00:00Z 10.0.0.1 GET /before 200 %d\n", i+1)
+	}
+	// Строка-мусор на 10MB без переводов строки, как стек, выведенный одной строкой
+	sb.WriteString("2024-01-01T00:00:00Z 10.0.0.1 GET /junk 200 1 ")
+	sb.WriteString(strings.Repeat("at com.example.Foo.bar(Foo.java:42) ", 10<<20/36))
+	sb.WriteString("\n")
+	for i := range 1000 {
+		fmt.Fprintf(&sb, "2024-01-01T00:00:00Z 10.0.0.1 GET /after 200 %d\n", i+1)
+	}
+	path := writeTempFile(t, "junk.log", sb.String())
+
+	for _, workers := range []int{1, 3} {
+		for _, chunk := range []int{minChunkSize, 1 << 20, defaultChunkSize} {
+			popts := &processOptions{chunkSize: chunk, maxLine: int(defaultMaxLineLength)}
+			res, err := runPipeline(context.Background(), path, workers, popts, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before, after := res.totals["/before"], res.totals["/after"]
+			if before == nil || after == nil || before.Count != 1000 || after.Count != 1000 || len(res.totals) != 2 {
+				t.Errorf("workers %d, chunk %s: endpoints %v, want 1000 /before and 1000 /after", workers, byteSize(chunk), res.totals)
+				continue
+			}
+			if res.counters.oversize != 1 || res.counters.malformed != 1 {
+				t.Errorf("workers %d, chunk %s: oversize %d, malformed %d, want 1 and 1",
+					workers, byteSize(chunk), res.counters.oversize, res.counters.malformed)
+			}
+		}
+	}
+
+	out, code := runAnalyzeFile(t, "-workers", "3", path)
+	if code != exitOK || !strings.Contains(out, `"total_requests": 2000,`) || !strings.Contains(out, `"malformed_lines": 1`) {
+		t.Errorf("exit %d, want 2000 requests and one malformed line:\n%s", code, out)
+	}
+}
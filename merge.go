@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+const mergeUsageHeader = `Usage: iw_challenge merge [flags] FILE...
+
+Combines result files produced by analyze (JSON format) into one report by
+re-aggregating min/max/sum/count per endpoint. Every endpoint in the inputs
+must carry its request count. Schema v2 files (-schema-version 2) also carry
+the sum, so the merge is exact; for v1 the sum is reconstructed as avg*count.
+For v2 files, each endpoint's first_seen/last_seen are merged as min/max,
+and rps is recomputed over the merged time span. The same goes for the
+summary's first_timestamp/last_timestamp of inputs made with -summary-span.
+All inputs must have the same schema version. Flags may follow the files.
+
+Flags:
+`
+
+// resultFile - JSON-отчёт analyze в том виде, в каком его читают merge и diff
+type resultFile struct {
+	// SchemaVersion отсутствует в файлах первой версии
+	SchemaVersion int                       `json:"schema_version"`
+	Partial       bool                      `json:"partial"`
+	Endpoints     map[string]resultEndpoint `json:"endpoints"`
+	Summary       *resultSummary            `json:"summary"`
+	// Combined - сводная часть отчёта -per-file
+	Combined *struct {
+		Endpoints map[string]resultEndpoint `json:"endpoints"`
+		Summary   *resultSummary            `json:"summary"`
+	} `json:"combined"`
+}
+
+// resultSummary - промежуток лога из summary; в отчётах старых версий его нет
+type resultSummary struct {
+	FirstTimestamp json.RawMessage `json:"first_timestamp"`
+	LastTimestamp  json.RawMessage `json:"last_timestamp"`
+}
+
+type resultEndpoint struct {
+	Min   *int64   `json:"min_response_time"`
+	Avg   *float64 `json:"avg_response_time"`
+	Max   *int64   `json:"max_response_time"`
+	Count *int64   `json:"count"`
+	// Sum есть начиная со второй версии схемы
+	Sum *int64 `json:"sum_response_time"`
+	// FirstSeen и LastSeen тоже из схемы 2. RawMessage отличает отсутствующее поле
+	// (nil) от null, который пишется, если у эндпоинта не разобрался ни один timestamp
+	FirstSeen json.RawMessage `json:"first_seen"`
+	LastSeen  json.RawMessage `json:"last_seen"`
+}
+
+func mergeMain(args []string) int {
+	// Пишем count, чтобы результат merge можно было снова передать в merge
+	opts := &options{unit: defaultUnit, includeCount: true, schemaVersion: defaultSchemaVersion, sharePrecision: defaultPrecision}
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), mergeUsageHeader)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.outPath, "o", "-", "write result to `file` instead of stdout (\"-\" means stdout)")
+	fs.StringVar(&opts.format, "format", "json", "output format: "+formatNames())
+	fs.IntVar(&opts.precision, "precision", defaultPrecision, "decimal places for avg_response_time (0-6)")
+	fs.BoolVar(&opts.compact, "compact", false, "with -format json, write the whole document on one line")
+
+	paths, err := parseInterspersed(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if len(paths) == 0 {
+		fmt.Fprintln(fs.Output(), "missing FILE arguments")
+		fs.Usage()
+		return exitUsage
+	}
+	if opts.precision < 0 || opts.precision > maxPrecision {
+		fmt.Fprintf(fs.Output(), "invalid -precision %d: must be between 0 and %d\n", opts.precision, maxPrecision)
+		fs.Usage()
+		return exitUsage
+	}
+	newRenderer, ok := renderers[opts.format]
+	if !ok {
+		fmt.Fprintf(fs.Output(), "unknown -format %q: expected one of %s\n", opts.format, formatNames())
+		fs.Usage()
+		return exitUsage
+	}
+
+	totals := make(map[string]*Stats)
+	partial := false
+	// timesLost - у какого-то входа схемы 2 нет first_seen и last_seen, и rps по
+	// общему промежутку не пересчитать
+	timesLost := false
+	// span - общий промежуток входов с -summary-span; spanFiles - сколько их
+	var span seenRange
+	spanFiles := 0
+	for i, path := range paths {
+		rf, err := loadResultFile(path)
+		if err != nil {
+			logger.errorf("%v", err)
+			return exitError
+		}
+		// Версию результата берём у первого файла, остальные обязаны совпадать
+		if i == 0 {
+			opts.schemaVersion = rf.schemaVersion()
+		} else if v := rf.schemaVersion(); v != opts.schemaVersion {
+			logger.errorf("%s has schema_version %d, but %s has %d: re-run analyze with the same -schema-version",
+				path, v, paths[0], opts.schemaVersion)
+			return exitError
+		}
+		stats, err := rf.stats(path)
+		if err != nil {
+			logger.errorf("%v", err)
+			return exitError
+		}
+		if opts.schemaVersion >= 2 && !timesLost && !hasSeen(stats) {
+			logger.warnf("%s has no first_seen/last_seen, so rps can't be recomputed over the merged time span: leaving out rps, first_seen and last_seen", path)
+			timesLost = true
+		}
+		fileSpan, err := rf.span()
+		if err != nil {
+			logger.errorf("%s: %v", path, err)
+			return exitError
+		}
+		if fileSpan != nil {
+			span.merge(fileSpan)
+			spanFiles++
+		}
+		mergeStats(totals, stats)
+		partial = partial || rf.Partial
+	}
+	if timesLost {
+		for _, s := range totals {
+			if s.extra != nil {
+				s.extra.seen = nil
+			}
+		}
+	}
+
+	out, err := createOutput(opts.outPath, 0)
+	if err != nil {
+		logger.errorf("error creating output file: %v", err)
+		return exitError
+	}
+	defer out.abort()
+	w := bufio.NewWriter(out)
+	rep := newReport(totals, sortOrder{key: "name"})
+	rep.partial = partial
+	// Промежуток summary переносится, только если он есть у всех входов
+	switch spanFiles {
+	case len(paths):
+		opts.summarySpan = true
+		rep.span = span
+	case 0:
+	default:
+		logger.warnf("%d of %d inputs have no first_timestamp/last_timestamp, so the merged time span is unknown: leaving them out", len(paths)-spanFiles, len(paths))
+	}
+	rep.highlights = findHighlights(totals)
+	if err := newRenderer(opts).render(w, rep); err != nil {
+		logger.errorf("error writing output: %v", err)
+		return exitError
+	}
+	if err := closeOutput(w, out); err != nil {
+		logger.errorf("error writing output: %v", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// loadResultFile читает JSON-отчёт analyze
+func loadResultFile(path string) (*resultFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rf resultFile
+	if err := json.Unmarshal(data, &rf); err != nil {
+		return nil, fmt.Errorf("%s: invalid result file: %w", path, err)
+	}
+	if rf.Partial {
+		logger.warnf("%s: result is partial", path)
+	}
+	return &rf, nil
+}
+
+func (rf *resultFile) schemaVersion() int {
+	if rf.SchemaVersion == 0 {
+		return 1
+	}
+	return rf.SchemaVersion
+}
+
+// stats восстанавливает Stats по эндпоинтам; для отчёта -per-file берётся сводная часть
+func (rf *resultFile) stats(path string) (map[string]*Stats, error) {
+	endpoints := rf.Endpoints
+	if endpoints == nil && rf.Combined != nil {
+		endpoints = rf.Combined.Endpoints
+	}
+	stats := make(map[string]*Stats, len(endpoints))
+	for endpoint, e := range endpoints {
+		if e.Min == nil || e.Avg == nil || e.Max == nil {
+			return nil, fmt.Errorf("%s: endpoint %q lacks min/avg/max", path, endpoint)
+		}
+		if e.Count == nil {
+			return nil, fmt.Errorf("%s: endpoint %q has no count, the file can't be re-aggregated", path, endpoint)
+		}
+		s := &Stats{Min: *e.Min, Max: *e.Max, Count: *e.Count}
+		if e.Sum != nil {
+			s.Sum = *e.Sum
+		} else {
+			// avg округлён до -precision, так что сумма восстанавливается приближённо
+			s.Sum = int64(math.Round(*e.Avg * float64(*e.Count)))
+		}
+		// Промежуток объединяется как min first_seen и max last_seen, а rps и
+		// duration_seconds рендер пересчитывает по нему
+		seen, err := e.seenRange()
+		if err != nil {
+			return nil, fmt.Errorf("%s: endpoint %q: %w", path, endpoint, err)
+		}
+		if seen != nil {
+			s.extra = &statsExtra{seen: seen}
+		}
+		stats[endpoint] = s
+	}
+	return stats, nil
+}
+
+// span - промежуток лога из summary отчёта с -summary-span; nil, если его там нет
+func (rf *resultFile) span() (*seenRange, error) {
+	summary := rf.Summary
+	if summary == nil && rf.Combined != nil {
+		summary = rf.Combined.Summary
+	}
+	if summary == nil {
+		return nil, nil
+	}
+	return parseSeenRange(summary.FirstTimestamp, summary.LastTimestamp, "first_timestamp", "last_timestamp")
+}
+
+// seenRange восстанавливает first_seen и last_seen эндпоинта; nil, если их нет в отчёте
+func (e *resultEndpoint) seenRange() (*seenRange, error) {
+	return parseSeenRange(e.FirstSeen, e.LastSeen, "first_seen", "last_seen")
+}
+
+// parseSeenRange разбирает пару timestamp first и last с именами полей firstName и
+// lastName. nil, если какого-то поля нет, и пустой промежуток, если они null
+func parseSeenRange(first, last json.RawMessage, firstName, lastName string) (*seenRange, error) {
+	if first == nil || last == nil {
+		return nil, nil
+	}
+	var firstStr, lastStr *string
+	if err := json.Unmarshal(first, &firstStr); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", firstName, err)
+	}
+	if err := json.Unmarshal(last, &lastStr); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", lastName, err)
+	}
+	if firstStr == nil || lastStr == nil {
+		return &seenRange{}, nil
+	}
+	firstAt, err := time.Parse(time.RFC3339Nano, *firstStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", firstName, err)
+	}
+	lastAt, err := time.Parse(time.RFC3339Nano, *lastStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", lastName, err)
+	}
+	return &seenRange{firstAt.UnixMilli(), lastAt.UnixMilli(), true}, nil
+}
+
+// hasSeen - у каждого эндпоинта stats есть first_seen и last_seen
+func hasSeen(stats map[string]*Stats) bool {
+	for _, s := range stats {
+		if s.extra == nil || s.extra.seen == nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeTimeSpan(t *testing.T) {
+	shard1 := "2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 10\n2024-01-01T00:00:10Z 10.0.0.1 GET /b 200 20\n"
+	shard2 := "2024-01-01T00:00:05Z 10.0.0.2 GET /a 200 30\n2024-01-01T00:00:20Z 10.0.0.2 GET /a 200 40\n"
+	dir := t.TempDir()
+	var reports []string
+	for i, log := range []string{shard1, shard2} {
+		out, code := runAnalyzeFile(t, "-schema-version", "2", writeTempFile(t, "shard.log", log))
+		if code != exitOK {
+			t.Fatalf("shard %d: exit %d", i, code)
+		}
+		path := filepath.Join(dir, "shard"+string(rune('1'+i))+".json")
+		if err := os.WriteFile(path, []byte(out), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		reports = append(reports, path)
+	}
+	single, _ := runAnalyzeFile(t, "-schema-version", "2", writeTempFile(t, "all.log", shard1+shard2))
+
+	merged := filepath.Join(dir, "merged.json")
+	if code := runCommand(append([]string{"merge", "-o", merged}, reports...)); code != exitOK {
+		t.Fatalf("merge: exit %d", code)
+	}
+	data, err := os.ReadFile(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// first_seen - минимум, last_seen - максимум, rps пересчитан по общим 20 секундам
+	for _, want := range []string{`"first_seen": "2024-01-01T00:00:00Z"`, `"last_seen": "2024-01-01T00:00:20Z"`, `"rps": 0.2,`, `"duration_seconds": 20`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("merged report lacks %s:\n%s", want, data)
+		}
+	}
+	if string(data) != single {
+		t.Errorf("merged report differs from a single run over both shards:\n%s\nwant:\n%s", data, single)
+	}
+
+	// Без first_seen и last_seen у одного из входов поля выпадают из результата целиком
+	stripped := filepath.Join(dir, "stripped.json")
+	writeStripped(t, single, stripped, "first_seen", "last_seen")
+	if code := runCommand([]string{"merge", "-o", merged, reports[0], stripped}); code != exitOK {
+		t.Fatalf("merge without first_seen: exit %d", code)
+	}
+	doc := readMerged(t, merged)
+	doc.Endpoints["summary"] = doc.Summary
+	for name, e := range doc.Endpoints {
+		for _, key := range []string{"rps", "first_seen", "last_seen", "duration_seconds"} {
+			if _, ok := e[key]; ok {
+				t.Errorf("%s: %q kept although one input has no time span", name, key)
+			}
+		}
+	}
+}
+
+func TestMergeSummarySpan(t *testing.T) {
+	shard1 := "2024-01-01T00:00:03Z 10.0.0.1 GET /a 200 10\n"
+	shard2 := "2024-01-01T00:00:00Z 10.0.0.2 GET /b 200 30\n2024-01-01T00:00:08Z 10.0.0.2 GET /a 200 40\n"
+	dir := t.TempDir()
+	report := func(name, log string, args ...string) string {
+		t.Helper()
+		out, code := runAnalyzeFile(t, append(append([]string{"-include-count"}, args...), writeTempFile(t, "shard.log", log))...)
+		if code != exitOK {
+			t.Fatalf("%s: exit %d", name, code)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(out), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	span1 := report("span1.json", shard1, "-summary-span")
+	span2 := report("span2.json", shard2, "-summary-span")
+	plain2 := report("plain2.json", shard2)
+
+	merged := filepath.Join(dir, "merged.json")
+	if code := runCommand([]string{"merge", "-o", merged, span1, span2}); code != exitOK {
+		t.Fatalf("merge: exit %d", code)
+	}
+	doc := readMerged(t, merged)
+	want := map[string]any{
+		"first_timestamp":  "2024-01-01T00:00:00Z",
+		"last_timestamp":   "2024-01-01T00:00:08Z",
+		"duration_seconds": 8.0,
+		"rps":              0.4,
+	}
+	for k, v := range want {
+		if doc.Summary[k] != v {
+			t.Errorf("summary %q = %v, want %v", k, doc.Summary[k], v)
+		}
+	}
+
+	// Если промежутка нет хотя бы у одного входа, его нет и в результате
+	for _, inputs := range [][]string{{span1, plain2}, {report("plain1.json", shard1), plain2}} {
+		if code := runCommand(append([]string{"merge", "-o", merged}, inputs...)); code != exitOK {
+			t.Fatalf("merge %v: exit %d", inputs, code)
+		}
+		doc = readMerged(t, merged)
+		for k := range want {
+			if _, ok := doc.Summary[k]; ok {
+				t.Errorf("merge %v: summary has %q", inputs, k)
+			}
+		}
+	}
+}
+
+// writeStripped пишет в path отчёт report без полей keys в эндпоинтах и summary
+func writeStripped(t *testing.T, report, path string, keys ...string) {
+	t.Helper()
+	var doc map[string]any
+	if err := json.Unmarshal([]byte(report), &doc); err != nil {
+		t.Fatal(err)
+	}
+	objects := []any{doc["summary"]}
+	for _, e := range doc["endpoints"].(map[string]any) {
+		objects = append(objects, e)
+	}
+	for _, o := range objects {
+		for _, k := range keys {
+			delete(o.(map[string]any), k)
+		}
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, body, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type mergedDoc struct {
+	Endpoints map[string]map[string]any `json:"endpoints"`
+	Summary   map[string]any            `json:"summary"`
+}
+
+func readMerged(t *testing.T, path string) mergedDoc {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc mergedDoc
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("%s: %v\n%s", path, err, data)
+	}
+	return doc
+}
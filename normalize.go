@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// -normalize-rules: файл правил вида "ШАБЛОН => ЗАМЕНА", по одному в строке. Шаблон с
+// '/' сравнивается по сегментам, '*' - любой непустой сегмент; шаблон после "~ " -
+// регулярное выражение, а замена может ссылаться на группы через $1. Пустые строки и
+// строки с # - комментарии. Правила пробуются по порядку, срабатывает первое совпавшее
+const (
+	normalizeArrow = "=>"
+	// normalizeID - чем -auto-normalize заменяет числовые сегменты и UUID
+	normalizeID = "{id}"
+)
+
+// normalizeRule - одно правило -normalize-rules
+type normalizeRule struct {
+	// segments - шаблон по сегментам через '/'; nil у регулярного выражения
+	segments []string
+	re       *regexp.Regexp
+	// replacement - новый path у шаблона, шаблон для Expand у регулярного выражения
+	replacement []byte
+}
+
+// pathNormalizer - правила и -auto-normalize. Общий для воркеров и только читается:
+// буфер для нового path у каждого воркера свой
+type pathNormalizer struct {
+	rules []normalizeRule
+	auto  bool
+}
+
+func newPathNormalizer(rules []normalizeRule, auto bool) *pathNormalizer {
+	if len(rules) == 0 && !auto {
+		return nil
+	}
+	return &pathNormalizer{rules: rules, auto: auto}
+}
+
+// loadNormalizeRules читает и компилирует правила при старте, чтобы ошибка в файле
+// была ошибкой флагов, а не битыми строками
+func loadNormalizeRules(path string) ([]normalizeRule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("normalize rules %s: %w", path, err)
+	}
+	defer file.Close()
+	var rules []normalizeRule
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule, err := parseNormalizeRule(text)
+		if err != nil {
+			return nil, fmt.Errorf("normalize rules %s:%d: %w", path, n, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("normalize rules %s: %w", path, err)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("normalize rules %s: no rules", path)
+	}
+	return rules, nil
+}
+
+func parseNormalizeRule(text string) (normalizeRule, error) {
+	pattern, replacement, ok := strings.Cut(text, normalizeArrow)
+	pattern, replacement = strings.TrimSpace(pattern), strings.TrimSpace(replacement)
+	if !ok || pattern == "" || replacement == "" {
+		return normalizeRule{}, fmt.Errorf("expected \"PATTERN %s REPLACEMENT\", got %q", normalizeArrow, text)
+	}
+	rule := normalizeRule{replacement: []byte(replacement)}
+	if expr, ok := strings.CutPrefix(pattern, "~"); ok {
+		re, err := regexp.Compile(strings.TrimSpace(expr))
+		if err != nil {
+			return normalizeRule{}, err
+		}
+		rule.re = re
+		return rule, nil
+	}
+	if !strings.HasPrefix(pattern, "/") {
+		return normalizeRule{}, fmt.Errorf("pattern %q must start with / or be a regular expression after ~", pattern)
+	}
+	rule.segments = strings.Split(pattern, "/")
+	for _, seg := range rule.segments {
+		if seg != "*" && strings.Contains(seg, "*") {
+			return normalizeRule{}, fmt.Errorf("pattern %q: * must be a whole segment", pattern)
+		}
+	}
+	return rule, nil
+}
+
+// matchSegments сравнивает path с шаблоном по сегментам без аллокаций: число сегментов
+// должно совпасть, "*" принимает любой непустой сегмент
+func (r *normalizeRule) matchSegments(path []byte) bool {
+	n := 0
+	for start := 0; ; {
+		end := bytes.IndexByte(path[start:], '/')
+		last := end < 0
+		if last {
+			end = len(path)
+		} else {
+			end += start
+		}
+		if n == len(r.segments) {
+			return false
+		}
+		part := path[start:end]
+		if seg := r.segments[n]; seg == "*" {
+			if len(part) == 0 {
+				return false
+			}
+		} else if string(part) != seg {
+			return false
+		}
+		n++
+		if last {
+			return n == len(r.segments)
+		}
+		start = end + 1
+	}
+}
+
+// apply подставляет новый path в rec.altPath. buf - буфер воркера, его и возвращает:
+// новый path годится только до следующей строки
+func (n *pathNormalizer) apply(line []byte, rec *lineRecord, buf []byte) []byte {
+	if rec.altPath != nil {
+		return buf
+	}
+	path := line[rec.path.start:rec.path.end]
+	for i := range n.rules {
+		r := &n.rules[i]
+		if r.re == nil {
+			if r.matchSegments(path) {
+				rec.altPath = r.replacement
+				return buf
+			}
+			continue
+		}
+		// Регулярное выражение дороже сегментов; совпавший кусок заменяется, остальное
+		// остаётся, так что с ^ и $ заменяется весь path
+		if loc := r.re.FindSubmatchIndex(path); loc != nil {
+			buf = append(buf[:0], path[:loc[0]]...)
+			buf = r.re.Expand(buf, r.replacement, path, loc)
+			buf = append(buf, path[loc[1]:]...)
+			rec.altPath = buf
+			return buf
+		}
+	}
+	if n.auto {
+		var changed bool
+		if buf, changed = autoNormalize(path, buf); changed {
+			rec.altPath = buf
+		}
+	}
+	return buf
+}
+
+// autoNormalize заменяет на {id} сегменты из одних цифр и сегменты вида UUID. buf
+// заполняется с первого заменённого сегмента; changed = false - path остался как есть
+func autoNormalize(path, buf []byte) ([]byte, bool) {
+	changed := false
+	for start := 0; start <= len(path); {
+		end := bytes.IndexByte(path[start:], '/')
+		if end < 0 {
+			end = len(path)
+		} else {
+			end += start
+		}
+		seg := path[start:end]
+		if isIDSegment(seg) {
+			if !changed {
+				buf = append(buf[:0], path[:start]...)
+				changed = true
+			}
+			buf = append(buf, normalizeID...)
+		} else if changed {
+			buf = append(buf, seg...)
+		}
+		if end < len(path) && changed {
+			buf = append(buf, '/')
+		}
+		start = end + 1
+	}
+	return buf, changed
+}
+
+// isIDSegment - сегмент из одних цифр или UUID вида 123e4567-e89b-12d3-a456-426614174000
+func isIDSegment(seg []byte) bool {
+	if len(seg) == 0 {
+		return false
+	}
+	digits := true
+	for _, c := range seg {
+		if c < '0' || c > '9' {
+			digits = false
+			break
+		}
+	}
+	if digits {
+		return true
+	}
+	if len(seg) != 36 {
+		return false
+	}
+	for i, c := range seg {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !isHexDigit(c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
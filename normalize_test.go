@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// mustRules компилирует правила -normalize-rules из строк
+func mustRules(t *testing.T, lines ...string) []normalizeRule {
+	t.Helper()
+	rules := make([]normalizeRule, 0, len(lines))
+	for _, l := range lines {
+		r, err := parseNormalizeRule(l)
+		if err != nil {
+			t.Fatalf("%q: %v", l, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// normalized - path после n; ok = false, если path остался как есть
+func normalized(n *pathNormalizer, path string) (string, bool) {
+	line := []byte(path)
+	rec := lineRecord{path: span{0, len(line)}}
+	n.apply(line, &rec, nil)
+	if rec.altPath == nil {
+		return path, false
+	}
+	return string(rec.altPath), true
+}
+
+func TestNormalizeRulePrecedence(t *testing.T) {
+	n := newPathNormalizer(mustRules(t,
+		"/users/me => /users/self",
+		"/users/* => /users/{id}",
+		"/users/*/orders/* => /users/{id}/orders/{id}",
+		`~ ^/files/(.+)\.(png|jpg)$ => /files/{name}.$2`,
+		"/files/* => /files/{file}",
+		`~ /v[0-9]+/ => /v{n}/`,
+	), true)
+	for path, want := range map[string]string{
+		// Срабатывает первое совпавшее правило: /users/me раньше /users/*
+		"/users/me":  "/users/self",
+		"/users/42":  "/users/{id}",
+		"/users/abc": "/users/{id}",
+		// Число сегментов должно совпасть: /users/* не съедает /users/1/orders/2
+		"/users/1/orders/2": "/users/{id}/orders/{id}",
+		// Регулярное выражение выше по списку сильнее шаблона по сегментам
+		"/files/a.png": "/files/{name}.png",
+		"/files/a.txt": "/files/{file}",
+		// Заменяется только совпавший кусок, остальное остаётся
+		"/api/v2/items/7": "/api/v{n}/items/7",
+		// -auto-normalize - только если не сработало ни одно правило
+		"/orders/123": "/orders/{id}",
+		"/orders/123e4567-e89b-12d3-a456-426614174000": "/orders/{id}",
+		"/a/1/b/2": "/a/{id}/b/{id}",
+	} {
+		if got, _ := normalized(n, path); got != want {
+			t.Errorf("%s -> %s, want %s", path, got, want)
+		}
+	}
+
+	// Порядок правил и есть приоритет
+	n = newPathNormalizer(mustRules(t, "/users/* => /users/{id}", "/users/me => /users/self"), false)
+	if got, _ := normalized(n, "/users/me"); got != "/users/{id}" {
+		t.Errorf("reversed rules: /users/me -> %s, want /users/{id}", got)
+	}
+}
+
+func TestNormalizeNonMatching(t *testing.T) {
+	n := newPathNormalizer(mustRules(t,
+		"/users/* => /users/{id}",
+		"/ => /root",
+		`~ ^/static/ => /assets/`,
+	), false)
+	for _, path := range []string{
+		"/users",
+		// "*" - только непустой сегмент
+		"/users/",
+		"/users//",
+		"/users/1/",
+		"/users/1/orders",
+		"/Users/1",
+		"/userss/1",
+		"users/1",
+		"/api/static/x",
+		"",
+	} {
+		if got, ok := normalized(n, path); ok {
+			t.Errorf("%q rewritten to %q", path, got)
+		}
+	}
+	if got, _ := normalized(n, "/"); got != "/root" {
+		t.Errorf("/ -> %s, want /root", got)
+	}
+
+	// -auto-normalize не трогает сегменты, которые только похожи на id
+	auto := newPathNormalizer(nil, true)
+	for _, path := range []string{"/api/v2", "/items/12a", "/items/-1", "/items/123e4567-e89b-12d3-a456-42661417400", "/items/123e4567_e89b_12d3_a456_426614174000", "/"} {
+		if got, ok := normalized(auto, path); ok {
+			t.Errorf("-auto-normalize: %q rewritten to %q", path, got)
+		}
+	}
+	if newPathNormalizer(nil, false) != nil {
+		t.Error("normalizer without rules and -auto-normalize is not nil")
+	}
+}
+
+func TestParseNormalizeRuleErrors(t *testing.T) {
+	for _, bad := range []string{
+		"/users/*",
+		"/users/* =>",
+		"=> /x",
+		"users/* => /users/{id}",
+		"/users/a* => /users/{id}",
+		"~ ( => /x",
+	} {
+		if _, err := parseNormalizeRule(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+
+	path := writeTempFile(t, "rules.txt", "# comment\n\n/users/* => /users/{id}\n/bad\n")
+	if _, err := loadNormalizeRules(path); err == nil || !strings.Contains(err.Error(), ":4:") {
+		t.Errorf("error should name line 4: %v", err)
+	}
+	path = writeTempFile(t, "rules.txt", "# only comments\n\n")
+	if _, err := loadNormalizeRules(path); err == nil {
+		t.Error("file without rules accepted")
+	}
+}
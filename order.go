@@ -0,0 +1,62 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// endpointStat - эндпоинт вместе с агрегированной статистикой, чтобы компаратор видел значения
+type endpointStat struct {
+	name  string
+	stats *Stats
+}
+
+var sortKeys = []string{"name", "count", "avg", "max", "min"}
+
+// sortOrder - порядок эндпоинтов в выводе (-sort, -desc)
+type sortOrder struct {
+	key  string
+	desc bool
+}
+
+func validSortKey(key string) bool {
+	return slices.Contains(sortKeys, key)
+}
+
+func sortKeyNames() string {
+	return strings.Join(sortKeys, ", ")
+}
+
+// sortEntries упорядочивает эндпоинты по ключу; при равенстве - по имени по возрастанию,
+// чтобы вывод был детерминированным и с -desc
+func sortEntries(entries []endpointStat, order sortOrder) {
+	var byKey func(a, b *Stats) int
+	switch order.key {
+	case "count":
+		byKey = func(a, b *Stats) int { return cmp.Compare(a.Count, b.Count) }
+	case "avg":
+		byKey = func(a, b *Stats) int {
+			return cmp.Compare(float64(a.Sum)/float64(a.Count), float64(b.Sum)/float64(b.Count))
+		}
+	case "max":
+		byKey = func(a, b *Stats) int { return cmp.Compare(a.Max, b.Max) }
+	case "min":
+		byKey = func(a, b *Stats) int { return cmp.Compare(a.Min, b.Min) }
+	}
+
+	slices.SortFunc(entries, func(a, b endpointStat) int {
+		if byKey != nil {
+			c := byKey(a.stats, b.stats)
+			if order.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		} else if order.desc {
+			return strings.Compare(b.name, a.name)
+		}
+		return strings.Compare(a.name, b.name)
+	})
+}
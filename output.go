@@ -0,0 +1,1097 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// report - всё, что нужно рендерерам: упорядоченные эндпоинты и сведения о прогоне
+type report struct {
+	entries []endpointStat
+	totals  map[string]*Stats
+	// partial - результат неполный (сработал -timeout с -allow-partial или это
+	// промежуточная запись -flush-interval); с markPartial метка пишется и когда false
+	partial     bool
+	markPartial bool
+	// malformed - сколько строк пропущено при разборе
+	malformed int64
+	// span - первый и последний timestamp лога для first_timestamp и last_timestamp
+	span seenRange
+	// meta - сведения о прогоне для -include-meta; у merge их нет
+	meta *runMeta
+	// files - отчёты по отдельным входным файлам для -per-file
+	files []fileReport
+	// highlights - сводка лидеров для table, markdown и html; nil с -no-highlights
+	highlights *highlights
+	// quantiles - квантили для -percentiles; nil, если они не запрошены
+	quantiles *quantileSpec
+	// apdex - выводить Apdex (-apdex-t)
+	apdex bool
+	// slow - пороги счётчиков -slow-threshold; nil, если счётчики не запрошены
+	slow slowList
+	// uniqueIPs - выводить оценку unique_ips (-unique-ips)
+	uniqueIPs bool
+	// statusBreakdown - выводить классы статусов и error_rate (-status-breakdown)
+	statusBreakdown bool
+}
+
+type fileReport struct {
+	path string
+	rep  *report
+}
+
+// runMeta - откуда взялся отчёт: входной файл, его размер, число частей и время работы
+type runMeta struct {
+	inputFiles  []string
+	fileSize    int64
+	parts       int
+	duration    time.Duration
+	linesParsed int64
+	// trimmedMean и trimmedMethod - -trimmed-mean и чем считался trimmed_avg; 0 - без него
+	trimmedMean   float64
+	trimmedMethod string
+}
+
+// grandTotal сводит статистику всех эндпоинтов до -top и -min-count; nil, если их нет
+func (r *report) grandTotal() *Stats {
+	var total *Stats
+	for _, s := range r.totals {
+		total = mergeInto(total, s)
+	}
+	return total
+}
+
+// newReport упорядочивает эндпоинты согласно order
+func newReport(totals map[string]*Stats, order sortOrder) *report {
+	entries := make([]endpointStat, 0, len(totals))
+	var empty []string
+	for endpoint, s := range totals {
+		if s.Count <= 0 {
+			empty = append(empty, endpoint)
+			continue
+		}
+		entries = append(entries, endpointStat{endpoint, s})
+	}
+	// Эндпоинт без запросов бывает только в отчёте, который читает merge. avg у него не
+	// определён, поэтому в отчёт он не попадает, и дальше делить на Count можно без проверок
+	if len(empty) > 0 {
+		slices.Sort(empty)
+		logger.warnf("leaving out %d endpoints without requests: %s", len(empty), strings.Join(empty, ", "))
+		kept := make(map[string]*Stats, len(entries))
+		for _, e := range entries {
+			kept[e.name] = e.stats
+		}
+		totals = kept
+	}
+	sortEntries(entries, order)
+	return &report{entries: entries, totals: totals}
+}
+
+// Имена сводных записей для эндпоинтов, отрезанных -top и -min-count
+const (
+	otherEndpoint    = "_other"
+	filteredEndpoint = "_filtered"
+)
+
+// trimOptions - фильтры вывода, применяемые к уже сведённой по всем воркерам статистике
+type trimOptions struct {
+	// minCount отбрасывает эндпоинты с меньшим количеством запросов
+	minCount     int64
+	keepFiltered bool
+	// top оставляет первые top эндпоинтов (0 - без ограничения)
+	top      int
+	topOther bool
+}
+
+// trim применяет -min-count и затем -top. Отброшенное можно сохранить в сводных
+// записях "_other" и "_filtered", чтобы итоги сходились
+func (r *report) trim(t trimOptions) {
+	var filtered *Stats
+	if t.minCount > 1 {
+		kept := r.entries[:0]
+		for _, e := range r.entries {
+			if e.stats.Count >= t.minCount {
+				kept = append(kept, e)
+				continue
+			}
+			filtered = mergeInto(filtered, e.stats)
+		}
+		r.entries = kept
+	}
+
+	if t.top > 0 && t.top < len(r.entries) {
+		rest := r.entries[t.top:]
+		r.entries = r.entries[:t.top:t.top]
+		if t.topOther {
+			var other *Stats
+			for _, e := range rest {
+				other = mergeInto(other, e.stats)
+			}
+			r.entries = append(r.entries, endpointStat{otherEndpoint, other})
+		}
+	}
+
+	if t.keepFiltered && filtered != nil {
+		r.entries = append(r.entries, endpointStat{filteredEndpoint, filtered})
+	}
+}
+
+// mergeInto сливает s в acc, создавая acc при первом вызове
+func mergeInto(acc, s *Stats) *Stats {
+	if acc == nil {
+		return s.clone()
+	}
+	acc.merge(s)
+	return acc
+}
+
+// renderer выводит отчёт в конкретном формате
+type renderer interface {
+	render(w io.Writer, rep *report) error
+}
+
+// humanFormatUnit - единица для table и markdown: с -humanize время печатается
+// как длительность, машинные форматы всегда получают числа в -unit
+func humanFormatUnit(opts *options) timeUnit {
+	if opts.humanize {
+		return humanUnit
+	}
+	return timeUnits[opts.unit]
+}
+
+var renderers = map[string]func(opts *options) renderer{
+	"json": func(opts *options) renderer {
+		return newJSONRenderer(opts)
+	},
+	"yaml": func(opts *options) renderer {
+		return yamlRenderer{newJSONRenderer(opts)}
+	},
+	"csv": func(opts *options) renderer {
+		return csvRenderer{precision: opts.precision, sharePrecision: opts.sharePrecision, unit: timeUnits[opts.unit]}
+	},
+	"table": func(opts *options) renderer {
+		t := tableRenderer{precision: opts.precision, sharePrecision: opts.sharePrecision, unit: humanFormatUnit(opts),
+			sparkWidth: sparklineWidthOf(opts)}
+		if useColor(opts) {
+			t.slowMax = opts.slowThresholds.highest()
+		}
+		return t
+	},
+	"ndjson": func(opts *options) renderer {
+		return ndjsonRenderer{precision: opts.precision, sharePrecision: opts.sharePrecision, unit: timeUnits[opts.unit]}
+	},
+	"markdown": func(opts *options) renderer {
+		return markdownRenderer{precision: opts.precision, sharePrecision: opts.sharePrecision, unit: humanFormatUnit(opts),
+			sparkWidth: sparklineWidthOf(opts)}
+	},
+	"html": func(opts *options) renderer {
+		return htmlRenderer{precision: opts.precision, unit: timeUnits[opts.unit]}
+	},
+	"prom": func(opts *options) renderer {
+		return promRenderer{prefix: opts.metricPrefix, precision: opts.precision, unit: timeUnits[opts.unit], buckets: opts.buckets}
+	},
+	"sql": func(opts *options) renderer {
+		return sqlRenderer{}
+	},
+	"list": func(opts *options) renderer {
+		return listRenderer{}
+	},
+}
+
+func formatNames() string {
+	return "json, yaml, ndjson, csv, table, markdown, html, prom, sql, list"
+}
+
+// Версии схемы JSON: в 1 нет count (если не задан -include-count), в 2 всегда есть count
+// и sum_response_time
+const (
+	defaultSchemaVersion = 1
+	maxSchemaVersion     = 2
+)
+
+// jsonRenderer пишет отчёт как JSON. Структуру задаёт document, она же идёт в -format yaml
+type jsonRenderer struct {
+	// compact пишет весь документ одной строкой
+	compact        bool
+	includeMeta    bool
+	includeCount   bool
+	schemaVersion  int
+	precision      int
+	sharePrecision int
+	unit           timeUnit
+	// stddev добавляет stddev_response_time (только в схеме 2)
+	stddev bool
+	// geo добавляет geo_avg (-geo-mean)
+	geo bool
+	// modeMinShare >= 0 добавляет mode_response_time (-mode); -1 - без него
+	modeMinShare float64
+	// buckets - границы -buckets для объекта "buckets" у эндпоинтов
+	buckets bucketList
+	// times добавляет rps, first_seen и last_seen (только в схеме 2)
+	times bool
+	// summarySpan добавляет в summary first_timestamp и last_timestamp (-summary-span)
+	summarySpan bool
+	// bytes добавляет объект "bytes" по -bytes-field (только в схеме 2)
+	bytes bool
+	// anomalies - пороги -flag-anomalies; nil - без "flags" и "flagged_endpoints".
+	// "flags" получают только эндпоинты, у которых сработало хоть одно правило
+	anomalies *anomalyLimits
+	// distribution добавляет в summary объект "distribution" (-global-distribution)
+	distribution bool
+	// fieldMap переименовывает поля документа (-field-map)
+	fieldMap map[string]string
+}
+
+func newJSONRenderer(opts *options) jsonRenderer {
+	return jsonRenderer{
+		compact:        opts.compact,
+		includeMeta:    opts.includeMeta,
+		includeCount:   opts.includeCount || opts.schemaVersion >= 2,
+		schemaVersion:  opts.schemaVersion,
+		precision:      opts.precision,
+		sharePrecision: opts.sharePrecision,
+		unit:           timeUnits[opts.unit],
+		stddev:         opts.stddev && opts.schemaVersion >= 2,
+		geo:            opts.geoMean,
+		modeMinShare:   modeMinShareOf(opts),
+		buckets:        opts.buckets,
+		times:          wantSeen(opts),
+		summarySpan:    opts.summarySpan,
+		distribution:   opts.globalDistribution,
+		bytes:          opts.bytesField > 0 && opts.schemaVersion >= 2,
+		anomalies:      anomalyLimitsOf(opts),
+		fieldMap:       opts.fieldMap,
+	}
+}
+
+// render в компактном режиме идёт через json.Encoder и MarshalJSON документа. Обычный
+// вид пишет writeJSONObject: Encoder.SetIndent переносит массивы и пустые объекты
+// иначе, чем исторический вывод, а он должен оставаться прежним байт в байт
+func (r jsonRenderer) render(w io.Writer, rep *report) error {
+	doc := r.document(rep)
+	if r.compact {
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		return enc.Encode(doc)
+	}
+	writeJSONObject(w, doc, "")
+	_, err := fmt.Fprint(w, "\n")
+	return err
+}
+
+// docField - поле упорядоченного документа отчёта. value - docObject, docArray, docNumber,
+// string, bool, []string или nil (null). Порядок полей и есть порядок в выводе
+type docField struct {
+	key   string
+	value any
+}
+
+type docObject []docField
+
+// docArray - массив объектов, например интервалы "timeline"
+type docArray []docObject
+
+// docNumber - число, уже отформатированное с нужной точностью и единицей
+type docNumber string
+
+// document собирает отчёт в дерево, общее для JSON и YAML, чтобы новые поля
+// появлялись в обоих форматах сразу
+func (r jsonRenderer) document(rep *report) docObject {
+	var doc docObject
+	// Первую версию не помечаем, чтобы не менять вывод для строгих потребителей
+	if r.schemaVersion > 1 {
+		doc = append(doc, docField{"schema_version", docNumber(strconv.Itoa(r.schemaVersion))})
+	}
+	if rep.partial || rep.markPartial {
+		doc = append(doc, docField{"partial", rep.partial})
+	}
+	if r.includeMeta {
+		var meta docObject
+		if m := rep.meta; m != nil {
+			if len(m.inputFiles) == 1 {
+				meta = append(meta, docField{"input_file", m.inputFiles[0]})
+			} else {
+				meta = append(meta, docField{"input_files", m.inputFiles})
+			}
+			meta = append(meta,
+				docField{"file_size_bytes", docInt(m.fileSize)},
+				docField{"parts", docInt(int64(m.parts))},
+				docField{"duration_ms", docInt(m.duration.Milliseconds())},
+				docField{"lines_parsed", docInt(m.linesParsed)},
+			)
+			if m.trimmedMean > 0 {
+				meta = append(meta, docField{"trimmed_mean", docObject{
+					{"percent", docNumber(strconv.FormatFloat(m.trimmedMean, 'f', -1, 64))},
+					{"method", m.trimmedMethod},
+				}})
+			}
+		}
+		meta = append(meta, docField{"version", getBuildInfo().versionString()})
+		doc = append(doc, docField{"meta", meta})
+	}
+
+	if rep.files == nil {
+		doc = append(doc, r.body(rep)...)
+	} else {
+		// -per-file: {"files": {"a.log": {...}, ...}, "combined": {...}}
+		files := make(docObject, 0, len(rep.files))
+		for _, f := range rep.files {
+			files = append(files, docField{f.path, r.body(f.rep)})
+		}
+		doc = append(doc, docField{"files", files}, docField{"combined", r.body(rep)})
+	}
+	if r.fieldMap != nil {
+		renameFields(doc, r.fieldMap, false)
+	}
+	return doc
+}
+
+// body - "endpoints" и "summary" одного отчёта
+func (r jsonRenderer) body(rep *report) docObject {
+	// summary и share считаются по всем эндпоинтам, а не только по оставшимся после -top и -min-count
+	total := rep.grandTotal()
+	totalRequests := countOf(total)
+	quantileKeys := rep.quantiles.fieldNames()
+	span := timeSpan(total)
+	extras := rep.extraColumns(r.sharePrecision)
+	endpoints := make(docObject, 0, len(rep.entries))
+	flagged := []string{}
+	for _, e := range rep.entries {
+		minV, avg, maxV := r.unit.formatStats(e.stats, r.precision)
+		fields := docObject{
+			{"min_response_time", docNumber(minV)},
+			{"avg_response_time", docNumber(avg)},
+			{"max_response_time", docNumber(maxV)},
+		}
+		for i, v := range rep.quantiles.values(e.stats) {
+			fields = append(fields, docField{quantileKeys[i], docNumber(r.unit.formatMs(v, r.precision))})
+		}
+		if mode, ok := r.modeOf(e.stats); ok {
+			fields = append(fields, docField{"mode_response_time", mode})
+		}
+		if r.includeCount {
+			fields = append(fields, docField{"count", docInt(e.stats.Count)})
+		}
+		// sum нужна merge, чтобы сводить отчёты без потери точности avg
+		if r.schemaVersion >= 2 {
+			fields = append(fields,
+				docField{"sum_response_time", docNumber(r.unit.formatValue(e.stats.Sum, r.precision))},
+				docField{"share", docNumber(formatShare(e.stats.Count, totalRequests, r.sharePrecision))},
+			)
+		}
+		fields = append(fields, r.timeFields(e.stats, span)...)
+		if sd, ok := r.stddevOf(e.stats); ok {
+			fields = append(fields, docField{"stddev_response_time", sd})
+		}
+		if geo, ok := r.geoMeanOf(e.stats); ok {
+			fields = append(fields, docField{"geo_avg", geo})
+		}
+		if b, ok := r.bytesOf(e.stats); ok {
+			fields = append(fields, docField{"bytes", b})
+		}
+		fields = append(fields, extraDocFields(extras, e.stats)...)
+		if x := e.stats.extra; x != nil && x.buckets != nil {
+			fields = append(fields, docField{"buckets", r.bucketsOf(e.stats)})
+		}
+		if x := e.stats.extra; x != nil && x.timeline != nil {
+			fields = append(fields, docField{"timeline", r.timelineOf(e.stats)})
+		}
+		if x := e.stats.extra; x != nil && x.slowest != nil {
+			fields = append(fields, docField{"slowest", r.slowestOf(e.stats)})
+		}
+		if r.anomalies != nil {
+			// У эндпоинта без срабатываний "flags" нет вовсе, а не пустой массив
+			if flags := r.anomalies.anomalies(e.stats); len(flags) > 0 {
+				flagged = append(flagged, e.name)
+				fields = append(fields, docField{"flags", flags})
+			}
+		}
+		endpoints = append(endpoints, docField{e.name, fields})
+	}
+
+	summary := docObject{
+		{"total_requests", docInt(totalRequests)},
+		{"unique_endpoints", docInt(int64(len(rep.totals)))},
+	}
+	if total != nil {
+		minV, avg, maxV := r.unit.formatStats(total, r.precision)
+		summary = append(summary,
+			docField{"min_response_time", docNumber(minV)},
+			docField{"avg_response_time", docNumber(avg)},
+			docField{"max_response_time", docNumber(maxV)},
+		)
+		for i, v := range rep.quantiles.values(total) {
+			summary = append(summary, docField{quantileKeys[i], docNumber(r.unit.formatMs(v, r.precision))})
+		}
+		if mode, ok := r.modeOf(total); ok {
+			summary = append(summary, docField{"mode_response_time", mode})
+		}
+		if sd, ok := r.stddevOf(total); ok {
+			summary = append(summary, docField{"stddev_response_time", sd}, docField{"cv_response_time", r.cvOf(total)})
+		}
+		if geo, ok := r.geoMeanOf(total); ok {
+			summary = append(summary, docField{"geo_avg", geo})
+		}
+		if b, ok := r.bytesOf(total); ok {
+			summary = append(summary, docField{"bytes", b})
+		}
+		summary = append(summary, extraDocFields(extras, total)...)
+		if times := r.timeFields(total, span); times != nil {
+			summary = append(summary, times...)
+			summary = append(summary, docField{"duration_seconds", durationSeconds(total, span)})
+		}
+		summary = append(summary, r.spanFields(rep.span, totalRequests)...)
+		if r.distribution {
+			if dist := r.distributionOf(rep, total); dist != nil {
+				summary = append(summary, docField{"distribution", dist})
+			}
+		}
+	} else {
+		summary = append(summary,
+			docField{"min_response_time", nil},
+			docField{"avg_response_time", nil},
+			docField{"max_response_time", nil},
+		)
+		for _, key := range quantileKeys {
+			summary = append(summary, docField{key, nil})
+		}
+		if r.stddev {
+			summary = append(summary, docField{"stddev_response_time", nil}, docField{"cv_response_time", nil})
+		}
+		if r.geo {
+			summary = append(summary, docField{"geo_avg", nil})
+		}
+		if r.bytes {
+			summary = append(summary, docField{"bytes", nil})
+		}
+		for _, c := range extras {
+			summary = append(summary, docField{c.name, nil})
+		}
+		if r.times {
+			summary = append(summary, docField{"rps", nil}, docField{"first_seen", nil}, docField{"last_seen", nil},
+				docField{"duration_seconds", nil})
+		}
+		summary = append(summary, r.spanFields(rep.span, 0)...)
+		if r.distribution {
+			summary = append(summary, docField{"distribution", nil})
+		}
+	}
+	if r.anomalies != nil {
+		summary = append(summary, docField{"flagged_endpoints", flagged})
+	}
+	summary = append(summary, docField{"malformed_lines", docInt(rep.malformed)})
+	return docObject{{"endpoints", endpoints}, {"summary", summary}}
+}
+
+// timeSpan - от первого до последнего timestamp всех эндпоинтов в миллисекундах;
+// 0, если timestamp не разбирались или все одинаковые
+func timeSpan(total *Stats) int64 {
+	if total == nil || total.extra == nil || total.extra.seen == nil || !total.extra.seen.ok {
+		return 0
+	}
+	return total.extra.seen.last - total.extra.seen.first
+}
+
+// timeFields - rps, first_seen и last_seen для s. rps - запросы s за весь промежуток span
+// лога, а не за промежуток самого эндпоинта, чтобы rps эндпоинтов складывались; null,
+// если промежуток нулевой. Без timestamp у s все три поля - null
+func (r jsonRenderer) timeFields(s *Stats, span int64) []docField {
+	if !r.times || s.extra == nil || s.extra.seen == nil {
+		return nil
+	}
+	var rps, first, last any
+	if span > 0 {
+		rps = docNumber(formatRatio(s.Count*1000, span, r.precision))
+	}
+	if seen := s.extra.seen; seen.ok {
+		first, last = formatTimestamp(seen.first), formatTimestamp(seen.last)
+	}
+	return []docField{{"rps", rps}, {"first_seen", first}, {"last_seen", last}}
+}
+
+// durationSeconds - промежуток span лога в секундах для summary, без округления:
+// из миллисекунд получается не больше трёх знаков. null, если timestamp не разобрался ни один
+func durationSeconds(total *Stats, span int64) any {
+	if !total.extra.seen.ok {
+		return nil
+	}
+	return secondsOf(span)
+}
+
+func secondsOf(ms int64) any {
+	return docNumber(strconv.FormatFloat(float64(ms)/1000, 'f', -1, 64))
+}
+
+// spanFields - промежуток лога для summary с -summary-span: first_timestamp и
+// last_timestamp в RFC3339 UTC, а без схемы 2 ещё duration_seconds и rps, которые
+// схема 2 и так пишет. Все поля - null, если не разобрался ни один timestamp; rps - и
+// при нулевом промежутке
+func (r jsonRenderer) spanFields(span seenRange, count int64) []docField {
+	if !r.summarySpan {
+		return nil
+	}
+	fields := []docField{{"first_timestamp", nil}, {"last_timestamp", nil}}
+	if !r.times {
+		fields = append(fields, docField{"duration_seconds", nil}, docField{"rps", nil})
+	}
+	if !span.ok {
+		return fields
+	}
+	fields[0].value = formatTimestamp(span.first)
+	fields[1].value = formatTimestamp(span.last)
+	if !r.times {
+		ms := span.last - span.first
+		fields[2].value = secondsOf(ms)
+		if ms > 0 {
+			fields[3].value = docNumber(formatRatio(count*1000, ms, r.precision))
+		}
+	}
+	return fields
+}
+
+// extraDocFields - поля cols, которые есть у s
+func extraDocFields(cols []extraColumn, s *Stats) []docField {
+	var fields []docField
+	for _, c := range cols {
+		if v, ok := c.value(s); ok {
+			fields = append(fields, docField{c.name, docNumber(v)})
+		}
+	}
+	return fields
+}
+
+// stddevOf - stddev_response_time для s; ok = false без -stddev или если накопителя
+// нет (merge сводит готовые отчёты, в которых его не сохранить)
+func (r jsonRenderer) stddevOf(s *Stats) (docNumber, bool) {
+	if !r.stddev || s.extra == nil || s.extra.spread == nil {
+		return "", false
+	}
+	return docNumber(r.unit.formatMs(s.extra.spread.stddev(), r.precision)), true
+}
+
+// cvPrecision - знаков после запятой у cv_response_time
+const cvPrecision = 3
+
+// cvOf - коэффициент вариации stddev / avg для s. Он безразмерный и обычно меньше
+// нескольких единиц, поэтому печатается с cvPrecision знаками, а не с -precision; null,
+// если avg нулевой: тогда все значения нули и отношение не определено
+func (r jsonRenderer) cvOf(s *Stats) any {
+	mean := s.extra.spread.mean
+	if mean == 0 {
+		return nil
+	}
+	return docNumber(strconv.FormatFloat(s.extra.spread.stddev()/mean, 'f', cvPrecision, 64))
+}
+
+// modeOf - mode_response_time для s; ok = false без -mode, без точной гистограммы
+// (после отката на скетчи) или если самое частое значение набрало не больше
+// -mode-min-share процентов запросов: у размытого распределения мода - шум
+func (r jsonRenderer) modeOf(s *Stats) (docNumber, bool) {
+	if r.modeMinShare < 0 || s.extra == nil || s.extra.exact == nil {
+		return "", false
+	}
+	v, c := s.extra.exact.mode()
+	if c == 0 || float64(c)*100 <= r.modeMinShare*float64(s.Count) {
+		return "", false
+	}
+	return docNumber(r.unit.formatValue(v, r.precision)), true
+}
+
+// geoMeanOf - geo_avg для s в -unit; ok = false без -geo-mean или у merge
+func (r jsonRenderer) geoMeanOf(s *Stats) (docNumber, bool) {
+	if !r.geo || s.extra == nil || s.extra.logs == nil {
+		return "", false
+	}
+	return docNumber(r.unit.formatMs(s.extra.logs.geoMean(), r.precision)), true
+}
+
+// bytesOf - объект "bytes" для s: сколько байт отдано всего и min/avg/max на запрос;
+// ok = false без -bytes-field или если накопителя нет (например, у merge)
+func (r jsonRenderer) bytesOf(s *Stats) (docObject, bool) {
+	if !r.bytes || s.extra == nil || s.extra.sent == nil {
+		return nil, false
+	}
+	b := s.extra.sent
+	return docObject{
+		{"total", docInt(b.Sum)},
+		{"min", docInt(b.Min)},
+		{"avg", docNumber(formatRatio(b.Sum, b.Count, r.precision))},
+		{"max", docInt(b.Max)},
+	}, true
+}
+
+// bucketsOf - накопительные счётчики -buckets: {"10": 3, "50": 7, ..., "+Inf": 9}.
+// Границы печатаются в -unit, как и остальные времена, но без округления до -precision
+func (r jsonRenderer) bucketsOf(s *Stats) docObject {
+	counts := cumulative(s.extra.buckets)
+	obj := make(docObject, len(counts))
+	for i, c := range counts {
+		obj[i] = docField{r.buckets.label(i, r.unit), docInt(c)}
+	}
+	return obj
+}
+
+// MarshalJSON сохраняет порядок полей: map из encoding/json отсортировал бы ключи по-своему
+func (obj docObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	obj.writeCompact(&buf)
+	return buf.Bytes(), nil
+}
+
+func (obj docObject) writeCompact(buf *bytes.Buffer) {
+	buf.WriteByte('{')
+	for i, f := range obj {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(jsonString(f.key))
+		buf.WriteByte(':')
+		switch v := f.value.(type) {
+		case docObject:
+			v.writeCompact(buf)
+		case docArray:
+			buf.WriteByte('[')
+			for j, obj := range v {
+				if j > 0 {
+					buf.WriteByte(',')
+				}
+				obj.writeCompact(buf)
+			}
+			buf.WriteByte(']')
+		default:
+			buf.WriteString(jsonScalar(f.value, ","))
+		}
+	}
+	buf.WriteByte('}')
+}
+
+func docInt(v int64) docNumber {
+	return docNumber(strconv.FormatInt(v, 10))
+}
+
+// writeJSONObject пишет объект с отступом в два пробела на уровень; indent - отступ
+// строки, на которой объект открывается
+func writeJSONObject(w io.Writer, obj docObject, indent string) {
+	if len(obj) == 0 {
+		fmt.Fprint(w, "{}")
+		return
+	}
+	fmt.Fprint(w, "{\n")
+	for i, f := range obj {
+		if i > 0 {
+			fmt.Fprint(w, ",\n")
+		}
+		fmt.Fprintf(w, "%s  %s: ", indent, jsonString(f.key))
+		switch v := f.value.(type) {
+		case docObject:
+			writeJSONObject(w, v, indent+"  ")
+		case docArray:
+			writeJSONArray(w, v, indent+"  ")
+		default:
+			fmt.Fprint(w, jsonScalar(f.value, ", "))
+		}
+	}
+	fmt.Fprintf(w, "\n%s}", indent)
+}
+
+// writeJSONArray пишет массив объектов по объекту на элемент; indent - отступ строки,
+// на которой массив открывается
+func writeJSONArray(w io.Writer, arr docArray, indent string) {
+	if len(arr) == 0 {
+		fmt.Fprint(w, "[]")
+		return
+	}
+	fmt.Fprint(w, "[\n")
+	for i, obj := range arr {
+		if i > 0 {
+			fmt.Fprint(w, ",\n")
+		}
+		fmt.Fprintf(w, "%s  ", indent)
+		writeJSONObject(w, obj, indent+"  ")
+	}
+	fmt.Fprintf(w, "\n%s]", indent)
+}
+
+// jsonScalar - значение, не являющееся объектом; sep разделяет элементы массива
+func jsonScalar(v any, sep string) string {
+	switch v := v.(type) {
+	case docNumber:
+		return string(v)
+	case string:
+		return jsonString(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case []string:
+		names := make([]string, len(v))
+		for i, s := range v {
+			names[i] = jsonString(s)
+		}
+		return "[" + strings.Join(names, sep) + "]"
+	case nil:
+		return "null"
+	}
+	panic(fmt.Sprintf("unexpected document value %T", v))
+}
+
+func countOf(s *Stats) int64 {
+	if s == nil {
+		return 0
+	}
+	return s.Count
+}
+
+// ndjsonRenderer пишет по JSON-объекту на строку: эндпоинты в порядке -sort,
+// последней строкой - итог по всем эндпоинтам файла, без учёта -top и -min-count.
+// Строки пишутся в w сразу, без общего буфера
+type ndjsonRenderer struct {
+	precision      int
+	sharePrecision int
+	unit           timeUnit
+}
+
+func (r ndjsonRenderer) render(w io.Writer, rep *report) error {
+	for _, e := range rep.entries {
+		minV, avg, maxV := r.unit.formatStats(e.stats, r.precision)
+		_, err := fmt.Fprintf(w, "{\"endpoint\":%s,\"min\":%s,\"avg\":%s,\"max\":%s%s,\"count\":%d}\n",
+			jsonString(e.name), minV, avg, maxV, r.extraFields(rep, e.stats), e.stats.Count)
+		if err != nil {
+			return err
+		}
+	}
+
+	total := rep.grandTotal()
+	fmt.Fprintf(w, "{\"summary\":true,\"endpoints\":%d", len(rep.totals))
+	if total != nil {
+		minV, avg, maxV := r.unit.formatStats(total, r.precision)
+		fmt.Fprintf(w, ",\"min\":%s,\"avg\":%s,\"max\":%s%s,\"count\":%d", minV, avg, maxV, r.extraFields(rep, total), total.Count)
+	} else {
+		fmt.Fprint(w, ",\"count\":0")
+	}
+	if rep.partial || rep.markPartial {
+		fmt.Fprintf(w, ",\"partial\":%t", rep.partial)
+	}
+	_, err := fmt.Fprint(w, "}\n")
+	return err
+}
+
+// extraFields - ",\"p50\":...,\"p99\":...,\"trimmed_avg\":...,\"apdex\":...,\"slow_count\":..." для строки
+// ndjson; пусто без -percentiles и необязательных накопителей
+func (r ndjsonRenderer) extraFields(rep *report, s *Stats) string {
+	var sb strings.Builder
+	names := rep.quantiles.names()
+	for i, v := range rep.quantiles.values(s) {
+		fmt.Fprintf(&sb, ",%s:%s", jsonString(names[i]), r.unit.formatMs(v, r.precision))
+	}
+	for _, c := range rep.extraColumns(r.sharePrecision) {
+		if v, ok := c.value(s); ok {
+			fmt.Fprintf(&sb, ",%s:%s", jsonString(c.name), v)
+		}
+	}
+	return sb.String()
+}
+
+// jsonString кодирует s как JSON-строку. Кавычки, обратные слэши и управляющие
+// символы в путях экранируются, <, > и & оставляем как есть, чтобы вывод читался.
+// Невалидный UTF-8 encoding/json заменяет на U+FFFD
+func jsonString(s string) string {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+type csvRenderer struct {
+	precision      int
+	sharePrecision int
+	unit           timeUnit
+}
+
+// Колонки времени подписаны единицей вывода: min_ms, avg_s, max_us
+func (r csvRenderer) render(w io.Writer, rep *report) error {
+	cw := csv.NewWriter(w)
+	suffix := "_" + r.unit.name
+	header := []string{"endpoint", "count", "share_pct", "min" + suffix, "avg" + suffix, "max" + suffix}
+	for _, col := range rep.quantiles.columns(strings.ToLower) {
+		header = append(header, col+suffix)
+	}
+	extras := rep.extraColumns(r.sharePrecision)
+	for _, c := range extras {
+		header = append(header, c.name)
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	total := countOf(rep.grandTotal())
+	for _, e := range rep.entries {
+		endpoint, end := e.name, e.stats
+		row := []string{
+			endpoint,
+			strconv.FormatInt(end.Count, 10),
+			formatShare(end.Count, total, r.sharePrecision),
+			r.unit.formatValue(end.Min, r.precision),
+			r.unit.formatAvg(end, r.precision),
+			r.unit.formatValue(end.Max, r.precision),
+		}
+		row = append(row, rep.quantiles.formatted(end, r.unit, r.precision)...)
+		row = append(row, extraCells(extras, end)...)
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Слишком длинные пути обрезаем, иначе таблица расползается
+const maxTableEndpointWidth = 60
+
+type tableRenderer struct {
+	precision      int
+	sharePrecision int
+	unit           timeUnit
+	// slowMax > 0 подсвечивает красным строки с max больше этого значения (в мс)
+	slowMax int64
+	// sparkWidth > 0 добавляет колонку DIST со спарклайном корзин -buckets
+	sparkWidth int
+}
+
+const (
+	ansiRed   = "\033[31m"
+	ansiReset = "\033[0m"
+)
+
+func (r tableRenderer) render(w io.Writer, rep *report) error {
+	// Квантили идут сразу за MAX, чтобы все времена стояли рядом
+	header := append([]string{"ENDPOINT", "MIN", "AVG", "MAX"}, rep.quantiles.columns(strings.ToUpper)...)
+	header = append(header, "COUNT", "SHARE")
+	extras := rep.extraColumns(r.sharePrecision)
+	for _, c := range extras {
+		header = append(header, strings.ToUpper(c.name))
+	}
+	if r.sparkWidth > 0 {
+		header = append(header, "DIST")
+	}
+	rows := make([][]string, 0, len(rep.entries))
+	total := countOf(rep.grandTotal())
+	for _, e := range rep.entries {
+		endpoint, end := e.name, e.stats
+		row := []string{
+			truncate(endpoint, maxTableEndpointWidth),
+			r.unit.formatValue(end.Min, r.precision),
+			r.unit.formatAvg(end, r.precision),
+			r.unit.formatValue(end.Max, r.precision),
+		}
+		row = append(row, rep.quantiles.formatted(end, r.unit, r.precision)...)
+		row = append(row,
+			groupThousands(end.Count),
+			formatShare(end.Count, total, r.sharePrecision)+"%",
+		)
+		row = append(row, extraCells(extras, end)...)
+		if r.sparkWidth > 0 {
+			row = append(row, sparklineOf(end, r.sparkWidth))
+		}
+		rows = append(rows, row)
+	}
+
+	// Ширины считаем отдельным проходом по всем строкам до начала вывода
+	widths := make([]int, len(header))
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+
+	if rep.highlights != nil {
+		if err := writeTableHighlights(w, rep.highlights, r.unit, r.precision); err != nil {
+			return err
+		}
+	}
+	if err := writeTableRow(w, header, widths, ""); err != nil {
+		return err
+	}
+	for i, row := range rows {
+		color := ""
+		if r.slowMax > 0 && rep.entries[i].stats.Max > r.slowMax {
+			color = ansiRed
+		}
+		if err := writeTableRow(w, row, widths, color); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTableRow выравнивает первую колонку по левому краю, числовые - по правому.
+// color, если не пуст, - ANSI-последовательность для всей строки; на ширину она не влияет
+func writeTableRow(w io.Writer, row []string, widths []int, color string) error {
+	var sb strings.Builder
+	sb.WriteString(color)
+	for i, cell := range row {
+		pad := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+		if i == 0 {
+			sb.WriteString(cell + pad)
+		} else {
+			sb.WriteString("  " + pad + cell)
+		}
+	}
+	if color != "" {
+		sb.WriteString(ansiReset)
+	}
+	sb.WriteByte('\n')
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// groupThousands печатает n с разделителем разрядов: 1234567 -> "1,234,567"
+func groupThousands(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	sign := ""
+	if n < 0 {
+		sign, s = "-", s[1:]
+	}
+	var sb strings.Builder
+	sb.WriteString(sign)
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+var colorModes = []string{"auto", "always", "never"}
+
+// useColor решает, красить ли таблицу: в режиме auto - только при выводе в терминал
+// и без переменной NO_COLOR
+func useColor(opts *options) bool {
+	switch opts.color {
+	case "always":
+		return true
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" || (opts.outPath != "" && opts.outPath != "-") {
+			return false
+		}
+		return isTerminal(os.Stdout)
+	}
+	return false
+}
+
+// markdownRenderer пишет таблицу GitHub Flavored Markdown; числа выровнены вправо
+// строкой выравнивания, а в исходнике колонки дополнены пробелами для читаемости
+type markdownRenderer struct {
+	precision      int
+	sharePrecision int
+	unit           timeUnit
+	// sparkWidth > 0 добавляет колонку Distribution со спарклайном корзин -buckets
+	sparkWidth int
+}
+
+var markdownEscaper = strings.NewReplacer(`|`, `\|`)
+
+func (r markdownRenderer) render(w io.Writer, rep *report) error {
+	header := append([]string{"Endpoint", "Count", "Share", "Min", "Avg", "Max"}, rep.quantiles.columns(strings.ToLower)...)
+	extras := rep.extraColumns(r.sharePrecision)
+	for _, c := range extras {
+		header = append(header, c.title)
+	}
+	if r.sparkWidth > 0 {
+		header = append(header, "Distribution")
+	}
+	rows := make([][]string, 0, len(rep.entries))
+	total := countOf(rep.grandTotal())
+	for _, e := range rep.entries {
+		minV, avg, maxV := r.unit.formatStats(e.stats, r.precision)
+		row := []string{
+			markdownEscaper.Replace(e.name),
+			strconv.FormatInt(e.stats.Count, 10),
+			formatShare(e.stats.Count, total, r.sharePrecision) + "%",
+			minV, avg, maxV,
+		}
+		row = append(row, rep.quantiles.formatted(e.stats, r.unit, r.precision)...)
+		row = append(row, extraCells(extras, e.stats)...)
+		if r.sparkWidth > 0 {
+			row = append(row, sparklineOf(e.stats, r.sparkWidth))
+		}
+		rows = append(rows, row)
+	}
+
+	widths := make([]int, len(header))
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	// Строке выравнивания нужно хотя бы "---:"
+	for i := range widths {
+		widths[i] = max(widths[i], 4)
+	}
+
+	align := make([]string, len(header))
+	for i, width := range widths {
+		if i == 0 {
+			align[i] = strings.Repeat("-", width)
+		} else {
+			align[i] = strings.Repeat("-", width-1) + ":"
+		}
+	}
+
+	if rep.highlights != nil {
+		if err := writeMarkdownHighlights(w, rep.highlights, r.unit, r.precision); err != nil {
+			return err
+		}
+	}
+	lines := append([][]string{header, align}, rows...)
+	for _, row := range lines {
+		var sb strings.Builder
+		sb.WriteByte('|')
+		for i, cell := range row {
+			pad := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+			if i == 0 {
+				sb.WriteString(" " + cell + pad + " |")
+			} else {
+				sb.WriteString(" " + pad + cell + " |")
+			}
+		}
+		sb.WriteByte('\n')
+		if _, err := io.WriteString(w, sb.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:width-1]) + "…"
+}
+
+// listRenderer - режим -list: по строке на эндпоинт, число запросов и имя, как у uniq -c.
+// Время ответа не выводится
+type listRenderer struct{}
+
+func (listRenderer) render(w io.Writer, rep *report) error {
+	width := 0
+	for _, e := range rep.entries {
+		width = max(width, len(strconv.FormatInt(e.stats.Count, 10)))
+	}
+	for _, e := range rep.entries {
+		if _, err := fmt.Fprintf(w, "%*d %s\n", width, e.stats.Count, e.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// trickyPaths - эндпоинты, которые ломали ручной вывод через "%s"
+var trickyPaths = []string{
+	`/search?q="foo"\bar`,
+	"/über/straße",
+	"/ctl\x01\x1fpath",
+	"/emoji/\U0001F600",
+	`/quote"`,
+}
+
+func trickyLog() string {
+	var sb strings.Builder
+	for i, p := range trickyPaths {
+		sb.WriteString("2024-01-01T00:00:00Z 10.0.0.1 GET " + p + " 200 " + strings.Repeat("1", i+1) + "\n")
+	}
+	// Невалидный UTF-8 encoding/json заменяет на U+FFFD
+	sb.WriteString("2024-01-01T00:00:00Z 10.0.0.1 GET /bad\xffbyte 200 5\n")
+	return sb.String()
+}
+
+func TestJSONOutputEscapesPaths(t *testing.T) {
+	path := writeTempFile(t, "tricky.log", trickyLog())
+	for _, schema := range []string{"1", "2"} {
+		out, code := runAnalyzeFile(t, "-schema-version", schema, path)
+		if code != exitOK {
+			t.Fatalf("exit %d", code)
+		}
+		var doc struct {
+			Endpoints map[string]struct {
+				Min int64   `json:"min_response_time"`
+				Avg float64 `json:"avg_response_time"`
+				Max int64   `json:"max_response_time"`
+			} `json:"endpoints"`
+		}
+		if err := json.Unmarshal([]byte(out), &doc); err != nil {
+			t.Fatalf("schema %s: output is not valid JSON: %v\n%s", schema, err, out)
+		}
+		for i, p := range trickyPaths {
+			e, ok := doc.Endpoints[p]
+			if !ok {
+				t.Errorf("schema %s: endpoint %q missing", schema, p)
+				continue
+			}
+			want := int64(0)
+			for range i + 1 {
+				want = want*10 + 1
+			}
+			if e.Min != want || e.Max != want || e.Avg != float64(want) {
+				t.Errorf("schema %s: %q = %+v, want %d", schema, p, e, want)
+			}
+		}
+		if _, ok := doc.Endpoints["/bad�byte"]; !ok {
+			t.Errorf("schema %s: invalid UTF-8 path not replaced with U+FFFD", schema)
+		}
+	}
+}
+
+func TestJSONOutputKeepsKeyOrder(t *testing.T) {
+	path := writeTempFile(t, "tricky.log", trickyLog())
+	out, _ := runAnalyzeFile(t, path)
+	sorted := append([]string{"/bad\uFFFDbyte"}, trickyPaths...)
+	sort.Strings(sorted)
+	last := -1
+	for _, p := range sorted {
+		at := strings.Index(out, jsonString(p)+": {")
+		if at < 0 || at < last {
+			t.Errorf("endpoint %q is missing or out of sorted order", p)
+		}
+		last = at
+	}
+	fields := []string{`"min_response_time"`, `"avg_response_time"`, `"max_response_time"`}
+	entry := out[strings.Index(out, jsonString(sorted[0])):]
+	if a, b, c := strings.Index(entry, fields[0]), strings.Index(entry, fields[1]), strings.Index(entry, fields[2]); !(a < b && b < c) {
+		t.Errorf("fields out of order: %s", entry[:min(len(entry), 200)])
+	}
+}
+
+func TestNDJSONOutputEscapesPaths(t *testing.T) {
+	path := writeTempFile(t, "tricky.log", trickyLog())
+	out, code := runAnalyzeFile(t, "-format", "ndjson", path)
+	if code != exitOK {
+		t.Fatalf("exit %d", code)
+	}
+	seen := map[string]bool{}
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		var row struct {
+			Endpoint string `json:"endpoint"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &row); err != nil {
+			t.Fatalf("invalid line %q: %v", sc.Text(), err)
+		}
+		seen[row.Endpoint] = true
+	}
+	for _, p := range trickyPaths {
+		if !seen[p] {
+			t.Errorf("endpoint %q missing from ndjson", p)
+		}
+	}
+}
+
+func TestJSONString(t *testing.T) {
+	tests := map[string]string{
+		`a"b`:      `"a\"b"`,
+		`a\b`:      `"a\\b"`,
+		"a\nb":     `"a\nb"`,
+		"a\x01":    `"a\u0001"`,
+		"<a&b>":    `"<a&b>"`,
+		"ü":        `"ü"`,
+		"bad\xff":  `"bad�"`,
+		"/api/x?y": `"/api/x?y"`,
+	}
+	for in, want := range tests {
+		if got := jsonString(in); got != want {
+			t.Errorf("jsonString(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestEmptyInputs(t *testing.T) {
+	for name, content := range map[string]string{
+		"empty":          "",
+		"newlines only":  "\n\n\r\n\n",
+		"malformed only": "2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 abc\n2024-01-01T00:00:00Z 10.0.0.1 GET /b 200 12x\n",
+	} {
+		path := writeTempFile(t, "in.log", content)
+		for schema, extra := range map[string][]string{"1": nil, "2": {"-stddev", "-unique-ips", "-percentiles", "50"}} {
+			out, code := runAnalyzeFile(t, append(append([]string{"-schema-version", schema}, extra...), path)...)
+			if code != exitOK {
+				t.Fatalf("%s, schema %s: exit %d", name, schema, code)
+			}
+			var doc struct {
+				Endpoints map[string]any `json:"endpoints"`
+				Summary   map[string]any `json:"summary"`
+			}
+			if err := json.Unmarshal([]byte(out), &doc); err != nil {
+				t.Fatalf("%s, schema %s: invalid JSON: %v\n%s", name, schema, err, out)
+			}
+			if doc.Endpoints == nil || len(doc.Endpoints) != 0 {
+				t.Errorf("%s, schema %s: endpoints = %v, want {}", name, schema, doc.Endpoints)
+			}
+			if doc.Summary["total_requests"] != 0.0 || doc.Summary["avg_response_time"] != nil {
+				t.Errorf("%s, schema %s: summary = %v", name, schema, doc.Summary)
+			}
+		}
+		// Ни один формат не делит на ноль
+		for _, format := range []string{"yaml", "ndjson", "csv", "table", "markdown", "prom", "html"} {
+			out, code := runAnalyzeFile(t, "-format", format, path)
+			if code != exitOK || strings.Contains(out, "NaN") || strings.Contains(out, "Inf") {
+				t.Errorf("%s, -format %s: exit %d\n%s", name, format, code, out)
+			}
+		}
+	}
+
+	// Порог -max-error-rate - единственная причина ненулевого кода
+	path := writeTempFile(t, "bad.log", "2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 abc\n")
+	if _, code := runAnalyzeFile(t, "-max-error-rate", "0.5", path); code != exitErrorRate {
+		t.Errorf("-max-error-rate 0.5 on malformed-only input: exit %d, want %d", code, exitErrorRate)
+	}
+}
+
+func TestZeroCountEndpointOmitted(t *testing.T) {
+	rep := newReport(map[string]*Stats{
+		"/a":    {Min: 1, Max: 3, Sum: 4, Count: 2},
+		"/zero": {},
+	}, sortOrder{key: "name"})
+	if len(rep.entries) != 1 || rep.entries[0].name != "/a" {
+		t.Errorf("entries = %v, want only /a", rep.entries)
+	}
+	if _, ok := rep.totals["/zero"]; ok {
+		t.Error("/zero kept in the totals")
+	}
+}
+
+func TestSummaryTimeSpan(t *testing.T) {
+	// 5 запросов за 5 секунд, не по порядку и с повтором секунды; строка без timestamp
+	// тоже считается в rps
+	path := writeTempFile(t, "span.log", "2024-01-01T00:00:01Z 10.0.0.1 GET /a 200 10\n"+
+		"2024-01-01T00:00:01Z 10.0.0.1 GET /b 200 20\n"+
+		"2024-01-01T00:00:06Z 10.0.0.1 GET /a 200 30\n"+
+		"2024-01-01T00:00:02Z 10.0.0.1 GET /a 200 40\n"+
+		"not-a-time 10.0.0.1 GET /b 200 50\n")
+	want := map[string]any{
+		"first_timestamp":  "2024-01-01T00:00:01Z",
+		"last_timestamp":   "2024-01-01T00:00:06Z",
+		"duration_seconds": 5.0,
+		"rps":              1.0,
+	}
+	for _, args := range [][]string{
+		nil,
+		{"-schema-version", "2"},
+		{"-workers", "3"},
+		{"-format", "yaml"},
+	} {
+		out, code := runAnalyzeFile(t, append(append([]string{"-summary-span"}, args...), path)...)
+		if code != exitOK {
+			t.Fatalf("%v: exit %d", args, code)
+		}
+		var doc struct {
+			Summary map[string]any `json:"summary"`
+		}
+		if len(args) > 0 && args[1] == "yaml" {
+			var v any
+			if err := yaml.Unmarshal([]byte(out), &v); err != nil {
+				t.Fatalf("%v: %v", args, err)
+			}
+			doc.Summary = asJSONValue(t, v).(map[string]any)["summary"].(map[string]any)
+		} else if err := json.Unmarshal([]byte(out), &doc); err != nil {
+			t.Fatalf("%v: %v\n%s", args, err, out)
+		}
+		for k, v := range want {
+			if doc.Summary[k] != v {
+				t.Errorf("%v: summary %q = %v, want %v", args, k, doc.Summary[k], v)
+			}
+		}
+	}
+
+	// Без -summary-span схема 1 не меняется, а схема 2 пишет промежуток своими ключами
+	out, _ := runAnalyzeFile(t, path)
+	if strings.Contains(out, "timestamp") || strings.Contains(out, "rps") || strings.Contains(out, "duration_seconds") {
+		t.Errorf("schema 1 without -summary-span has time fields:\n%s", out)
+	}
+	out, _ = runAnalyzeFile(t, "-schema-version", "2", path)
+	if strings.Contains(out, "timestamp") || !strings.Contains(out, `"duration_seconds": 5`) {
+		t.Errorf("schema 2 without -summary-span:\n%s", out)
+	}
+	if _, code := runAnalyzeFile(t, "-summary-span", "-format", "table", path); code != exitUsage {
+		t.Errorf("-summary-span -format table: exit %d, want %d", code, exitUsage)
+	}
+
+	// Все timestamp одинаковые: промежуток нулевой, и rps не определён
+	path = writeTempFile(t, "same.log", "2024-01-01T00:00:01Z 10.0.0.1 GET /a 200 10\n2024-01-01T00:00:01Z 10.0.0.1 GET /a 200 20\n")
+	out, _ = runAnalyzeFile(t, "-summary-span", path)
+	for _, field := range []string{`"duration_seconds": 0,`, `"rps": null,`} {
+		if !strings.Contains(out, field) {
+			t.Errorf("equal timestamps: want %s in\n%s", field, out)
+		}
+	}
+}
+
+// keyOrder - ключи каждого объекта документа data по порядку; объект задаёт путь из
+// ключей через "/" от корня, корень - ""
+func keyOrder(t *testing.T, data string) map[string][]string {
+	t.Helper()
+	dec := json.NewDecoder(strings.NewReader(data))
+	keys := make(map[string][]string)
+	var walk func(path string)
+	walk = func(path string) {
+		tok, err := dec.Token()
+		if err != nil {
+			t.Fatalf("%v\n%s", err, data)
+		}
+		switch tok {
+		case json.Delim('{'):
+			keys[path] = []string{}
+			for dec.More() {
+				tok, err := dec.Token()
+				if err != nil {
+					t.Fatal(err)
+				}
+				key := tok.(string)
+				keys[path] = append(keys[path], key)
+				walk(path + "/" + key)
+			}
+			dec.Token()
+		case json.Delim('['):
+			for dec.More() {
+				walk(path + "/[]")
+			}
+			dec.Token()
+		}
+	}
+	walk("")
+	return keys
+}
+
+func TestSchemaVersionShape(t *testing.T) {
+	path := writeTempFile(t, "shape.log", "2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 10\n"+
+		"2024-01-01T00:00:01Z 10.0.0.1 GET /a 200 25\n"+
+		"2024-01-01T00:00:02Z 10.0.0.2 POST /b 201 7\n")
+	run := func(args ...string) string {
+		t.Helper()
+		out, code := runAnalyzeFile(t, append(args, path)...)
+		if code != exitOK {
+			t.Fatalf("%v: exit %d", args, code)
+		}
+		return out
+	}
+	v1, v1Count, v2 := run(), run("-include-count"), run("-schema-version", "2")
+	if explicit := run("-schema-version", "1"); explicit != v1 {
+		t.Errorf("-schema-version 1 differs from the default:\n%s\nwant:\n%s", explicit, v1)
+	}
+	if explicit := run("-schema-version", "1", "-include-count"); explicit != v1Count {
+		t.Errorf("-schema-version 1 -include-count differs from -include-count:\n%s", explicit)
+	}
+
+	stats := []string{"min_response_time", "avg_response_time", "max_response_time"}
+	summary := []string{"total_requests", "unique_endpoints", "min_response_time", "avg_response_time", "max_response_time"}
+	times := []string{"rps", "first_seen", "last_seen"}
+	join := func(parts ...[]string) []string {
+		var all []string
+		for _, p := range parts {
+			all = append(all, p...)
+		}
+		return all
+	}
+	for _, tt := range []struct {
+		name     string
+		out      string
+		root, ep []string
+		sum      []string
+	}{
+		{"v1", v1, []string{"endpoints", "summary"}, stats, join(summary, []string{"malformed_lines"})},
+		{"v1 -include-count", v1Count, []string{"endpoints", "summary"}, join(stats, []string{"count"}), join(summary, []string{"malformed_lines"})},
+		{"v2", v2, []string{"schema_version", "endpoints", "summary"},
+			join(stats, []string{"count", "sum_response_time", "share"}, times),
+			join(summary, times, []string{"duration_seconds", "malformed_lines"})},
+	} {
+		keys := keyOrder(t, tt.out)
+		for obj, want := range map[string][]string{"": tt.root, "/endpoints//a": tt.ep, "/endpoints//b": tt.ep, "/summary": tt.sum} {
+			if got := keys[obj]; strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("%s: keys of %q = %v, want %v", tt.name, obj, got, want)
+			}
+		}
+	}
+
+	// Общие поля у обеих схем совпадают по значению
+	var doc1, doc2 struct {
+		SchemaVersion int                       `json:"schema_version"`
+		Endpoints     map[string]map[string]any `json:"endpoints"`
+		Summary       map[string]any            `json:"summary"`
+	}
+	if err := json.Unmarshal([]byte(v1Count), &doc1); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(v2), &doc2); err != nil {
+		t.Fatal(err)
+	}
+	if doc1.SchemaVersion != 0 || doc2.SchemaVersion != 2 {
+		t.Errorf("schema_version = %d and %d, want absent and 2", doc1.SchemaVersion, doc2.SchemaVersion)
+	}
+	for name, e1 := range doc1.Endpoints {
+		for k, v := range e1 {
+			if doc2.Endpoints[name][k] != v {
+				t.Errorf("%s %s: v1 %v, v2 %v", name, k, v, doc2.Endpoints[name][k])
+			}
+		}
+	}
+	for k, v := range doc1.Summary {
+		if doc2.Summary[k] != v {
+			t.Errorf("summary %s: v1 %v, v2 %v", k, v, doc2.Summary[k])
+		}
+	}
+	if e := doc2.Endpoints["/a"]; e["sum_response_time"] != 35.0 || e["share"] != 66.7 {
+		t.Errorf("v2 /a = %v, want sum 35 and share 66.7", e)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetRow - строка файла -parquet-out, по одной на эндпоинт, страницы сжаты Snappy.
+// Схема стабильна: столбцы не переименовываются и не меняют тип, новые добавляются
+// только в конец
+//
+//	endpoint  BYTE_ARRAY (STRING), словарное кодирование
+//	count     INT64
+//	min       INT64, мс
+//	max       INT64, мс
+//	sum       INT64, мс
+//	avg       DOUBLE, мс, без округления
+//	run_date  INT64 (TIMESTAMP(MILLIS, isAdjustedToUTC=true)), начало прогона
+type parquetRow struct {
+	Endpoint string    `parquet:"endpoint,dict"`
+	Count    int64     `parquet:"count"`
+	Min      int64     `parquet:"min"`
+	Max      int64     `parquet:"max"`
+	Sum      int64     `parquet:"sum"`
+	Avg      float64   `parquet:"avg"`
+	RunDate  time.Time `parquet:"run_date,timestamp(millisecond:utc)"`
+}
+
+// parquetRenderer пишет эндпоинты отчёта в Parquet для -parquet-out, в порядке -sort.
+// Время в мс, независимо от -unit и -precision: файл читают программы, а не люди
+type parquetRenderer struct {
+	runDate time.Time
+}
+
+func (r parquetRenderer) render(w io.Writer, rep *report) error {
+	rows := make([]parquetRow, 0, len(rep.entries))
+	for _, e := range rep.entries {
+		s := e.stats
+		avg := 0.0
+		if s.Count > 0 {
+			avg = float64(s.Sum) / float64(s.Count)
+		}
+		rows = append(rows, parquetRow{e.name, s.Count, s.Min, s.Max, s.Sum, avg, r.runDate.UTC()})
+	}
+	// Snappy понимают DuckDB, Athena и Spark без настроек
+	pw := parquet.NewGenericWriter[parquetRow](w, parquet.Compression(&parquet.Snappy))
+	if _, err := pw.Write(rows); err != nil {
+		return err
+	}
+	return pw.Close()
+}
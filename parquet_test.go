@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+func TestParquetOut(t *testing.T) {
+	log := writeTempFile(t, "a.log", "2024-01-01T00:00:00Z 10.0.0.1 GET /api/users 200 10\n"+
+		"2024-01-01T00:00:01Z 10.0.0.1 GET /api/users 200 25\n"+
+		"2024-01-01T00:00:02Z 10.0.0.1 GET /über 200 7\n")
+	path := filepath.Join(t.TempDir(), "result.parquet")
+	before := time.Now().Truncate(time.Millisecond)
+	if _, code := runAnalyzeFile(t, "-parquet-out", path, "-sort", "name", "-unit", "s", log); code != exitOK {
+		t.Fatalf("exit %d", code)
+	}
+
+	rows, err := parquet.ReadFile[parquetRow](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2: %+v", len(rows), rows)
+	}
+	runDate := rows[0].RunDate
+	if runDate.Before(before) || runDate.After(time.Now()) || runDate.Location() != time.UTC {
+		t.Errorf("run_date = %v, want the start of the run in UTC", runDate)
+	}
+	// -unit не влияет: в файле всегда миллисекунды
+	want := []parquetRow{
+		{"/api/users", 2, 10, 25, 35, 17.5, runDate},
+		{"/über", 1, 7, 7, 7, 7, runDate},
+	}
+	for i := range want {
+		if !rows[i].RunDate.Equal(want[i].RunDate) {
+			t.Errorf("row %d run_date = %v, want %v", i, rows[i].RunDate, want[i].RunDate)
+		}
+		rows[i].RunDate = want[i].RunDate
+		if rows[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestParquetSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "result.parquet")
+	rep := newReport(map[string]*Stats{"/a": {Min: 1, Max: 3, Sum: 4, Count: 2}}, sortOrder{key: "name"})
+	if err := writeReport(path, 0, parquetRenderer{time.Unix(1700000000, 0)}, rep); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf, err := parquet.OpenFile(f, st.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Порядок, имена и типы столбцов - часть документированной схемы
+	var got []string
+	for _, field := range pf.Schema().Fields() {
+		got = append(got, field.Name()+" "+field.Type().String())
+	}
+	// INT(64,true) - знаковый INT64
+	wantSchema := []string{
+		"endpoint STRING", "count INT(64,true)", "min INT(64,true)", "max INT(64,true)", "sum INT(64,true)", "avg DOUBLE",
+		"run_date TIMESTAMP(isAdjustedToUTC=true,unit=MILLIS)",
+	}
+	if strings.Join(got, "\n") != strings.Join(wantSchema, "\n") {
+		t.Errorf("schema:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(wantSchema, "\n"))
+	}
+
+	endpoint := pf.Metadata().RowGroups[0].Columns[0].MetaData
+	if !slices.Contains(endpoint.Encoding, format.RLEDictionary) {
+		t.Errorf("endpoint encodings = %v, want dictionary encoding", endpoint.Encoding)
+	}
+	if endpoint.Codec != format.Snappy {
+		t.Errorf("endpoint codec = %v, want snappy", endpoint.Codec)
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Строка лога: "2024-01-01T00:00:00Z 192.168.1.1 GET /api/users 200 123". Поля
+// разделены пробелами (один или несколько) и определяются по номеру, так что ширина
+// timestamp и IP (в том числе IPv6) может быть любой
+const (
+	// responseTimeField - номер поля времени ответа, считая с единицы, как в awk
+	responseTimeField = 6
+)
+
+// span - полуинтервал [start, end) байт строки
+type span struct {
+	start, end int
+}
+
+// lineRecord - разобранная строка: границы полей и время ответа
+type lineRecord struct {
+	timestamp, ip, method, path, status, responseTime span
+	// value - время ответа в миллисекундах
+	value int64
+	// sent и sentBytes - поле -bytes-field и его значение; без флага не заполняются
+	sent      span
+	sentBytes int64
+	// altPath, если задан, - эндпоинт вместо path из строки: unknownPath у строк без path
+	// (-unknown-path) или path после -normalize-rules и -auto-normalize
+	altPath []byte
+}
+
+// pathOf - path строки line или altPath, если он задан
+func (rec *lineRecord) pathOf(line []byte) []byte {
+	if rec.altPath != nil {
+		return rec.altPath
+	}
+	return line[rec.path.start:rec.path.end]
+}
+
+// lineParseError - строка не разобралась; index - байт строки, на котором сломался разбор
+type lineParseError struct {
+	index int
+	err   error
+}
+
+func (e *lineParseError) Error() string {
+	return e.err.Error()
+}
+
+func (e *lineParseError) Unwrap() error {
+	return e.err
+}
+
+// lineFormat - флаги, которые меняют разбор строк
+type lineFormat struct {
+	// bytesField - номер поля размера ответа (-bytes-field); 0 - размер не читается
+	bytesField int
+	// spacedPaths - в path бывают пробелы (-paths-may-contain-spaces)
+	spacedPaths bool
+	// fields - номера полей по -fields; nil - раскладка по умолчанию
+	fields *fieldPlan
+	// template - шаблон -line-format; с ним остальные поля не используются
+	template *lineTemplate
+	// combined и alb - -input-format combined и alb
+	combined, alb bool
+	// json - ключи -input-format jsonl; nil - строки не JSON
+	json *jsonPlan
+	// csv - колонки -input-format csv и tsv; nil - строки не CSV
+	csv *csvPlan
+	// logfmt - ключи -input-format logfmt; nil - строки не logfmt
+	logfmt *logfmtPlan
+	// w3c - -input-format w3c; fieldsW3C - раскладка последней встреченной директивы
+	// #Fields, её меняет lineProcessor
+	w3c       bool
+	fieldsW3C *w3cPlan
+	// maxValue - -max-response-time: строки с временем больше него битые; 0 - без предела
+	maxValue int64
+	// times - -time-format: как записано время ответа там, где формат его не задаёт
+	times timeFormat
+}
+
+func lineFormatOf(opts *options) lineFormat {
+	f := lineFormat{bytesField: opts.bytesField, spacedPaths: opts.spacedPaths, template: opts.lineTemplate,
+		combined: opts.inputFormat == inputFormatCombined, alb: opts.inputFormat == inputFormatALB,
+		w3c: opts.inputFormat == inputFormatW3C, maxValue: opts.maxResponseTime,
+		times: timeFormats[opts.timeFormat]}
+	switch opts.inputFormat {
+	case inputFormatJSONL:
+		f.json = newJSONPlan(keysOr(opts.jsonKeys, defaultJSONKeys))
+	case inputFormatLogfmt:
+		f.logfmt = newLogfmtPlan(keysOr(opts.logfmtKeys, defaultLogfmtKeys), opts.unknownPath)
+	case inputFormatCSV:
+		f.csv = newCSVPlan(columnsOf(opts), ',')
+	case inputFormatTSV:
+		f.csv = newCSVPlan(columnsOf(opts), '\t')
+	}
+	if opts.fields.isSet() {
+		f.fields = newFieldPlan(opts.fields, opts.bytesField)
+	}
+	return f
+}
+
+func (f lineFormat) parse(line []byte) (lineRecord, error) {
+	switch {
+	case f.template != nil:
+		return f.template.parse(line, f.times)
+	case f.combined:
+		return parseLineCombined(line)
+	case f.alb:
+		return parseLineALB(line)
+	case f.json != nil:
+		return f.json.parse(line, f.times)
+	case f.csv != nil:
+		return f.csv.parse(line, f.times)
+	case f.logfmt != nil:
+		return f.logfmt.parse(line, f.times)
+	case f.w3c:
+		return f.fieldsW3C.parse(line)
+	case f.fields != nil:
+		return f.fields.parse(line, f.times)
+	case f.spacedPaths:
+		return parseLineSpaced(line, f.times)
+	}
+	return parseLineBytes(line, f.bytesField, f.times)
+}
+
+// limit - ошибка строки, время ответа которой больше -max-response-time. Проверка
+// отдельно от parse, чтобы быстрый путь без предела не копировал lineRecord лишний раз
+func (f lineFormat) limit(rec *lineRecord) error {
+	if f.maxValue > 0 && rec.value > f.maxValue {
+		return &lineParseError{rec.responseTime.start, fmt.Errorf("response time %dms is above -max-response-time %d", rec.value, f.maxValue)}
+	}
+	return nil
+}
+
+// parseLine разбирает одну строку без завершающего '\n'. Для агрегации нужны только
+// path (эндпоинт) и responseTime, остальные границы заполняются попутно
+func parseLine(line []byte) (lineRecord, error) {
+	return parseLineBytes(line, 0, timeIntMillis)
+}
+
+// parseLineBytes - parseLine, который с bytesField > 0 читает ещё и размер ответа из поля
+// с этим номером (-bytes-field). Тогда время ответа кончается на пробеле, а за ним могут
+// идти другие поля
+func parseLineBytes(line []byte, bytesField int, times timeFormat) (lineRecord, error) {
+	var rec lineRecord
+	// Логи с Windows кончают строки на "\r\n": '\r' не относится к последнему полю
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	// Поля по порядку номеров: поле - байты между двумя пробелами, которые не стоят
+	// вплотную. Время ответа без -bytes-field занимает весь остаток строки, поэтому им
+	// хватает want пробелов после полей. prev - последний пройденный пробел
+	want := responseTimeField
+	if bytesField == 0 {
+		want--
+	}
+	var f [responseTimeField]span
+	n, prev := 0, -1
+	field := func(p int) bool {
+		if p > prev+1 {
+			f[n] = span{prev + 1, p}
+			n++
+		}
+		prev = p
+		return n == want
+	}
+	i := 0
+words:
+	for ; i+8 <= len(line); i += 8 {
+		for m := spaceMask(line[i:]); m != 0; m &= m - 1 {
+			if field(i + bits.TrailingZeros64(m)/8) {
+				break words
+			}
+		}
+	}
+	for ; n < want && i < len(line); i++ {
+		if line[i] == ' ' {
+			field(i)
+		}
+	}
+	if n < responseTimeField {
+		if sp, ok := nextField(line, prev+1); ok {
+			if bytesField == 0 {
+				sp.end = len(line)
+			}
+			f[n] = sp
+			n++
+		}
+	}
+	rec.timestamp, rec.ip, rec.method, rec.path, rec.status, rec.responseTime = f[0], f[1], f[2], f[3], f[4], f[5]
+	if n < responseTimeField {
+		return rec, &lineParseError{len(line), fmt.Errorf("expected %d space-separated fields (timestamp, ip, method, path, status, response time), found %d", responseTimeField, n)}
+	}
+
+	value, bad, err := times.millis(line[rec.responseTime.start:rec.responseTime.end])
+	if err != nil {
+		return rec, &lineParseError{rec.responseTime.start + bad, err}
+	}
+	rec.value = value
+	if bytesField > 0 {
+		return rec, rec.parseSent(line, bytesField)
+	}
+	return rec, nil
+}
+
+// parseLineSpaced - разбор для -paths-may-contain-spaces: timestamp, IP и method - первые
+// три поля, время ответа и status - два последних, а path - всё между method и status,
+// включая пробелы внутри. Пробелы по краям path к нему не относятся
+func parseLineSpaced(line []byte, times timeFormat) (lineRecord, error) {
+	var rec lineRecord
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	fail := func() (lineRecord, error) {
+		return rec, &lineParseError{len(line), fmt.Errorf("expected at least %d space-separated fields (timestamp, ip, method, path, status, response time), found %d", responseTimeField, countFields(line))}
+	}
+	end := 0
+	for _, f := range [...]*span{&rec.timestamp, &rec.ip, &rec.method} {
+		sp, ok := nextField(line, end)
+		if !ok {
+			return fail()
+		}
+		*f, end = sp, sp.end
+	}
+	rec.responseTime = prevField(line, len(line))
+	rec.status = prevField(line, rec.responseTime.start)
+	path, ok := nextField(line, rec.method.end)
+	if !ok || rec.status.start <= path.start {
+		return fail()
+	}
+	rec.path = span{path.start, rec.status.start}
+	for line[rec.path.end-1] == ' ' {
+		rec.path.end--
+	}
+
+	value, bad, err := times.millis(line[rec.responseTime.start:rec.responseTime.end])
+	if err != nil {
+		return rec, &lineParseError{rec.responseTime.start + bad, err}
+	}
+	rec.value = value
+	return rec, nil
+}
+
+// prevField - последнее поле, которое кончается не позже end; пустой span в начале
+// строки, если до end одни пробелы
+func prevField(line []byte, end int) span {
+	for end > 0 && line[end-1] == ' ' {
+		end--
+	}
+	return span{bytes.LastIndexByte(line[:end], ' ') + 1, end}
+}
+
+// countFields - сколько в строке полей через пробелы; нужно только для сообщений об ошибке
+func countFields(line []byte) int {
+	n := 0
+	for sp, ok := nextField(line, 0); ok; sp, ok = nextField(line, sp.end) {
+		n++
+	}
+	return n
+}
+
+// parseSent находит поле bytesField после времени ответа и разбирает его; "-" - ноль байт,
+// как пишет nginx для ответов без тела
+func (rec *lineRecord) parseSent(line []byte, bytesField int) error {
+	end := rec.responseTime.end
+	for field := responseTimeField + 1; field <= bytesField; field++ {
+		sp, ok := nextField(line, end)
+		if !ok {
+			return &lineParseError{len(line), fmt.Errorf("line ends before field %d (-bytes-field)", bytesField)}
+		}
+		rec.sent = sp
+		end = sp.end
+	}
+	return rec.parseSentValue(line)
+}
+
+// parseSentValue разбирает уже найденное поле rec.sent
+func (rec *lineRecord) parseSentValue(line []byte) error {
+	b := line[rec.sent.start:rec.sent.end]
+	if len(b) == 1 && b[0] == '-' {
+		return nil
+	}
+	v, err := parseIntFast(b)
+	if err != nil {
+		return &lineParseError{rec.sent.start + badDigitIndex(b), err}
+	}
+	rec.sentBytes = v
+	return nil
+}
+
+// nextField - поле, которое начинается с первого не-пробела от from и тянется до
+// следующего пробела или конца строки; ok = false, если дальше одни пробелы
+func nextField(line []byte, from int) (sp span, ok bool) {
+	for from < len(line) && line[from] == ' ' {
+		from++
+	}
+	if from == len(line) {
+		return span{}, false
+	}
+	return span{from, fieldEnd(line, from)}, true
+}
+
+// fieldEnd - конец поля, начинающегося со start: следующий пробел или конец строки
+func fieldEnd(line []byte, start int) int {
+	i := start
+	for ; i+8 <= len(line); i += 8 {
+		if m := spaceMask(line[i:]); m != 0 {
+			return i + bits.TrailingZeros64(m)/8
+		}
+	}
+	for i < len(line) && line[i] != ' ' {
+		i++
+	}
+	return i
+}
+
+// spaceMask - старшие биты байт-пробелов среди первых 8 байт b. Поля короткие, и
+// bytes.IndexByte на них дороже проверки восьми байт разом: x ^ 0x20... обнуляет
+// пробелы, а у ((x & 0x7f...) + 0x7f...) | x старший бит байта сброшен только у нуля.
+// Переносов между байтами нет, так что маска точная
+func spaceMask(b []byte) uint64 {
+	const low7, highs, spaces = 0x7f7f7f7f7f7f7f7f, 0x8080808080808080, 0x2020202020202020
+	x := binary.LittleEndian.Uint64(b) ^ spaces
+	return ^((x & low7) + low7 | x) & highs
+}
+
+// badDigitIndex - индекс первого байта, который parseIntFast не принимает, или len(b).
+// Пробелы, табуляции и \r допустимы только до и после цифр. В b из одних пробелов
+// цифр нет, и это 0; пустое b проверяет сам parseIntFast
+func badDigitIndex(b []byte) int {
+	i := 0
+	for i < len(b) && isNumberSpace(b[i]) {
+		i++
+	}
+	start := i
+	for i < len(b) && b[i] >= '0' && b[i] <= '9' {
+		i++
+	}
+	if i == start {
+		if start < len(b) {
+			return start
+		}
+		return 0
+	}
+	for j := i; j < len(b); j++ {
+		if !isNumberSpace(b[j]) {
+			return i
+		}
+	}
+	return len(b)
+}
+
+// isNumberSpace - байт, который может стоять вокруг числа: кроме пробела и табуляции
+// это \r, если строку с \r\n разбирают без обрезки
+func isNumberSpace(c byte) bool {
+	return isBlank(c) || c == '\r'
+}
+
+// numberError объясняет, почему parseIntFast не принял b
+func numberError(b []byte) error {
+	i := badDigitIndex(b)
+	switch {
+	case len(bytes.Trim(b, " \t\r")) == 0:
+		return fmt.Errorf("no digits in %q", b)
+	case i < len(b):
+		return fmt.Errorf("invalid digit %q in %q", b[i], b)
+	}
+	return nil
+}
+
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// parseIntFast разбирает десятичное число без аллокаций. Пробелы, табуляции и \r по
+// краям пропускаются; внутри числа они, как и управляющие символы, - ошибка: "1 2" не 12.
+// Пустое поле или поле из одних пробелов - тоже ошибка, а не 0
+func parseIntFast(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > maxFastDigits {
+		return parseIntChecked(b)
+	}
+	var val int64
+	// Обычно в b одни цифры; расположение остального проверяется с первым же не-цифрой
+	checked := false
+	for _, c := range b {
+		if c >= '0' && c <= '9' {
+			val = val*10 + int64(c-'0')
+			continue
+		}
+		if !checked {
+			if err := numberError(b); err != nil {
+				return 0, err
+			}
+			checked = true
+		}
+	}
+	return val, nil
+}
+
+// maxFastDigits - в столько цифр любое число помещается в int64, и parseIntFast
+// не проверяет переполнение; байты длиннее разбирает parseIntChecked
+const maxFastDigits = 18
+
+// parseIntChecked - parseIntFast для длинных и пустых b: число больше math.MaxInt64 -
+// ошибка, а не значение, которое перевалило через ноль
+func parseIntChecked(b []byte) (int64, error) {
+	if err := numberError(b); err != nil {
+		return 0, err
+	}
+	var val int64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int64(c - '0')
+		if val > (math.MaxInt64-d)/10 {
+			return 0, fmt.Errorf("number %q overflows int64", b)
+		}
+		val = val*10 + d
+	}
+	return val, nil
+}
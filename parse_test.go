@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseLineFieldWidths(t *testing.T) {
+	tests := []struct {
+		line                string
+		timestamp, ip, path string
+		value               int64
+	}{
+		{"2024-01-01T00:00:00Z 192.168.1.1 GET /api/users 200 123", "2024-01-01T00:00:00Z", "192.168.1.1", "/api/users", 123},
+		{"2024-01-01T00:00:00Z 1.2.3.4 GET /a 200 7", "2024-01-01T00:00:00Z", "1.2.3.4", "/a", 7},
+		{"2024-01-01T00:00:00Z 2001:db8:85a3::8a2e:370:7334 POST /v6 201 42", "2024-01-01T00:00:00Z", "2001:db8:85a3::8a2e:370:7334", "/v6", 42},
+		{"2024-01-01T00:00:00Z ::1 GET /local 200 1", "2024-01-01T00:00:00Z", "::1", "/local", 1},
+		{"2024-01-01T00:00:00.123456+03:00 10.0.0.1 GET /frac 200 5", "2024-01-01T00:00:00.123456+03:00", "10.0.0.1", "/frac", 5},
+		{"1704067200 10.0.0.1 GET /epoch 200 9", "1704067200", "10.0.0.1", "/epoch", 9},
+		{"2024-01-01 10.0.0.1 GET /date 200 3", "2024-01-01", "10.0.0.1", "/date", 3},
+		// Несколько пробелов подряд - один разделитель
+		{"2024-01-01T00:00:00Z  10.0.0.1   GET  /wide    200  11", "2024-01-01T00:00:00Z", "10.0.0.1", "/wide", 11},
+		{"2024-01-01T00:00:00Z 10.0.0.1 GET /crlf 200 12\r", "2024-01-01T00:00:00Z", "10.0.0.1", "/crlf", 12},
+	}
+	for _, tt := range tests {
+		rec, err := parseLine([]byte(tt.line))
+		if err != nil {
+			t.Errorf("parseLine(%q): %v", tt.line, err)
+			continue
+		}
+		line := tt.line
+		got := [3]string{line[rec.timestamp.start:rec.timestamp.end], line[rec.ip.start:rec.ip.end], line[rec.path.start:rec.path.end]}
+		if got != [3]string{tt.timestamp, tt.ip, tt.path} || rec.value != tt.value {
+			t.Errorf("parseLine(%q) = %q and %d, want %q and %d", tt.line, got, rec.value, [3]string{tt.timestamp, tt.ip, tt.path}, tt.value)
+		}
+	}
+
+	for _, bad := range []string{
+		"",
+		"2024-01-01T00:00:00Z",
+		"2024-01-01T00:00:00Z 2001:db8::1 GET /a 200",
+		"2024-01-01T00:00:00Z 2001:db8::1 GET /a 200 abc",
+	} {
+		if _, err := parseLine([]byte(bad)); err == nil {
+			t.Errorf("parseLine(%q) accepted", bad)
+		}
+	}
+}
+
+// BenchmarkParseLine - разбор строки с IP разной ширины. Пробелы ищутся словами по
+// 8 байт, так что от ширины IP и позиций полей цена почти не зависит
+func BenchmarkParseLine(b *testing.B) {
+	for name, ip := range map[string]string{
+		"ipv4-short": "1.2.3.4",
+		"ipv4":       "192.168.100.200",
+		"ipv6":       "2001:db8:85a3::8a2e:370:7334",
+	} {
+		line := []byte(fmt.Sprintf("2024-01-01T00:00:00Z %s GET /api/items/%s 200 1234", ip, strings.Repeat("x", 16)))
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(line)))
+			b.ReportAllocs()
+			for range b.N {
+				if _, err := parseLine(line); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestParseIntFastOverflow(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"123", 123, true},
+		{"999999999999999999", 999999999999999999, true},
+		{"9223372036854775807", math.MaxInt64, true},
+		{"9223372036854775808", 0, false},
+		{"9999999999999999999", 0, false},
+		{strings.Repeat("9", 40), 0, false},
+		// Ведущие нули длину не ограничивают: важна величина, а не число цифр
+		{"00000000000000000000042", 42, true},
+		{"-1", 0, false},
+		{"+1", 0, false},
+		{"-9223372036854775808", 0, false},
+		{"1e3", 0, false},
+		{"12.5", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseIntFast([]byte(tt.in))
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseIntFast(%q) = %d, %v; want %d, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMaxResponseTime(t *testing.T) {
+	path := writeTempFile(t, "big.log", "2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 100\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 60000\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 60001\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 9223372036854775808\n")
+	out, code := runAnalyzeFile(t, "-max-response-time", "60000", path)
+	if code != exitOK || !strings.Contains(out, `"max_response_time": 60000`) || !strings.Contains(out, `"malformed_lines": 2`) {
+		t.Errorf("-max-response-time 60000: exit %d\n%s", code, out)
+	}
+	// Без предела переполнение всё равно битая строка, а не отрицательное время
+	out, _ = runAnalyzeFile(t, path)
+	if !strings.Contains(out, `"max_response_time": 60001`) || !strings.Contains(out, `"malformed_lines": 1`) {
+		t.Errorf("no limit:\n%s", out)
+	}
+}
+
+func TestParseIntFastWhitespace(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"42", 42, true},
+		{" 42 ", 42, true},
+		{"\t42\t", 42, true},
+		{"42\r", 42, true},
+		{"  " + "9223372036854775807" + " ", math.MaxInt64, true},
+		{"4 2", 0, false},
+		{"4\t2", 0, false},
+		{"4\r2", 0, false},
+		{"1 2 3", 0, false},
+		{"42\n", 0, false},
+		{"4\x002", 0, false},
+		{"", 0, false},
+		{" ", 0, false},
+		{"\t\r", 0, false},
+		{strings.Repeat(" ", 30), 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseIntFast([]byte(tt.in))
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseIntFast(%q) = %d, %v; want %d, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseLineSpaced(t *testing.T) {
+	const prefix = "2024-01-01T00:00:00Z 10.0.0.1 GET "
+	tests := []struct {
+		rest, path, status string
+		value              int64
+	}{
+		{"/a 200 10", "/a", "200", 10},
+		{"/search q 200 11", "/search q", "200", 11},
+		{"/a b c/d e 404 12", "/a b c/d e", "404", 12},
+		// Пробелы подряд внутри path сохраняются как есть
+		{"/a  b   c 200 13", "/a  b   c", "200", 13},
+		// Пробелы между path и status в path не входят
+		{"/a b   200 14", "/a b", "200", 14},
+		{"/trail  500 15", "/trail", "500", 15},
+		{"/a b 200 16 ", "/a b", "200", 16},
+		{"/a b 200 17\r", "/a b", "200", 17},
+		{"/a b  200   18", "/a b", "200", 18},
+	}
+	for _, tt := range tests {
+		line := prefix + tt.rest
+		rec, err := parseLineSpaced([]byte(line), timeIntMillis)
+		if err != nil {
+			t.Errorf("parseLineSpaced(%q): %v", line, err)
+			continue
+		}
+		path, status := line[rec.path.start:rec.path.end], line[rec.status.start:rec.status.end]
+		if path != tt.path || status != tt.status || rec.value != tt.value || line[rec.method.start:rec.method.end] != "GET" {
+			t.Errorf("parseLineSpaced(%q) = path %q, status %q, %dms; want %q, %q, %dms", line, path, status, rec.value, tt.path, tt.status, tt.value)
+		}
+	}
+
+	for _, bad := range []string{
+		"",
+		"2024-01-01T00:00:00Z 10.0.0.1",
+		// Только method: ни path, ни status, ни времени
+		"2024-01-01T00:00:00Z 10.0.0.1 GET",
+		"2024-01-01T00:00:00Z 10.0.0.1 GET ",
+		// Без status последнее поле перед временем - сам path
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 10",
+		"2024-01-01T00:00:00Z 10.0.0.1 GET 200 10",
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a b 200 abc",
+	} {
+		if rec, err := parseLineSpaced([]byte(bad), timeIntMillis); err == nil {
+			t.Errorf("parseLineSpaced(%q) accepted: path %q", bad, bad[rec.path.start:rec.path.end])
+		}
+	}
+}
+
+func TestPathsMayContainSpaces(t *testing.T) {
+	path := writeTempFile(t, "spaced.log", "2024-01-01T00:00:00Z 10.0.0.1 GET /files/my report.pdf 200 10\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /files/my report.pdf  200 30\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 5\n")
+	out, code := runAnalyzeFile(t, "-include-count", "-paths-may-contain-spaces", path)
+	if code != exitOK || !strings.Contains(out, `"/files/my report.pdf": {`) || !strings.Contains(out, `"count": 2`) || !strings.Contains(out, `"malformed_lines": 0`) {
+		t.Errorf("-paths-may-contain-spaces: exit %d\n%s", code, out)
+	}
+	// Без флага в path попадает только первое слово, и строки не разбираются
+	out, _ = runAnalyzeFile(t, path)
+	if !strings.Contains(out, `"malformed_lines": 2`) {
+		t.Errorf("without -paths-may-contain-spaces:\n%s", out)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// partDebug - итог одной части файла для -debug-parts: границы, сколько разобрано и за сколько
+type partDebug struct {
+	index     int
+	offset    int64
+	size      int64
+	lines     int64
+	bytesRead int64
+	endpoints int
+	wall      time.Duration
+}
+
+// filePartsDebug - части одного файла и время, которое сборщик потратил на слияние их дельт
+type filePartsDebug struct {
+	path  string
+	parts []partDebug
+	merge time.Duration
+}
+
+// partsDebug копит отчёты частей по всем файлам; пишет в него только сборщик runPipeline
+type partsDebug struct {
+	files []filePartsDebug
+}
+
+// printPartsDebug печатает для -debug-parts по строке на часть, чтобы было видно
+// перекос в размерах частей и отстающего воркера
+func printPartsDebug(w io.Writer, d *partsDebug) {
+	for _, f := range d.files {
+		fmt.Fprintf(w, "parts of %s:\n", f.path)
+		fmt.Fprintf(w, "  %4s  %12s  %10s  %12s  %9s  %10s  %10s\n", "PART", "OFFSET", "SIZE", "LINES", "ENDPOINTS", "WALL", "MB/S")
+		for _, p := range f.parts {
+			rate := 0.0
+			if secs := p.wall.Seconds(); secs > 0 {
+				rate = float64(p.bytesRead) / secs / (1 << 20)
+			}
+			fmt.Fprintf(w, "  %4d  %12d  %10s  %12d  %9d  %10v  %10.1f\n",
+				p.index+1, p.offset, humanBytes(p.size), p.lines, p.endpoints, p.wall.Round(time.Microsecond), rate)
+		}
+		fmt.Fprintf(w, "  merge: %v\n", f.merge.Round(time.Microsecond))
+	}
+}
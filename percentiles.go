@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// percentile - квантиль из -percentiles; label - как его написали (50, 99.9), q - доля 0..1
+type percentile struct {
+	label string
+	q     float64
+}
+
+// name - имя квантиля в выводе: p50, p99_9 (точка в именах полей и колонок мешает)
+func (p percentile) name() string {
+	return "p" + strings.ReplaceAll(p.label, ".", "_")
+}
+
+// percentileList - значение флага -percentiles: 50,90,95,99
+type percentileList []percentile
+
+func (l percentileList) String() string {
+	labels := make([]string, len(l))
+	for i, p := range l {
+		labels[i] = p.label
+	}
+	return strings.Join(labels, ",")
+}
+
+func (l *percentileList) Set(s string) error {
+	var list percentileList
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(item, 64)
+		if err != nil || v <= 0 || v > 100 {
+			return fmt.Errorf("invalid percentile %q: expected a number in (0, 100]", item)
+		}
+		p := percentile{label: strconv.FormatFloat(v, 'f', -1, 64), q: v / 100}
+		if seen[p.label] {
+			return fmt.Errorf("percentile %s specified twice", p.label)
+		}
+		seen[p.label] = true
+		list = append(list, p)
+	}
+	*l = list
+	return nil
+}
+
+// quantileSpec - какие квантили печатать и по какому отображению читать скетчи
+type quantileSpec struct {
+	percentiles percentileList
+	mapping     *sketchMapping
+	// trim - -trimmed-mean в процентах; 0 - trimmed_avg не печатается
+	trim float64
+}
+
+// names - имена значений values: p50, p99_9 и trimmed_avg для -trimmed-mean
+func (q *quantileSpec) names() []string {
+	if q == nil {
+		return nil
+	}
+	names := make([]string, 0, len(q.percentiles)+1)
+	for _, p := range q.percentiles {
+		names = append(names, p.name())
+	}
+	if q.trim > 0 {
+		names = append(names, "trimmed_avg")
+	}
+	return names
+}
+
+// fieldNames - поля квантилей в документе JSON/YAML: p50_response_time, ..., trimmed_avg
+func (q *quantileSpec) fieldNames() []string {
+	if q == nil {
+		return nil
+	}
+	names := q.names()
+	for i := range q.percentiles {
+		names[i] += "_response_time"
+	}
+	return names
+}
+
+// columns - заголовки колонок квантилей: names() через caseFn (P50 для table, p50 для markdown)
+func (q *quantileSpec) columns(caseFn func(string) string) []string {
+	if q == nil {
+		return nil
+	}
+	cols := q.names()
+	for i, name := range cols {
+		cols[i] = caseFn(name)
+	}
+	return cols
+}
+
+// formatted - values(s), отформатированные в unit; для Stats без скетча - пустые строки
+func (q *quantileSpec) formatted(s *Stats, unit timeUnit, prec int) []string {
+	if q == nil {
+		return nil
+	}
+	cells := make([]string, len(q.names()))
+	for i, v := range q.values(s) {
+		cells[i] = unit.formatMs(v, prec)
+	}
+	return cells
+}
+
+// values - оценки квантилей s в миллисекундах в порядке percentiles, за ними trimmed_avg;
+// nil, если скетча нет (например, у merge, который сводит готовые отчёты). Оценка не
+// выходит за точные min и max, которые Stats знает и так
+func (q *quantileSpec) values(s *Stats) []float64 {
+	if q == nil || s == nil || s.extra == nil || (s.extra.sketch == nil && s.extra.exact == nil) {
+		return nil
+	}
+	out := make([]float64, 0, len(q.percentiles)+1)
+	for _, p := range q.percentiles {
+		var v float64
+		if x := s.extra; x.exact != nil {
+			v = x.exact.quantile(q.mapping, p.q, s.Count)
+		} else {
+			v = x.sketch.quantile(q.mapping, p.q, s.Count)
+		}
+		out = append(out, min(max(v, float64(s.Min)), float64(s.Max)))
+	}
+	if q.trim > 0 {
+		out = append(out, q.trimmedMean(s))
+	}
+	return out
+}
+
+// each передаёт fn значения распределения s по возрастанию с числом повторов: точные
+// значения гистограммы -exact-percentiles или оценки корзин скетча. Оценки, как и у
+// квантилей, не выходят за точные min и max. У s должно быть распределение (см. values)
+func (q *quantileSpec) each(s *Stats, fn func(v float64, c int64)) {
+	lo, hi := float64(s.Min), float64(s.Max)
+	clamped := func(v float64, c int64) { fn(min(max(v, lo), hi), c) }
+	x := s.extra
+	if x.exact == nil {
+		x.sketch.each(q.mapping, clamped)
+		return
+	}
+	for v, c := range x.exact.counts {
+		if c != 0 {
+			fn(float64(v), int64(c))
+		}
+	}
+	if x.exact.overflow != nil {
+		x.exact.overflow.each(q.mapping, clamped)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	httppprof "net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"slices"
+	"strings"
+	"time"
+)
+
+var profileKinds = []string{"cpu", "heap", "trace", "block", "mutex"}
+
+// profileSpec - значение флага -profile: cpu=/tmp/cpu.prof,heap=/tmp/heap.prof,...
+type profileSpec map[string]string
+
+func (p profileSpec) String() string {
+	items := make([]string, 0, len(p))
+	for _, kind := range profileKinds {
+		if path, ok := p[kind]; ok {
+			items = append(items, kind+"="+path)
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+func (p profileSpec) Set(s string) error {
+	for _, item := range strings.Split(s, ",") {
+		if item == "" {
+			continue
+		}
+		kind, path, ok := strings.Cut(item, "=")
+		if !ok || path == "" {
+			return fmt.Errorf("invalid profile %q: expected kind=path", item)
+		}
+		if !slices.Contains(profileKinds, kind) {
+			return fmt.Errorf("unknown profile kind %q: expected one of %s", kind, strings.Join(profileKinds, ", "))
+		}
+		if _, dup := p[kind]; dup {
+			return fmt.Errorf("profile %q specified twice", kind)
+		}
+		p[kind] = path
+	}
+	return nil
+}
+
+// applyProfileEnv поддерживает устаревшие переменные CPU_PROFILE и MEM_PROFILE,
+// если соответствующий профиль не задан через -profile
+func applyProfileEnv(p profileSpec) {
+	for kind, env := range map[string]string{"cpu": "CPU_PROFILE", "heap": "MEM_PROFILE"} {
+		path := os.Getenv(env)
+		if path == "" {
+			continue
+		}
+		if _, ok := p[kind]; ok {
+			continue
+		}
+		logger.warnf("%s is deprecated, use -profile %s=%s", env, kind, path)
+		p[kind] = path
+	}
+}
+
+// profiler держит запущенные профили; stop сбрасывает их на диск
+type profiler struct {
+	stops []func() error
+}
+
+// startProfiles создаёт файлы всех профилей сразу, чтобы ошибки всплывали до начала обработки.
+// cpu и trace пишутся всё время работы, heap/block/mutex снимаются в stop
+func startProfiles(spec profileSpec, blockRate, mutexFraction int) (*profiler, error) {
+	p := &profiler{}
+	for _, kind := range profileKinds {
+		path, ok := spec[kind]
+		if !ok {
+			continue
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			p.stop()
+			return nil, fmt.Errorf("error creating %s profile: %w", kind, err)
+		}
+
+		var stop func() error
+		switch kind {
+		case "cpu":
+			if err := pprof.StartCPUProfile(f); err != nil {
+				f.Close()
+				p.stop()
+				return nil, fmt.Errorf("error starting CPU profile: %w", err)
+			}
+			stop = func() error {
+				pprof.StopCPUProfile()
+				return nil
+			}
+		case "trace":
+			if err := trace.Start(f); err != nil {
+				f.Close()
+				p.stop()
+				return nil, fmt.Errorf("error starting trace: %w", err)
+			}
+			stop = func() error {
+				trace.Stop()
+				return nil
+			}
+		case "heap":
+			stop = func() error {
+				runtime.GC()
+				return pprof.Lookup("heap").WriteTo(f, 0)
+			}
+		case "block":
+			runtime.SetBlockProfileRate(blockRate)
+			stop = func() error {
+				return pprof.Lookup("block").WriteTo(f, 0)
+			}
+		case "mutex":
+			runtime.SetMutexProfileFraction(mutexFraction)
+			stop = func() error {
+				return pprof.Lookup("mutex").WriteTo(f, 0)
+			}
+		}
+
+		p.stops = append(p.stops, func() error {
+			err := stop()
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return fmt.Errorf("error writing %s profile: %w", kind, err)
+			}
+			return nil
+		})
+	}
+	return p, nil
+}
+
+// stop останавливает профили в обратном порядке; безопасно вызывать повторно
+func (p *profiler) stop() error {
+	var errs []error
+	for i := len(p.stops) - 1; i >= 0; i-- {
+		errs = append(errs, p.stops[i]())
+	}
+	p.stops = nil
+	return errors.Join(errs...)
+}
+
+// pprofShutdownTimeout - сколько ждём завершения запросов к -pprof-addr при выходе
+const pprofShutdownTimeout = 5 * time.Second
+
+// serveHTTP поднимает net/http/pprof на addr, пока идёт обработка; stop его гасит.
+// Порт занимаем сразу, чтобы занятый адрес был ошибкой запуска, а не строчкой в логе
+func (p *profiler) serveHTTP(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error starting pprof server: %w", err)
+	}
+
+	// Свой mux, а не DefaultServeMux: отдаём только отладочные обработчики
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", httppprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", httppprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", httppprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", httppprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", httppprof.Trace)
+	srv := &http.Server{Handler: mux}
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ln) }()
+	logger.infof("pprof server listening on http://%s/debug/pprof/", ln.Addr())
+
+	p.stops = append(p.stops, func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), pprofShutdownTimeout)
+		defer cancel()
+		err := srv.Shutdown(ctx)
+		if serr := <-done; !errors.Is(serr, http.ErrServerClosed) && err == nil {
+			err = serr
+		}
+		if err != nil {
+			return fmt.Errorf("error stopping pprof server: %w", err)
+		}
+		return nil
+	})
+	return nil
+}
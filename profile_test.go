@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestRunMainHelper - не тест сам по себе: в нём запускают настоящий процесс, чтобы
+// проверить то, что происходит после os.Exit. Аргументы идут после "--"
+func TestRunMainHelper(t *testing.T) {
+	if os.Getenv("IW_TEST_RUN_MAIN") != "1" {
+		t.Skip("helper process")
+	}
+	args := os.Args
+	for i, a := range args {
+		if a == "--" {
+			args = args[i+1:]
+			break
+		}
+	}
+	os.Exit(runCommand(args))
+}
+
+// runMainProcess запускает бинарник теста как iw_challenge с env и args
+func runMainProcess(t *testing.T, env []string, args ...string) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestRunMainHelper$", "--"}, args...)...)
+	cmd.Env = append(append(os.Environ(), "IW_TEST_RUN_MAIN=1"), env...)
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0
+}
+
+// checkProfile проверяет, что в path целый профиль: pprof пишет gzip, и оборванный
+// на выходе файл не дочитывается до конца потока
+func checkProfile(t *testing.T, path string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("profile not written: %v", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("%s is truncated: %v", path, err)
+	}
+	if len(body) == 0 {
+		t.Fatalf("%s is empty", path)
+	}
+}
+
+func TestCPUProfileEnvPath(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "a.log")
+	if err := os.WriteFile(log, []byte("2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 12\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cpu, mem := filepath.Join(dir, "run1.prof"), filepath.Join(dir, "mem.prof")
+	env := []string{"CPU_PROFILE=" + cpu, "MEM_PROFILE=" + mem}
+	if code := runMainProcess(t, env, "-q", "-o", filepath.Join(dir, "out.json"), log); code != exitOK {
+		t.Fatalf("exit %d", code)
+	}
+	checkProfile(t, cpu)
+	checkProfile(t, mem)
+	if _, err := os.Stat("cpu.prof"); err == nil {
+		t.Error("profile written to ./cpu.prof instead of CPU_PROFILE")
+	}
+}
+
+func TestProfileFlushedOnErrorExit(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "bad.log")
+	if err := os.WriteFile(log, []byte("not a log line\n2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 12\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cpu := filepath.Join(dir, "cpu.prof")
+	code := runMainProcess(t, nil, "-q", "-profile", "cpu="+cpu, "-max-error-rate", "0.1", "-o", filepath.Join(dir, "out.json"), log)
+	if code != exitErrorRate {
+		t.Fatalf("exit %d, want %d", code, exitErrorRate)
+	}
+	checkProfile(t, cpu)
+}
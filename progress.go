@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressReporter раз в секунду печатает в stderr, сколько байт обработали воркеры.
+// Если stderr не терминал, вместо обновляемой строки печатается строка на каждые 10%
+type progressReporter struct {
+	total int64
+	tty   bool
+	start time.Time
+	done  atomic.Int64
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+	// lastStep - последний напечатанный без терминала десяток процентов; stepMu держат,
+	// пока печатают следующие, чтобы строки от разных воркеров не перемешались
+	stepMu   sync.Mutex
+	lastStep atomic.Int64
+}
+
+// Как часто перерисовывать строку прогресса
+const progressInterval = time.Second
+
+func newProgressReporter(total int64) *progressReporter {
+	return &progressReporter{total: total, tty: isTerminal(os.Stderr)}
+}
+
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// begin запускает отрисовку; при обработке нескольких файлов повторные вызовы ничего не делают
+func (p *progressReporter) begin() {
+	if p == nil || p.stopCh != nil {
+		return
+	}
+	p.start = time.Now()
+	p.stopCh = make(chan struct{})
+	// Без терминала строки печатает add, когда перейдён очередной десяток процентов
+	if !p.tty {
+		return
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.print(p.done.Load())
+			case <-p.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// add вызывается воркером после каждой прочитанной пачки; пачки большие, так что
+// общий счётчик на всех воркеров не становится узким местом
+func (p *progressReporter) add(n int64) {
+	if p == nil {
+		return
+	}
+	done := p.done.Add(n)
+	if !p.tty {
+		p.printSteps(done)
+	}
+}
+
+// printSteps печатает по строке на каждый десяток процентов, который перешёл done,
+// включая 100%. Одна пачка может перешагнуть несколько десятков: пропущенные
+// печатаются с байтами своего порога, последний - с done
+func (p *progressReporter) printSteps(done int64) {
+	if p.total <= 0 {
+		return
+	}
+	step := min(done*10/p.total, 10)
+	if step <= p.lastStep.Load() {
+		return
+	}
+	p.stepMu.Lock()
+	defer p.stepMu.Unlock()
+	last := p.lastStep.Load()
+	if step <= last {
+		return
+	}
+	for s := last + 1; s < step; s++ {
+		p.print(p.total * s / 10)
+	}
+	p.print(done)
+	p.lastStep.Store(step)
+}
+
+// finish останавливает отрисовку и стирает строку прогресса, чтобы не смешивать её с результатом
+func (p *progressReporter) finish() {
+	if p == nil || p.stopCh == nil {
+		return
+	}
+	close(p.stopCh)
+	p.wg.Wait()
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if logger.statusLine {
+		fmt.Fprint(logger.w, "\r\033[K")
+		logger.statusLine = false
+	}
+}
+
+// print выводит строку прогресса для done обработанных байт: в терминале поверх
+// предыдущей, иначе отдельной строкой
+func (p *progressReporter) print(done int64) {
+	pct := 100.0
+	if p.total > 0 {
+		pct = float64(done) * 100 / float64(p.total)
+	}
+
+	elapsed := time.Since(p.start).Seconds()
+	speed := float64(done) / elapsed
+	eta := "?"
+	if speed > 0 {
+		eta = time.Duration(float64(p.total-done) / speed * float64(time.Second)).Round(time.Second).String()
+	}
+	line := fmt.Sprintf("%s / %s (%.1f%%) %.1f MB/s ETA %s",
+		humanBytes(done), humanBytes(p.total), pct, speed/(1<<20), eta)
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if p.tty {
+		fmt.Fprint(logger.w, "\r\033[K"+line)
+		logger.statusLine = true
+	} else {
+		fmt.Fprintln(logger.w, line)
+	}
+}
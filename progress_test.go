@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// progressSteps - проценты строк прогресса, которые напечатал logger
+func progressSteps(t *testing.T, out string) []string {
+	t.Helper()
+	var steps []string
+	for _, m := range regexp.MustCompile(`\((\d+\.\d)%\)`).FindAllStringSubmatch(out, -1) {
+		steps = append(steps, m[1])
+	}
+	return steps
+}
+
+func captureLogger(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	w, level := logger.w, logger.level
+	logger.w, logger.level = &buf, levelWarn
+	t.Cleanup(func() { logger.w, logger.level = w, level })
+	return &buf
+}
+
+func TestProgressEveryStep(t *testing.T) {
+	all := []string{"10.0", "20.0", "30.0", "40.0", "50.0", "60.0", "70.0", "80.0", "90.0", "100.0"}
+	for _, tt := range []struct {
+		name   string
+		chunks []int64
+		want   []string
+	}{
+		{"small chunks", []int64{50, 50, 50, 50, 50, 50, 50, 50, 50, 50, 50, 50, 50, 50, 50, 50, 50, 50, 50, 50}, all},
+		// Пачка через несколько десятков сразу печатает каждый из них
+		{"one chunk", []int64{1000}, all},
+		{"jumps", []int64{350, 10, 500, 140}, []string{"10.0", "20.0", "35.0", "40.0", "50.0", "60.0", "70.0", "86.0", "90.0", "100.0"}},
+		{"unfinished", []int64{120, 700}, []string{"12.0", "20.0", "30.0", "40.0", "50.0", "60.0", "70.0", "82.0"}},
+	} {
+		buf := captureLogger(t)
+		p := &progressReporter{total: 1000}
+		p.begin()
+		for _, n := range tt.chunks {
+			p.add(n)
+		}
+		p.finish()
+		if got := progressSteps(t, buf.String()); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: printed %v, want %v\n%s", tt.name, got, tt.want, buf)
+		}
+	}
+}
+
+func TestProgressConcurrentWorkers(t *testing.T) {
+	buf := captureLogger(t)
+	p := &progressReporter{total: 8000}
+	p.begin()
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				p.add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	p.finish()
+	// Каждый десяток ровно один раз и по порядку, хотя add зовут восемь воркеров
+	want := "10.0 20.0 30.0 40.0 50.0 60.0 70.0 80.0 90.0 100.0"
+	if got := strings.Join(progressSteps(t, buf.String()), " "); got != want {
+		t.Errorf("printed %s, want %s\n%s", got, want, buf)
+	}
+}
+
+func TestProgressTerminalTicks(t *testing.T) {
+	buf := captureLogger(t)
+	p := &progressReporter{total: 1000, tty: true}
+	// В терминале строку рисует только таймер, add сам ничего не печатает. Буфер
+	// проверяется до begin: потом в него пишет горутина таймера
+	p.add(500)
+	if buf.Len() != 0 {
+		t.Errorf("add printed on a terminal: %q", buf)
+	}
+	p.begin()
+	time.Sleep(progressInterval + progressInterval/2)
+	p.finish()
+	if out := buf.String(); !strings.Contains(out, "(50.0%)") || !strings.HasSuffix(out, "\r\033[K") {
+		t.Errorf("terminal output %q", out)
+	}
+}
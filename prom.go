@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Имена метрик в формате Prometheus: [a-zA-Z_:][a-zA-Z0-9_:]*
+var metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// Название единицы для имени метрики: endpoint_response_time_milliseconds
+var promUnitNames = map[string]string{"ms": "milliseconds", "s": "seconds", "us": "microseconds"}
+
+// promRenderer пишет отчёт в текстовом формате Prometheus для textfile-коллектора node_exporter
+type promRenderer struct {
+	prefix    string
+	precision int
+	unit      timeUnit
+	// buckets - границы -buckets; с ними добавляется метрика-гистограмма
+	buckets bucketList
+}
+
+// writeHistograms пишет -buckets как histogram: _bucket с накопительными le, _sum и _count.
+// Отдельное имя, потому что у endpoint_response_time уже есть gauge с меткой stat
+func (r promRenderer) writeHistograms(w io.Writer, rep *report) {
+	name := r.prefix + "endpoint_request_duration_" + promUnitNames[r.unit.name]
+	fmt.Fprintf(w, "# HELP %s Histogram of response times per endpoint.\n", name)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for _, e := range rep.entries {
+		x := e.stats.extra
+		if x == nil || x.buckets == nil {
+			continue
+		}
+		label := promLabelValue(e.name)
+		for i, c := range cumulative(x.buckets) {
+			fmt.Fprintf(w, "%s_bucket{endpoint=\"%s\",le=\"%s\"} %d\n", name, label, r.buckets.label(i, r.unit), c)
+		}
+		fmt.Fprintf(w, "%s_sum{endpoint=\"%s\"} %s\n", name, label, r.unit.formatExact(e.stats.Sum))
+		fmt.Fprintf(w, "%s_count{endpoint=\"%s\"} %d\n", name, label, e.stats.Count)
+	}
+}
+
+func (r promRenderer) render(w io.Writer, rep *report) error {
+	timeName := r.prefix + "endpoint_response_time_" + promUnitNames[r.unit.name]
+	fmt.Fprintf(w, "# HELP %s Response time per endpoint, stat is min, avg or max.\n", timeName)
+	// gauge, а не counter: каждый прогон описывает свой файл и значения не монотонны
+	fmt.Fprintf(w, "# TYPE %s gauge\n", timeName)
+	for _, e := range rep.entries {
+		label := promLabelValue(e.name)
+		fmt.Fprintf(w, "%s{endpoint=\"%s\",stat=\"min\"} %s\n", timeName, label, r.unit.formatValue(e.stats.Min, r.precision))
+		fmt.Fprintf(w, "%s{endpoint=\"%s\",stat=\"avg\"} %s\n", timeName, label, r.unit.formatAvg(e.stats, r.precision))
+		fmt.Fprintf(w, "%s{endpoint=\"%s\",stat=\"max\"} %s\n", timeName, label, r.unit.formatValue(e.stats.Max, r.precision))
+	}
+
+	// Имя с _total по правилам Prometheus - counter: число запросов за прогон только
+	// растёт вместе с логом, и rate() по нему осмыслен
+	countName := r.prefix + "endpoint_requests_total"
+	fmt.Fprintf(w, "# HELP %s Number of requests per endpoint.\n", countName)
+	fmt.Fprintf(w, "# TYPE %s counter\n", countName)
+	for _, e := range rep.entries {
+		fmt.Fprintf(w, "%s{endpoint=\"%s\"} %d\n", countName, promLabelValue(e.name), e.stats.Count)
+	}
+	if r.buckets != nil {
+		r.writeHistograms(w, rep)
+	}
+	if rep.partial {
+		_, err := fmt.Fprintf(w, "# result is partial: -timeout was exceeded\n")
+		return err
+	}
+	return nil
+}
+
+var promLabelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabelValue экранирует значение метки: обратный слэш, кавычку и перевод строки.
+// Значение обязано быть UTF-8, невалидные байты заменяем на U+FFFD, как и в JSON
+func promLabelValue(s string) string {
+	return promLabelReplacer.Replace(strings.ToValidUTF8(s, "\uFFFD"))
+}
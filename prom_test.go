@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+func parseProm(t *testing.T, out string) map[string]*dto.MetricFamily {
+	t.Helper()
+	parser := expfmt.NewTextParser(model.LegacyValidation)
+	families, err := parser.TextToMetricFamilies(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("output does not parse: %v\n%s", err, out)
+	}
+	return families
+}
+
+func labelOf(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+func TestPromOutputParses(t *testing.T) {
+	path := writeTempFile(t, "prom.log", "2024-01-01T00:00:00Z 10.0.0.1 GET /api/users 200 12\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /api/users 200 30\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /q?a=\"x\"\\y 200 7\n")
+	out, code := runAnalyzeFile(t, "-format", "prom", "-metric-prefix", "iw_", "-buckets", "10,20", path)
+	if code != exitOK {
+		t.Fatalf("exit %d", code)
+	}
+	families := parseProm(t, out)
+
+	times := families["iw_endpoint_response_time_milliseconds"]
+	if times.GetType() != dto.MetricType_GAUGE {
+		t.Errorf("response time type = %v, want gauge", times.GetType())
+	}
+	got := map[string]float64{}
+	for _, m := range times.GetMetric() {
+		got[labelOf(m, "endpoint")+" "+labelOf(m, "stat")] = m.GetGauge().GetValue()
+	}
+	want := map[string]float64{
+		"/api/users min": 12, "/api/users avg": 21, "/api/users max": 30,
+		`/q?a="x"\y min`: 7, `/q?a="x"\y avg`: 7, `/q?a="x"\y max`: 7,
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+
+	requests := families["iw_endpoint_requests_total"]
+	if requests.GetType() != dto.MetricType_COUNTER {
+		t.Errorf("requests type = %v, want counter", requests.GetType())
+	}
+	for _, m := range requests.GetMetric() {
+		if labelOf(m, "endpoint") == "/api/users" && m.GetCounter().GetValue() != 2 {
+			t.Errorf("/api/users requests = %v, want 2", m.GetCounter().GetValue())
+		}
+	}
+
+	hist := families["iw_endpoint_request_duration_milliseconds"]
+	if hist.GetType() != dto.MetricType_HISTOGRAM {
+		t.Fatalf("histogram type = %v", hist.GetType())
+	}
+	for _, m := range hist.GetMetric() {
+		if labelOf(m, "endpoint") != "/api/users" {
+			continue
+		}
+		h := m.GetHistogram()
+		if h.GetSampleCount() != 2 || h.GetSampleSum() != 42 {
+			t.Errorf("histogram count %d sum %v, want 2 and 42", h.GetSampleCount(), h.GetSampleSum())
+		}
+		// 12 и 30: ни одного <= 10, один <= 20
+		if b := h.GetBucket(); len(b) < 2 || b[0].GetCumulativeCount() != 0 || b[1].GetCumulativeCount() != 1 {
+			t.Errorf("buckets = %v", b)
+		}
+	}
+}
+
+func TestPromLabelEscaping(t *testing.T) {
+	for in, want := range map[string]string{
+		`a\b`:     `a\\b`,
+		`a"b`:     `a\"b`,
+		"a\nb":    `a\nb`,
+		"bad\xff": "bad�",
+	} {
+		if got := promLabelValue(in); got != want {
+			t.Errorf("promLabelValue(%q) = %q, want %q", in, got, want)
+		}
+	}
+	// Перевод строки в метке не должен ломать разбор
+	out := "# TYPE x gauge\nx{endpoint=\"" + promLabelValue("a\nb\\\"") + "\"} 1\n"
+	m := parseProm(t, out)["x"].GetMetric()[0]
+	if got := labelOf(m, "endpoint"); got != "a\nb\\\"" {
+		t.Errorf("label round trip = %q", got)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+)
+
+// printRunStats печатает сводку прогона для -stats; пишется в stderr, чтобы не мешать результату
+func printRunStats(w io.Writer, elapsed time.Duration, res *pipelineResult, aliases bool) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	secs := elapsed.Seconds()
+	rate := func(v int64) float64 {
+		if secs == 0 {
+			return 0
+		}
+		return float64(v) / secs
+	}
+
+	fmt.Fprintf(w, "elapsed:           %v\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "bytes read:        %d (%s)\n", res.bytesRead, humanBytes(res.bytesRead))
+	fmt.Fprintf(w, "lines parsed:      %d\n", res.counters.lines)
+	fmt.Fprintf(w, "malformed skipped: %d (%.4f%% of lines)\n", res.counters.malformed, res.counters.errorRate()*100)
+	fmt.Fprintf(w, "headers skipped:   %d, comments skipped: %d\n", res.counters.headers, res.counters.comments)
+	fmt.Fprintf(w, "oversize skipped:  %d (longer than -max-line-length, counted as malformed)\n", res.counters.oversize)
+	if res.rawKeys != nil {
+		// До переписывания path ключей может быть на порядки больше, поэтому их только оценивают
+		fmt.Fprintf(w, "unique endpoints:  %d (about %d before paths were rewritten)\n", len(res.totals), res.rawKeys.estimate())
+	} else {
+		fmt.Fprintf(w, "unique endpoints:  %d\n", len(res.totals))
+	}
+	if aliases {
+		fmt.Fprintf(w, "aliased requests:  %d\n", res.counters.aliased)
+	}
+	fmt.Fprintf(w, "throughput:        %.1f MB/s, %.0f lines/s\n", rate(res.bytesRead)/(1<<20), rate(res.counters.lines))
+	// HeapSys - память, полученная кучей от ОС; рантайм почти не отдаёт её обратно, так что это оценка пика
+	fmt.Fprintf(w, "peak heap:         %s\n", humanBytes(int64(mem.HeapSys)))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"math"
+)
+
+// Скетч - DDSketch: корзина i хранит значения из (gamma^(i-1), gamma^i], так что любой
+// квантиль оценивается с относительной ошибкой не больше accuracy. Если корзин не хватает,
+// соседние корзины попарно сливаются (gamma возводится в квадрат, как в UDDSketch): точность
+// падает для всех квантилей одинаково, а не теряются нижние. Сливаются скетчи сложением
+// корзин на общем уровне, поэтому порядок воркеров на результат не влияет
+
+const (
+	defaultSketchAccuracy = 0.02
+	maxSketchAccuracy     = 0.5
+	// maxSketchBins ограничивает память скетча: 256 корзин по 4 байта - 1KB на эндпоинт.
+	// При accuracy 2% это времена от 1 до 28000ms с полной точностью; разброс шире - со слиянием корзин
+	maxSketchBins = 256
+	// Индексы значений до smallSketchValues считаются заранее, без логарифма на каждой строке
+	smallSketchValues = 4096
+)
+
+// sketchMapping переводит значения в индексы корзин и обратно. Он общий для всех
+// скетчей прогона: сливать можно только скетчи с одинаковым gamma
+type sketchMapping struct {
+	gamma    float64
+	logGamma float64
+	small    []int32
+}
+
+func newSketchMapping(accuracy float64) *sketchMapping {
+	gamma := (1 + accuracy) / (1 - accuracy)
+	m := &sketchMapping{gamma: gamma, logGamma: math.Log(gamma), small: make([]int32, smallSketchValues)}
+	for v := 1; v < smallSketchValues; v++ {
+		m.small[v] = m.compute(int64(v))
+	}
+	return m
+}
+
+func (m *sketchMapping) compute(v int64) int32 {
+	return int32(math.Ceil(math.Log(float64(v)) / m.logGamma))
+}
+
+// index - корзина значения v >= 1 на нулевом уровне
+func (m *sketchMapping) index(v int64) int32 {
+	if v < smallSketchValues {
+		return m.small[v]
+	}
+	return m.compute(v)
+}
+
+// value - оценка значений корзины i уровня level: середина (g^(i-1), g^i] в смысле
+// относительной ошибки, где g = gamma^(2^level)
+func (m *sketchMapping) value(i int32, level uint8) float64 {
+	logG := m.logGamma * float64(uint64(1)<<level)
+	return 2 * math.Exp(float64(i)*logG) / (1 + math.Exp(logG))
+}
+
+// coarser переводит индекс корзины на shift уровней вверх: ceil(i / 2^shift).
+// Индексы не отрицательны, значения меньше 1 в корзины не попадают
+func coarser(i int32, shift uint8) int32 {
+	return int32((int64(i) + int64(1)<<shift - 1) >> shift)
+}
+
+// sketch - счётчики корзин одного эндпоинта. Нули (и отрицательные значения) считаются
+// отдельно: логарифма у них нет. Корзины 32-битные ради памяти; что не поместилось,
+// уходит в carry по абсолютному индексу корзины - по 2^32 значений за единицу
+type sketch struct {
+	zero   int64
+	level  uint8
+	offset int32
+	bins   []uint32
+	carry  map[int32]uint64
+}
+
+func (s *sketch) add(m *sketchMapping, v int64) {
+	if v <= 0 {
+		s.zero++
+		return
+	}
+	s.addAt(coarser(m.index(v), s.level), 1)
+}
+
+// count - сколько значений в корзине с абсолютным индексом i
+func (s *sketch) count(i int32) uint64 {
+	return uint64(s.bins[i-s.offset]) + s.carry[i]<<32
+}
+
+// addAt добавляет n значений в корзину i текущего уровня. Если с ней корзин станет
+// больше maxSketchBins, скетч сначала огрубляется
+func (s *sketch) addAt(i int32, n uint64) {
+	if len(s.bins) == 0 {
+		s.offset = i
+		s.bins = append(s.bins, 0)
+	}
+	for max(i, s.offset+int32(len(s.bins))-1)-min(i, s.offset) >= maxSketchBins {
+		s.coarsen()
+		i = coarser(i, 1)
+	}
+	if i < s.offset {
+		grown := make([]uint32, len(s.bins)+int(s.offset-i))
+		copy(grown[s.offset-i:], s.bins)
+		s.offset, s.bins = i, grown
+	}
+	for hi := s.offset + int32(len(s.bins)) - 1; hi < i; hi++ {
+		s.bins = append(s.bins, 0)
+	}
+	s.put(i, s.count(i)+n)
+}
+
+// put записывает в корзину i (внутри диапазона) значение count
+func (s *sketch) put(i int32, count uint64) {
+	s.bins[i-s.offset] = uint32(count)
+	if high := count >> 32; high != 0 {
+		if s.carry == nil {
+			s.carry = make(map[int32]uint64)
+		}
+		s.carry[i] = high
+	} else if s.carry != nil {
+		delete(s.carry, i)
+	}
+}
+
+// coarsen поднимает скетч на уровень: корзины 2k-1 и 2k сливаются в k
+func (s *sketch) coarsen() {
+	if len(s.bins) == 0 {
+		s.level++
+		return
+	}
+	old, oldOffset, oldCarry := s.bins, s.offset, s.carry
+	count := func(i int32) uint64 { return uint64(old[i-oldOffset]) + oldCarry[i]<<32 }
+	hi := coarser(oldOffset+int32(len(old))-1, 1)
+	s.level++
+	s.offset = coarser(oldOffset, 1)
+	s.bins = make([]uint32, hi-s.offset+1)
+	s.carry = nil
+	for j := range old {
+		i := oldOffset + int32(j)
+		k := coarser(i, 1)
+		s.put(k, s.count(k)+count(i))
+	}
+}
+
+// merge добавляет к s корзины o; скетчи приводятся к старшему из двух уровней
+func (s *sketch) merge(o *sketch) {
+	s.zero += o.zero
+	for s.level < o.level {
+		s.coarsen()
+	}
+	// Сначала старшую корзину, чтобы младшие сразу легли в окончательный диапазон.
+	// Уровень s может подняться по ходу, поэтому индекс o переводится заново
+	for j := len(o.bins) - 1; j >= 0; j-- {
+		i := o.offset + int32(j)
+		if c := o.count(i); c != 0 {
+			s.addAt(coarser(i, s.level-o.level), c)
+		}
+	}
+}
+
+func (s *sketch) clone() *sketch {
+	c := *s
+	c.bins = append([]uint32(nil), s.bins...)
+	if s.carry != nil {
+		c.carry = make(map[int32]uint64, len(s.carry))
+		for i, v := range s.carry {
+			c.carry[i] = v
+		}
+	}
+	return &c
+}
+
+// quantile оценивает квантиль q (0..1) для count значений в миллисекундах
+func (s *sketch) quantile(m *sketchMapping, q float64, count int64) float64 {
+	return s.atRank(m, q*float64(count-1))
+}
+
+// atRank оценивает значение с номером rank (с нуля) в порядке возрастания
+func (s *sketch) atRank(m *sketchMapping, rank float64) float64 {
+	cum := float64(s.zero)
+	if cum > rank || len(s.bins) == 0 {
+		return 0
+	}
+	for j := range s.bins {
+		i := s.offset + int32(j)
+		cum += float64(s.count(i))
+		if cum > rank {
+			return m.value(i, s.level)
+		}
+	}
+	return m.value(s.offset+int32(len(s.bins))-1, s.level)
+}
+
+// each передаёт fn оценку значений и число значений каждой непустой корзины по
+// возрастанию, начиная с нулей
+func (s *sketch) each(m *sketchMapping, fn func(v float64, c int64)) {
+	if s.zero != 0 {
+		fn(0, s.zero)
+	}
+	for j := range s.bins {
+		i := s.offset + int32(j)
+		if c := s.count(i); c != 0 {
+			fn(m.value(i, s.level), int64(c))
+		}
+	}
+}
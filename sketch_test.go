@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// sketchOf - скетч значений values
+func sketchOf(m *sketchMapping, values []int64) *sketch {
+	s := &sketch{}
+	for _, v := range values {
+		s.add(m, v)
+	}
+	return s
+}
+
+// checkQuantiles сравнивает квантили скетча с точными по отсортированным values:
+// относительная ошибка не должна превышать accuracy
+func checkQuantiles(t *testing.T, name string, m *sketchMapping, s *sketch, values []int64, accuracy float64) {
+	t.Helper()
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	n := int64(len(sorted))
+	for _, q := range []float64{0, 0.01, 0.25, 0.5, 0.9, 0.95, 0.99, 0.999, 1} {
+		want := float64(sorted[int(q*float64(n-1))])
+		got := s.quantile(m, q, n)
+		if want == 0 {
+			if got != 0 {
+				t.Errorf("%s: p%v = %v, want 0", name, q*100, got)
+			}
+			continue
+		}
+		if err := math.Abs(got-want) / want; err > accuracy+1e-9 {
+			t.Errorf("%s: p%v = %.2f, want %.0f within %.1f%% (error %.2f%%)", name, q*100, got, want, accuracy*100, err*100)
+		}
+	}
+}
+
+func TestSketchRelativeError(t *testing.T) {
+	rng := rand.New(rand.NewPCG(7, 8))
+	for _, accuracy := range []float64{defaultSketchAccuracy, 0.05, 0.1} {
+		m := newSketchMapping(accuracy)
+		for name, gen := range map[string]func() int64{
+			"uniform":   func() int64 { return 1 + rng.Int64N(5000) },
+			"lognormal": func() int64 { return 1 + int64(math.Exp(4+rng.NormFloat64())) },
+			// Сотни одинаковых значений и редкие хвосты
+			"spiky": func() int64 {
+				if rng.IntN(100) == 0 {
+					return 2000 + rng.Int64N(1000)
+				}
+				return 12
+			},
+			"with zeros": func() int64 { return max(0, rng.Int64N(200)-50) },
+		} {
+			values := make([]int64, 20000)
+			for i := range values {
+				values[i] = gen()
+			}
+			s := sketchOf(m, values)
+			if s.level != 0 {
+				t.Errorf("%s at %v: coarsened to level %d on a narrow range", name, accuracy, s.level)
+			}
+			checkQuantiles(t, name, m, s, values, accuracy)
+		}
+	}
+}
+
+func TestSketchCoarsening(t *testing.T) {
+	m := newSketchMapping(defaultSketchAccuracy)
+	rng := rand.New(rand.NewPCG(9, 10))
+	// От 1ms до ~11 суток: в 256 корзин это не влезает, и скетч огрубляется
+	values := make([]int64, 50000)
+	for i := range values {
+		values[i] = 1 + int64(math.Exp(rng.Float64()*math.Log(1e9)))
+	}
+	s := sketchOf(m, values)
+	if s.level == 0 || len(s.bins) > maxSketchBins {
+		t.Fatalf("level %d with %d bins, want coarsened within %d bins", s.level, len(s.bins), maxSketchBins)
+	}
+	// После огрубления на level уровней gamma возводится в 2^level-ю степень
+	g := math.Pow(m.gamma, float64(uint64(1)<<s.level))
+	checkQuantiles(t, "coarsened", m, s, values, (g-1)/(g+1))
+}
+
+func TestSketchMergeOrder(t *testing.T) {
+	m := newSketchMapping(defaultSketchAccuracy)
+	rng := rand.New(rand.NewPCG(11, 12))
+	var all []int64
+	shards := make([][]int64, 5)
+	for i := range shards {
+		// Диапазоны частей разные, и одна из них в одиночку огрубляется
+		hi := int64(100) << (4 * i)
+		for range 2000 {
+			v := rng.Int64N(hi)
+			shards[i] = append(shards[i], v)
+			all = append(all, v)
+		}
+	}
+	single := sketchOf(m, all)
+	quantiles := func(s *sketch) []float64 {
+		var out []float64
+		for _, q := range []float64{0.1, 0.5, 0.9, 0.99} {
+			out = append(out, s.quantile(m, q, int64(len(all))))
+		}
+		return out
+	}
+	for _, order := range [][]int{{0, 1, 2, 3, 4}, {4, 3, 2, 1, 0}, {2, 0, 4, 1, 3}} {
+		merged := &sketch{}
+		for _, i := range order {
+			merged.merge(sketchOf(m, shards[i]))
+		}
+		if merged.zero != single.zero || merged.level != single.level || !reflect.DeepEqual(quantiles(merged), quantiles(single)) {
+			t.Errorf("merge order %v: level %d, %v; single sketch: level %d, %v", order, merged.level, quantiles(merged), single.level, quantiles(single))
+		}
+	}
+	// clone не делит корзины с оригиналом
+	c := single.clone()
+	c.add(m, 1)
+	if reflect.DeepEqual(c.bins, single.bins) {
+		t.Error("clone shares bins with the original")
+	}
+}
+
+func TestSketchCarry(t *testing.T) {
+	m := newSketchMapping(defaultSketchAccuracy)
+	s := &sketch{}
+	i := m.index(100)
+	// Больше 2^32 значений в одной корзине уходят в carry
+	s.addAt(i, 1<<32+5)
+	s.addAt(i, math.MaxUint32)
+	if got, want := s.count(i), uint64(1<<32+5+math.MaxUint32); got != want {
+		t.Errorf("count = %d, want %d", got, want)
+	}
+	o := &sketch{}
+	o.addAt(i, 1<<33)
+	s.merge(o)
+	if got, want := s.count(i), uint64(1<<32+5+math.MaxUint32+1<<33); got != want {
+		t.Errorf("merged count = %d, want %d", got, want)
+	}
+	if v := s.quantile(m, 0.5, int64(s.count(i))); math.Abs(v-100)/100 > defaultSketchAccuracy {
+		t.Errorf("p50 = %v, want about 100", v)
+	}
+}
+
+func TestPercentileListSet(t *testing.T) {
+	var l percentileList
+	if err := l.Set("50, 90,99.9,100"); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range l {
+		names = append(names, p.name())
+	}
+	if strings.Join(names, ",") != "p50,p90,p99_9,p100" || l.String() != "50,90,99.9,100" || math.Abs(l[2].q-0.999) > 1e-12 {
+		t.Errorf("-percentiles 50,90,99.9,100: %v (%s)", names, l.String())
+	}
+	for _, bad := range []string{"0", "-5", "101", "abc", "50,50.0", "p99"} {
+		if err := l.Set(bad); err == nil {
+			t.Errorf("-percentiles %s accepted", bad)
+		}
+	}
+}
+
+func TestPercentilesOutput(t *testing.T) {
+	var sb strings.Builder
+	// /a: 1..1000ms по разу, точные p50 = 500 и p99 = 990
+	for i := range 1000 {
+		fmt.Fprintf(&sb, "2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 %d\n", i+1)
+	}
+	sb.WriteString("2024-01-01T00:00:00Z 10.0.0.1 GET /one 200 7\n")
+	path := writeTempFile(t, "pct.log", sb.String())
+
+	var outputs []string
+	for _, workers := range []string{"1", "4"} {
+		out, code := runAnalyzeFile(t, "-percentiles", "50,99", "-workers", workers, path)
+		if code != exitOK {
+			t.Fatalf("-workers %s: exit %d", workers, code)
+		}
+		outputs = append(outputs, out)
+	}
+	// Скетчи частей сливаются без потерь, так что число воркеров результат не меняет
+	if outputs[0] != outputs[1] {
+		t.Errorf("-workers 1 and 4 differ:\n%s\n%s", outputs[0], outputs[1])
+	}
+	var doc map[string]any
+	if err := json.Unmarshal([]byte(outputs[0]), &doc); err != nil {
+		t.Fatal(err)
+	}
+	a := doc["endpoints"].(map[string]any)["/a"].(map[string]any)
+	for key, want := range map[string]float64{"p50_response_time": 500, "p99_response_time": 990} {
+		got, _ := a[key].(float64)
+		if math.Abs(got-want)/want > defaultSketchAccuracy {
+			t.Errorf("/a %s = %v, want %v within %v", key, a[key], want, defaultSketchAccuracy)
+		}
+	}
+	// Оценка не выходит за точные min и max: у одного значения все квантили равны ему
+	one := doc["endpoints"].(map[string]any)["/one"].(map[string]any)
+	if one["p50_response_time"] != 7.0 || one["p99_response_time"] != 7.0 {
+		t.Errorf("/one = %v, want p50 and p99 of 7", one)
+	}
+	if _, ok := doc["summary"].(map[string]any)["p99_response_time"]; !ok {
+		t.Errorf("summary has no p99_response_time: %v", doc["summary"])
+	}
+
+	for _, bad := range [][]string{{"-percentiles", "0"}, {"-percentiles", "50", "-sketch-accuracy", "0"}, {"-percentiles", "50", "-sketch-accuracy", "0.6"}} {
+		if _, code := runAnalyzeFile(t, append(bad, path)...); code != exitUsage {
+			t.Errorf("%v: exit %d, want %d", bad, code, exitUsage)
+		}
+	}
+}
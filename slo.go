@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sloRule - пороги для эндпоинтов, подходящих под Pattern. Значения в мс,
+// незаданный порог не проверяется
+type sloRule struct {
+	Pattern string   `json:"pattern"`
+	MaxAvg  *float64 `json:"max_avg"`
+	MaxMax  *int64   `json:"max_max"`
+	// percentiles - пороги max_p95, max_p99.9 и т.п. по возрастанию квантиля. Имя ключа
+	// задаёт квантиль, поэтому их разбирает takeSLOPercentiles, а не декодер
+	percentiles []sloPercentile
+}
+
+// sloPercentile - порог max_pNN: квантиль p не выше max мс
+type sloPercentile struct {
+	p   percentile
+	max float64
+}
+
+// sloConfig - содержимое файла -slo. Правила проверяются по порядку, выигрывает
+// первое подходящее; Default применяется к эндпоинтам, не подошедшим ни под одно
+type sloConfig struct {
+	Rules   []sloRule `json:"rules"`
+	Default *sloRule  `json:"default"`
+	// mapping - отображение скетчей, по которому читаются квантили для max_pNN
+	mapping *sketchMapping
+}
+
+// Ключи вида max_p95 и max_p99.9
+var sloPercentileKeyRe = regexp.MustCompile(`^max_p([0-9]+(\.[0-9]+)?)$`)
+
+// loadSLOConfig читает файл -slo: YAML для .yaml/.yml, иначе JSON
+func loadSLOConfig(filePath string) (*sloConfig, error) {
+	cfg, err := readSLOConfig(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("slo %s: %w", filePath, err)
+	}
+	return cfg, nil
+}
+
+func readSLOConfig(filePath string) (*sloConfig, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".yaml", ".yml":
+		doc, err = parseYAML(data)
+	default:
+		err = json.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	percentiles, err := takeSLOPercentiles(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	// YAML разобран в те же типы, что и JSON, так что строгая проверка ключей и типов
+	// для обоих форматов делается одним декодером
+	normalized, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(normalized))
+	dec.DisallowUnknownFields()
+	var cfg sloConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, err
+	}
+	for i := range cfg.Rules {
+		cfg.Rules[i].percentiles = percentiles[i]
+	}
+	if cfg.Default != nil {
+		cfg.Default.percentiles = percentiles[len(cfg.Rules)]
+	}
+	if len(cfg.Rules) == 0 && cfg.Default == nil {
+		return nil, fmt.Errorf("no rules and no default rule")
+	}
+	for i := range cfg.Rules {
+		if err := cfg.Rules[i].validate(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	if cfg.Default != nil {
+		if cfg.Default.Pattern != "" {
+			return nil, fmt.Errorf("default rule must not have a pattern")
+		}
+		if err := cfg.Default.checkThresholds(); err != nil {
+			return nil, fmt.Errorf("default rule: %w", err)
+		}
+	}
+	return &cfg, nil
+}
+
+// takeSLOPercentiles вынимает ключи max_pNN из правил doc, чтобы остальное проверил
+// строгий декодер. Пороги возвращаются по правилам из "rules", за ними - у "default"
+func takeSLOPercentiles(doc any) ([][]sloPercentile, error) {
+	top, ok := doc.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected an object with \"rules\" and \"default\"")
+	}
+	var rules []any
+	if list, ok := top["rules"].([]any); ok {
+		rules = list
+	}
+	if def, ok := top["default"]; ok {
+		rules = append(rules, def)
+	}
+	out := make([][]sloPercentile, len(rules))
+	for i, r := range rules {
+		m, ok := r.(map[string]any)
+		if !ok {
+			continue
+		}
+		var labels []string
+		for key, v := range m {
+			match := sloPercentileKeyRe.FindStringSubmatch(key)
+			if match == nil {
+				continue
+			}
+			limit, ok := v.(float64)
+			if !ok {
+				return nil, fmt.Errorf("%s: expected a number of milliseconds", key)
+			}
+			var p percentileList
+			if err := p.Set(match[1]); err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			if slices.Contains(labels, p[0].label) {
+				return nil, fmt.Errorf("%s: percentile %s specified twice", key, p[0].label)
+			}
+			labels = append(labels, p[0].label)
+			out[i] = append(out[i], sloPercentile{p[0], limit})
+			delete(m, key)
+		}
+		sort.Slice(out[i], func(a, b int) bool { return out[i][a].p.q < out[i][b].p.q })
+	}
+	return out, nil
+}
+
+// hasPercentiles - есть ли в файле пороги max_pNN, для которых нужны скетчи
+func (c *sloConfig) hasPercentiles() bool {
+	if c.Default != nil && len(c.Default.percentiles) > 0 {
+		return true
+	}
+	for _, r := range c.Rules {
+		if len(r.percentiles) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *sloRule) validate() error {
+	if r.Pattern == "" {
+		return fmt.Errorf("pattern is required")
+	}
+	if _, err := path.Match(r.Pattern, ""); err != nil {
+		return fmt.Errorf("pattern %q: %w", r.Pattern, err)
+	}
+	if err := r.checkThresholds(); err != nil {
+		return fmt.Errorf("pattern %q: %w", r.Pattern, err)
+	}
+	return nil
+}
+
+func (r *sloRule) checkThresholds() error {
+	if r.MaxAvg == nil && r.MaxMax == nil && len(r.percentiles) == 0 {
+		return fmt.Errorf("no thresholds, set max_avg, max_max and/or max_pNN")
+	}
+	if r.MaxAvg != nil && *r.MaxAvg < 0 || r.MaxMax != nil && *r.MaxMax < 0 {
+		return fmt.Errorf("thresholds must not be negative")
+	}
+	for _, p := range r.percentiles {
+		if p.max < 0 {
+			return fmt.Errorf("thresholds must not be negative")
+		}
+	}
+	return nil
+}
+
+// matches сравнивает эндпоинт с шаблоном: без метасимволов - точное совпадение,
+// иначе glob по правилам path.Match, где * и ? не захватывают "/"
+func (r *sloRule) matches(endpoint string) bool {
+	if !strings.ContainsAny(r.Pattern, `*?[\`) {
+		return r.Pattern == endpoint
+	}
+	ok, _ := path.Match(r.Pattern, endpoint)
+	return ok
+}
+
+// ruleFor возвращает первое подходящее правило, затем правило по умолчанию, или nil
+func (c *sloConfig) ruleFor(endpoint string) *sloRule {
+	for i := range c.Rules {
+		if c.Rules[i].matches(endpoint) {
+			return &c.Rules[i]
+		}
+	}
+	return c.Default
+}
+
+// sloResult - проверка одного эндпоинта
+type sloResult struct {
+	endpoint   string
+	rule       *sloRule
+	stats      Stats
+	violations []string
+}
+
+// sloReport - итог проверки всех эндпоинтов; эндпоинты без правила не проверяются
+type sloReport struct {
+	results   []sloResult
+	unmatched []string
+	failed    int
+}
+
+// evaluateSLO проверяет каждый эндпоинт по его правилу, в порядке имён
+func evaluateSLO(cfg *sloConfig, totals map[string]*Stats) *sloReport {
+	names := make([]string, 0, len(totals))
+	for name := range totals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rep := &sloReport{}
+	for _, name := range names {
+		rule := cfg.ruleFor(name)
+		if rule == nil {
+			rep.unmatched = append(rep.unmatched, name)
+			continue
+		}
+		s := *totals[name]
+		res := sloResult{endpoint: name, rule: rule, stats: s}
+		if rule.MaxAvg != nil && s.Count > 0 {
+			if avg := float64(s.Sum) / float64(s.Count); avg > *rule.MaxAvg {
+				res.violations = append(res.violations,
+					fmt.Sprintf("avg %sms > %sms", formatFloat(avg, 1), strconv.FormatFloat(*rule.MaxAvg, 'f', -1, 64)))
+			}
+		}
+		if rule.MaxMax != nil && s.Count > 0 && s.Max > *rule.MaxMax {
+			res.violations = append(res.violations, fmt.Sprintf("max %dms > %dms", s.Max, *rule.MaxMax))
+		}
+		// Квантили берутся из точной гистограммы -exact-percentiles, если она есть, иначе из скетча
+		for _, p := range rule.percentiles {
+			values := (&quantileSpec{percentiles: percentileList{p.p}, mapping: cfg.mapping}).values(&s)
+			if len(values) > 0 && values[0] > p.max {
+				res.violations = append(res.violations, fmt.Sprintf("%s %sms > %sms",
+					"p"+p.p.label, formatFloat(values[0], 1), strconv.FormatFloat(p.max, 'f', -1, 64)))
+			}
+		}
+		if len(res.violations) > 0 {
+			rep.failed++
+		}
+		rep.results = append(rep.results, res)
+	}
+	return rep
+}
+
+func (r *sloRule) describe() string {
+	if r.Pattern == "" {
+		return "default"
+	}
+	return r.Pattern
+}
+
+// print печатает PASS/FAIL по каждому проверенному эндпоинту и итоговую строку
+func (rep *sloReport) print(w io.Writer, filePath string) {
+	width := len("ENDPOINT")
+	for _, res := range rep.results {
+		width = max(width, len(res.endpoint))
+	}
+	for _, res := range rep.results {
+		status := "PASS"
+		detail := ""
+		if len(res.violations) > 0 {
+			status = "FAIL"
+			detail = "  " + strings.Join(res.violations, ", ")
+		}
+		fmt.Fprintf(w, "%s  %-*s  rule %q%s\n", status, width, res.endpoint, res.rule.describe(), detail)
+	}
+	fmt.Fprintf(w, "SLO %s: %d endpoints checked, %d failed", filePath, len(rep.results), rep.failed)
+	if len(rep.unmatched) > 0 {
+		fmt.Fprintf(w, ", %d without a rule", len(rep.unmatched))
+	}
+	fmt.Fprintln(w)
+}
+
+// sloError - хотя бы один эндпоинт нарушил -slo
+type sloError struct {
+	failed, checked int
+}
+
+func (e *sloError) Error() string {
+	return fmt.Sprintf("%d of %d endpoints violate the SLO", e.failed, e.checked)
+}
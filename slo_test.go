@@ -0,0 +1,166 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func loadSLOFromString(t *testing.T, name, content string) (*sloConfig, error) {
+	t.Helper()
+	return loadSLOConfig(writeTempFile(t, name, content))
+}
+
+func TestSLORuleForFirstMatchWins(t *testing.T) {
+	cfg, err := loadSLOFromString(t, "slo.yaml", `rules:
+  - pattern: /api/users/profile
+    max_max: 1
+  - pattern: "/api/users/*"
+    max_max: 2
+  - pattern: "/api/*"
+    max_max: 3
+  - pattern: /api/items
+    max_max: 4
+default:
+  max_max: 5
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for endpoint, want := range map[string]int64{
+		"/api/users/profile": 1,
+		"/api/users/42":      2,
+		"/api/orders":        3,
+		// Дальше в списке есть точное правило, но "/api/*" стоит раньше
+		"/api/items": 3,
+		// * не захватывает "/"
+		"/api/users/42/orders": 5,
+		"/health":              5,
+	} {
+		if got := *cfg.ruleFor(endpoint).MaxMax; got != want {
+			t.Errorf("%s matched the rule with max_max %d, want %d", endpoint, got, want)
+		}
+	}
+	cfg.Default = nil
+	if r := cfg.ruleFor("/health"); r != nil {
+		t.Errorf("/health matched %q without a default rule", r.Pattern)
+	}
+}
+
+func TestLoadSLOConfigPercentiles(t *testing.T) {
+	cfg, err := loadSLOFromString(t, "slo.json", `{"rules": [{"pattern": "/a", "max_p99.9": 900, "max_p50": 100, "max_avg": 80}], "default": {"max_p95": 300}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range cfg.Rules[0].percentiles {
+		got = append(got, p.p.label+"<="+formatFloat(p.max, 0))
+	}
+	if want := []string{"50<=100", "99.9<=900"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rule percentiles = %v, want %v", got, want)
+	}
+	if d := cfg.Default.percentiles; len(d) != 1 || d[0].p.q != 0.95 || d[0].max != 300 {
+		t.Errorf("default percentiles = %+v", d)
+	}
+
+	for name, content := range map[string]string{
+		"zero":      `{"rules": [{"pattern": "/a", "max_p0": 1}]}`,
+		"over 100":  `{"rules": [{"pattern": "/a", "max_p101": 1}]}`,
+		"twice":     `{"rules": [{"pattern": "/a", "max_p95": 1, "max_p95.0": 2}]}`,
+		"string":    `{"rules": [{"pattern": "/a", "max_p95": "1s"}]}`,
+		"negative":  `{"default": {"max_p95": -1}}`,
+		"no digits": `{"rules": [{"pattern": "/a", "max_p": 1}]}`,
+	} {
+		if _, err := loadSLOFromString(t, "slo.json", content); err == nil {
+			t.Errorf("%s: config accepted", name)
+		}
+	}
+}
+
+// sloStats - Stats со значениями 1..n, у каждого эндпоинта точная гистограмма или скетч
+func sloStats(n int64, exact bool, m *sketchMapping) *Stats {
+	s := &Stats{Min: 1, Max: n, extra: &statsExtra{}}
+	if exact {
+		s.extra.exact = &exactHist{}
+	} else {
+		s.extra.sketch = &sketch{}
+	}
+	for v := int64(1); v <= n; v++ {
+		s.Sum += v
+		s.Count++
+		if exact {
+			s.extra.exact.add(m, v)
+		} else {
+			s.extra.sketch.add(m, v)
+		}
+	}
+	return s
+}
+
+func TestEvaluateSLOThresholds(t *testing.T) {
+	cfg, err := loadSLOFromString(t, "slo.yaml", `rules:
+  - pattern: /slow
+    max_p95: 90
+    max_p50: 60
+  - pattern: /fast
+    max_p99: 100
+    max_max: 100
+  - pattern: /avg
+    max_avg: 50
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.mapping = newSketchMapping(defaultSketchAccuracy)
+	for _, exact := range []bool{true, false} {
+		totals := map[string]*Stats{
+			"/slow": sloStats(100, exact, cfg.mapping),
+			"/fast": sloStats(100, exact, cfg.mapping),
+			"/avg":  sloStats(100, exact, cfg.mapping),
+			"/none": sloStats(100, exact, cfg.mapping),
+		}
+		rep := evaluateSLO(cfg, totals)
+		if rep.failed != 2 || !reflect.DeepEqual(rep.unmatched, []string{"/none"}) {
+			t.Fatalf("exact %v: %d failed, unmatched %v", exact, rep.failed, rep.unmatched)
+		}
+		violations := map[string]string{}
+		for _, res := range rep.results {
+			violations[res.endpoint] = strings.Join(res.violations, ", ")
+		}
+		// p50 около 50 укладывается в 60, p95 около 95 - нет; max ровно на пороге не нарушение
+		if v := violations["/slow"]; !strings.HasPrefix(v, "p95 ") || !strings.HasSuffix(v, "ms > 90ms") || strings.Contains(v, "p50") {
+			t.Errorf("exact %v: /slow violations = %q", exact, v)
+		}
+		if v := violations["/fast"]; v != "" {
+			t.Errorf("exact %v: /fast violations = %q", exact, v)
+		}
+		if v := violations["/avg"]; v != "avg 50.5ms > 50ms" {
+			t.Errorf("exact %v: /avg violations = %q", exact, v)
+		}
+	}
+
+	// Без распределения (как у merge) перцентильный порог не проверяется
+	plain := map[string]*Stats{"/slow": {Min: 1, Max: 100, Sum: 5050, Count: 100}}
+	if rep := evaluateSLO(cfg, plain); rep.failed != 0 {
+		t.Errorf("violation without a distribution: %+v", rep.results)
+	}
+}
+
+func TestSLOPercentileExitCode(t *testing.T) {
+	var sb strings.Builder
+	for i := range 100 {
+		sb.WriteString("2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 " + formatFloat(float64(i+1), 0) + "\n")
+	}
+	log := writeTempFile(t, "slo.log", sb.String())
+	for limit, want := range map[string]int{"90": exitSLO, "200": exitOK} {
+		slo := writeTempFile(t, "slo.yaml", "default:\n  max_p95: "+limit+"\n")
+		// Скетч ведётся и без -percentiles, а в выводе перцентилей не появляется
+		out, code := runAnalyzeFile(t, "-slo", slo, log)
+		if code != want {
+			t.Errorf("max_p95 %s: exit %d, want %d", limit, code, want)
+		}
+		if code == exitOK && strings.Contains(out, "p95") {
+			t.Errorf("max_p95 %s: p95 appeared in the report:\n%s", limit, out)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import "strconv"
+
+// defaultSlowThreshold - порог -slow-threshold по умолчанию, в мс
+const defaultSlowThreshold = 1000
+
+// slowList - значение флага -slow-threshold: пороги в миллисекундах по возрастанию.
+// Для -color важен только последний; счётчики медленных запросов ведутся по каждому
+type slowList []int64
+
+func (l slowList) String() string {
+	return formatMsList(l)
+}
+
+func (l *slowList) Set(s string) error {
+	list, err := parseMsList(s, "slow threshold", "slow thresholds")
+	if err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// highest - порог подсветки -color: самый большой из заданных
+func (l slowList) highest() int64 {
+	return l[len(l)-1]
+}
+
+// count учитывает значение v в counts: counts[i] - сколько значений больше l[i].
+// Пороги идут по возрастанию, так что на первом непревышенном можно остановиться
+func (l slowList) count(counts []int64, v int64) {
+	for i, t := range l {
+		if v <= t {
+			return
+		}
+		counts[i]++
+	}
+}
+
+// fieldNames - поля счётчиков в выводе парами slow_count, slow_pct. С одним порогом
+// имена без суффикса, с несколькими - slow_count_200, slow_pct_200 и т.д.
+func (l slowList) fieldNames() []string {
+	names := make([]string, 0, 2*len(l))
+	for _, t := range l {
+		suffix := ""
+		if len(l) > 1 {
+			suffix = "_" + strconv.FormatInt(t, 10)
+		}
+		names = append(names, "slow_count"+suffix, "slow_pct"+suffix)
+	}
+	return names
+}
+
+// columns - поля fieldNames: число медленных запросов и их доля в процентах с prec знаками
+func (l slowList) columns(prec int) []extraColumn {
+	names := l.fieldNames()
+	cols := make([]extraColumn, len(names))
+	for i, name := range names {
+		cols[i] = extraColumn{name, name, func(s *Stats) (string, bool) {
+			if s == nil || s.extra == nil || s.extra.slow == nil {
+				return "", false
+			}
+			n := s.extra.slow[i/2]
+			if i%2 == 0 {
+				return strconv.FormatInt(n, 10), true
+			}
+			return formatShare(n, s.Count, prec), true
+		}}
+	}
+	return cols
+}
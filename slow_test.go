@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSlowListCount(t *testing.T) {
+	var l slowList
+	if err := l.Set("100, 400,1000"); err != nil {
+		t.Fatal(err)
+	}
+	counts := make([]int64, len(l))
+	// Порог не входит: медленнее - строго больше
+	for _, v := range []int64{0, 100, 101, 400, 401, 1000, 1001, 5000} {
+		l.count(counts, v)
+	}
+	if want := []int64{6, 4, 2}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("counts %v, want %v", counts, want)
+	}
+	if l.highest() != 1000 || l.String() != "100,400,1000" {
+		t.Errorf("highest %d, String %q", l.highest(), l.String())
+	}
+	if got, want := l.fieldNames(), []string{"slow_count_100", "slow_pct_100", "slow_count_400", "slow_pct_400", "slow_count_1000", "slow_pct_1000"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fieldNames %v, want %v", got, want)
+	}
+	if got := (slowList{250}).fieldNames(); !reflect.DeepEqual(got, []string{"slow_count", "slow_pct"}) {
+		t.Errorf("single threshold fieldNames %v", got)
+	}
+
+	for _, bad := range []string{"500,200", "200,200", "-5", "abc", "1.5"} {
+		if err := l.Set(bad); err == nil {
+			t.Errorf("Set(%q) accepted", bad)
+		}
+	}
+}
+
+func TestSlowThresholdOutput(t *testing.T) {
+	path := writeTempFile(t, "slow.log", "2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 100\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 101\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /b 200 101\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 400\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /b 200 401\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 401\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /b 200 500\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /b 200 999\n")
+	want := map[string]map[string]any{
+		"/a":      {"slow_count_100": 3.0, "slow_pct_100": 75.0, "slow_count_400": 1.0, "slow_pct_400": 25.0},
+		"/b":      {"slow_count_100": 4.0, "slow_pct_100": 100.0, "slow_count_400": 3.0, "slow_pct_400": 75.0},
+		"summary": {"slow_count_100": 7.0, "slow_pct_100": 87.5, "slow_count_400": 4.0, "slow_pct_400": 50.0},
+	}
+	for _, workers := range []string{"1", "3"} {
+		out, code := runAnalyzeFile(t, "-slow-threshold", "100,400", "-chunk-size", "64K", "-workers", workers, path)
+		if code != exitOK {
+			t.Fatalf("-workers %s: exit %d", workers, code)
+		}
+		var doc struct {
+			Endpoints map[string]map[string]any `json:"endpoints"`
+			Summary   map[string]any            `json:"summary"`
+		}
+		if err := json.Unmarshal([]byte(out), &doc); err != nil {
+			t.Fatal(err)
+		}
+		doc.Endpoints["summary"] = doc.Summary
+		for name, fields := range want {
+			for k, v := range fields {
+				if doc.Endpoints[name][k] != v {
+					t.Errorf("-workers %s: %s %s = %v, want %v", workers, name, k, doc.Endpoints[name][k], v)
+				}
+			}
+		}
+	}
+
+	out, _ := runAnalyzeFile(t, "-slow-threshold", "400", "-format", "csv", path)
+	if !strings.HasPrefix(out, "endpoint,count,share_pct,min_ms,avg_ms,max_ms,slow_count,slow_pct\n") || !strings.Contains(out, "/b,4,50.0,101,500.3,999,3,75.0\n") {
+		t.Errorf("csv:\n%s", out)
+	}
+	// Порог по умолчанию только подсвечивает таблицу, счётчиков без флага нет
+	out, _ = runAnalyzeFile(t, path)
+	if strings.Contains(out, "slow_") {
+		t.Errorf("slow counters without -slow-threshold:\n%s", out)
+	}
+	// Форматы без этих полей флаг принимают ради -color, но счётчиков не печатают
+	out, code := runAnalyzeFile(t, "-slow-threshold", "400", "-format", "prom", path)
+	if code != exitOK || strings.Contains(out, "slow") {
+		t.Errorf("prom: exit %d\n%s", code, out)
+	}
+	if _, code := runAnalyzeFile(t, "-slow-threshold", "", path); code != exitUsage {
+		t.Errorf("empty -slow-threshold: exit %d, want %d", code, exitUsage)
+	}
+}
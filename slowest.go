@@ -0,0 +1,107 @@
+package main
+
+import (
+	"container/heap"
+	"slices"
+)
+
+// slowRequest - один из самых медленных запросов эндпоинта. Из строки хранятся только
+// timestamp и IP, а не она целиком, чтобы память не зависела от длины путей
+type slowRequest struct {
+	value     int64
+	timestamp string
+	ip        string
+}
+
+// slowerThan - a медленнее b. При равном времени медленнее считается более ранний
+// запрос, затем меньший IP: так набор самых медленных не зависит от порядка строк
+// и воркеров
+func slowerThan(a, b *slowRequest) bool {
+	if a.value != b.value {
+		return a.value > b.value
+	}
+	if a.timestamp != b.timestamp {
+		return a.timestamp < b.timestamp
+	}
+	return a.ip < b.ip
+}
+
+// slowestHeap - n самых медленных запросов эндпоинта для -capture-slowest; куча с самым
+// быстрым из них в корне, так что новый запрос сравнивается только с корнем
+type slowestHeap struct {
+	n    int
+	reqs []slowRequest
+}
+
+func newSlowestHeap(n int) *slowestHeap {
+	return &slowestHeap{n: n, reqs: make([]slowRequest, 0, n)}
+}
+
+func (h *slowestHeap) Len() int           { return len(h.reqs) }
+func (h *slowestHeap) Less(i, j int) bool { return slowerThan(&h.reqs[j], &h.reqs[i]) }
+func (h *slowestHeap) Swap(i, j int)      { h.reqs[i], h.reqs[j] = h.reqs[j], h.reqs[i] }
+func (h *slowestHeap) Push(x any)         { h.reqs = append(h.reqs, x.(slowRequest)) }
+
+func (h *slowestHeap) Pop() any {
+	last := h.reqs[len(h.reqs)-1]
+	h.reqs = h.reqs[:len(h.reqs)-1]
+	return last
+}
+
+// add учитывает запрос строки line. Строки копируются, только когда запрос попадает
+// в кучу; обычный запрос быстрее корня отсекается одним сравнением
+func (h *slowestHeap) add(line []byte, rec *lineRecord) {
+	if len(h.reqs) == h.n && rec.value < h.reqs[0].value {
+		return
+	}
+	r := slowRequest{rec.value, string(line[rec.timestamp.start:rec.timestamp.end]), string(line[rec.ip.start:rec.ip.end])}
+	switch {
+	case len(h.reqs) < h.n:
+		heap.Push(h, r)
+	case slowerThan(&r, &h.reqs[0]):
+		h.reqs[0] = r
+		heap.Fix(h, 0)
+	}
+}
+
+// merge добавляет запросы o и заново собирает кучу из объединения, отбрасывая лишние
+func (h *slowestHeap) merge(o *slowestHeap) {
+	h.reqs = append(h.reqs, o.reqs...)
+	heap.Init(h)
+	for len(h.reqs) > h.n {
+		heap.Pop(h)
+	}
+}
+
+func (h *slowestHeap) clone() *slowestHeap {
+	return &slowestHeap{n: h.n, reqs: slices.Clone(h.reqs)}
+}
+
+// sorted - запросы от самого медленного
+func (h *slowestHeap) sorted() []slowRequest {
+	reqs := slices.Clone(h.reqs)
+	slices.SortFunc(reqs, func(a, b slowRequest) int {
+		if slowerThan(&a, &b) {
+			return -1
+		}
+		if slowerThan(&b, &a) {
+			return 1
+		}
+		return 0
+	})
+	return reqs
+}
+
+// slowestOf - массив "slowest" эндпоинта: время в -unit, timestamp и IP как в логе
+func (r jsonRenderer) slowestOf(s *Stats) docArray {
+	reqs := s.extra.slowest.sorted()
+	arr := make(docArray, len(reqs))
+	for i, req := range reqs {
+		arr[i] = docObject{
+			{"response_time", docNumber(r.unit.formatValue(req.value, r.precision))},
+			{"timestamp", req.timestamp},
+			{"ip", req.ip},
+		}
+	}
+	return arr
+}
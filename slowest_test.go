@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// slowLine - строка лога для slowestHeap.add
+func slowLine(ts, ip string, value int64) ([]byte, *lineRecord) {
+	line := []byte(ts + " " + ip)
+	return line, &lineRecord{
+		timestamp: span{0, len(ts)},
+		ip:        span{len(ts) + 1, len(line)},
+		value:     value,
+	}
+}
+
+func TestSlowestHeap(t *testing.T) {
+	rng := rand.New(rand.NewPCG(15, 16))
+	reqs := make([]slowRequest, 500)
+	for i := range reqs {
+		// Мало разных значений - много равных времён, порядок решают timestamp и IP
+		reqs[i] = slowRequest{rng.Int64N(20), fmt.Sprintf("t%03d", rng.IntN(50)), fmt.Sprintf("10.0.0.%d", i)}
+	}
+	want := slices.Clone(reqs)
+	slices.SortFunc(want, func(a, b slowRequest) int {
+		if slowerThan(&a, &b) {
+			return -1
+		}
+		return 1
+	})
+
+	for _, n := range []int{1, 5, 37, 500, 600} {
+		top := want[:min(n, len(want))]
+		whole := newSlowestHeap(n)
+		for _, r := range reqs {
+			whole.add(slowLine(r.timestamp, r.ip, r.value))
+		}
+		if got := whole.sorted(); !reflect.DeepEqual(got, top) {
+			t.Errorf("n=%d: %v, want %v", n, got, top)
+		}
+
+		// По частям в любом порядке слияния - тот же набор
+		parts := make([]*slowestHeap, 4)
+		for i := range parts {
+			parts[i] = newSlowestHeap(n)
+		}
+		for i, r := range reqs {
+			parts[i%len(parts)].add(slowLine(r.timestamp, r.ip, r.value))
+		}
+		merged := parts[3].clone()
+		for _, p := range []*slowestHeap{parts[1], parts[0], parts[2]} {
+			merged.merge(p)
+		}
+		if got := merged.sorted(); !reflect.DeepEqual(got, top) {
+			t.Errorf("n=%d merged: %v, want %v", n, got, top)
+		}
+	}
+}
+
+func TestCaptureSlowest(t *testing.T) {
+	path := writeTempFile(t, "slowest.log", "2024-01-01T00:00:03Z 10.0.0.3 GET /a 200 50\n"+
+		"2024-01-01T00:00:01Z 10.0.0.1 GET /a 200 50\n"+
+		"2024-01-01T00:00:02Z 10.0.0.2 GET /a 200 90\n"+
+		"2024-01-01T00:00:04Z 10.0.0.4 GET /a 200 10\n"+
+		"2024-01-01T00:00:05Z 10.0.0.5 GET /b 200 7\n")
+	type slow struct {
+		ResponseTime float64 `json:"response_time"`
+		Timestamp    string  `json:"timestamp"`
+		IP           string  `json:"ip"`
+	}
+	want := map[string][]slow{
+		// При равном времени первым идёт более ранний запрос
+		"/a": {{90, "2024-01-01T00:00:02Z", "10.0.0.2"}, {50, "2024-01-01T00:00:01Z", "10.0.0.1"}},
+		"/b": {{7, "2024-01-01T00:00:05Z", "10.0.0.5"}},
+	}
+	for _, workers := range []string{"1", "3"} {
+		out, code := runAnalyzeFile(t, "-schema-version", "2", "-capture-slowest", "2", "-chunk-size", "64K", "-workers", workers, path)
+		if code != exitOK {
+			t.Fatalf("-workers %s: exit %d", workers, code)
+		}
+		var doc struct {
+			Endpoints map[string]struct {
+				Slowest []slow `json:"slowest"`
+			} `json:"endpoints"`
+		}
+		if err := json.Unmarshal([]byte(out), &doc); err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string][]slow)
+		for name, e := range doc.Endpoints {
+			got[name] = e.Slowest
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("-workers %s: slowest %+v, want %+v", workers, got, want)
+		}
+	}
+
+	for _, bad := range [][]string{
+		{"-capture-slowest", "2"},
+		{"-schema-version", "2", "-capture-slowest", "-1"},
+		{"-schema-version", "2", "-capture-slowest", "2", "-format", "csv"},
+	} {
+		if _, code := runAnalyzeFile(t, append(bad, path)...); code != exitUsage {
+			t.Errorf("%v: exit %d, want %d", bad, code, exitUsage)
+		}
+	}
+}
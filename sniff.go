@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Проверка начала файла до запуска воркеров: gzip или бинарный файл по ошибке дают
+// либо лавину битых строк, либо молча неверный отчёт. Смотрятся только первые sniffSize
+// байт каждого файла, так что мусор дальше этой проверкой не ловится. -force её отключает
+const (
+	// sniffSize - сколько байт начала файла смотрит checkTextInput
+	sniffSize = 4 << 10
+	// maxControlShare - доля управляющих байт, выше которой вход не текст. В логе их
+	// почти нет: разве что ESC цветного вывода
+	maxControlShare = 0.05
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+var (
+	errBinaryInput = errors.New("input does not look like a text log (did you mean to decompress it?); use -force to read it anyway")
+	errGzipInput   = errors.New("input is gzip-compressed, and gzip input is not read directly; decompress it first, e.g. zcat FILE > FILE.log, or use -force to read it anyway")
+)
+
+// checkTextInput читает начало уже открытого файла и возвращает ошибку, если это не текст
+func checkTextInput(file io.ReaderAt) error {
+	buf := make([]byte, sniffSize)
+	n, err := file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	return sniffText(buf[:n])
+}
+
+// isNotText - ошибка checkTextInput о том, что вход не текст
+func isNotText(err error) bool {
+	return errors.Is(err, errBinaryInput) || errors.Is(err, errGzipInput)
+}
+
+// sniffText - эвристика по началу файла: NUL в тексте не бывает, а управляющих байт,
+// кроме \t, \r и \n, в нём мало. Байты больше 0x7f не считаются: это может быть UTF-8
+func sniffText(b []byte) error {
+	if bytes.HasPrefix(b, gzipMagic) {
+		return errGzipInput
+	}
+	control := 0
+	for _, c := range b {
+		switch {
+		case c == 0:
+			return errBinaryInput
+		case c < ' ' && c != '\t' && c != '\n' && c != '\r', c == 0x7f:
+			control++
+		}
+	}
+	if float64(control) > maxControlShare*float64(len(b)) {
+		return errBinaryInput
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRefuseGzipInput(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(strings.Repeat("2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 12\n", 100)))
+	zw.Close()
+	path := filepath.Join(t.TempDir(), "a.log.gz")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := runPipeline(t.Context(), path, 2, &processOptions{chunkSize: defaultChunkSize}, false)
+	if !isNotText(err) || !strings.Contains(err.Error(), "gzip") || !strings.Contains(err.Error(), "zcat") {
+		t.Errorf("gzip input: %v", err)
+	}
+	if _, code := runAnalyzeFile(t, path); code != exitError {
+		t.Errorf("gzip input: exit %d, want %d", code, exitError)
+	}
+	if _, code := runAnalyzeFile(t, "-force", path); code != exitOK {
+		t.Errorf("-force: exit %d", code)
+	}
+}
+
+func TestSniffText(t *testing.T) {
+	log := strings.Repeat("2024-01-01T00:00:00Z 10.0.0.1 GET /a 200 12\r\n", 100)
+	for name, b := range map[string]string{
+		"log":        log,
+		"empty":      "",
+		"utf-8":      "2024-01-01T00:00:00Z 10.0.0.1 GET /über 200 12\n",
+		"ansi color": "\x1b[32m" + log + "\x1b[0m",
+	} {
+		if err := sniffText([]byte(b)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	for name, b := range map[string]string{
+		"NUL":     log + "\x00",
+		"control": strings.Repeat("ab\x01\x02", 100),
+		"gzip":    "\x1f\x8b\x08\x00",
+	} {
+		if err := sniffText([]byte(b)); !isNotText(err) {
+			t.Errorf("%s accepted: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import "strings"
+
+const (
+	defaultSparklineWidth = 8
+	maxSparklineWidth     = 64
+)
+
+// sparkBlocks - уровни спарклайна снизу вверх; пустая корзина рисуется нижним
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline рисует счётчики корзин -buckets (не накопительные, последняя - +Inf)
+// строкой из width символов. Каждый символ - сумма своей доли корзин: при корзинах
+// больше width соседние складываются, при меньшем числе корзина растягивается на
+// несколько символов. Высота нормирована на самый большой символ эндпоинта
+func sparkline(counts []int64, width int) string {
+	n := len(counts)
+	cells := make([]int64, width)
+	var peak int64
+	for i := range cells {
+		lo := i * n / width
+		hi := max((i+1)*n/width, lo+1)
+		for _, c := range counts[lo:hi] {
+			cells[i] += c
+		}
+		peak = max(peak, cells[i])
+	}
+	var sb strings.Builder
+	for _, c := range cells {
+		level := 0
+		if peak > 0 {
+			level = int(c * int64(len(sparkBlocks)-1) / peak)
+		}
+		sb.WriteRune(sparkBlocks[level])
+	}
+	return sb.String()
+}
+
+// sparklineOf - спарклайн корзин s; пусто, если их нет (например, у merge)
+func sparklineOf(s *Stats, width int) string {
+	if s.extra == nil || s.extra.buckets == nil {
+		return ""
+	}
+	return sparkline(s.extra.buckets, width)
+}
+
+// sparklineWidthOf - ширина колонки спарклайна для table и markdown; 0 без -buckets
+func sparklineWidthOf(opts *options) int {
+	if len(opts.buckets) == 0 {
+		return 0
+	}
+	return opts.sparklineWidth
+}
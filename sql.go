@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Схема для -format sql. CREATE ... IF NOT EXISTS позволяет дописывать прогоны в одну базу
+const sqlSchema = `CREATE TABLE IF NOT EXISTS runs (
+  id INTEGER PRIMARY KEY,
+  created_at TEXT NOT NULL,
+  input_files TEXT,
+  file_size_bytes INTEGER,
+  lines_parsed INTEGER,
+  malformed_lines INTEGER NOT NULL,
+  duration_ms INTEGER,
+  partial INTEGER NOT NULL,
+  version TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS endpoint_stats (
+  run_id INTEGER NOT NULL REFERENCES runs(id),
+  endpoint TEXT NOT NULL,
+  count INTEGER NOT NULL,
+  min INTEGER NOT NULL,
+  avg REAL NOT NULL,
+  max INTEGER NOT NULL,
+  sum INTEGER NOT NULL,
+  PRIMARY KEY (run_id, endpoint)
+);
+`
+
+// sqlRenderer пишет SQL-скрипт для sqlite3: схему, строку runs и по строке
+// endpoint_stats на эндпоинт, всё в одной транзакции: iw_challenge -format sql FILE |
+// sqlite3 results.db. Ту же базу пишет напрямую -sqlite-out (sqlite.go).
+// Время в мс, независимо от -unit
+type sqlRenderer struct{}
+
+func (sqlRenderer) render(w io.Writer, rep *report) error {
+	fmt.Fprint(w, "BEGIN;\n"+sqlSchema)
+
+	cols := make([]string, 0, 7)
+	for _, v := range runValues(rep) {
+		cols = append(cols, sqlLiteral(v))
+	}
+	fmt.Fprintf(w, "INSERT INTO runs (%s)\n  VALUES (%s, %s);\n", sqlRunColumns, sqlNow, strings.Join(cols, ", "))
+
+	// id только что вставленного прогона: last_insert_rowid() меняется после каждой вставки
+	for _, e := range rep.entries {
+		s := e.stats
+		avg := 0.0
+		if s.Count > 0 {
+			avg = float64(s.Sum) / float64(s.Count)
+		}
+		_, err := fmt.Fprintf(w, "INSERT INTO endpoint_stats VALUES ((SELECT max(id) FROM runs), %s, %d, %d, %s, %d, %d);\n",
+			sqlString(e.name), s.Count, s.Min, strconv.FormatFloat(avg, 'g', -1, 64), s.Max, s.Sum)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprint(w, "COMMIT;\n")
+	return err
+}
+
+// Столбцы runs, которые заполняет runValues, и время прогона по часам sqlite
+const (
+	sqlRunColumns = "created_at, input_files, file_size_bytes, lines_parsed, malformed_lines, duration_ms, partial, version"
+	sqlNow        = "strftime('%Y-%m-%dT%H:%M:%fZ', 'now')"
+)
+
+// runValues - значения строки runs после created_at; nil - NULL, когда у отчёта нет meta
+func runValues(rep *report) []any {
+	var files, size, lines, duration any
+	if m := rep.meta; m != nil {
+		files = strings.Join(m.inputFiles, "\n")
+		size, lines, duration = m.fileSize, m.linesParsed, m.duration.Milliseconds()
+	}
+	partial := int64(0)
+	if rep.partial {
+		partial = 1
+	}
+	return []any{files, size, lines, rep.malformed, duration, partial, getBuildInfo().versionString()}
+}
+
+// sqlLiteral - значение runValues в виде литерала SQL
+func sqlLiteral(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return sqlString(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	}
+	panic(fmt.Sprintf("sqlLiteral: unexpected %T", v))
+}
+
+// sqlString - строковый литерал SQL: кавычки удваиваются, невалидный UTF-8 заменяется
+// на U+FFFD, иначе sqlite3 сохранит строку, которую не все клиенты смогут прочитать
+func sqlString(s string) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	// Драйвер на чистом Go: сборке не нужен cgo
+	_ "modernc.org/sqlite"
+)
+
+// writeSQLite дописывает прогон rep в базу SQLite path (-sqlite-out), создавая её и
+// таблицы sqlSchema при необходимости. Схема та же, что у -format sql, так что базы
+// обоих путей совместимы. Всё пишется в одной транзакции: при ошибке в базе не
+// остаётся половины прогона. Возвращает id новой строки runs
+func writeSQLite(path string, rep *report) (int64, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	// Соединение одно: транзакция и так занимает базу целиком
+	db.SetMaxOpenConns(1)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(sqlSchema); err != nil {
+		return 0, fmt.Errorf("creating tables: %w", err)
+	}
+	res, err := tx.Exec("INSERT INTO runs ("+sqlRunColumns+") VALUES ("+sqlNow+", ?, ?, ?, ?, ?, ?, ?)", runValues(rep)...)
+	if err != nil {
+		return 0, fmt.Errorf("inserting run: %w", err)
+	}
+	runID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO endpoint_stats (run_id, endpoint, count, min, avg, max, sum) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	for _, e := range rep.entries {
+		s := e.stats
+		avg := 0.0
+		if s.Count > 0 {
+			avg = float64(s.Sum) / float64(s.Count)
+		}
+		// Как и sqlString в -format sql, невалидный UTF-8 заменяется на U+FFFD
+		name := strings.ToValidUTF8(e.name, "\uFFFD")
+		if _, err := stmt.Exec(runID, name, s.Count, s.Min, avg, s.Max, s.Sum); err != nil {
+			return 0, fmt.Errorf("inserting %q: %w", e.name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return runID, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteOut(t *testing.T) {
+	log := writeTempFile(t, "a.log", "2024-01-01T00:00:00Z 10.0.0.1 GET /api/users 200 10\n"+
+		"2024-01-01T00:00:01Z 10.0.0.1 GET /api/users 200 30\n"+
+		"2024-01-01T00:00:02Z 10.0.0.1 GET /o'brien 200 7\n"+
+		"not a log line\n")
+	dbPath := filepath.Join(t.TempDir(), "results.db")
+	// Второй прогон в ту же базу добавляет строку runs, а не заменяет первую
+	for range 2 {
+		if _, code := runAnalyzeFile(t, "-sqlite-out", dbPath, log); code != exitOK {
+			t.Fatalf("exit %d", code)
+		}
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var runs, malformed, lines int64
+	if err := db.QueryRow("SELECT count(*), max(malformed_lines), max(lines_parsed) FROM runs").Scan(&runs, &malformed, &lines); err != nil {
+		t.Fatal(err)
+	}
+	if runs != 2 || malformed != 1 || lines != 3 {
+		t.Errorf("runs: %d rows, malformed %d, lines %d; want 2, 1, 3", runs, malformed, lines)
+	}
+
+	rows, err := db.Query("SELECT run_id, endpoint, count, min, avg, max, sum FROM endpoint_stats ORDER BY run_id, endpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	type row struct {
+		run         int64
+		endpoint    string
+		count, minV int64
+		avg         float64
+		maxV, sum   int64
+	}
+	var got []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.run, &r.endpoint, &r.count, &r.minV, &r.avg, &r.maxV, &r.sum); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, r)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []row{
+		{1, "/api/users", 2, 10, 20, 30, 40}, {1, "/o'brien", 1, 7, 7, 7, 7},
+		{2, "/api/users", 2, 10, 20, 30, 40}, {2, "/o'brien", 1, 7, 7, 7, 7},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("endpoint_stats = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// Скрипт -format sql пишет в ту же схему, и его можно выполнить в этой же базе
+	script, code := runAnalyzeFile(t, "-format", "sql", log)
+	if code != exitOK {
+		t.Fatalf("-format sql: exit %d", code)
+	}
+	if _, err := db.Exec(script); err != nil {
+		t.Fatalf("-format sql script does not apply to the -sqlite-out database: %v", err)
+	}
+	var avg float64
+	if err := db.QueryRow("SELECT avg FROM endpoint_stats WHERE run_id = 3 AND endpoint = '/api/users'").Scan(&avg); err != nil || avg != 20 {
+		t.Errorf("run 3 /api/users avg = %v, %v; want 20", avg, err)
+	}
+}
+
+func TestSQLiteOutRejectsStdout(t *testing.T) {
+	if _, err := parseArgs([]string{"-sqlite-out", "-", "a.log"}); err == nil {
+		t.Error("-sqlite-out - accepted")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Максимальный размер UDP-пакета для statsd: MTU 1500 минус заголовки IP и UDP с запасом,
+// как советует документация DogStatsD
+const statsdMaxPacket = 1432
+
+// Как долго ждать отправки одного пакета; UDP не блокируется, но Dial разрешает имя
+const statsdTimeout = 5 * time.Second
+
+// Имя метрики statsd: буквы, цифры, _ и ., разделитель уровней
+var statsdPrefixRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.]*$`)
+
+// statsdTagReplacer убирает из значения тега символы, которые DogStatsD считает
+// разделителями: | между полями, , между тегами, # перед тегами, и пробелы
+var statsdTagReplacer = strings.NewReplacer("|", "_", ",", "_", "#", "_", " ", "_", "\t", "_", "\n", "_", "\r", "_")
+
+// statsdTagValue готовит имя эндпоинта для тега endpoint:...
+func statsdTagValue(s string) string {
+	return statsdTagReplacer.Replace(strings.ToValidUTF8(s, "_"))
+}
+
+// statsdLines формирует строки метрик: gauge min/avg/max в мс и count запросов за прогон
+func statsdLines(rep *report, prefix string, precision int) []string {
+	ms := timeUnits[defaultUnit]
+	lines := make([]string, 0, len(rep.entries)*4)
+	for _, e := range rep.entries {
+		tag := "|#endpoint:" + statsdTagValue(e.name)
+		lines = append(lines,
+			prefix+"endpoint.response_time.min:"+ms.formatValue(e.stats.Min, 0)+"|g"+tag,
+			prefix+"endpoint.response_time.avg:"+ms.formatAvg(e.stats, precision)+"|g"+tag,
+			prefix+"endpoint.response_time.max:"+ms.formatValue(e.stats.Max, 0)+"|g"+tag,
+			fmt.Sprintf("%sendpoint.requests:%d|c%s", prefix, e.stats.Count, tag),
+		)
+	}
+	return lines
+}
+
+// statsdPackets склеивает строки через \n в пакеты не больше statsdMaxPacket.
+// Строка длиннее предела уходит отдельным пакетом: резать её нельзя, а сервер её хотя бы увидит
+func statsdPackets(lines []string) [][]byte {
+	var packets [][]byte
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if buf.Len() > 0 && buf.Len()+1+len(line) > statsdMaxPacket {
+			packets = append(packets, bytes.Clone(buf.Bytes()))
+			buf.Reset()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() > 0 {
+		packets = append(packets, buf.Bytes())
+	}
+	return packets
+}
+
+// sendStatsd отправляет отчёт по UDP на addr. Ошибки только возвращаются: вызывающий
+// предупреждает, но прогон из-за недоступного агента не проваливается
+func sendStatsd(addr, prefix string, precision int, rep *report) error {
+	conn, err := net.DialTimeout("udp", addr, statsdTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	packets := statsdPackets(statsdLines(rep, prefix, precision))
+	for i, p := range packets {
+		conn.SetWriteDeadline(time.Now().Add(statsdTimeout))
+		if _, err := conn.Write(p); err != nil {
+			return fmt.Errorf("packet %d of %d: %w", i+1, len(packets), err)
+		}
+	}
+	logger.infof("statsd: sent %d metrics to %s in %d packets", len(rep.entries)*4, addr, len(packets))
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenStatsd - фальшивый агент: UDP-сокет на свободном порту localhost
+func listenStatsd(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skipf("no UDP on localhost: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readPackets читает пакеты, пока они приходят; отправка уже закончилась, так что
+// короткой паузы после последнего пакета хватает
+func readPackets(t *testing.T, conn *net.UDPConn) []string {
+	t.Helper()
+	var packets []string
+	buf := make([]byte, 64<<10)
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return packets
+		}
+		packets = append(packets, string(buf[:n]))
+	}
+}
+
+func TestStatsdSend(t *testing.T) {
+	conn := listenStatsd(t)
+	log := writeTempFile(t, "a.log", "2024-01-01T00:00:00Z 10.0.0.1 GET /api/users 200 10\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /api/users 200 25\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a|b,c#d 200 7\n")
+	if _, code := runAnalyzeFile(t, "-statsd-addr", conn.LocalAddr().String(), "-statsd-prefix", "iw.", log); code != exitOK {
+		t.Fatalf("exit %d", code)
+	}
+	packets := readPackets(t, conn)
+	if len(packets) != 1 {
+		t.Fatalf("got %d packets, want 1: %q", len(packets), packets)
+	}
+	got := strings.Split(packets[0], "\n")
+	want := []string{
+		"iw.endpoint.response_time.min:10|g|#endpoint:/api/users",
+		"iw.endpoint.response_time.avg:17.5|g|#endpoint:/api/users",
+		"iw.endpoint.response_time.max:25|g|#endpoint:/api/users",
+		"iw.endpoint.requests:2|c|#endpoint:/api/users",
+		"iw.endpoint.response_time.min:7|g|#endpoint:/a_b_c_d",
+		"iw.endpoint.response_time.avg:7.0|g|#endpoint:/a_b_c_d",
+		"iw.endpoint.response_time.max:7|g|#endpoint:/a_b_c_d",
+		"iw.endpoint.requests:1|c|#endpoint:/a_b_c_d",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("metrics:\n%s\nwant:\n%s", packets[0], strings.Join(want, "\n"))
+	}
+}
+
+func TestStatsdPacketLimit(t *testing.T) {
+	conn := listenStatsd(t)
+	var sb strings.Builder
+	for i := range 300 {
+		fmt.Fprintf(&sb, "2024-01-01T00:00:00Z 10.0.0.1 GET /api/items/%03d 200 %d\n", i, i+1)
+	}
+	if _, code := runAnalyzeFile(t, "-statsd-addr", conn.LocalAddr().String(), writeTempFile(t, "many.log", sb.String())); code != exitOK {
+		t.Fatalf("exit %d", code)
+	}
+	packets := readPackets(t, conn)
+	lines := 0
+	for i, p := range packets {
+		if len(p) > statsdMaxPacket {
+			t.Errorf("packet %d is %d bytes, limit %d", i, len(p), statsdMaxPacket)
+		}
+		// Пакет режется только между строками
+		for _, line := range strings.Split(p, "\n") {
+			if !strings.HasPrefix(line, "endpoint.") || !strings.Contains(line, "|#endpoint:/api/items/") {
+				t.Errorf("packet %d: broken line %q", i, line)
+			}
+			lines++
+		}
+	}
+	if lines != 300*4 || len(packets) < 2 {
+		t.Errorf("got %d lines in %d packets, want %d lines in several packets", lines, len(packets), 300*4)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import "strconv"
+
+// Классы статусов для -status-breakdown: 2xx-5xx по первой цифре, всё остальное
+// (не три цифры, 1xx, 6xx и выше) - unknown
+const (
+	statusClassCount = 5
+	statusUnknown    = statusClassCount - 1
+	// errorRatePrecision - знаков после точки у error_rate
+	errorRatePrecision = 4
+)
+
+// statusNames - суффиксы полей status_2xx ... status_unknown в порядке классов
+var statusNames = [statusClassCount]string{"2xx", "3xx", "4xx", "5xx", "unknown"}
+
+// statusCounts - сколько запросов эндпоинта пришлось на каждый класс статусов
+type statusCounts [statusClassCount]int64
+
+// statusClass - класс статуса b. Статус разбирается только здесь: parseLine берёт
+// пятое поле любой ширины как есть, и "-" или нецифровой статус строку не ломает
+func statusClass(b []byte) int {
+	if len(b) != 3 || b[0] < '2' || b[0] > '5' || !isDigit(b[1]) || !isDigit(b[2]) {
+		return statusUnknown
+	}
+	return int(b[0] - '2')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (c *statusCounts) merge(o *statusCounts) {
+	for i, n := range o {
+		c[i] += n
+	}
+}
+
+// errorRate - доля 4xx и 5xx среди всех запросов с errorRatePrecision знаками
+func (c *statusCounts) errorRate() string {
+	var count int64
+	for _, n := range c {
+		count += n
+	}
+	if count == 0 {
+		return formatRatio(0, 1, errorRatePrecision)
+	}
+	return formatRatio(c[2]+c[3], count, errorRatePrecision)
+}
+
+// statusColumns - status_2xx ... status_unknown и error_rate
+func statusColumns() []extraColumn {
+	counts := func(s *Stats) (*statusCounts, bool) {
+		if s == nil || s.extra == nil || s.extra.status == nil {
+			return nil, false
+		}
+		return s.extra.status, true
+	}
+	cols := make([]extraColumn, 0, statusClassCount+1)
+	for i, name := range statusNames {
+		title := name
+		if i == statusUnknown {
+			title = "Unknown"
+		}
+		cols = append(cols, extraColumn{"status_" + name, title, func(s *Stats) (string, bool) {
+			c, ok := counts(s)
+			if !ok {
+				return "", false
+			}
+			return strconv.FormatInt(c[i], 10), true
+		}})
+	}
+	return append(cols, extraColumn{"error_rate", "Error rate", func(s *Stats) (string, bool) {
+		c, ok := counts(s)
+		if !ok {
+			return "", false
+		}
+		return c.errorRate(), true
+	}})
+}
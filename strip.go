@@ -0,0 +1,105 @@
+package main
+
+import "bytes"
+
+// pathStrip - что меняется в path прямо в line до агрегации. Порядок: -strip-query и
+// -strip-fragment, затем -decode-paths, -normalize-trailing-slash и
+// -case-insensitive-paths; правила -normalize-rules и -auto-normalize видят уже результат
+type pathStrip struct {
+	query, fragment bool
+	decode          bool
+	trailingSlash   bool
+	lower           bool
+}
+
+func pathStripOf(opts *options) pathStrip {
+	return pathStrip{query: opts.stripQuery, fragment: opts.stripFragment, decode: opts.decodePaths,
+		trailingSlash: opts.trailingSlash, lower: opts.caseInsensitivePaths}
+}
+
+func (s pathStrip) enabled() bool {
+	return s.query || s.fragment || s.decode || s.trailingSlash || s.lower
+}
+
+// apply меняет rec.path и байты line под ним. Ключ и карта stats видят уже
+// изменённый path
+func (s pathStrip) apply(line []byte, rec *lineRecord) {
+	if rec.altPath != nil {
+		return
+	}
+	if s.query || s.fragment {
+		s.cut(line, rec)
+	}
+	// Раскрытие после обрезки: %3F - часть path, а не начало query
+	if s.decode {
+		p := line[rec.path.start:rec.path.end]
+		if bytes.IndexByte(p, '%') >= 0 {
+			rec.path.end = rec.path.start + percentDecode(p)
+		}
+	}
+	if s.trailingSlash && rec.path.end-rec.path.start > 1 && line[rec.path.end-1] == '/' {
+		rec.path.end--
+	}
+	if s.lower {
+		// Только ASCII: байты UTF-8 больше 0x7f не трогаются, так что /Über и /über -
+		// разные эндпоинты
+		for i := rec.path.start; i < rec.path.end; i++ {
+			if c := line[i]; c >= 'A' && c <= 'Z' {
+				line[i] = c + 'a' - 'A'
+			}
+		}
+	}
+}
+
+// cut укорачивает rec.path до первого '?' или '#'. Path, от которого ничего не
+// осталось ("?page=2"), становится "/": его первый байт переписывается прямо в line,
+// как при раскрытии экранирования
+func (s pathStrip) cut(line []byte, rec *lineRecord) {
+	for i := rec.path.start; i < rec.path.end; i++ {
+		if c := line[i]; c == '?' && s.query || c == '#' && s.fragment {
+			if i == rec.path.start {
+				line[i] = '/'
+				i++
+			}
+			rec.path.end = i
+			return
+		}
+	}
+}
+
+// percentDecode раскрывает %XX прямо в b и возвращает новую длину: раскрытое короче
+// записи, как у unescapeJSON. Неверные последовательности (%G1, % в конце) остаются как
+// есть. Байты не собираются в UTF-8, так что %C0%AF - два байта, а не '/'
+func percentDecode(b []byte) int {
+	w := 0
+	for r := 0; r < len(b); r++ {
+		if c := b[r]; c == '%' && r+2 < len(b) && isHexDigit(b[r+1]) && isHexDigit(b[r+2]) {
+			b[w] = hexValue(b[r+1])<<4 | hexValue(b[r+2])
+			r += 2
+		} else {
+			b[w] = c
+		}
+		w++
+	}
+	return w
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
+
+// rawKeysOf - HyperLogLog ключей до обрезки, нормализации и алиасов для -stats; nil, если
+// path не меняется или сводка не нужна
+func rawKeysOf(opts *options) *hllSpec {
+	rewrites := pathStripOf(opts).enabled() || len(opts.normalizeRules) > 0 || opts.autoNormalize || opts.aliases != nil
+	if !opts.stats || !rewrites {
+		return nil
+	}
+	return newHLLSpec(defaultHLLPrecision)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPercentDecode(t *testing.T) {
+	for in, want := range map[string]string{
+		"/search%2Fq":    "/search/q",
+		"/a%2fb":         "/a/b",
+		"/hello%20world": "/hello world",
+		"/%E2%82%AC":     "/€",
+		"/caf%C3%A9":     "/café",
+		// Overlong UTF-8 не собирается в '/': это два байта как есть
+		"/a%C0%AFb":    "/a\xC0\xAFb",
+		"/a%E0%80%AFb": "/a\xE0\x80\xAFb",
+		// Уже раскрытый path не меняется, раскрытие однократное
+		"/search/q":    "/search/q",
+		"/über straße": "/über straße",
+		"/a%252Fb":     "/a%2Fb",
+		// Неверные последовательности остаются как есть
+		"/a%G1":     "/a%G1",
+		"/a%":       "/a%",
+		"/a%2":      "/a%2",
+		"/100%":     "/100%",
+		"/%%41":     "/%A",
+		"%41%42%43": "ABC",
+		"":          "",
+	} {
+		b := []byte(in)
+		if got := string(b[:percentDecode(b)]); got != want {
+			t.Errorf("percentDecode(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestDecodePathsBeforeRules(t *testing.T) {
+	rules := writeTempFile(t, "rules.txt", "/users/* => /users/{id}\n")
+	// %2F раскрывается в '/' и меняет число сегментов; %3F после -strip-query - часть path
+	path := writeTempFile(t, "enc.log", "2024-01-01T00:00:00Z 10.0.0.1 GET /users%2F42 200 10\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /users/7 200 20\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /q%3Fx?page=2 200 30\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /a%C0%AFb 200 40\n")
+	endpoints := func(args ...string) map[string]any {
+		t.Helper()
+		out, code := runAnalyzeFile(t, append(args, path)...)
+		if code != exitOK {
+			t.Fatalf("%v: exit %d", args, code)
+		}
+		var doc struct {
+			Endpoints map[string]any `json:"endpoints"`
+		}
+		if err := json.Unmarshal([]byte(out), &doc); err != nil {
+			t.Fatal(err)
+		}
+		return doc.Endpoints
+	}
+
+	got := endpoints("-decode-paths", "-strip-query", "-normalize-rules", rules)
+	// Overlong-байты в JSON становятся U+FFFD
+	for _, want := range []string{"/users/{id}", "/q?x", "/a\uFFFD\uFFFDb"} {
+		if _, ok := got[want]; !ok {
+			t.Errorf("-decode-paths: %q missing from %v", want, got)
+		}
+	}
+	if len(got) != 3 {
+		t.Errorf("-decode-paths: %d endpoints, want 3: %v", len(got), got)
+	}
+	// Без -decode-paths /users%2F42 - один сегмент, и правило его не берёт
+	got = endpoints("-strip-query", "-normalize-rules", rules)
+	if _, ok := got["/users%2F42"]; !ok {
+		t.Errorf("without -decode-paths: /users%%2F42 missing from %v", got)
+	}
+}
+
+// stripped - path после s
+func stripped(s pathStrip, path string) string {
+	line := []byte(path)
+	rec := lineRecord{path: span{0, len(line)}}
+	s.apply(line, &rec)
+	return string(line[rec.path.start:rec.path.end])
+}
+
+func TestTrailingSlashAndCase(t *testing.T) {
+	slash := pathStrip{trailingSlash: true}
+	for in, want := range map[string]string{
+		"/api/users/": "/api/users",
+		"/api/users":  "/api/users",
+		// "/" остаётся собой, и снимается только один '/'
+		"/":    "/",
+		"//":   "/",
+		"/a//": "/a/",
+		"":     "",
+	} {
+		if got := stripped(slash, in); got != want {
+			t.Errorf("-normalize-trailing-slash: %q -> %q, want %q", in, got, want)
+		}
+	}
+
+	// '/' в конце снимается уже после -strip-query и -decode-paths
+	all := pathStrip{query: true, fragment: true, decode: true, trailingSlash: true, lower: true}
+	for in, want := range map[string]string{
+		"/API/Users/?Page=2": "/api/users",
+		"/?x=1":              "/",
+		"/a/#Top":            "/a",
+		"/A%2F":              "/a",
+		"/A%2f%41":           "/a/a",
+		// Меняются только ASCII-буквы
+		"/Über/ÄX": "/Über/Äx",
+	} {
+		if got := stripped(all, in); got != want {
+			t.Errorf("all: %q -> %q, want %q", in, got, want)
+		}
+	}
+	if got := stripped(pathStrip{trailingSlash: true}, "/a/?x"); got != "/a/?x" {
+		t.Errorf("without -strip-query: /a/?x -> %q", got)
+	}
+}
+
+func TestTrailingSlashAndCaseFlags(t *testing.T) {
+	rules := writeTempFile(t, "rules.txt", "/users/* => /users/{id}\n")
+	path := writeTempFile(t, "paths.log", "2024-01-01T00:00:00Z 10.0.0.1 GET /Users/1/ 200 10\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /USERS/2 200 30\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET /users/3 200 20\n"+
+		"2024-01-01T00:00:00Z 10.0.0.1 GET / 200 1\n")
+	for _, workers := range []string{"1", "3"} {
+		got := endpointsOf(t, path, "-normalize-trailing-slash", "-case-insensitive-paths", "-normalize-rules", rules, "-workers", workers)
+		// Правила видят уже путь в нижнем регистре и без '/' в конце
+		if len(got) != 2 || got["/users/{id}"]["count"] != 3.0 || got["/"] == nil {
+			t.Errorf("-workers %s: %v", workers, got)
+		}
+	}
+	got := endpointsOf(t, path, "-normalize-trailing-slash")
+	for _, want := range []string{"/Users/1", "/USERS/2", "/users/3", "/"} {
+		if got[want] == nil {
+			t.Errorf("-normalize-trailing-slash: %q missing from %v", want, got)
+		}
+	}
+	got = endpointsOf(t, path, "-case-insensitive-paths")
+	for _, want := range []string{"/users/1/", "/users/2", "/users/3", "/"} {
+		if got[want] == nil {
+			t.Errorf("-case-insensitive-paths: %q missing from %v", want, got)
+		}
+	}
+}
+
+// endpointsOf - эндпоинты JSON-вывода analyze с -include-count и args
+func endpointsOf(t *testing.T, path string, args ...string) map[string]map[string]any {
+	t.Helper()
+	out, code := runAnalyzeFile(t, append(append([]string{"-include-count"}, args...), path)...)
+	if code != exitOK {
+		t.Fatalf("%v: exit %d", args, code)
+	}
+	var doc struct {
+		Endpoints map[string]map[string]any `json:"endpoints"`
+	}
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc.Endpoints
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// -time-format: как записано время ответа. Stats всегда в целых миллисекундах, дроби
+// округляются до ближайшей, половина - вверх: 0.0425s -> 43ms, 42.5ms -> 43ms
+const (
+	timeFormatIntMs   = "int-ms"
+	timeFormatSeconds = "float-seconds"
+	timeFormatAuto    = "auto"
+)
+
+type timeFormat uint8
+
+const (
+	// timeIntMillis - целые миллисекунды, как было всегда
+	timeIntMillis timeFormat = iota
+	// timeFloatSeconds - секунды с дробью: 0.042 или 2
+	timeFloatSeconds
+	// timeAuto - число с суффиксом ms, s или us; без суффикса целое - миллисекунды,
+	// а число с точкой - секунды, как time-taken у w3c
+	timeAuto
+)
+
+var timeFormats = map[string]timeFormat{
+	timeFormatIntMs:   timeIntMillis,
+	timeFormatSeconds: timeFloatSeconds,
+	timeFormatAuto:    timeAuto,
+}
+
+func timeFormatNames() string {
+	return timeFormatIntMs + ", " + timeFormatSeconds + ", " + timeFormatAuto
+}
+
+// millis разбирает время ответа в миллисекунды прямо по байтам, без аллокаций. bad -
+// индекс байта в b, на котором разбор остановился, для ошибки
+func (tf timeFormat) millis(b []byte) (ms int64, bad int, err error) {
+	if tf == timeIntMillis {
+		ms, err = parseIntFast(b)
+		if err != nil {
+			return 0, badDigitIndex(b), err
+		}
+		return ms, 0, nil
+	}
+	return tf.fraction(b)
+}
+
+// fraction - millis для float-seconds и auto
+func (tf timeFormat) fraction(b []byte) (ms int64, bad int, err error) {
+	// Пробелы по краям допустимы, как у parseIntFast
+	start, end := 0, len(b)
+	for start < end && isNumberSpace(b[start]) {
+		start++
+	}
+	for end > start && isNumberSpace(b[end-1]) {
+		end--
+	}
+	num := b[start:end]
+	scale := 3
+	micros := false
+	if tf == timeAuto {
+		switch {
+		case bytes.HasSuffix(num, []byte("us")):
+			num, micros = num[:len(num)-2], true
+		case bytes.HasSuffix(num, []byte("ms")):
+			num, scale = num[:len(num)-2], 0
+		case bytes.HasSuffix(num, []byte("s")):
+			num = num[:len(num)-1]
+		case bytes.IndexByte(num, '.') < 0:
+			scale = 0
+		}
+	}
+	if len(num) == 0 {
+		return 0, 0, fmt.Errorf("no digits in %q", b)
+	}
+	if micros {
+		return microsToMillis(num, start)
+	}
+	ms, bad, err = parseScaled(num, scale)
+	if err != nil {
+		return 0, start + bad, err
+	}
+	return ms, 0, nil
+}
+
+// jsonMillis - millis для jsonl и logfmt: с int-ms там, как и раньше, годится и дробное
+// число миллисекунд
+func (tf timeFormat) jsonMillis(b []byte) (int64, int, error) {
+	if tf == timeIntMillis {
+		ms, err := parseJSONMillis(b)
+		return ms, 0, err
+	}
+	return tf.millis(b)
+}
+
+// microsToMillis переводит микросекунды в миллисекунды. Дробь микросекунды на результат
+// не влияет: round(x/1000) для x из [n, n+1) - это (n+500)/1000
+func microsToMillis(num []byte, start int) (int64, int, error) {
+	// Сначала всё число целиком, чтобы битая дробь тоже была ошибкой
+	if _, bad, err := parseScaled(num, 0); err != nil {
+		return 0, start + bad, err
+	}
+	if dot := bytes.IndexByte(num, '.'); dot >= 0 {
+		num = num[:dot]
+	}
+	micros, _, _ := parseScaled(num, 0)
+	return micros/1000 + (micros%1000+500)/1000, 0, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestTimeFormatRounding(t *testing.T) {
+	tests := []struct {
+		format timeFormat
+		in     string
+		want   int64
+		ok     bool
+	}{
+		{timeIntMillis, "42", 42, true},
+		{timeIntMillis, "0.042", 0, false},
+		{timeIntMillis, "42ms", 0, false},
+
+		{timeFloatSeconds, "0.042", 42, true},
+		// Половина миллисекунды округляется вверх, решает первая лишняя цифра
+		{timeFloatSeconds, "0.0425", 43, true},
+		{timeFloatSeconds, "0.0424999", 42, true},
+		{timeFloatSeconds, "0.0005", 1, true},
+		{timeFloatSeconds, "0.0004999", 0, true},
+		{timeFloatSeconds, "1.9995", 2000, true},
+		{timeFloatSeconds, "2", 2000, true},
+		{timeFloatSeconds, " 0.1 ", 100, true},
+		{timeFloatSeconds, "0.1\r", 100, true},
+		{timeFloatSeconds, "1.", 0, false},
+		{timeFloatSeconds, ".5", 0, false},
+		{timeFloatSeconds, "-0.1", 0, false},
+		{timeFloatSeconds, "1e-3", 0, false},
+		{timeFloatSeconds, "0.1s", 0, false},
+		{timeFloatSeconds, "", 0, false},
+
+		{timeAuto, "42", 42, true},
+		{timeAuto, "42ms", 42, true},
+		{timeAuto, "42.5ms", 43, true},
+		{timeAuto, "42.4999ms", 42, true},
+		{timeAuto, "0.0425s", 43, true},
+		{timeAuto, "1.2s", 1200, true},
+		// Число с точкой без суффикса - секунды
+		{timeAuto, "0.5", 500, true},
+		{timeAuto, "1499.9us", 1, true},
+		{timeAuto, "1500us", 2, true},
+		{timeAuto, "499us", 0, true},
+		{timeAuto, "500us", 1, true},
+		{timeAuto, "0.9us", 0, true},
+		{timeAuto, "ms", 0, false},
+		{timeAuto, "42 ms", 0, false},
+		{timeAuto, "42MS", 0, false},
+		{timeAuto, "1.2.3s", 0, false},
+		{timeAuto, "12.xus", 0, false},
+	}
+	for _, tt := range tests {
+		got, _, err := tt.format.millis([]byte(tt.in))
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("format %d: millis(%q) = %d, %v; want %d, ok %v", tt.format, tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTimeFormatJSON(t *testing.T) {
+	// В jsonl и logfmt int-ms принимает и дробные миллисекунды
+	for in, want := range map[string]int64{"42": 42, "42.5": 43, "42.4": 42} {
+		if got, _, err := timeIntMillis.jsonMillis([]byte(in)); err != nil || got != want {
+			t.Errorf("jsonMillis(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	if got, _, err := timeAuto.jsonMillis([]byte("0.0425s")); err != nil || got != 43 {
+		t.Errorf("auto jsonMillis(0.0425s) = %d, %v; want 43", got, err)
+	}
+}
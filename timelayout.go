@@ -0,0 +1,359 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultTimeLayout - раскладка timestamp по умолчанию, как в time.RFC3339
+const defaultTimeLayout = time.RFC3339
+
+// layoutKind - элемент раскладки timestamp
+type layoutKind uint8
+
+const (
+	layoutLiteral layoutKind = iota
+	layoutYear
+	layoutMonth
+	// layoutMonthName - трёхбуквенное имя месяца по-английски (Jan), как в CLF
+	layoutMonthName
+	layoutDay
+	layoutHour
+	layoutMinute
+	layoutSecond
+	// layoutFraction - ровно width цифр долей секунды (.000), layoutOptFraction - точка
+	// и сколько угодно цифр или ничего (.999)
+	layoutFraction
+	layoutOptFraction
+	// layoutZone - смещение ±hh:mm (или ±hhmm без colon); с zulu вместо него может быть Z
+	layoutZone
+)
+
+type layoutElem struct {
+	kind  layoutKind
+	lit   byte
+	width int
+	colon bool
+	zulu  bool
+	// off - смещение элемента от начала timestamp для элементов фиксированной части
+	off int
+	// implicit - доли секунды, которых нет в раскладке: как и time.Parse, parse берёт их
+	// после секунд, только если за точкой идёт цифра
+	implicit bool
+}
+
+// layoutTokens - поддерживаемые элементы раскладок Go; длинные идут раньше своих префиксов
+var layoutTokens = []struct {
+	token string
+	elem  layoutElem
+}{
+	{"Z07:00", layoutElem{kind: layoutZone, colon: true, zulu: true}},
+	{"Z0700", layoutElem{kind: layoutZone, zulu: true}},
+	{"-07:00", layoutElem{kind: layoutZone, colon: true}},
+	{"-0700", layoutElem{kind: layoutZone}},
+	{"2006", layoutElem{kind: layoutYear, width: 4}},
+	{"01", layoutElem{kind: layoutMonth, width: 2}},
+	{"Jan", layoutElem{kind: layoutMonthName, width: 3}},
+	{"02", layoutElem{kind: layoutDay, width: 2}},
+	{"15", layoutElem{kind: layoutHour, width: 2}},
+	{"04", layoutElem{kind: layoutMinute, width: 2}},
+	{"05", layoutElem{kind: layoutSecond, width: 2}},
+}
+
+// Элементы раскладок Go, которые парсер не понимает: у них нет фиксированной ширины
+// или они текстовые
+var unsupportedLayoutTokens = []string{"January", "Mon", "MST", "PM", "pm", "_2", "__2", "06", "002"}
+
+// timeLayout - раскладка timestamp, разобранная один раз. parse читает по ней строку
+// без time.Parse, без аллокаций и без часовых поясов по имени
+type timeLayout struct {
+	text  string
+	elems []layoutElem
+	// Первые fixed элементов - литералы и поля цифр с известными смещениями, они занимают
+	// fixedLen байт; parse проверяет их без продвижения по строке. Обычно это всё, кроме
+	// долей секунды и часового пояса
+	fixed    int
+	fixedLen int
+}
+
+func compileTimeLayout(layout string) (*timeLayout, error) {
+	l := &timeLayout{text: layout}
+	var have [layoutZone + 1]bool
+	for i := 0; i < len(layout); {
+		// Полное имя месяца начинается с Jan, но ширина у него переменная
+		if strings.HasPrefix(layout[i:], "January") {
+			return nil, fmt.Errorf("invalid time layout %q: element %q is not supported", layout, "January")
+		}
+		if elem, n, ok := matchLayoutToken(layout[i:]); ok {
+			// 01 и Jan - один и тот же месяц
+			kind := elem.kind
+			if kind == layoutMonthName {
+				kind = layoutMonth
+			}
+			if have[kind] {
+				return nil, fmt.Errorf("invalid time layout %q: %s appears twice", layout, layout[i:i+n])
+			}
+			have[kind] = true
+			l.elems = append(l.elems, elem)
+			i += n
+			continue
+		}
+		for _, tok := range unsupportedLayoutTokens {
+			if strings.HasPrefix(layout[i:], tok) {
+				return nil, fmt.Errorf("invalid time layout %q: element %q is not supported", layout, tok)
+			}
+		}
+		if c := layout[i]; c >= '0' && c <= '9' {
+			return nil, fmt.Errorf("invalid time layout %q: unexpected digit at %d", layout, i)
+		}
+		l.elems = append(l.elems, layoutElem{kind: layoutLiteral, lit: layout[i]})
+		i++
+	}
+	for _, kind := range []layoutKind{layoutYear, layoutMonth, layoutDay, layoutHour, layoutMinute, layoutSecond} {
+		if !have[kind] {
+			return nil, fmt.Errorf("invalid time layout %q: must contain 2006, 01 (or Jan), 02, 15, 04 and 05", layout)
+		}
+	}
+	// time.Parse принимает доли секунды сразу после секунд, даже если раскладка их не
+	// называет: 2024-01-01T00:00:00.5Z подходит под RFC3339
+	if !have[layoutFraction] && !have[layoutOptFraction] {
+		for i, e := range l.elems {
+			if e.kind == layoutSecond {
+				l.elems = append(l.elems[:i+1], append([]layoutElem{{kind: layoutOptFraction, implicit: true}}, l.elems[i+1:]...)...)
+				break
+			}
+		}
+	}
+	for ; l.fixed < len(l.elems); l.fixed++ {
+		e := &l.elems[l.fixed]
+		if e.kind == layoutFraction || e.kind == layoutOptFraction || e.kind == layoutZone {
+			break
+		}
+		e.off = l.fixedLen
+		l.fixedLen += max(e.width, 1)
+	}
+	return l, nil
+}
+
+// matchLayoutToken узнаёт элемент в начале s, в том числе доли секунды .000 и .999
+func matchLayoutToken(s string) (layoutElem, int, bool) {
+	if len(s) > 1 && s[0] == '.' && (s[1] == '0' || s[1] == '9') {
+		n := 1
+		for n < len(s) && s[n] == s[1] {
+			n++
+		}
+		if s[1] == '0' {
+			return layoutElem{kind: layoutFraction, width: n - 1}, n, true
+		}
+		return layoutElem{kind: layoutOptFraction}, n, true
+	}
+	for _, t := range layoutTokens {
+		if strings.HasPrefix(s, t.token) {
+			return t.elem, len(t.token), true
+		}
+	}
+	return layoutElem{}, 0, false
+}
+
+// parse переводит timestamp b в миллисекунды Unix; ok = false, если b не по раскладке
+// или дата невозможна. b должен совпасть с раскладкой целиком
+func (l *timeLayout) parse(b []byte) (ms int64, ok bool) {
+	if len(b) < l.fixedLen {
+		return 0, false
+	}
+	// f - значения полей по layoutKind
+	var f [layoutZone + 1]int64
+	for _, e := range l.elems[:l.fixed] {
+		if e.kind == layoutLiteral {
+			if b[e.off] != e.lit {
+				return 0, false
+			}
+			continue
+		}
+		if e.kind == layoutMonthName {
+			v, ok := parseMonthName(b[e.off : e.off+e.width])
+			if !ok {
+				return 0, false
+			}
+			f[layoutMonth] = v
+			continue
+		}
+		v, ok := parseFixedDigits(b[e.off : e.off+e.width])
+		if !ok {
+			return 0, false
+		}
+		f[e.kind] = v
+	}
+	var frac, zone int64
+	var fracDigits int
+	i := l.fixedLen
+	for _, e := range l.elems[l.fixed:] {
+		switch e.kind {
+		case layoutLiteral:
+			if i >= len(b) || b[i] != e.lit {
+				return 0, false
+			}
+			i++
+		case layoutFraction, layoutOptFraction:
+			if e.kind == layoutOptFraction && (i >= len(b) || b[i] != '.') {
+				continue
+			}
+			if e.implicit && (i+1 >= len(b) || b[i+1] < '0' || b[i+1] > '9') {
+				continue
+			}
+			if i >= len(b) || b[i] != '.' {
+				return 0, false
+			}
+			start := i + 1
+			end := start
+			for end < len(b) && b[end] >= '0' && b[end] <= '9' && (e.kind == layoutOptFraction || end-start < e.width) {
+				end++
+			}
+			if end == start || (e.kind == layoutFraction && end-start != e.width) {
+				return 0, false
+			}
+			// Миллисекундам хватает трёх первых цифр, остальные отбрасываются
+			for _, c := range b[start:min(end, start+3)] {
+				frac = frac*10 + int64(c-'0')
+				fracDigits++
+			}
+			i = end
+		case layoutZone:
+			if e.zulu && i < len(b) && b[i] == 'Z' {
+				i++
+				continue
+			}
+			width := 5
+			if e.colon {
+				width = 6
+			}
+			if i+width > len(b) || (b[i] != '+' && b[i] != '-') || (e.colon && b[i+3] != ':') {
+				return 0, false
+			}
+			hh, ok1 := parseFixedDigits(b[i+1 : i+3])
+			mm, ok2 := parseFixedDigits(b[i+width-2 : i+width])
+			if !ok1 || !ok2 || hh > 23 || mm > 59 {
+				return 0, false
+			}
+			zone = (hh*60 + mm) * 60
+			if b[i] == '-' {
+				zone = -zone
+			}
+			i += width
+		case layoutMonthName:
+			if i+e.width > len(b) {
+				return 0, false
+			}
+			v, ok := parseMonthName(b[i : i+e.width])
+			if !ok {
+				return 0, false
+			}
+			f[layoutMonth] = v
+			i += e.width
+		default:
+			if i+e.width > len(b) {
+				return 0, false
+			}
+			v, ok := parseFixedDigits(b[i : i+e.width])
+			if !ok {
+				return 0, false
+			}
+			f[e.kind] = v
+			i += e.width
+		}
+	}
+	year, month, day := f[layoutYear], f[layoutMonth], f[layoutDay]
+	hour, minute, sec := f[layoutHour], f[layoutMinute], f[layoutSecond]
+	if i != len(b) || month < 1 || month > 12 || day < 1 || day > daysIn(month, year) || hour > 23 || minute > 59 || sec > 59 {
+		return 0, false
+	}
+	for ; fracDigits < 3; fracDigits++ {
+		frac *= 10
+	}
+	secs := daysFromCivil(year, month, day)*86400 + hour*3600 + minute*60 + sec - zone
+	return secs*1000 + frac, true
+}
+
+// parseFixedDigits разбирает ровно len(b) десятичных цифр
+func parseFixedDigits(b []byte) (int64, bool) {
+	var v int64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		v = v*10 + int64(c-'0')
+	}
+	return v, true
+}
+
+// monthNames - имена месяцев для Jan, как их пишет CLF
+var monthNames = [12]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+
+// parseMonthName переводит имя месяца в номер с единицы; регистр должен совпасть
+func parseMonthName(b []byte) (int64, bool) {
+	for i, name := range monthNames {
+		if string(b) == name {
+			return int64(i + 1), true
+		}
+	}
+	return 0, false
+}
+
+func daysIn(month, year int64) int64 {
+	switch month {
+	case 2:
+		if year%4 == 0 && (year%100 != 0 || year%400 == 0) {
+			return 29
+		}
+		return 28
+	case 4, 6, 9, 11:
+		return 30
+	}
+	return 31
+}
+
+// daysFromCivil - число дней от 1970-01-01 до даты григорианского календаря
+// (алгоритм days_from_civil Говарда Хиннанта; годы здесь не отрицательны)
+func daysFromCivil(y, m, d int64) int64 {
+	if m <= 2 {
+		y--
+	}
+	era := y / 400
+	if y < 0 {
+		era = (y - 399) / 400
+	}
+	yoe := y - era*400
+	mp := (m + 9) % 12
+	doy := (153*mp+2)/5 + d - 1
+	doe := yoe*365 + yoe/4 - yoe/100 + doy
+	return era*146097 + doe - 719468
+}
+
+// formatTimestamp печатает миллисекунды Unix как RFC3339 в UTC; доли секунды - только ненулевые
+func formatTimestamp(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339Nano)
+}
+
+// seenRange - самый ранний и самый поздний timestamp эндпоинта в миллисекундах Unix;
+// ok = false, пока не встретилось ни одного разобранного timestamp
+type seenRange struct {
+	first, last int64
+	ok          bool
+}
+
+func (r *seenRange) add(ms int64) {
+	if !r.ok {
+		*r = seenRange{ms, ms, true}
+		return
+	}
+	r.first = min(r.first, ms)
+	r.last = max(r.last, ms)
+}
+
+func (r *seenRange) merge(o *seenRange) {
+	if o.ok {
+		r.add(o.first)
+		r.add(o.last)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTimeLayoutParse(t *testing.T) {
+	const base = 1704067200000 // 2024-01-01T00:00:00Z
+	tests := []struct {
+		layout, in string
+		want       int64
+		ok         bool
+	}{
+		{defaultTimeLayout, "2024-01-01T00:00:00Z", base, true},
+		{defaultTimeLayout, "2024-01-01T03:00:00+03:00", base, true},
+		{defaultTimeLayout, "2023-12-31T19:30:00-04:30", base, true},
+		// Доли секунды после секунд RFC3339 принимает, как и time.Parse
+		{defaultTimeLayout, "2024-01-01T00:00:00.5Z", base + 500, true},
+		{defaultTimeLayout, "2024-01-01T00:00:00.123Z", base + 123, true},
+		{defaultTimeLayout, "2024-01-01T00:00:00.123456789+00:00", base + 123, true},
+		{defaultTimeLayout, "2024-01-01T00:00:00.Z", 0, false},
+		{defaultTimeLayout, "2024-01-01T00:00:00.5", 0, false},
+		{defaultTimeLayout, "2024-01-01T00:00:00", 0, false},
+		{defaultTimeLayout, "2024-01-01 00:00:00Z", 0, false},
+		{defaultTimeLayout, "2024-02-30T00:00:00Z", 0, false},
+		{defaultTimeLayout, "2024-01-01T24:00:00Z", 0, false},
+		{defaultTimeLayout, "2024-01-01T00:00:00+24:00", 0, false},
+		{defaultTimeLayout, "2024-01-01T00:00:00Zjunk", 0, false},
+		{defaultTimeLayout, "", 0, false},
+		{"2006-01-02T15:04:05.000Z07:00", "2024-01-01T00:00:00.042Z", base + 42, true},
+		{"2006-01-02T15:04:05.000Z07:00", "2024-01-01T00:00:00.42Z", 0, false},
+		{"2006-01-02T15:04:05.000Z07:00", "2024-01-01T00:00:00Z", 0, false},
+		{"2006-01-02T15:04:05.999Z07:00", "2024-01-01T00:00:00Z", base, true},
+		{"2006-01-02T15:04:05.999Z07:00", "2024-01-01T00:00:00.7Z", base + 700, true},
+		// Точка без цифры после секунд - литерал раскладки, а не доли секунды
+		{"2006-01-02T15:04:05.json", "2024-01-01T00:00:00.json", base, true},
+		{"2006-01-02T15:04:05.json", "2024-01-01T00:00:00.25.json", base + 250, true},
+		{"02/Jan/2006:15:04:05 -0700", "01/Jan/2024:03:00:00 +0300", base, true},
+		{"02/Jan/2006:15:04:05 -0700", "01/jan/2024:03:00:00 +0300", 0, false},
+		{"2006-01-02 15:04:05", "2024-02-29 00:00:00", base + 59*86400000, true},
+		{"2006-01-02 15:04:05", "2023-02-29 00:00:00", 0, false},
+	}
+	for _, tt := range tests {
+		l, err := compileTimeLayout(tt.layout)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.layout, err)
+		}
+		got, ok := l.parse([]byte(tt.in))
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("%q: parse(%q) = %d, %v; want %d, %v", tt.layout, tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCompileTimeLayoutErrors(t *testing.T) {
+	for _, bad := range []string{
+		"",
+		"2006-01-02",
+		"2006-01-02T15:04",
+		"2006-01-02T15:04:05 MST",
+		"Mon 2006-01-02T15:04:05",
+		"January 02 2006 15:04:05",
+		"2006-01-02T15:04:05 2006",
+		"2006-01-02T15:04:05 1",
+	} {
+		if _, err := compileTimeLayout(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestTimeLayoutMismatchWarning(t *testing.T) {
+	var stderr bytes.Buffer
+	w, level := logger.w, logger.level
+	logger.w, logger.level = &stderr, levelWarn
+	t.Cleanup(func() { logger.w, logger.level = w, level })
+
+	path := writeTempFile(t, "in.log", "2024-01-01T00:00:00.5Z 10.0.0.1 GET /a 200 10\n2024-01-01T00:00:02.5Z 10.0.0.1 GET /a 200 20\n")
+	out := writeTempFile(t, "out.json", "")
+	if code := runCommand([]string{"analyze", "-o", out, "-schema-version", "2", path}); code != exitOK {
+		t.Fatalf("exit %d", code)
+	}
+	if strings.Contains(stderr.String(), "no timestamp matched") {
+		t.Errorf("fractional RFC3339 timestamps did not parse:\n%s", stderr.String())
+	}
+
+	stderr.Reset()
+	if code := runCommand([]string{"analyze", "-o", out, "-schema-version", "2", "-time-layout", "02/Jan/2006:15:04:05", path}); code != exitOK {
+		t.Fatalf("exit %d", code)
+	}
+	if !strings.Contains(stderr.String(), `no timestamp matched -time-layout "02/Jan/2006:15:04:05"`) {
+		t.Errorf("no warning for a layout that matches nothing:\n%s", stderr.String())
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// defaultMaxBuckets - сколько пар (эндпоинт, интервал -bucket) можно накопить по умолчанию
+const defaultMaxBuckets = 100000
+
+// bucketStart - начало интервала шириной width мс, в который попадает ms. Интервалы
+// выровнены на кратные width от эпохи, поэтому у всех воркеров границы совпадают
+// и дельты складываются по ключу
+func bucketStart(ms, width int64) int64 {
+	start := ms / width * width
+	if start > ms {
+		start -= width
+	}
+	return start
+}
+
+// addTimeline учитывает значение v в интервале, начинающемся с start
+func addTimeline(timeline map[int64]*Stats, start, v int64) {
+	if s := timeline[start]; s != nil {
+		addValue(s, v)
+		return
+	}
+	timeline[start] = addValue(nil, v)
+}
+
+func mergeTimeline(dst, src map[int64]*Stats) {
+	for start, s := range src {
+		dst[start] = mergeInto(dst[start], s)
+	}
+}
+
+func cloneTimeline(t map[int64]*Stats) map[int64]*Stats {
+	c := make(map[int64]*Stats, len(t))
+	for start, s := range t {
+		c[start] = s.clone()
+	}
+	return c
+}
+
+// checkTimeline возвращает ошибку, если пар (эндпоинт, интервал) в итоге стало больше
+// limit: память растёт с числом интервалов на число эндпоинтов
+func (m *merger) checkTimeline(limit int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pairs := 0
+	for _, s := range m.total.totals {
+		if s.extra != nil {
+			pairs += len(s.extra.timeline)
+		}
+	}
+	if pairs > limit {
+		return fmt.Errorf("-bucket needs more than %d (endpoint, bucket) pairs, the -max-buckets limit; use a wider -bucket or raise -max-buckets", limit)
+	}
+	return nil
+}
+
+// timelineOf - "timeline" эндпоинта для JSON и YAML: интервалы по возрастанию начала
+func (r jsonRenderer) timelineOf(s *Stats) docArray {
+	starts := make([]int64, 0, len(s.extra.timeline))
+	for start := range s.extra.timeline {
+		starts = append(starts, start)
+	}
+	slices.Sort(starts)
+	arr := make(docArray, len(starts))
+	for i, start := range starts {
+		b := s.extra.timeline[start]
+		minV, avg, maxV := r.unit.formatStats(b, r.precision)
+		arr[i] = docObject{
+			{"bucket_start", formatTimestamp(start)},
+			{"min", docNumber(minV)},
+			{"avg", docNumber(avg)},
+			{"max", docNumber(maxV)},
+			{"count", docInt(b.Count)},
+		}
+	}
+	return arr
+}
+
+// validBucketWidth - -bucket задаётся целым числом миллисекунд: timestamp точнее не разбираются
+func validBucketWidth(d time.Duration) bool {
+	return d > 0 && d%time.Millisecond == 0
+}
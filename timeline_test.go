@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBucketStart(t *testing.T) {
+	for _, tt := range []struct{ ms, width, want int64 }{
+		{0, 60000, 0},
+		{59999, 60000, 0},
+		{60000, 60000, 60000},
+		{1704067259999, 60000, 1704067200000},
+		// До эпохи интервал всё равно начинается не позже ms
+		{-1, 60000, -60000},
+		{-60000, 60000, -60000},
+		{-60001, 60000, -120000},
+		{1500, 1, 1500},
+	} {
+		if got := bucketStart(tt.ms, tt.width); got != tt.want {
+			t.Errorf("bucketStart(%d, %d) = %d, want %d", tt.ms, tt.width, got, tt.want)
+		}
+	}
+}
+
+type timelineBucket struct {
+	Start string  `json:"bucket_start"`
+	Min   int64   `json:"min"`
+	Avg   float64 `json:"avg"`
+	Max   int64   `json:"max"`
+	Count int64   `json:"count"`
+}
+
+func TestTimelineBuckets(t *testing.T) {
+	path := writeTempFile(t, "timeline.log", "2024-01-01T00:01:00Z 10.0.0.1 GET /a 200 30\n"+
+		"2024-01-01T00:00:59.999Z 10.0.0.1 GET /a 200 10\n"+
+		// То же 00:00:30 UTC в другой зоне
+		"2024-01-01T03:00:30+03:00 10.0.0.1 GET /a 200 20\n"+
+		"2024-01-01T00:02:10Z 10.0.0.1 GET /b 200 5\n"+
+		// Строка без разобранного timestamp считается в эндпоинте, но не в timeline
+		"bad-ts 10.0.0.1 GET /a 200 100\n")
+	want := map[string][]timelineBucket{
+		"/a": {
+			{"2024-01-01T00:00:00Z", 10, 15, 20, 2},
+			{"2024-01-01T00:01:00Z", 30, 30, 30, 1},
+		},
+		"/b": {{"2024-01-01T00:02:00Z", 5, 5, 5, 1}},
+	}
+	var first string
+	for _, workers := range []string{"1", "3"} {
+		out, code := runAnalyzeFile(t, "-bucket", "1m", "-include-count", "-workers", workers, path)
+		if code != exitOK {
+			t.Fatalf("-workers %s: exit %d", workers, code)
+		}
+		var doc struct {
+			Endpoints map[string]struct {
+				Count    int64            `json:"count"`
+				Timeline []timelineBucket `json:"timeline"`
+			} `json:"endpoints"`
+		}
+		if err := json.Unmarshal([]byte(out), &doc); err != nil {
+			t.Fatalf("%v\n%s", err, out)
+		}
+		got := make(map[string][]timelineBucket)
+		for name, e := range doc.Endpoints {
+			got[name] = e.Timeline
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("-workers %s: timeline %+v, want %+v", workers, got, want)
+		}
+		if doc.Endpoints["/a"].Count != 4 {
+			t.Errorf("-workers %s: /a count %d, want 4", workers, doc.Endpoints["/a"].Count)
+		}
+		if first == "" {
+			first = out
+		} else if out != first {
+			t.Errorf("-workers %s differs from -workers 1:\n%s", workers, out)
+		}
+	}
+
+	// Час целиком - один интервал
+	out, _ := runAnalyzeFile(t, "-bucket", "1h", path)
+	var doc struct {
+		Endpoints map[string]struct {
+			Timeline []timelineBucket `json:"timeline"`
+		} `json:"endpoints"`
+	}
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if tl := doc.Endpoints["/a"].Timeline; len(tl) != 1 || tl[0] != (timelineBucket{"2024-01-01T00:00:00Z", 10, 20, 30, 3}) {
+		t.Errorf("-bucket 1h: /a timeline %+v", tl)
+	}
+
+	// Три пары (эндпоинт, интервал) как раз помещаются в -max-buckets 3
+	if _, code := runAnalyzeFile(t, "-bucket", "1m", "-max-buckets", "3", path); code != exitOK {
+		t.Errorf("-max-buckets 3: exit %d, want %d", code, exitOK)
+	}
+	for _, bad := range [][]string{{"-bucket", "-1m"}, {"-bucket", "1.5ms"}, {"-bucket", "1m", "-format", "table"}} {
+		if _, code := runAnalyzeFile(t, append(bad, path)...); code != exitUsage {
+			t.Errorf("%v: exit %d, want %d", bad, code, exitUsage)
+		}
+	}
+}
+
+func TestCheckTimeline(t *testing.T) {
+	// Предел проверяется на merger: после этой ошибки analyze не ждёт воркеров, и
+	// следующий запуск в том же тесте гонялся бы с ними за logger
+	m := newMerger()
+	for _, name := range []string{"/a", "/b"} {
+		timeline := make(map[int64]*Stats)
+		addTimeline(timeline, 0, 10)
+		addTimeline(timeline, 60000, 20)
+		addTimeline(timeline, 60000, 30)
+		m.total.totals[name] = &Stats{Count: 3, extra: &statsExtra{timeline: timeline}}
+	}
+	m.total.totals["/plain"] = &Stats{Count: 1}
+	if err := m.checkTimeline(4); err != nil {
+		t.Errorf("4 pairs with -max-buckets 4: %v", err)
+	}
+	if err := m.checkTimeline(3); err == nil || !strings.Contains(err.Error(), "more than 3 (endpoint, bucket) pairs") {
+		t.Errorf("4 pairs with -max-buckets 3: %v", err)
+	}
+}
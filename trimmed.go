@@ -0,0 +1,50 @@
+package main
+
+// trimmedSum складывает значения с номерами [lo, hi) при обходе распределения по
+// возрастанию. Корзина может попасть в окно частично: берётся столько её значений,
+// сколько в окне помещается
+type trimmedSum struct {
+	lo, hi int64
+	// pos - сколько значений уже пройдено
+	pos int64
+	sum float64
+}
+
+// add учитывает c значений, равных v (или оценённых как v)
+func (t *trimmedSum) add(v float64, c int64) {
+	start, end := t.pos, t.pos+c
+	t.pos = end
+	if n := min(end, t.hi) - max(start, t.lo); n > 0 {
+		t.sum += float64(n) * v
+	}
+}
+
+// trimmedMean - среднее s без q.trim процентов самых малых и самых больших значений.
+// Отбрасывается floor(count * trim / 100) значений с каждой стороны, как в
+// scipy.stats.trim_mean. С точной гистограммой результат точный (кроме значений от
+// exactPercentileCap, они берутся из скетча переполнения), со скетчем - по оценкам корзин
+func (q *quantileSpec) trimmedMean(s *Stats) float64 {
+	cut := int64(float64(s.Count) * q.trim / 100)
+	t := &trimmedSum{lo: cut, hi: s.Count - cut}
+	q.each(s, t.add)
+	if t.hi <= t.lo {
+		return 0
+	}
+	return t.sum / float64(t.hi-t.lo)
+}
+
+// trimmedMethod - чем считался trimmed_avg для meta: "exact" по точным гистограммам
+// -exact-percentiles или "sketch". После отката по -max-memory точных гистограмм не
+// остаётся ни у одного эндпоинта, так что хватает посмотреть на любой
+func trimmedMethod(res *pipelineResult, opts *options) string {
+	if !opts.exactPercentiles {
+		return "sketch"
+	}
+	for _, s := range res.totals {
+		if s.extra == nil || s.extra.exact == nil {
+			return "sketch"
+		}
+		break
+	}
+	return "exact"
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// bruteTrimmedMean - среднее без floor(n * trim / 100) значений с каждой стороны
+func bruteTrimmedMean(values []int64, trim float64) float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	cut := int(float64(len(sorted)) * trim / 100)
+	kept := sorted[cut : len(sorted)-cut]
+	if len(kept) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range kept {
+		sum += float64(v)
+	}
+	return sum / float64(len(kept))
+}
+
+// distributionOf - Stats со значениями values и точной гистограммой или скетчем
+func distributionOf(values []int64, exact bool, m *sketchMapping) *Stats {
+	s := &Stats{Min: values[0], Max: values[0], extra: &statsExtra{}}
+	if exact {
+		s.extra.exact = &exactHist{}
+	} else {
+		s.extra.sketch = &sketch{}
+	}
+	for _, v := range values {
+		s.Min, s.Max = min(s.Min, v), max(s.Max, v)
+		s.Sum += v
+		s.Count++
+		if exact {
+			s.extra.exact.add(m, v)
+		} else {
+			s.extra.sketch.add(m, v)
+		}
+	}
+	return s
+}
+
+func TestTrimmedMeanMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewPCG(5, 6))
+	m := newSketchMapping(defaultSketchAccuracy)
+	// Длинный хвост: отсечение заметно меняет среднее
+	values := make([]int64, 2001)
+	for i := range values {
+		values[i] = int64(rng.ExpFloat64() * 100)
+		if i%100 == 0 {
+			values[i] += 20000
+		}
+	}
+	for _, trim := range []float64{1, 5, 10, 25, 49.99} {
+		want := bruteTrimmedMean(values, trim)
+		q := &quantileSpec{mapping: m, trim: trim}
+		if got := q.trimmedMean(distributionOf(values, true, m)); math.Abs(got-want) > 1e-9*want {
+			t.Errorf("trim %v, exact: %v, brute force %v", trim, got, want)
+		}
+		// Со скетчем - в пределах его относительной ошибки
+		if got := q.trimmedMean(distributionOf(values, false, m)); math.Abs(got-want) > defaultSketchAccuracy*want {
+			t.Errorf("trim %v, sketch: %v, brute force %v", trim, got, want)
+		}
+	}
+}
+
+func TestTrimmedMeanSmallCounts(t *testing.T) {
+	m := newSketchMapping(defaultSketchAccuracy)
+	q := &quantileSpec{mapping: m, trim: 10}
+	for _, tt := range []struct {
+		values []int64
+		want   float64
+	}{
+		// floor(9 * 0.1) = 0: ничего не отбрасывается
+		{[]int64{1, 2, 3, 4, 5, 6, 7, 8, 1000}, 1036.0 / 9},
+		// floor(10 * 0.1) = 1 с каждой стороны
+		{[]int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 1000}, 44.0 / 8},
+		{[]int64{7}, 7},
+	} {
+		if got := q.trimmedMean(distributionOf(tt.values, true, m)); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("trimmed mean of %v = %v, want %v", tt.values, got, tt.want)
+		}
+	}
+}
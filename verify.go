@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const verifyUsageHeader = `Usage: iw_challenge verify [flags] FILE
+
+Runs the parallel pipeline and a deliberately simple single-threaded parser
+over FILE and reports every endpoint where their results differ.
+
+Flags:
+`
+
+// Больше этого количества расхождений не печатаем
+const maxReportedMismatches = 20
+
+func verifyMain(args []string) int {
+	opts := &options{chunkSize: defaultChunkSize}
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), verifyUsageHeader)
+		fs.PrintDefaults()
+	}
+	fs.IntVar(&opts.numWorkers, "workers", runtime.NumCPU(), "number of parallel workers")
+	fs.Var(&opts.chunkSize, "chunk-size", "per-worker read buffer `size`, e.g. 512K, 4M, 64M")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() != 1 || opts.numWorkers < 1 || opts.chunkSize < minChunkSize {
+		fmt.Fprintf(fs.Output(), "expected exactly one FILE, -workers >= 1 and -chunk-size >= %s\n", byteSize(minChunkSize))
+		fs.Usage()
+		return exitUsage
+	}
+	filePath := fs.Arg(0)
+
+	runtime.GOMAXPROCS(opts.numWorkers)
+	popts := &processOptions{chunkSize: int(opts.chunkSize)}
+	res, err := runPipeline(context.Background(), filePath, opts.numWorkers, popts, false)
+	if err != nil {
+		logger.errorf("%v", err)
+		return exitError
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		logger.errorf("%v", err)
+		return exitError
+	}
+	defer f.Close()
+	ref, refCounters, err := referenceParse(f)
+	if err != nil {
+		logger.errorf("error reading file: %v", err)
+		return exitError
+	}
+
+	mismatches := diffStats(res.totals, ref)
+	if res.counters != refCounters {
+		mismatches = append([]string{fmt.Sprintf("line counts: pipeline %d parsed/%d malformed, reference %d/%d",
+			res.counters.lines, res.counters.malformed, refCounters.lines, refCounters.malformed)}, mismatches...)
+	}
+	if len(mismatches) == 0 {
+		fmt.Printf("OK: %d endpoints, %d lines match\n", len(ref), refCounters.lines)
+		return exitOK
+	}
+
+	for i, m := range mismatches {
+		if i == maxReportedMismatches {
+			fmt.Printf("... and %d more\n", len(mismatches)-i)
+			break
+		}
+		fmt.Println(m)
+	}
+	fmt.Printf("FAIL: %d mismatches\n", len(mismatches))
+	return exitError
+}
+
+// referenceParse - эталонный разбор: по строке за раз, поля через strings.Fields.
+// Специально никаких оптимизаций, чтобы было с чем сравнивать быстрый путь
+func referenceParse(r io.Reader) (map[string]*Stats, lineCounters, error) {
+	stats := make(map[string]*Stats)
+	var counters lineCounters
+
+	br := bufio.NewReader(r)
+	// first - первая строка, с которой снимается BOM; пустые строки не считаются вовсе
+	first := true
+	for {
+		line, err := br.ReadString('\n')
+		if first {
+			line = strings.TrimPrefix(line, "\uFEFF")
+			first = false
+		}
+		if fields := strings.Fields(line); len(fields) > 0 {
+			var t int64
+			var perr error
+			if len(fields) < 6 {
+				perr = errors.New("too few fields")
+			} else {
+				t, perr = strconv.ParseInt(fields[5], 10, 64)
+			}
+			if perr != nil {
+				counters.malformed++
+			} else {
+				counters.lines++
+				s, ok := stats[fields[3]]
+				if !ok {
+					s = &Stats{Min: t, Max: t}
+					stats[fields[3]] = s
+				}
+				s.Min = min(s.Min, t)
+				s.Max = max(s.Max, t)
+				s.Sum += t
+				s.Count++
+			}
+		}
+		if err == io.EOF {
+			return stats, counters, nil
+		}
+		if err != nil {
+			return nil, counters, err
+		}
+	}
+}
+
+// diffStats описывает все расхождения между двумя наборами статистики
+func diffStats(got, want map[string]*Stats) []string {
+	names := make(map[string]struct{}, len(got)+len(want))
+	for k := range got {
+		names[k] = struct{}{}
+	}
+	for k := range want {
+		names[k] = struct{}{}
+	}
+	sorted := make([]string, 0, len(names))
+	for k := range names {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var out []string
+	for _, name := range sorted {
+		g, w := got[name], want[name]
+		switch {
+		case g == nil:
+			out = append(out, fmt.Sprintf("%q: missing in pipeline output", name))
+		case w == nil:
+			out = append(out, fmt.Sprintf("%q: unexpected in pipeline output", name))
+		case g.Min != w.Min || g.Max != w.Max || g.Sum != w.Sum || g.Count != w.Count:
+			out = append(out, fmt.Sprintf("%q: pipeline %s, reference %s", name, verifyStats(g), verifyStats(w)))
+		}
+	}
+	return out
+}
+
+// verifyStats - поля Stats, которые сверяет verify; накопители квантилей и прочего тут всегда пусты
+func verifyStats(s *Stats) string {
+	return fmt.Sprintf("{Min:%d Max:%d Sum:%d Count:%d}", s.Min, s.Max, s.Sum, s.Count)
+}
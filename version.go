@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Переопределяются при сборке:
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Если commit и buildDate не заданы, берутся из debug.ReadBuildInfo (vcs.revision, vcs.time).
+var (
+	version   = "0.1.0"
+	commit    = ""
+	buildDate = ""
+)
+
+type buildInfo struct {
+	Version   string
+	Commit    string
+	GoVersion string
+	BuildDate string
+}
+
+func getBuildInfo() buildInfo {
+	bi := buildInfo{
+		Version:   version,
+		Commit:    commit,
+		GoVersion: runtime.Version(),
+		BuildDate: buildDate,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && bi.Commit == "":
+				bi.Commit = s.Value
+			case s.Key == "vcs.time" && bi.BuildDate == "":
+				bi.BuildDate = s.Value
+			}
+		}
+	}
+	return bi
+}
+
+// versionString - короткая строка версии для результатов: "0.1.0+1a4aa56"
+func (bi buildInfo) versionString() string {
+	if bi.Commit == "" {
+		return bi.Version
+	}
+	return bi.Version + "+" + shortCommit(bi.Commit)
+}
+
+func (bi buildInfo) String() string {
+	orUnknown := func(s string) string {
+		if s == "" {
+			return "unknown"
+		}
+		return s
+	}
+	return fmt.Sprintf("iw_challenge %s\ncommit: %s\ngo: %s\nbuilt: %s",
+		bi.Version, orUnknown(bi.Commit), bi.GoVersion, orUnknown(bi.BuildDate))
+}
+
+func shortCommit(c string) string {
+	if len(c) > 7 {
+		return c[:7]
+	}
+	return c
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// -input-format w3c: расширенный формат W3C (IIS, CDN). Порядок полей задаёт строка
+// "#Fields: date time cs-method cs-uri-stem ...", остальные строки с # - метаданные
+const (
+	inputFormatW3C = "w3c"
+	// w3cTimeLayout - -time-layout по умолчанию для w3c: поля date и time вместе, в UTC
+	w3cTimeLayout = "2006-01-02 15:04:05.999"
+	// maxW3CDirectiveLength - длиннее этой строка #Fields не читается при поиске директив
+	maxW3CDirectiveLength = 64 * 1024
+)
+
+var w3cFieldsPrefix = []byte("#Fields:")
+
+// w3cFieldNames - поля W3C, из которых берутся поля строки; date и time вместе дают timestamp
+var w3cFieldNames = map[string]fieldKind{
+	"c-ip":        fieldIP,
+	"cs-method":   fieldMethod,
+	"cs-uri-stem": fieldPath,
+	"sc-status":   fieldStatus,
+	"time-taken":  fieldTime,
+}
+
+// w3cPlan - раскладка полей по одной директиве #Fields. Если директива не годится,
+// err объясняет почему, и строки до следующей директивы битые
+type w3cPlan struct {
+	fields *fieldPlan
+	// dateTime - timestamp - это поля date и сразу за ним time
+	dateTime bool
+	err      error
+}
+
+// parseW3CDirective разбирает строку "#Fields: ..."; поля нумеруются с единицы, как в -fields
+func parseW3CDirective(line []byte) *w3cPlan {
+	names := strings.Fields(string(bytes.TrimPrefix(line, w3cFieldsPrefix)))
+	var m fieldMapping
+	plan := &w3cPlan{}
+	for i, name := range names {
+		if i+1 > maxFieldIndex {
+			break
+		}
+		if kind, ok := w3cFieldNames[name]; ok && m[kind] == 0 {
+			m[kind] = i + 1
+		}
+		if name == "date" && m[fieldTimestamp] == 0 && i+1 < len(names) && names[i+1] == "time" {
+			m[fieldTimestamp] = i + 1
+			plan.dateTime = true
+		}
+	}
+	if m[fieldPath] == 0 || m[fieldTime] == 0 {
+		plan.err = fmt.Errorf("#Fields %q has no cs-uri-stem or time-taken", strings.Join(names, " "))
+		return plan
+	}
+	plan.fields = newFieldPlan(m, 0)
+	plan.fields.source = "#Fields"
+	return plan
+}
+
+// parse разбирает строку данных по директиве. Поля разделены пробелами, у CloudFront -
+// табуляциями: они заменяются пробелами прямо в line. time-taken у IIS - целые
+// миллисекунды, а CloudFront пишет секунды с долями (0.002): число с точкой - секунды
+func (plan *w3cPlan) parse(line []byte) (lineRecord, error) {
+	if plan == nil {
+		return lineRecord{}, &lineParseError{0, errors.New("no #Fields directive before this line")}
+	}
+	if plan.err != nil {
+		return lineRecord{}, &lineParseError{0, plan.err}
+	}
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	for i := bytes.IndexByte(line, '\t'); i >= 0; i = bytes.IndexByte(line, '\t') {
+		line[i] = ' '
+	}
+	rec, err := plan.fields.split(line)
+	if err != nil {
+		return rec, err
+	}
+	if plan.dateTime {
+		if t, ok := nextField(line, rec.timestamp.end); ok {
+			rec.timestamp.end = t.end
+		}
+	}
+
+	t := line[rec.responseTime.start:rec.responseTime.end]
+	if bytes.IndexByte(t, '.') >= 0 {
+		value, bad, err := parseSeconds(t)
+		if err != nil {
+			return rec, &lineParseError{rec.responseTime.start + bad, err}
+		}
+		rec.value = value
+		return rec, nil
+	}
+	value, err := parseIntFast(t)
+	if err != nil {
+		return rec, &lineParseError{rec.responseTime.start + badDigitIndex(t), err}
+	}
+	rec.value = value
+	return rec, nil
+}
+
+// w3cDirective - директива #Fields и байт файла, с которого она действует
+type w3cDirective struct {
+	offset int64
+	plan   *w3cPlan
+}
+
+// w3cPartPlans находит все директивы #Fields до конца последней части и возвращает
+// для каждой части раскладку, которая действует в её начале. Директивы в данных самой
+// части воркер применяет сам, но строки до первой из них зависят от директив раньше:
+// в склеенных при ротации файлах они бывают в любом месте, поэтому файл просматривается
+// целиком до запуска воркеров
+func w3cPartPlans(ctx context.Context, filePath string, parts []part) ([]*w3cPlan, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	end := parts[len(parts)-1].offset + parts[len(parts)-1].size
+
+	var directives []w3cDirective
+	buf := make([]byte, 1<<20)
+	// Строка директивы начинается в начале файла или после '\n'; prev - последний байт
+	// предыдущей пачки, чтобы не пропустить директиву на стыке
+	prev := byte('\n')
+	for pos := int64(0); pos < end; {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		n, err := file.ReadAt(buf[:min(int64(len(buf)), end-pos)], pos)
+		if n == 0 && err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		data := buf[:n]
+		for i := 0; i < len(data); {
+			j := bytes.IndexByte(data[i:], '#')
+			if j < 0 {
+				break
+			}
+			at := i + j
+			i = at + 1
+			if at == 0 && prev != '\n' || at > 0 && data[at-1] != '\n' {
+				continue
+			}
+			// Другие комментарии отсеиваются без чтения строки, если она в пачке целиком
+			if at+len(w3cFieldsPrefix) <= len(data) && !bytes.HasPrefix(data[at:], w3cFieldsPrefix) {
+				continue
+			}
+			line, err := readDirective(file, pos+int64(at))
+			if err != nil {
+				return nil, err
+			}
+			if bytes.HasPrefix(line, w3cFieldsPrefix) {
+				directives = append(directives, w3cDirective{pos + int64(at), parseW3CDirective(line)})
+			}
+		}
+		prev = data[n-1]
+		pos += int64(n)
+	}
+
+	plans := make([]*w3cPlan, len(parts))
+	d := 0
+	var current *w3cPlan
+	for i, p := range parts {
+		for d < len(directives) && directives[d].offset < p.offset {
+			current = directives[d].plan
+			d++
+		}
+		plans[i] = current
+	}
+	return plans, nil
+}
+
+// readDirective читает строку с байта offset без '\n' и '\r'
+func readDirective(file *os.File, offset int64) ([]byte, error) {
+	buf := make([]byte, maxW3CDirectiveLength)
+	n, err := file.ReadAt(buf, offset)
+	if n == 0 && err != nil && err != io.EOF {
+		return nil, err
+	}
+	line := buf[:n]
+	if nl := bytes.IndexByte(line, '\n'); nl >= 0 {
+		line = line[:nl]
+	}
+	return bytes.TrimSuffix(line, []byte("\r")), nil
+}
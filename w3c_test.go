@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestW3CParse(t *testing.T) {
+	plan := parseW3CDirective([]byte("#Fields: date time s-ip cs-method cs-uri-stem cs-uri-query sc-status time-taken c-ip"))
+	if plan.err != nil || !plan.dateTime {
+		t.Fatalf("directive: %v, dateTime %v", plan.err, plan.dateTime)
+	}
+	tests := []struct {
+		line, ts, path, ip string
+		ms                 int64
+	}{
+		{"2024-01-01 00:00:01 10.0.0.9 GET /a - 200 15 10.0.0.1", "2024-01-01 00:00:01", "/a", "10.0.0.1", 15},
+		// CloudFront делит поля табуляцией и пишет time-taken в секундах
+		{"2024-01-01\t00:00:02.5\t10.0.0.9\tGET\t/b\tx=1\t200\t0.0025\t::1\r", "2024-01-01 00:00:02.5", "/b", "::1", 3},
+	}
+	for _, tt := range tests {
+		line := []byte(tt.line)
+		rec, err := plan.parse(line)
+		if err != nil {
+			t.Errorf("%q: %v", tt.line, err)
+			continue
+		}
+		got := [3]string{string(line[rec.timestamp.start:rec.timestamp.end]), string(line[rec.path.start:rec.path.end]), string(line[rec.ip.start:rec.ip.end])}
+		if got != [3]string{tt.ts, tt.path, tt.ip} || rec.value != tt.ms {
+			t.Errorf("%q = %q %dms, want %q %dms", tt.line, got, rec.value, [3]string{tt.ts, tt.path, tt.ip}, tt.ms)
+		}
+	}
+	for _, bad := range []string{"2024-01-01 00:00:01 10.0.0.9 GET /a - 200", "2024-01-01 00:00:01 10.0.0.9 GET /a - 200 1.x 10.0.0.1", "2024-01-01 00:00:01 10.0.0.9 GET /a - 200 -1 10.0.0.1"} {
+		if _, err := plan.parse([]byte(bad)); err == nil {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+
+	// Без cs-uri-stem или time-taken директива не годится, и строки после неё битые
+	for _, d := range []string{"#Fields: date time cs-uri-stem", "#Fields: time-taken c-ip", "#Fields:"} {
+		if p := parseW3CDirective([]byte(d)); p.err == nil {
+			t.Errorf("%q accepted", d)
+		} else if _, err := p.parse([]byte("/a 1")); err == nil {
+			t.Errorf("%q: line accepted", d)
+		}
+	}
+	if _, err := (*w3cPlan)(nil).parse([]byte("/a 1")); err == nil {
+		t.Error("line without a directive accepted")
+	}
+	// time без date перед ним - не timestamp
+	if p := parseW3CDirective([]byte("#Fields: time date cs-uri-stem time-taken")); p.dateTime {
+		t.Error("time date taken as a timestamp")
+	}
+}
+
+func TestW3CInput(t *testing.T) {
+	path := writeTempFile(t, "u_ex.log", "/early 1\n"+
+		"#Software: Microsoft Internet Information Services 10.0\n"+
+		"#Fields: date time cs-method cs-uri-stem sc-status time-taken\n"+
+		"2024-01-01 00:00:01 GET /a 200 10\n"+
+		"2024-01-01 00:00:02\tGET\t/a\t200\t0.030\n"+
+		"#Fields: time-taken cs-uri-stem date time\n"+
+		"5 /b 2024-01-01 00:00:09.5\n")
+	out, code := runAnalyzeFile(t, "-input-format", "w3c", "-include-count", "-summary-span", path)
+	if code != exitOK {
+		t.Fatalf("exit %d", code)
+	}
+	for _, want := range []string{`"/a": {`, `"avg_response_time": 20.0,`, `"/b": {`, `"total_requests": 3,`, `"malformed_lines": 1`,
+		`"first_timestamp": "2024-01-01T00:00:01Z"`, `"last_timestamp": "2024-01-01T00:00:09.5Z"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%s missing:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "/early") {
+		t.Errorf("line before #Fields aggregated:\n%s", out)
+	}
+	if _, code := runAnalyzeFile(t, "-input-format", "w3c", "-fields", "path=1,time=2", path); code != exitUsage {
+		t.Errorf("-fields with w3c: exit %d, want %d", code, exitUsage)
+	}
+}
+
+// Директивы в середине файла действуют и на части других воркеров
+func TestW3CDirectivesAcrossParts(t *testing.T) {
+	var sb strings.Builder
+	for i := range 3000 {
+		if i%1000 == 0 {
+			if i/1000%2 == 0 {
+				sb.WriteString("#Fields: date time cs-method cs-uri-stem sc-status time-taken\n")
+			} else {
+				sb.WriteString("#Date: 2024-01-01 00:00:00\n#Fields: time-taken sc-status cs-uri-stem\n")
+			}
+		}
+		p := fmt.Sprintf("/p%d", i%3)
+		if i/1000%2 == 0 {
+			fmt.Fprintf(&sb, "2024-01-01 00:00:00 GET %s 200 %d\n", p, i%50)
+		} else {
+			fmt.Fprintf(&sb, "%d 200 %s\n", i%50, p)
+		}
+	}
+	path := writeTempFile(t, "rotated.log", sb.String())
+	want, code := runAnalyzeFile(t, "-input-format", "w3c", "-include-count", "-workers", "1", path)
+	if code != exitOK || !strings.Contains(want, `"total_requests": 3000,`) || !strings.Contains(want, `"malformed_lines": 0`) {
+		t.Fatalf("-workers 1: exit %d\n%s", code, want)
+	}
+	for _, workers := range []string{"2", "5"} {
+		got, _ := runAnalyzeFile(t, "-input-format", "w3c", "-include-count", "-workers", workers, "-chunk-size", "64K", path)
+		if got != want {
+			t.Errorf("-workers %s:\n%s\nwant\n%s", workers, got, want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import "math"
+
+// welford - среднее и сумма квадратов отклонений по алгоритму Уэлфорда для -stddev.
+// Sum в Stats целочисленная и точная, но дисперсия через сумму квадратов теряет точность
+// на больших значениях, поэтому считаем отдельно
+type welford struct {
+	count int64
+	mean  float64
+	m2    float64
+}
+
+func (w *welford) add(x float64) {
+	w.count++
+	delta := x - w.mean
+	w.mean += delta / float64(w.count)
+	w.m2 += delta * (x - w.mean)
+}
+
+// merge сводит два накопителя формулой параллельной дисперсии (Chan и др.)
+func (w *welford) merge(o *welford) {
+	if o.count == 0 {
+		return
+	}
+	if w.count == 0 {
+		*w = *o
+		return
+	}
+	n := float64(w.count + o.count)
+	delta := o.mean - w.mean
+	w.mean += delta * float64(o.count) / n
+	w.m2 += o.m2 + delta*delta*float64(w.count)*float64(o.count)/n
+	w.count += o.count
+}
+
+// stddev - стандартное отклонение генеральной совокупности (делим на count, а не count-1)
+func (w *welford) stddev() float64 {
+	if w.count == 0 {
+		return 0
+	}
+	return math.Sqrt(w.m2 / float64(w.count))
+}
+
+// logSum - сумма натуральных логарифмов времён ответа для -geo-mean. Логарифма нуля
+// нет, поэтому 0ms считается как 1ms (логарифм 0): такой запрос тянет среднее вниз,
+// но не обнуляет его
+type logSum struct {
+	count int64
+	sum   float64
+}
+
+func (l *logSum) add(v int64) {
+	l.count++
+	if v > 1 {
+		l.sum += math.Log(float64(v))
+	}
+}
+
+func (l *logSum) merge(o *logSum) {
+	l.count += o.count
+	l.sum += o.sum
+}
+
+// geoMean - среднее геометрическое в миллисекундах
+func (l *logSum) geoMean() float64 {
+	if l.count == 0 {
+		return 0
+	}
+	return math.Exp(l.sum / float64(l.count))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+// bruteStddev - стандартное отклонение генеральной совокупности в два прохода
+func bruteStddev(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sq / float64(len(values)))
+}
+
+func TestWelfordMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, tt := range []struct {
+		name   string
+		offset float64
+	}{
+		{"latencies", 0},
+		// Сумма квадратов здесь потеряла бы все значащие цифры дисперсии
+		{"large offset", 1e9},
+	} {
+		values := make([]float64, 10000)
+		for i := range values {
+			values[i] = tt.offset + float64(rng.IntN(2000))
+		}
+		want := bruteStddev(values)
+
+		// Один накопитель и те же значения, разбитые на неравные части и сведённые merge
+		for _, parts := range []int{1, 3, 7, 64} {
+			total := &welford{}
+			for p := range parts {
+				w := &welford{}
+				for _, v := range values[p*len(values)/parts : (p+1)*len(values)/parts] {
+					w.add(v)
+				}
+				total.merge(w)
+			}
+			if got := total.stddev(); math.Abs(got-want) > 1e-9*want {
+				t.Errorf("%s, %d parts: stddev %v, brute force %v", tt.name, parts, got, want)
+			}
+			if total.count != int64(len(values)) {
+				t.Errorf("%s, %d parts: count %d", tt.name, parts, total.count)
+			}
+		}
+	}
+}
+
+func TestWelfordEdgeCases(t *testing.T) {
+	var w welford
+	if w.stddev() != 0 {
+		t.Errorf("empty stddev = %v", w.stddev())
+	}
+	w.add(42)
+	if w.stddev() != 0 {
+		t.Errorf("single value stddev = %v", w.stddev())
+	}
+	// Пустой накопитель с любой стороны merge ничего не меняет
+	w.merge(&welford{})
+	e := welford{}
+	e.merge(&w)
+	if e != w {
+		t.Errorf("merge into empty = %+v, want %+v", e, w)
+	}
+	two := welford{}
+	two.add(1)
+	two.add(3)
+	if two.stddev() != 1 {
+		t.Errorf("stddev of 1 and 3 = %v, want 1", two.stddev())
+	}
+}
+
+func TestGeoMeanMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	var l logSum
+	var logs float64
+	n := 5000
+	for range n {
+		v := int64(rng.IntN(3000))
+		l.add(v)
+		// 0ms считается как 1ms
+		logs += math.Log(float64(max(v, 1)))
+	}
+	if got, want := l.geoMean(), math.Exp(logs/float64(n)); math.Abs(got-want) > 1e-9*want {
+		t.Errorf("geo mean %v, brute force %v", got, want)
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// yamlLine - значимая строка YAML: отступ и содержимое без комментария
+type yamlLine struct {
+	no     int
+	indent int
+	text   string
+}
+
+// parseYAML понимает блочное подмножество YAML, которого хватает для файлов настроек:
+// отображения "key: value", списки "- item" (в том числе "- key: value"), скаляры
+// в кавычках и без, комментарии через #. Потоковый стиль ([...], {...}), якоря и
+// многострочные скаляры не поддерживаются. Результат - map[string]any, []any и скаляры,
+// как у encoding/json, чтобы его можно было перегнать в структуру через JSON
+func parseYAML(data []byte) (any, error) {
+	var lines []yamlLine
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for no := 1; sc.Scan(); no++ {
+		raw := strings.TrimRight(sc.Text(), " \t\r")
+		if strings.HasPrefix(raw, "---") && no == 1 {
+			continue
+		}
+		text := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", no)
+		}
+		text = stripYAMLComment(text)
+		if text == "" {
+			continue
+		}
+		lines = append(lines, yamlLine{no, len(raw) - len(strings.TrimLeft(raw, " ")), text})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	p := &yamlParser{lines: lines}
+	v, err := p.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].no)
+	}
+	return v, nil
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// block разбирает отображение или список, все строки которого имеют отступ indent
+func (p *yamlParser) block(indent int) (any, error) {
+	if isYAMLListItem(p.lines[p.pos].text) {
+		return p.list(indent)
+	}
+	return p.mapping(indent, nil)
+}
+
+func (p *yamlParser) list(indent int) (any, error) {
+	items := []any{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent || !isYAMLListItem(l.text) {
+			return nil, fmt.Errorf("line %d: expected a list item at indentation %d", l.no, indent)
+		}
+		rest := strings.TrimSpace(strings.TrimPrefix(l.text, "-"))
+		switch {
+		case rest == "":
+			// "-" и вложенный блок на следующих строках
+			p.pos++
+			v, err := p.nested(indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		case yamlKeyValue(rest):
+			// "- key: value": отображение, остальные ключи которого выровнены по key
+			keyIndent := l.indent + len(l.text) - len(rest)
+			p.lines[p.pos] = yamlLine{l.no, keyIndent, rest}
+			v, err := p.mapping(keyIndent, nil)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		default:
+			v, err := parseYAMLScalar(rest, l.no)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+			p.pos++
+		}
+	}
+	return items, nil
+}
+
+func (p *yamlParser) mapping(indent int, m map[string]any) (any, error) {
+	if m == nil {
+		m = make(map[string]any)
+	}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.no)
+		}
+		key, val, ok := splitYAMLKey(l.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", l.no)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("line %d: key %q defined twice", l.no, key)
+		}
+		p.pos++
+		if val == "" {
+			v, err := p.nested(indent)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+			continue
+		}
+		v, err := parseYAMLScalar(val, l.no)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// nested разбирает блок под ключом или "-"; список под ключом может иметь тот же отступ
+func (p *yamlParser) nested(parent int) (any, error) {
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	l := p.lines[p.pos]
+	switch {
+	case l.indent > parent:
+		return p.block(l.indent)
+	case l.indent == parent && isYAMLListItem(l.text):
+		return p.list(l.indent)
+	}
+	return nil, nil
+}
+
+func isYAMLListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func yamlKeyValue(text string) bool {
+	_, _, ok := splitYAMLKey(text)
+	return ok
+}
+
+// splitYAMLKey делит "key: value"; ключ может быть в кавычках
+func splitYAMLKey(text string) (key, val string, ok bool) {
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		end := closingQuote(text)
+		if text[0] == '\'' {
+			end = strings.IndexByte(text[1:], '\'') + 1
+		}
+		if end <= 0 || !strings.HasPrefix(text[end+1:], ":") {
+			return "", "", false
+		}
+		k, err := parseYAMLScalar(text[:end+1], 0)
+		if err != nil {
+			return "", "", false
+		}
+		return k.(string), strings.TrimSpace(text[end+2:]), true
+	}
+	i := strings.Index(text, ": ")
+	if i < 0 {
+		if !strings.HasSuffix(text, ":") {
+			return "", "", false
+		}
+		i = len(text) - 1
+	}
+	key = strings.TrimSpace(text[:i])
+	if key == "" || strings.ContainsAny(key, "[]{}") {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(text[i+1:]), true
+}
+
+// stripYAMLComment убирает комментарий: # в начале или после пробела, вне кавычек
+func stripYAMLComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' '):
+			return strings.TrimRight(text[:i], " ")
+		}
+	}
+	return text
+}
+
+func parseYAMLScalar(val string, lineNo int) (any, error) {
+	switch {
+	case strings.HasPrefix(val, `"`):
+		if end := closingQuote(val); end == len(val)-1 {
+			return strconv.Unquote(val)
+		}
+		return nil, fmt.Errorf("line %d: bad double-quoted string %s", lineNo, val)
+	case strings.HasPrefix(val, "'"):
+		if len(val) >= 2 && strings.HasSuffix(val, "'") {
+			return strings.ReplaceAll(val[1:len(val)-1], "''", "'"), nil
+		}
+		return nil, fmt.Errorf("line %d: bad single-quoted string %s", lineNo, val)
+	case strings.HasPrefix(val, "[") || strings.HasPrefix(val, "{"):
+		return nil, fmt.Errorf("line %d: flow style %s is not supported", lineNo, val)
+	case strings.HasPrefix(val, "&") || strings.HasPrefix(val, "*") || strings.HasPrefix(val, "|") || strings.HasPrefix(val, ">"):
+		return nil, fmt.Errorf("line %d: anchors and block scalars are not supported", lineNo)
+	}
+	switch val {
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	case "null", "~":
+		return nil, nil
+	}
+	// ParseFloat понимает и inf, nan, 0x1p3, а для YAML это строки
+	if yamlNumberRe.MatchString(val) {
+		if f, err := strconv.ParseFloat(strings.ReplaceAll(val, "_", ""), 64); err == nil {
+			return f, nil
+		}
+	}
+	return val, nil
+}
+
+var yamlNumberRe = regexp.MustCompile(`^[-+]?([0-9][0-9_]*(\.[0-9_]*)?|\.[0-9]+)([eE][-+]?[0-9]+)?$`)
+
+// yamlRenderer пишет тот же документ, что и -format json, в блочном стиле YAML
+type yamlRenderer struct {
+	json jsonRenderer
+}
+
+func (r yamlRenderer) render(w io.Writer, rep *report) error {
+	writeYAMLObject(w, r.json.document(rep), "")
+	return nil
+}
+
+func writeYAMLObject(w io.Writer, obj docObject, indent string) {
+	for _, f := range obj {
+		fmt.Fprintf(w, "%s%s:", indent, yamlString(f.key))
+		switch v := f.value.(type) {
+		case docObject:
+			if len(v) == 0 {
+				fmt.Fprint(w, " {}\n")
+				continue
+			}
+			fmt.Fprint(w, "\n")
+			writeYAMLObject(w, v, indent+"  ")
+		case docArray:
+			if len(v) == 0 {
+				fmt.Fprint(w, " []\n")
+				continue
+			}
+			fmt.Fprint(w, "\n")
+			writeYAMLArray(w, v, indent)
+		case []string:
+			if len(v) == 0 {
+				fmt.Fprint(w, " []\n")
+				continue
+			}
+			fmt.Fprint(w, "\n")
+			for _, s := range v {
+				fmt.Fprintf(w, "%s  - %s\n", indent, yamlString(s))
+			}
+		default:
+			fmt.Fprintf(w, " %s\n", yamlScalar(v))
+		}
+	}
+}
+
+func yamlScalar(v any) string {
+	switch v := v.(type) {
+	case docNumber:
+		return string(v)
+	case string:
+		return yamlString(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return "null"
+	}
+	panic(fmt.Sprintf("unexpected document value %T", v))
+}
+
+// Строки, которые можно оставить без кавычек: начинаются с буквы, "/" или "_"
+// и состоят из безопасных символов. ":", "#", кавычки, скобки, пробелы и прочее,
+// что YAML трактует по-своему, требуют кавычек. С цифры строка может оказаться
+// числом, датой или 0x10 в YAML 1.1, такие тоже в кавычках
+var yamlPlainRe = regexp.MustCompile(`^[A-Za-z_/][A-Za-z0-9_/.\-]*$`)
+
+// yamlString возвращает s как скаляр YAML. В двойных кавычках экранирование как в JSON:
+// YAML понимает те же \", \\ и \uXXXX
+func yamlString(s string) string {
+	if yamlPlainRe.MatchString(s) && !yamlReserved[strings.ToLower(s)] {
+		return s
+	}
+	return jsonString(s)
+}
+
+// Слова, которые YAML 1.1 читает как bool или null
+var yamlReserved = map[string]bool{
+	"y": true, "n": true, "yes": true, "no": true, "on": true, "off": true,
+	"true": true, "false": true, "null": true,
+}
+
+// writeYAMLArray пишет массив объектов элементами "- ": первое поле объекта - на строке
+// с дефисом, остальные - с отступом под ним
+func writeYAMLArray(w io.Writer, arr docArray, indent string) {
+	for _, obj := range arr {
+		var buf bytes.Buffer
+		writeYAMLObject(&buf, obj, indent+"    ")
+		item := buf.String()
+		fmt.Fprint(w, indent+"  - "+strings.TrimPrefix(item, indent+"    "))
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// yamlTrickyPaths - эндпоинты, которые YAML без кавычек прочитал бы иначе
+var yamlTrickyPaths = []string{
+	"/a:b", "key: value", "- dash", "#hash", "/x #frag", "true", "yes", "null", "~", "123",
+	"0x10", "1e3", "@at", "*star", "&anchor", "!tag", "%pct", "`tick", "{flow}", "[list]",
+	"'single'", `"double"`, "/tab\there", "/trailing:",
+}
+
+// asJSONValue приводит разобранный документ к типам encoding/json: в YAML целые -
+// int, а сравнивать надо с тем, что дал бы JSON
+func asJSONValue(t *testing.T, v any) any {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out any
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// renderString рендерит rep форматом format с флагами args
+func renderString(t *testing.T, rep *report, args ...string) string {
+	t.Helper()
+	opts, err := parseArgs(append(args, "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sb strings.Builder
+	if err := renderers[opts.format](opts).render(&sb, rep); err != nil {
+		t.Fatal(err)
+	}
+	return sb.String()
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	totals := make(map[string]*Stats)
+	for i, p := range append(append([]string{}, yamlTrickyPaths...), trickyPaths...) {
+		v := int64(i + 1)
+		totals[p] = &Stats{Min: v, Max: 2 * v, Sum: 3 * v, Count: 2}
+	}
+	for _, schema := range []string{"1", "2"} {
+		rep := newReport(totals, sortOrder{key: "name"})
+		out := renderString(t, rep, "-format", "yaml", "-schema-version", schema)
+		var fromYAML any
+		if err := yaml.Unmarshal([]byte(out), &fromYAML); err != nil {
+			t.Fatalf("schema %s: output is not valid YAML: %v\n%s", schema, err, out)
+		}
+		var fromJSON any
+		if err := json.Unmarshal([]byte(renderString(t, rep, "-format", "json", "-schema-version", schema)), &fromJSON); err != nil {
+			t.Fatal(err)
+		}
+		if got := asJSONValue(t, fromYAML); !reflect.DeepEqual(got, fromJSON) {
+			t.Errorf("schema %s: YAML document differs from JSON:\n%s", schema, out)
+		}
+
+		// Ключи эндпоинтов читаются обратно без искажений
+		var doc struct {
+			Endpoints map[string]struct {
+				Min int64 `yaml:"min_response_time"`
+				Max int64 `yaml:"max_response_time"`
+			} `yaml:"endpoints"`
+		}
+		if err := yaml.Unmarshal([]byte(out), &doc); err != nil {
+			t.Fatal(err)
+		}
+		for p, s := range totals {
+			e, ok := doc.Endpoints[p]
+			if !ok {
+				t.Errorf("schema %s: endpoint %q lost or mangled", schema, p)
+				continue
+			}
+			if e.Min != s.Min || e.Max != s.Max {
+				t.Errorf("schema %s: %q = %+v, want min %d max %d", schema, p, e, s.Min, s.Max)
+			}
+		}
+	}
+}
+
+func TestYAMLString(t *testing.T) {
+	for in, want := range map[string]string{
+		"/api/users": "/api/users",
+		"_private":   "_private",
+		"/a:b":       `"/a:b"`,
+		"true":       `"true"`,
+		"No":         `"No"`,
+		"123":        `"123"`,
+		"- x":        `"- x"`,
+		"a\"b":       `"a\"b"`,
+		"":           `""`,
+	} {
+		if got := yamlString(in); got != want {
+			t.Errorf("yamlString(%q) = %s, want %s", in, got, want)
+		}
+	}
+}